@@ -259,7 +259,11 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	v.Set("ulimits", string(ulimitsJson))
 
 	headers := http.Header(make(map[string][]string))
-	buf, err := json.Marshal(cli.configFile.AuthConfigs)
+	authConfigs, err := cli.configFile.GetAllAuthConfigs()
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(authConfigs)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+
+	Cli "github.com/docker/docker/cli"
+	flag "github.com/docker/docker/pkg/mflag"
+)
+
+// CmdCheckpoint checkpoints the processes of one or more running containers.
+//
+// Usage: docker checkpoint [OPTIONS] CONTAINER [CONTAINER...]
+func (cli *DockerCli) CmdCheckpoint(args ...string) error {
+	cmd := Cli.Subcmd("checkpoint", []string{"CONTAINER [CONTAINER...]"}, "Checkpoint one or more running containers", true)
+	leaveRunning := cmd.Bool([]string{"-leave-running"}, false, "Leave the container running after checkpointing")
+	cmd.Require(flag.Min, 1)
+
+	cmd.ParseFlags(args, true)
+
+	v := url.Values{}
+	if *leaveRunning {
+		v.Set("leaveRunning", "1")
+	}
+
+	var errNames []string
+	for _, name := range cmd.Args() {
+		if _, _, err := readBody(cli.call("POST", fmt.Sprintf("/containers/%s/checkpoint?%s", name, v.Encode()), nil, nil)); err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			errNames = append(errNames, name)
+		} else {
+			fmt.Fprintf(cli.out, "%s\n", name)
+		}
+	}
+	if len(errNames) > 0 {
+		return fmt.Errorf("Error: failed to checkpoint containers: %v", errNames)
+	}
+	return nil
+}
+
+// CmdRestore restores the processes of one or more checkpointed containers.
+//
+// Usage: docker restore CONTAINER [CONTAINER...]
+func (cli *DockerCli) CmdRestore(args ...string) error {
+	cmd := Cli.Subcmd("restore", []string{"CONTAINER [CONTAINER...]"}, "Restore one or more checkpointed containers", true)
+	cmd.Require(flag.Min, 1)
+
+	cmd.ParseFlags(args, true)
+
+	var errNames []string
+	for _, name := range cmd.Args() {
+		if _, _, err := readBody(cli.call("POST", fmt.Sprintf("/containers/%s/restore", name), nil, nil)); err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			errNames = append(errNames, name)
+		} else {
+			fmt.Fprintf(cli.out, "%s\n", name)
+		}
+	}
+	if len(errNames) > 0 {
+		return fmt.Errorf("Error: failed to restore containers: %v", errNames)
+	}
+	return nil
+}
@@ -25,6 +25,36 @@ const (
 	acrossContainers = fromContainer | toContainer
 )
 
+// volumeCpPrefix is the prefix of the arguments of a copy naming a volume
+// instead of a container, as in `vol:NAME:PATH`.
+const volumeCpPrefix = "vol"
+
+// cpTarget is the container or the volume of a copy.
+type cpTarget struct {
+	container string
+	volume    string
+}
+
+func (t cpTarget) isSet() bool {
+	return t.container != "" || t.volume != ""
+}
+
+func (t cpTarget) String() string {
+	if t.volume != "" {
+		return volumeCpPrefix + ":" + t.volume
+	}
+	return t.container
+}
+
+// archiveURL returns the URL of the archive endpoint of the target, with the
+// given query.
+func (t cpTarget) archiveURL(query url.Values) string {
+	if t.volume != "" {
+		return fmt.Sprintf("/volumes/%s/archive?%s", t.volume, query.Encode())
+	}
+	return fmt.Sprintf("/containers/%s/archive?%s", t.container, query.Encode())
+}
+
 // CmdCp copies files/folders to or from a path in a container.
 //
 // When copying from a container, if LOCALPATH is '-' the data is written as a
@@ -34,15 +64,22 @@ const (
 // archive file from STDIN, and the destination CONTAINER:PATH, must specify
 // a directory.
 //
+// When copying between containers, the data is copied by the daemon. A
+// volume can be used instead of any container with vol:NAME:PATH.
+//
 // Usage:
-// 	docker cp CONTAINER:PATH LOCALPATH|-
-// 	docker cp LOCALPATH|- CONTAINER:PATH
+// 	docker cp [OPTIONS] CONTAINER:PATH LOCALPATH|-
+// 	docker cp [OPTIONS] LOCALPATH|- CONTAINER:PATH
+// 	docker cp [OPTIONS] CONTAINER:PATH CONTAINER:PATH
 func (cli *DockerCli) CmdCp(args ...string) error {
 	cmd := Cli.Subcmd(
 		"cp",
-		[]string{"CONTAINER:PATH LOCALPATH|-", "LOCALPATH|- CONTAINER:PATH"},
+		[]string{"[OPTIONS] CONTAINER:PATH LOCALPATH|-", "[OPTIONS] LOCALPATH|- CONTAINER:PATH", "[OPTIONS] CONTAINER:PATH CONTAINER:PATH"},
 		strings.Join([]string{
-			"Copy files/folders between a container and your host.\n",
+			"Copy files/folders between a container and your host,\n",
+			"or between containers.\n",
+			"Use 'vol:NAME:PATH' instead of 'CONTAINER:PATH' to copy\n",
+			"from or to the volume NAME.\n",
 			"Use '-' as the source to read a tar archive from stdin\n",
 			"and extract it to a directory destination in a container.\n",
 			"Use '-' as the destination to stream a tar archive of a\n",
@@ -50,6 +87,8 @@ func (cli *DockerCli) CmdCp(args ...string) error {
 		}, ""),
 		true,
 	)
+	copyUIDGID := cmd.Bool([]string{"a", "-archive"}, false, "Archive mode (copy all uid/gid information)")
+	chown := cmd.String([]string{"-chown"}, "", "Give the copied files to UID:GID")
 
 	cmd.Require(flag.Exact, 2)
 	cmd.ParseFlags(args, true)
@@ -61,25 +100,28 @@ func (cli *DockerCli) CmdCp(args ...string) error {
 		return fmt.Errorf("destination can not be empty")
 	}
 
-	srcContainer, srcPath := splitCpArg(cmd.Arg(0))
-	dstContainer, dstPath := splitCpArg(cmd.Arg(1))
+	src, srcPath := splitCpArg(cmd.Arg(0))
+	dst, dstPath := splitCpArg(cmd.Arg(1))
 
 	var direction copyDirection
-	if srcContainer != "" {
+	if src.isSet() {
 		direction |= fromContainer
 	}
-	if dstContainer != "" {
+	if dst.isSet() {
 		direction |= toContainer
 	}
 
+	if direction == fromContainer && (*copyUIDGID || *chown != "") {
+		return fmt.Errorf("the ownership of the files can only be set when copying to a container")
+	}
+
 	switch direction {
 	case fromContainer:
-		return cli.copyFromContainer(srcContainer, srcPath, dstPath)
+		return cli.copyFromContainer(src, srcPath, dstPath)
 	case toContainer:
-		return cli.copyToContainer(srcPath, dstContainer, dstPath)
+		return cli.copyToContainer(srcPath, dst, dstPath, *copyUIDGID, *chown)
 	case acrossContainers:
-		// Copying between containers isn't supported.
-		return fmt.Errorf("copying between containers is not supported")
+		return cli.copyAcrossContainers(src, srcPath, dst, dstPath, *copyUIDGID, *chown)
 	default:
 		// User didn't specify any container.
 		return fmt.Errorf("must specify at least one container source")
@@ -100,10 +142,13 @@ func (cli *DockerCli) CmdCp(args ...string) error {
 // so we have to check for a `/` or `.` prefix. Also, in the case of a Windows
 // client, a `:` could be part of an absolute Windows path, in which case it
 // is immediately proceeded by a backslash.
-func splitCpArg(arg string) (container, path string) {
+//
+// A VOLUME:PATH is given as `vol:VOLUME:PATH`, so a container named `vol` has
+// to be given by ID.
+func splitCpArg(arg string) (target cpTarget, path string) {
 	if filepath.IsAbs(arg) {
 		// Explicit local absolute path, e.g., `C:\foo` or `/foo`.
-		return "", arg
+		return cpTarget{}, arg
 	}
 
 	parts := strings.SplitN(arg, ":", 2)
@@ -111,19 +156,27 @@ func splitCpArg(arg string) (container, path string) {
 	if len(parts) == 1 || strings.HasPrefix(parts[0], ".") {
 		// Either there's no `:` in the arg
 		// OR it's an explicit local relative path like `./file:name.txt`.
-		return "", arg
+		return cpTarget{}, arg
+	}
+
+	if parts[0] == volumeCpPrefix {
+		volumeParts := strings.SplitN(parts[1], ":", 2)
+		if len(volumeParts) == 1 {
+			return cpTarget{volume: volumeParts[0]}, ""
+		}
+		return cpTarget{volume: volumeParts[0]}, volumeParts[1]
 	}
 
-	return parts[0], parts[1]
+	return cpTarget{container: parts[0]}, parts[1]
 }
 
-func (cli *DockerCli) statContainerPath(containerName, path string) (types.ContainerPathStat, error) {
+func (cli *DockerCli) statContainerPath(target cpTarget, path string) (types.ContainerPathStat, error) {
 	var stat types.ContainerPathStat
 
 	query := make(url.Values, 1)
 	query.Set("path", filepath.ToSlash(path)) // Normalize the paths used in the API.
 
-	urlStr := fmt.Sprintf("/containers/%s/archive?%s", containerName, query.Encode())
+	urlStr := target.archiveURL(query)
 
 	response, err := cli.call("HEAD", urlStr, nil, nil)
 	if err != nil {
@@ -160,7 +213,7 @@ func resolveLocalPath(localPath string) (absPath string, err error) {
 	return archive.PreserveTrailingDotOrSeparator(absPath, localPath), nil
 }
 
-func (cli *DockerCli) copyFromContainer(srcContainer, srcPath, dstPath string) (err error) {
+func (cli *DockerCli) copyFromContainer(src cpTarget, srcPath, dstPath string) (err error) {
 	if dstPath != "-" {
 		// Get an absolute destination path.
 		dstPath, err = resolveLocalPath(dstPath)
@@ -172,7 +225,7 @@ func (cli *DockerCli) copyFromContainer(srcContainer, srcPath, dstPath string) (
 	query := make(url.Values, 1)
 	query.Set("path", filepath.ToSlash(srcPath)) // Normalize the paths used in the API.
 
-	urlStr := fmt.Sprintf("/containers/%s/archive?%s", srcContainer, query.Encode())
+	urlStr := src.archiveURL(query)
 
 	response, err := cli.call("GET", urlStr, nil, nil)
 	if err != nil {
@@ -215,7 +268,7 @@ func (cli *DockerCli) copyFromContainer(srcContainer, srcPath, dstPath string) (
 	return archive.CopyTo(response.body, srcInfo, dstPath)
 }
 
-func (cli *DockerCli) copyToContainer(srcPath, dstContainer, dstPath string) (err error) {
+func (cli *DockerCli) copyToContainer(srcPath string, dst cpTarget, dstPath string, copyUIDGID bool, chown string) (err error) {
 	if srcPath != "-" {
 		// Get an absolute source path.
 		srcPath, err = resolveLocalPath(srcPath)
@@ -231,7 +284,7 @@ func (cli *DockerCli) copyToContainer(srcPath, dstContainer, dstPath string) (er
 
 	// Prepare destination copy info by stat-ing the container path.
 	dstInfo := archive.CopyInfo{Path: dstPath}
-	dstStat, err := cli.statContainerPath(dstContainer, dstPath)
+	dstStat, err := cli.statContainerPath(dst, dstPath)
 
 	// If the destination is a symbolic link, we should evaluate it.
 	if err == nil && dstStat.Mode&os.ModeSymlink != 0 {
@@ -243,7 +296,7 @@ func (cli *DockerCli) copyToContainer(srcPath, dstContainer, dstPath string) (er
 		}
 
 		dstInfo.Path = linkTarget
-		dstStat, err = cli.statContainerPath(dstContainer, linkTarget)
+		dstStat, err = cli.statContainerPath(dst, linkTarget)
 	}
 
 	// Ignore any error and assume that the parent directory of the destination
@@ -266,7 +319,7 @@ func (cli *DockerCli) copyToContainer(srcPath, dstContainer, dstPath string) (er
 		content = os.Stdin
 		resolvedDstPath = dstInfo.Path
 		if !dstInfo.IsDir {
-			return fmt.Errorf("destination %q must be a directory", fmt.Sprintf("%s:%s", dstContainer, dstPath))
+			return fmt.Errorf("destination %q must be a directory", fmt.Sprintf("%s:%s", dst, dstPath))
 		}
 	} else {
 		// Prepare source copy info.
@@ -303,12 +356,18 @@ func (cli *DockerCli) copyToContainer(srcPath, dstContainer, dstPath string) (er
 		content = preparedArchive
 	}
 
-	query := make(url.Values, 2)
+	query := make(url.Values, 4)
 	query.Set("path", filepath.ToSlash(resolvedDstPath)) // Normalize the paths used in the API.
 	// Do not allow for an existing directory to be overwritten by a non-directory and vice versa.
 	query.Set("noOverwriteDirNonDir", "true")
+	if copyUIDGID {
+		query.Set("copyUIDGID", "true")
+	}
+	if chown != "" {
+		query.Set("chown", chown)
+	}
 
-	urlStr := fmt.Sprintf("/containers/%s/archive?%s", dstContainer, query.Encode())
+	urlStr := dst.archiveURL(query)
 
 	response, err := cli.stream("PUT", urlStr, &streamOpts{in: content})
 	if err != nil {
@@ -322,3 +381,34 @@ func (cli *DockerCli) copyToContainer(srcPath, dstContainer, dstPath string) (er
 
 	return nil
 }
+
+func (cli *DockerCli) copyAcrossContainers(src cpTarget, srcPath string, dst cpTarget, dstPath string, copyUIDGID bool, chown string) error {
+	// The daemon streams the archive of the source to the destination, with
+	// the same copy behavior as copying through the client.
+	config := types.ArchiveCopyConfig{
+		Source: types.ArchiveCopyEndpoint{
+			Container: src.container,
+			Volume:    src.volume,
+			Path:      filepath.ToSlash(srcPath), // Normalize the paths used in the API.
+		},
+		Destination: types.ArchiveCopyEndpoint{
+			Container: dst.container,
+			Volume:    dst.volume,
+			Path:      filepath.ToSlash(dstPath),
+		},
+		CopyUIDGID: copyUIDGID,
+		Chown:      chown,
+	}
+
+	response, err := cli.call("POST", "/archive/copy", config, nil)
+	if err != nil {
+		return err
+	}
+	defer response.body.Close()
+
+	if response.statusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from daemon: %d", response.statusCode)
+	}
+
+	return nil
+}
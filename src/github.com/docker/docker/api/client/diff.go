@@ -2,12 +2,17 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 
 	"github.com/docker/docker/api/types"
 	Cli "github.com/docker/docker/cli"
+	"github.com/docker/docker/opts"
 	"github.com/docker/docker/pkg/archive"
 	flag "github.com/docker/docker/pkg/mflag"
+	"github.com/docker/docker/pkg/units"
 )
 
 // CmdDiff shows changes on a container's filesystem.
@@ -16,9 +21,14 @@ import (
 // character that indicates the status of the file: C (modified), A (added),
 // or D (deleted).
 //
-// Usage: docker diff CONTAINER
+// Usage: docker diff [OPTIONS] CONTAINER
 func (cli *DockerCli) CmdDiff(args ...string) error {
 	cmd := Cli.Subcmd("diff", []string{"CONTAINER"}, "Inspect changes on a container's filesystem", true)
+	flInclude := opts.NewListOpts(nil)
+	cmd.Var(&flInclude, []string{"-include"}, "Only show the changes matching a path pattern")
+	flExclude := opts.NewListOpts(nil)
+	cmd.Var(&flExclude, []string{"-exclude"}, "Do not show the changes matching a path pattern")
+	verbose := cmd.Bool([]string{"v", "-verbose"}, false, "Show the mode and size of the changed files")
 	cmd.Require(flag.Exact, 1)
 
 	cmd.ParseFlags(args, true)
@@ -27,19 +37,40 @@ func (cli *DockerCli) CmdDiff(args ...string) error {
 		return fmt.Errorf("Container name cannot be empty")
 	}
 
-	serverResp, err := cli.call("GET", "/containers/"+cmd.Arg(0)+"/changes", nil, nil)
+	v := url.Values{}
+	v.Set("stream", "1")
+	for _, include := range flInclude.GetAll() {
+		v.Add("include", include)
+	}
+	for _, exclude := range flExclude.GetAll() {
+		v.Add("exclude", exclude)
+	}
+
+	serverResp, err := cli.call("GET", "/containers/"+cmd.Arg(0)+"/changes?"+v.Encode(), nil, nil)
 	if err != nil {
 		return err
 	}
 
 	defer serverResp.body.Close()
 
-	changes := []types.ContainerChange{}
-	if err := json.NewDecoder(serverResp.body).Decode(&changes); err != nil {
-		return err
-	}
+	dec := json.NewDecoder(serverResp.body)
+	for {
+		// The stream ends with an error if the changes could not all
+		// be computed.
+		var change struct {
+			types.ContainerChange
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&change); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if change.Error != "" {
+			return errors.New(change.Error)
+		}
 
-	for _, change := range changes {
 		var kind string
 		switch change.Kind {
 		case archive.ChangeModify:
@@ -49,7 +80,18 @@ func (cli *DockerCli) CmdDiff(args ...string) error {
 		case archive.ChangeDelete:
 			kind = "D"
 		}
-		fmt.Fprintf(cli.out, "%s %s\n", kind, change.Path)
+		if !*verbose {
+			fmt.Fprintf(cli.out, "%s %s\n", kind, change.Path)
+			continue
+		}
+		mode, size := "-", "-"
+		if change.Kind != archive.ChangeDelete {
+			mode = change.Mode.String()
+			if change.Mode.IsRegular() {
+				size = units.HumanSize(float64(change.Size))
+			}
+		}
+		fmt.Fprintf(cli.out, "%s %s %10s %s\n", kind, mode, size, change.Path)
 	}
 
 	return nil
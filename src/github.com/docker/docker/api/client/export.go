@@ -3,9 +3,11 @@ package client
 import (
 	"errors"
 	"io"
+	"net/url"
 	"os"
 
 	Cli "github.com/docker/docker/cli"
+	"github.com/docker/docker/opts"
 	flag "github.com/docker/docker/pkg/mflag"
 )
 
@@ -17,6 +19,11 @@ import (
 func (cli *DockerCli) CmdExport(args ...string) error {
 	cmd := Cli.Subcmd("export", []string{"CONTAINER"}, "Export the contents of a container's filesystem as a tar archive", true)
 	outfile := cmd.String([]string{"o", "-output"}, "", "Write to a file, instead of STDOUT")
+	flInclude := opts.NewListOpts(nil)
+	cmd.Var(&flInclude, []string{"-include"}, "Only export a path and its content")
+	flExclude := opts.NewListOpts(nil)
+	cmd.Var(&flExclude, []string{"-exclude"}, "Do not export the paths matching a pattern")
+	changes := cmd.Bool([]string{"-changes"}, false, "Only export the changes since the image, as a layer")
 	cmd.Require(flag.Exact, 1)
 
 	cmd.ParseFlags(args, true)
@@ -34,12 +41,23 @@ func (cli *DockerCli) CmdExport(args ...string) error {
 		return errors.New("Cowardly refusing to save to a terminal. Use the -o flag or redirect.")
 	}
 
+	v := url.Values{}
+	for _, include := range flInclude.GetAll() {
+		v.Add("include", include)
+	}
+	for _, exclude := range flExclude.GetAll() {
+		v.Add("exclude", exclude)
+	}
+	if *changes {
+		v.Set("changes", "1")
+	}
+
 	image := cmd.Arg(0)
 	sopts := &streamOpts{
 		rawTerminal: true,
 		out:         output,
 	}
-	if _, err := cli.stream("GET", "/containers/"+image+"/export", sopts); err != nil {
+	if _, err := cli.stream("GET", "/containers/"+image+"/export?"+v.Encode(), sopts); err != nil {
 		return err
 	}
 
@@ -98,19 +98,7 @@ func (cli *DockerCli) CmdImages(args ...string) error {
 			repoDigests = []string{}
 		}
 
-		// combine the tags and digests lists
-		tagsAndDigests := append(repoTags, repoDigests...)
-		for _, repoAndRef := range tagsAndDigests {
-			repo, ref := parsers.ParseRepositoryTag(repoAndRef)
-			// default tag and digest to none - if there's a value, it'll be set below
-			tag := "<none>"
-			digest := "<none>"
-			if utils.DigestReference(ref) {
-				digest = ref
-			} else {
-				tag = ref
-			}
-
+		printRef := func(repo, tag, digest string) {
 			if !*quiet {
 				if *showDigests {
 					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s ago\t%s\n", repo, tag, digest, ID, units.HumanDuration(time.Now().UTC().Sub(time.Unix(int64(image.Created), 0))), units.HumanSize(float64(image.VirtualSize)))
@@ -121,6 +109,50 @@ func (cli *DockerCli) CmdImages(args ...string) error {
 				fmt.Fprintln(w, ID)
 			}
 		}
+
+		// group the tags and digests by repository, so that the tags are
+		// shown along with the digest of their repository
+		var repos []string
+		tagsByRepo := make(map[string][]string)
+		digestsByRepo := make(map[string][]string)
+		for _, repoAndRef := range append(repoTags, repoDigests...) {
+			repo, ref := parsers.ParseRepositoryTag(repoAndRef)
+			if len(tagsByRepo[repo]) == 0 && len(digestsByRepo[repo]) == 0 {
+				repos = append(repos, repo)
+			}
+			if utils.DigestReference(ref) {
+				digestsByRepo[repo] = append(digestsByRepo[repo], ref)
+			} else {
+				tagsByRepo[repo] = append(tagsByRepo[repo], ref)
+			}
+		}
+
+		for _, repo := range repos {
+			// tags are shown with the first digest of their repository
+			digests := digestsByRepo[repo]
+			digest := "<none>"
+			if len(digests) > 0 {
+				digest = digests[0]
+			}
+			if tags := tagsByRepo[repo]; len(tags) > 0 {
+				for _, tag := range tags {
+					printRef(repo, tag, digest)
+				}
+				// the other digests are only shown when asked for
+				if len(digests) > 0 {
+					digests = digests[1:]
+				}
+			} else if !*showDigests {
+				printRef(repo, "<none>", digest)
+				continue
+			}
+			if !*showDigests {
+				continue
+			}
+			for _, digest := range digests {
+				printRef(repo, "<none>", digest)
+			}
+		}
 	}
 
 	if !*quiet {
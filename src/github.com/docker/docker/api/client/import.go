@@ -23,6 +23,7 @@ func (cli *DockerCli) CmdImport(args ...string) error {
 	cmd := Cli.Subcmd("import", []string{"file|URL|- [REPOSITORY[:TAG]]"}, "Create an empty filesystem image and import the contents of the\ntarball (.tar, .tar.gz, .tgz, .bzip, .tar.xz, .txz) into it, then\noptionally tag it.", true)
 	flChanges := opts.NewListOpts(nil)
 	cmd.Var(&flChanges, []string{"c", "-change"}, "Apply Dockerfile instruction to the created image")
+	flParent := cmd.String([]string{"-parent"}, "", "Apply the tarball as a layer on top of this image")
 	cmd.Require(flag.Min, 1)
 
 	cmd.ParseFlags(args, true)
@@ -35,6 +36,9 @@ func (cli *DockerCli) CmdImport(args ...string) error {
 
 	v.Set("fromSrc", src)
 	v.Set("repo", repository)
+	if *flParent != "" {
+		v.Set("parent", *flParent)
+	}
 	for _, change := range flChanges.GetAll() {
 		v.Add("changes", change)
 	}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/docker/docker/api/types"
 	Cli "github.com/docker/docker/cli"
-	"github.com/docker/docker/cliconfig"
 	flag "github.com/docker/docker/pkg/mflag"
 	"github.com/docker/docker/pkg/term"
 	"github.com/docker/docker/registry"
@@ -56,9 +55,9 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 		return string(line)
 	}
 
-	authconfig, ok := cli.configFile.AuthConfigs[serverAddress]
-	if !ok {
-		authconfig = cliconfig.AuthConfig{}
+	authconfig, err := cli.configFile.GetAuthConfig(serverAddress)
+	if err != nil {
+		return err
 	}
 
 	if username == "" {
@@ -116,7 +115,9 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 
 	serverResp, err := cli.call("POST", "/auth", cli.configFile.AuthConfigs[serverAddress], nil)
 	if serverResp.statusCode == 401 {
-		delete(cli.configFile.AuthConfigs, serverAddress)
+		if err2 := cli.configFile.EraseAuthConfig(serverAddress); err2 != nil {
+			fmt.Fprintf(cli.out, "WARNING: could not erase credentials: %v\n", err2)
+		}
 		if err2 := cli.configFile.Save(); err2 != nil {
 			fmt.Fprintf(cli.out, "WARNING: could not save config file: %v\n", err2)
 		}
@@ -135,10 +136,17 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 		return err
 	}
 
+	if err := cli.configFile.StoreAuthConfig(authconfig); err != nil {
+		return fmt.Errorf("Error saving credentials: %v", err)
+	}
 	if err := cli.configFile.Save(); err != nil {
 		return fmt.Errorf("Error saving config file: %v", err)
 	}
-	fmt.Fprintf(cli.out, "WARNING: login credentials saved in %s\n", cli.configFile.Filename())
+	if helper := cli.configFile.CredentialHelper(serverAddress); helper != "" {
+		fmt.Fprintf(cli.out, "Login credentials saved in credential helper %s\n", helper)
+	} else {
+		fmt.Fprintf(cli.out, "WARNING: login credentials saved in %s\n", cli.configFile.Filename())
+	}
 
 	if response.Status != "" {
 		fmt.Fprintf(cli.out, "%s\n", response.Status)
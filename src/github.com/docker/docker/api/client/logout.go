@@ -24,11 +24,13 @@ func (cli *DockerCli) CmdLogout(args ...string) error {
 		serverAddress = cmd.Arg(0)
 	}
 
-	if _, ok := cli.configFile.AuthConfigs[serverAddress]; !ok {
+	if _, ok := cli.configFile.AuthConfigs[serverAddress]; !ok && cli.configFile.CredentialHelper(serverAddress) == "" {
 		fmt.Fprintf(cli.out, "Not logged in to %s\n", serverAddress)
 	} else {
 		fmt.Fprintf(cli.out, "Remove login credentials for %s\n", serverAddress)
-		delete(cli.configFile.AuthConfigs, serverAddress)
+		if err := cli.configFile.EraseAuthConfig(serverAddress); err != nil {
+			return fmt.Errorf("Failed to erase credentials: %v", err)
+		}
 
 		if err := cli.configFile.Save(); err != nil {
 			return fmt.Errorf("Failed to save docker config: %v", err)
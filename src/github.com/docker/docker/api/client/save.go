@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"strconv"
 
 	Cli "github.com/docker/docker/cli"
 	flag "github.com/docker/docker/pkg/mflag"
@@ -18,6 +19,9 @@ import (
 func (cli *DockerCli) CmdSave(args ...string) error {
 	cmd := Cli.Subcmd("save", []string{"IMAGE [IMAGE...]"}, "Save an image(s) to a tar archive (streamed to STDOUT by default)", true)
 	outfile := cmd.String([]string{"o", "-output"}, "", "Write to an file, instead of STDOUT")
+	compression := cmd.String([]string{"-compression"}, "none", "Compress the layers with none, gzip, bzip2, xz or zstd")
+	compressionLevel := cmd.Int([]string{"-compression-level"}, -1, "Compression level of the layers, -1 for the default level")
+	format := cmd.String([]string{"-format"}, "docker", "Layout of the tar archive, docker or oci")
 	cmd.Require(flag.Min, 1)
 
 	cmd.ParseFlags(args, true)
@@ -40,13 +44,20 @@ func (cli *DockerCli) CmdSave(args ...string) error {
 		out:         output,
 	}
 
+	v := url.Values{}
+	if *format != "docker" {
+		v.Set("format", *format)
+	}
+	if *compression != "none" {
+		v.Set("compression", *compression)
+		v.Set("compressionlevel", strconv.Itoa(*compressionLevel))
+	}
 	if len(cmd.Args()) == 1 {
 		image := cmd.Arg(0)
-		if _, err := cli.stream("GET", "/images/"+image+"/get", sopts); err != nil {
+		if _, err := cli.stream("GET", "/images/"+image+"/get?"+v.Encode(), sopts); err != nil {
 			return err
 		}
 	} else {
-		v := url.Values{}
 		for _, arg := range cmd.Args() {
 			v.Add("names", arg)
 		}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/types"
+	Cli "github.com/docker/docker/cli"
+	flag "github.com/docker/docker/pkg/mflag"
+	"github.com/docker/libtrust"
+)
+
+// signingKeyPath returns the path of the default key images are signed with.
+// Its public key is saved next to it, with the .pub extension.
+func (cli *DockerCli) signingKeyPath() string {
+	return filepath.Join(cli.trustDirectory(), "signing", "key.json")
+}
+
+// CmdSign signs an image with a key of the trust directory. The detached
+// signature is stored by the daemon along with the image, without any
+// Notary server.
+//
+// Usage: docker sign [OPTIONS] IMAGE
+func (cli *DockerCli) CmdSign(args ...string) error {
+	cmd := Cli.Subcmd("sign", []string{"IMAGE"}, "Sign an image with a local key", true)
+	keyPath := cmd.String([]string{"k", "-key"}, cli.signingKeyPath(), "Private key to sign the image with")
+	cmd.Require(flag.Exact, 1)
+
+	cmd.ParseFlags(args, true)
+
+	key, err := api.LoadOrCreateTrustKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	pubKeyPath := strings.TrimSuffix(*keyPath, filepath.Ext(*keyPath)) + ".pub"
+	if err := libtrust.SavePublicKey(pubKeyPath, key.PublicKey()); err != nil {
+		return err
+	}
+
+	name := cmd.Arg(0)
+	serverResp, err := cli.call("GET", "/images/"+name+"/json", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer serverResp.body.Close()
+
+	var img types.ImageInspect
+	if err := json.NewDecoder(serverResp.body).Decode(&img); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(types.ImageSignaturePayload{ImageID: img.Id})
+	if err != nil {
+		return err
+	}
+	js, err := libtrust.NewJSONSignature(payload)
+	if err != nil {
+		return err
+	}
+	if err := js.Sign(key); err != nil {
+		return err
+	}
+	signature, err := js.PrettySignature("signatures")
+	if err != nil {
+		return err
+	}
+
+	headers := map[string][]string{"Content-Type": {"application/json"}}
+	if _, _, err := readBody(cli.clientRequest("POST", "/images/"+name+"/signatures", bytes.NewReader(signature), headers)); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Signed %s with key %s\n", img.Id, key.KeyID())
+	fmt.Fprintf(cli.out, "Public key: %s\n", pubKeyPath)
+	return nil
+}
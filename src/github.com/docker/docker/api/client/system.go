@@ -0,0 +1,162 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	Cli "github.com/docker/docker/cli"
+	flag "github.com/docker/docker/pkg/mflag"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/units"
+)
+
+// CmdSystem manages the Docker installation.
+//
+// Usage: docker system COMMAND
+func (cli *DockerCli) CmdSystem(args ...string) error {
+	cmd := Cli.Subcmd("system", []string{"COMMAND"}, "Manage Docker\n\nCommands:\n  df    Show docker disk usage", true)
+	cmd.Require(flag.Min, 1)
+
+	cmd.ParseFlags(args, true)
+
+	cmd.Usage()
+	return fmt.Errorf("docker system: '%s' is not a docker system command.", cmd.Arg(0))
+}
+
+// CmdSystemDf shows the disk usage of images, containers, their logs and
+// volumes, and how much of it could be reclaimed by removing what is not used.
+//
+// Usage: docker system df [OPTIONS]
+func (cli *DockerCli) CmdSystemDf(args ...string) error {
+	cmd := Cli.Subcmd("system df", nil, "Show docker disk usage", true)
+	verbose := cmd.Bool([]string{"v", "-verbose"}, false, "Show detailed information on space usage")
+	cmd.Require(flag.Exact, 0)
+
+	cmd.ParseFlags(args, true)
+
+	serverResp, err := cli.call("GET", "/system/df", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer serverResp.body.Close()
+
+	du := &types.DiskUsage{}
+	if err := json.NewDecoder(serverResp.body).Decode(du); err != nil {
+		return fmt.Errorf("Error reading remote disk usage: %v", err)
+	}
+
+	if *verbose {
+		printDiskUsageVerbose(cli.out, du)
+		return nil
+	}
+	printDiskUsage(cli.out, du)
+	return nil
+}
+
+// printDiskUsage prints the disk usage summary of each type. The reclaimable
+// space is what removing the images without containers, the stopped
+// containers and their logs would free.
+func printDiskUsage(out io.Writer, du *types.DiskUsage) {
+	w := tabwriter.NewWriter(out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE")
+
+	var activeImages int
+	var imagesReclaimable int64
+	for _, img := range du.Images {
+		if img.Containers > 0 {
+			activeImages++
+		} else {
+			imagesReclaimable += img.UniqueSize
+		}
+	}
+	fmt.Fprintf(w, "Images\t%d\t%d\t%s\t%s\n", len(du.Images), activeImages, units.HumanSize(float64(du.LayersSize)), reclaimable(imagesReclaimable, du.LayersSize))
+
+	var activeContainers int
+	var containersSize, containersReclaimable, logsSize, logsReclaimable int64
+	for _, c := range du.Containers {
+		running := strings.HasPrefix(c.Status, "Up")
+		if running {
+			activeContainers++
+		}
+		if c.SizeRw > 0 {
+			containersSize += c.SizeRw
+			if !running {
+				containersReclaimable += c.SizeRw
+			}
+		}
+		logsSize += c.LogSize
+		if !running {
+			logsReclaimable += c.LogSize
+		}
+	}
+	fmt.Fprintf(w, "Containers\t%d\t%d\t%s\t%s\n", len(du.Containers), activeContainers, units.HumanSize(float64(containersSize)), reclaimable(containersReclaimable, containersSize))
+	fmt.Fprintf(w, "Logs\t%d\t%d\t%s\t%s\n", len(du.Containers), activeContainers, units.HumanSize(float64(logsSize)), reclaimable(logsReclaimable, logsSize))
+
+	var volumesSize int64
+	for _, v := range du.Volumes {
+		if v.Size > 0 {
+			volumesSize += v.Size
+		}
+	}
+	fmt.Fprintf(w, "Volumes\t%d\t%d\t%s\t%s\n", len(du.Volumes), len(du.Volumes), units.HumanSize(float64(volumesSize)), reclaimable(0, volumesSize))
+	w.Flush()
+}
+
+// printDiskUsageVerbose prints the disk usage of each image, container and
+// volume.
+func printDiskUsageVerbose(out io.Writer, du *types.DiskUsage) {
+	fmt.Fprintf(out, "Images space usage:\n\n")
+	w := tabwriter.NewWriter(out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\tSHARED SIZE\tUNIQUE SIZE\tCONTAINERS")
+	for _, img := range du.Images {
+		created := units.HumanDuration(time.Now().UTC().Sub(time.Unix(int64(img.Created), 0)))
+		for _, repoTag := range img.RepoTags {
+			repo, tag := parsers.ParseRepositoryTag(repoTag)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\t%s\t%s\t%d\n", repo, tag, stringid.TruncateID(img.ID), created, units.HumanSize(float64(img.Size)), units.HumanSize(float64(img.SharedSize)), units.HumanSize(float64(img.UniqueSize)), img.Containers)
+		}
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nContainers space usage:\n\n")
+	w = tabwriter.NewWriter(out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tIMAGE\tCREATED\tSTATUS\tSIZE\tLOG SIZE\tNAMES")
+	for _, c := range du.Containers {
+		size := "N/A"
+		if c.SizeRw >= 0 {
+			size = units.HumanSize(float64(c.SizeRw))
+		}
+		var names []string
+		for _, name := range c.Names {
+			names = append(names, strings.TrimPrefix(name, "/"))
+		}
+		created := units.HumanDuration(time.Now().UTC().Sub(time.Unix(int64(c.Created), 0)))
+		fmt.Fprintf(w, "%s\t%s\t%s ago\t%s\t%s\t%s\t%s\n", stringid.TruncateID(c.ID), c.Image, created, c.Status, size, units.HumanSize(float64(c.LogSize)), strings.Join(names, ","))
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nVolumes space usage:\n\n")
+	w = tabwriter.NewWriter(out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "VOLUME NAME\tDRIVER\tCONTAINERS\tSIZE")
+	for _, v := range du.Volumes {
+		size := "N/A"
+		if v.Size >= 0 {
+			size = units.HumanSize(float64(v.Size))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", v.Name, v.Driver, v.Containers, size)
+	}
+	w.Flush()
+}
+
+// reclaimable formats the size that could be reclaimed out of total.
+func reclaimable(size, total int64) string {
+	if total <= 0 {
+		return units.HumanSize(float64(size))
+	}
+	return fmt.Sprintf("%s (%d%%)", units.HumanSize(float64(size)), size*100/total)
+}
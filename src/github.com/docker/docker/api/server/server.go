@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -24,7 +25,9 @@ import (
 	"github.com/docker/docker/builder"
 	"github.com/docker/docker/cliconfig"
 	"github.com/docker/docker/daemon"
+	"github.com/docker/docker/daemon/firewall"
 	"github.com/docker/docker/graph"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/parsers"
@@ -264,6 +267,24 @@ func (s *Server) getVersion(version version.Version, w http.ResponseWriter, r *h
 	return writeJSON(w, http.StatusOK, v)
 }
 
+func (s *Server) getFirewallRules(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	return writeJSON(w, http.StatusOK, s.daemon.FirewallRules(nil))
+}
+
+func (s *Server) postFirewallRules(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := checkForJson(r); err != nil {
+		return err
+	}
+
+	policy, err := firewall.Parse(r.Body)
+	if err != nil {
+		return fmt.Errorf("Invalid firewall policy: %v", err)
+	}
+
+	// The policy is only compiled against the running containers, not applied
+	return writeJSON(w, http.StatusOK, s.daemon.FirewallRules(policy))
+}
+
 func (s *Server) postContainersKill(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -345,12 +366,56 @@ func (s *Server) postContainersUnpause(version version.Version, w http.ResponseW
 	return nil
 }
 
+func (s *Server) postContainersCheckpoint(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	if err := s.daemon.ContainerCheckpoint(vars["name"], boolValue(r, "leaveRunning")); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func (s *Server) postContainersRestore(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	if err := s.daemon.ContainerRestore(vars["name"]); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
 func (s *Server) getContainersExport(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
 
-	return s.daemon.ContainerExport(vars["name"], w)
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	exportConfig := &daemon.ContainerExportConfig{
+		IncludeFiles:    r.Form["include"],
+		ExcludePatterns: r.Form["exclude"],
+		Changes:         boolValue(r, "changes"),
+		Outstream:       w,
+	}
+	return s.daemon.ContainerExport(vars["name"], exportConfig)
 }
 
 func (s *Server) getImagesJSON(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
@@ -382,6 +447,15 @@ func (s *Server) getInfo(version version.Version, w http.ResponseWriter, r *http
 	return writeJSON(w, http.StatusOK, info)
 }
 
+func (s *Server) getSystemDiskUsage(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	du, err := s.daemon.SystemDiskUsage()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, du)
+}
+
 func (s *Server) getEvents(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -523,12 +597,43 @@ func (s *Server) getContainersChanges(version version.Version, w http.ResponseWr
 		return fmt.Errorf("Missing parameter")
 	}
 
-	changes, err := s.daemon.ContainerChanges(vars["name"])
-	if err != nil {
+	if err := parseForm(r); err != nil {
 		return err
 	}
 
-	return writeJSON(w, http.StatusOK, changes)
+	changesConfig := &daemon.ContainerChangesConfig{
+		IncludePatterns: r.Form["include"],
+		ExcludePatterns: r.Form["exclude"],
+	}
+
+	if !boolValue(r, "stream") {
+		changes := []*types.ContainerChange{}
+		if err := s.daemon.ContainerChanges(vars["name"], changesConfig, func(change *types.ContainerChange) error {
+			changes = append(changes, change)
+			return nil
+		}); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, changes)
+	}
+
+	// The response is only sent with the first change, so that errors
+	// computing the changes are still returned as such. Errors after it end
+	// the stream.
+	w.Header().Set("Content-Type", "application/json")
+	output := ioutils.NewWriteFlusher(w)
+	enc := json.NewEncoder(output)
+	sent := false
+	err := s.daemon.ContainerChanges(vars["name"], changesConfig, func(change *types.ContainerChange) error {
+		sent = true
+		return enc.Encode(change)
+	})
+	if err != nil && sent {
+		sf := streamformatter.NewJSONStreamFormatter()
+		output.Write(sf.FormatError(err))
+		return nil
+	}
+	return err
 }
 
 func (s *Server) getContainersTop(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
@@ -685,6 +790,22 @@ func (s *Server) postImagesTag(version version.Version, w http.ResponseWriter, r
 	return nil
 }
 
+func (s *Server) postImagesSignatures(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+
+	signature, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	keyIDs, err := s.daemon.Repositories().AddSignature(vars["name"], signature)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, keyIDs)
+}
+
 func (s *Server) postCommit(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -767,9 +888,10 @@ func (s *Server) postImagesCreate(version version.Version, w http.ResponseWriter
 		}
 
 		imagePullConfig := &graph.ImagePullConfig{
-			MetaHeaders: metaHeaders,
-			AuthConfig:  authConfig,
-			OutStream:   output,
+			MetaHeaders:     metaHeaders,
+			AuthConfig:      authConfig,
+			OutStream:       output,
+			ProgressSummary: version.GreaterThanOrEqualTo("1.21"),
 		}
 
 		err = s.daemon.Repositories().Pull(image, tag, imagePullConfig)
@@ -789,7 +911,19 @@ func (s *Server) postImagesCreate(version version.Version, w http.ResponseWriter
 		// generated from the download to be available to the output
 		// stream processing below
 		var newConfig *runconfig.Config
-		newConfig, err = builder.BuildFromConfig(s.daemon, &runconfig.Config{}, imageImportConfig.Changes)
+		// The changes are applied on top of the config of the parent image
+		baseConfig := &runconfig.Config{}
+		if name := r.Form.Get("parent"); name != "" {
+			parent, err := s.daemon.Repositories().LookupImage(name)
+			if err != nil {
+				return err
+			}
+			imageImportConfig.Parent = parent.ID
+			if parent.Config != nil {
+				baseConfig = parent.Config
+			}
+		}
+		newConfig, err = builder.BuildFromConfig(s.daemon, baseConfig, imageImportConfig.Changes)
 		if err != nil {
 			return err
 		}
@@ -873,10 +1007,11 @@ func (s *Server) postImagesPush(version version.Version, w http.ResponseWriter,
 	name := vars["name"]
 	output := ioutils.NewWriteFlusher(w)
 	imagePushConfig := &graph.ImagePushConfig{
-		MetaHeaders: metaHeaders,
-		AuthConfig:  authConfig,
-		Tag:         r.Form.Get("tag"),
-		OutStream:   output,
+		MetaHeaders:     metaHeaders,
+		AuthConfig:      authConfig,
+		Tag:             r.Form.Get("tag"),
+		OutStream:       output,
+		ProgressSummary: version.GreaterThanOrEqualTo("1.21"),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -900,10 +1035,41 @@ func (s *Server) getImagesGet(version version.Version, w http.ResponseWriter, r
 		return err
 	}
 
+	imageExportConfig := &graph.ImageExportConfig{
+		Compression:      archive.Uncompressed,
+		CompressionLevel: archive.DefaultCompressionLevel,
+	}
+	if compression := r.Form.Get("compression"); compression != "" {
+		c, err := archive.ParseCompression(compression)
+		if err != nil {
+			return err
+		}
+		imageExportConfig.Compression = c
+	}
+	if level := r.Form.Get("compressionlevel"); level != "" {
+		l, err := strconv.Atoi(level)
+		if err != nil {
+			return fmt.Errorf("Invalid compression level %s", level)
+		}
+		imageExportConfig.CompressionLevel = l
+	}
+	if err := imageExportConfig.Compression.ValidateLevel(imageExportConfig.CompressionLevel); err != nil {
+		return err
+	}
+	if err := imageExportConfig.Compression.CheckCommand(); err != nil {
+		return err
+	}
+	switch format := r.Form.Get("format"); format {
+	case "", graph.ExportFormatDocker, graph.ExportFormatOCI:
+		imageExportConfig.Format = format
+	default:
+		return fmt.Errorf("Unsupported image export format %s", format)
+	}
+
 	w.Header().Set("Content-Type", "application/x-tar")
 
 	output := ioutils.NewWriteFlusher(w)
-	imageExportConfig := &graph.ImageExportConfig{Outstream: output}
+	imageExportConfig.Outstream = output
 	if name, ok := vars["name"]; ok {
 		imageExportConfig.Names = []string{name}
 	} else {
@@ -1038,6 +1204,15 @@ func (s *Server) deleteImages(version version.Version, w http.ResponseWriter, r
 	return writeJSON(w, http.StatusOK, list)
 }
 
+func (s *Server) postImagesGC(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	list, err := s.daemon.ImageGC()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, list)
+}
+
 func (s *Server) postContainersStart(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -1391,6 +1566,16 @@ func setContainerPathStatHeader(stat *types.ContainerPathStat, header http.Heade
 	return nil
 }
 
+// extractFormValues returns the options of extracting an archive given in
+// the query parameters.
+func extractFormValues(r *http.Request) *daemon.ExtractOptions {
+	return &daemon.ExtractOptions{
+		NoOverwriteDirNonDir: boolValue(r, "noOverwriteDirNonDir"),
+		CopyUIDGID:           boolValue(r, "copyUIDGID"),
+		Chown:                r.Form.Get("chown"),
+	}
+}
+
 func (s *Server) headContainersArchive(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	v, err := archiveFormValues(r, vars)
 	if err != nil {
@@ -1433,8 +1618,65 @@ func (s *Server) putContainersArchive(version version.Version, w http.ResponseWr
 		return err
 	}
 
-	noOverwriteDirNonDir := boolValue(r, "noOverwriteDirNonDir")
-	return s.daemon.ContainerExtractToDir(v.name, v.path, noOverwriteDirNonDir, r.Body)
+	return s.daemon.ContainerExtractToDir(v.name, v.path, extractFormValues(r), r.Body)
+}
+
+func (s *Server) headVolumesArchive(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	v, err := archiveFormValues(r, vars)
+	if err != nil {
+		return err
+	}
+
+	stat, err := s.daemon.VolumeStatPath(v.name, v.path)
+	if err != nil {
+		return err
+	}
+
+	return setContainerPathStatHeader(stat, w.Header())
+}
+
+func (s *Server) getVolumesArchive(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	v, err := archiveFormValues(r, vars)
+	if err != nil {
+		return err
+	}
+
+	tarArchive, stat, err := s.daemon.VolumeArchivePath(v.name, v.path)
+	if err != nil {
+		return err
+	}
+	defer tarArchive.Close()
+
+	if err := setContainerPathStatHeader(stat, w.Header()); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	_, err = io.Copy(w, tarArchive)
+
+	return err
+}
+
+func (s *Server) putVolumesArchive(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	v, err := archiveFormValues(r, vars)
+	if err != nil {
+		return err
+	}
+
+	return s.daemon.VolumeExtractToDir(v.name, v.path, extractFormValues(r), r.Body)
+}
+
+func (s *Server) postArchiveCopy(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := checkForJson(r); err != nil {
+		return err
+	}
+
+	config := &types.ArchiveCopyConfig{}
+	if err := json.NewDecoder(r.Body).Decode(config); err != nil {
+		return err
+	}
+
+	return s.daemon.ArchiveCopy(config)
 }
 
 func (s *Server) postContainerExecCreate(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
@@ -1627,11 +1869,14 @@ func createRouter(s *Server) *mux.Router {
 	m := map[string]map[string]HttpApiFunc{
 		"HEAD": {
 			"/containers/{name:.*}/archive": s.headContainersArchive,
+			"/volumes/{name:.*}/archive":    s.headVolumesArchive,
 		},
 		"GET": {
 			"/_ping":                          s.ping,
 			"/events":                         s.getEvents,
 			"/info":                           s.getInfo,
+			"/system/df":                      s.getSystemDiskUsage,
+			"/firewall/rules":                 s.getFirewallRules,
 			"/version":                        s.getVersion,
 			"/images/json":                    s.getImagesJSON,
 			"/images/search":                  s.getImagesSearch,
@@ -1650,33 +1895,41 @@ func createRouter(s *Server) *mux.Router {
 			"/containers/{name:.*}/attach/ws": s.wsContainersAttach,
 			"/exec/{id:.*}/json":              s.getExecByID,
 			"/containers/{name:.*}/archive":   s.getContainersArchive,
+			"/volumes/{name:.*}/archive":      s.getVolumesArchive,
 		},
 		"POST": {
-			"/auth":                         s.postAuth,
-			"/commit":                       s.postCommit,
-			"/build":                        s.postBuild,
-			"/images/create":                s.postImagesCreate,
-			"/images/load":                  s.postImagesLoad,
-			"/images/{name:.*}/push":        s.postImagesPush,
-			"/images/{name:.*}/tag":         s.postImagesTag,
-			"/containers/create":            s.postContainersCreate,
-			"/containers/{name:.*}/kill":    s.postContainersKill,
-			"/containers/{name:.*}/pause":   s.postContainersPause,
-			"/containers/{name:.*}/unpause": s.postContainersUnpause,
-			"/containers/{name:.*}/restart": s.postContainersRestart,
-			"/containers/{name:.*}/start":   s.postContainersStart,
-			"/containers/{name:.*}/stop":    s.postContainersStop,
-			"/containers/{name:.*}/wait":    s.postContainersWait,
-			"/containers/{name:.*}/resize":  s.postContainersResize,
-			"/containers/{name:.*}/attach":  s.postContainersAttach,
-			"/containers/{name:.*}/copy":    s.postContainersCopy,
-			"/containers/{name:.*}/exec":    s.postContainerExecCreate,
-			"/exec/{name:.*}/start":         s.postContainerExecStart,
-			"/exec/{name:.*}/resize":        s.postContainerExecResize,
-			"/containers/{name:.*}/rename":  s.postContainerRename,
+			"/auth":                            s.postAuth,
+			"/firewall/rules":                  s.postFirewallRules,
+			"/commit":                          s.postCommit,
+			"/build":                           s.postBuild,
+			"/images/create":                   s.postImagesCreate,
+			"/images/load":                     s.postImagesLoad,
+			"/images/gc":                       s.postImagesGC,
+			"/images/{name:.*}/push":           s.postImagesPush,
+			"/images/{name:.*}/tag":            s.postImagesTag,
+			"/images/{name:.*}/signatures":     s.postImagesSignatures,
+			"/containers/create":               s.postContainersCreate,
+			"/containers/{name:.*}/kill":       s.postContainersKill,
+			"/containers/{name:.*}/pause":      s.postContainersPause,
+			"/containers/{name:.*}/unpause":    s.postContainersUnpause,
+			"/containers/{name:.*}/checkpoint": s.postContainersCheckpoint,
+			"/containers/{name:.*}/restore":    s.postContainersRestore,
+			"/containers/{name:.*}/restart":    s.postContainersRestart,
+			"/containers/{name:.*}/start":      s.postContainersStart,
+			"/containers/{name:.*}/stop":       s.postContainersStop,
+			"/containers/{name:.*}/wait":       s.postContainersWait,
+			"/containers/{name:.*}/resize":     s.postContainersResize,
+			"/containers/{name:.*}/attach":     s.postContainersAttach,
+			"/containers/{name:.*}/copy":       s.postContainersCopy,
+			"/containers/{name:.*}/exec":       s.postContainerExecCreate,
+			"/exec/{name:.*}/start":            s.postContainerExecStart,
+			"/exec/{name:.*}/resize":           s.postContainerExecResize,
+			"/containers/{name:.*}/rename":     s.postContainerRename,
+			"/archive/copy":                    s.postArchiveCopy,
 		},
 		"PUT": {
 			"/containers/{name:.*}/archive": s.putContainersArchive,
+			"/volumes/{name:.*}/archive":    s.putVolumesArchive,
 		},
 		"DELETE": {
 			"/containers/{name:.*}": s.deleteContainers,
@@ -46,6 +46,10 @@ type ContainerCommitResponse struct {
 type ContainerChange struct {
 	Kind int
 	Path string
+	// Size and Mode are those of the changed file, they are not set for
+	// deleted files and Size is only set for regular files
+	Size int64       `json:",omitempty"`
+	Mode os.FileMode `json:",omitempty"`
 }
 
 // GET "/images/{name:.*}/history"
@@ -84,6 +88,8 @@ type GraphDriverData struct {
 // GET "/images/{name:.*}/json"
 type ImageInspect struct {
 	Id              string
+	RepoTags        []string
+	RepoDigests     []string
 	Parent          string
 	Comment         string
 	Created         string
@@ -97,6 +103,15 @@ type ImageInspect struct {
 	Size            int64
 	VirtualSize     int64
 	GraphDriver     GraphDriverData
+	SignedBy        []string
+}
+
+// ImageSignaturePayload is the payload of the detached signatures of images,
+// in the JSON Web Signatures of "POST /images/{name:.*}/signatures". The image
+// ID is the digest of the image config, which covers the layers, so it anchors
+// the content like a manifest digest, and it exists without a registry.
+type ImageSignaturePayload struct {
+	ImageID string `json:"imageID"`
 }
 
 // GET  "/containers/json"
@@ -128,6 +143,26 @@ type CopyConfig struct {
 	Resource string
 }
 
+// ArchiveCopyEndpoint is the source or the destination of Remote API:
+// POST "/archive/copy"
+// It is a path in either a container or a volume.
+type ArchiveCopyEndpoint struct {
+	Container string `json:",omitempty"`
+	Volume    string `json:",omitempty"`
+	Path      string
+}
+
+// ArchiveCopyConfig contains the request body of Remote API:
+// POST "/archive/copy"
+type ArchiveCopyConfig struct {
+	Source      ArchiveCopyEndpoint
+	Destination ArchiveCopyEndpoint
+	// CopyUIDGID keeps the owners of the copied files instead of root.
+	CopyUIDGID bool `json:",omitempty"`
+	// Chown gives the copied files to an owner in the UID:GID form.
+	Chown string `json:",omitempty"`
+}
+
 // ContainerPathStat is used to encode the header from
 // 	GET /containers/{name:.*}/archive
 // "name" is basename of the resource.
@@ -194,6 +229,84 @@ type Info struct {
 	Name               string
 	Labels             []string
 	ExperimentalBuild  bool
+	// DriverPool is the status of the storage pool of the graph driver, for
+	// drivers storing layers in a pool of limited space
+	DriverPool *PoolStatus `json:",omitempty"`
+}
+
+// PoolUsage is the usage of the data or metadata space of a storage pool,
+// in bytes.
+type PoolUsage struct {
+	Used      uint64
+	Total     uint64
+	Available uint64
+}
+
+// PoolStatus is the status of the storage pool of the graph driver.
+type PoolStatus struct {
+	Name     string
+	Data     PoolUsage
+	Metadata PoolUsage
+	// MinFreeSpacePercent is the free space, in percent of the pool, below
+	// which containers cannot be created
+	MinFreeSpacePercent uint32
+	// WarningThresholdPercent is the usage, in percent of the pool, above
+	// which an event is logged
+	WarningThresholdPercent uint32
+	AutoExtend              bool
+}
+
+// GET "/system/df"
+type DiskUsage struct {
+	// LayersSize is the size of all the image layers, counted once each
+	LayersSize int64
+	Images     []*ImageDiskUsage
+	Containers []*ContainerDiskUsage
+	Volumes    []*VolumeDiskUsage
+}
+
+// ImageDiskUsage is the disk usage of an image, which shares the layers of
+// SharedSize with other images. Size is SharedSize plus UniqueSize.
+type ImageDiskUsage struct {
+	ID         string `json:"Id"`
+	RepoTags   []string
+	Created    int
+	Size       int64
+	SharedSize int64
+	UniqueSize int64
+	// Containers is the number of containers created from the image
+	Containers int
+}
+
+// ContainerDiskUsage is the disk usage of the writable layer and the logs
+// of a container. SizeRw is -1 when it cannot be computed.
+type ContainerDiskUsage struct {
+	ID      string `json:"Id"`
+	Names   []string
+	Image   string
+	Created int
+	Status  string
+	SizeRw  int64
+	LogSize int64
+}
+
+// VolumeDiskUsage is the disk usage of a volume. Size is -1 for the volumes
+// of volume drivers other than "local".
+type VolumeDiskUsage struct {
+	Name       string
+	Driver     string
+	Mountpoint string
+	Size       int64
+	// Containers is the number of containers using the volume
+	Containers int
+}
+
+// GET "/firewall/rules" and POST "/firewall/rules"
+type FirewallRules struct {
+	// Enabled is true if the daemon enforces the policy given with --icc-policy
+	Enabled bool
+	// Bridges maps bridge names to the iptables rules of their policy chain
+	Bridges map[string][]string
 }
 
 // This struct is a temp struct used by execStart
@@ -206,16 +319,18 @@ type ExecStartCheck struct {
 }
 
 type ContainerState struct {
-	Running    bool
-	Paused     bool
-	Restarting bool
-	OOMKilled  bool
-	Dead       bool
-	Pid        int
-	ExitCode   int
-	Error      string
-	StartedAt  string
-	FinishedAt string
+	Running        bool
+	Paused         bool
+	Restarting     bool
+	OOMKilled      bool
+	Dead           bool
+	Checkpointed   bool
+	Pid            int
+	ExitCode       int
+	Error          string
+	StartedAt      string
+	FinishedAt     string
+	CheckpointedAt string
 }
 
 // GET "/containers/{name:.*}/json"
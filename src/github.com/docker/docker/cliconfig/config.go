@@ -59,6 +59,13 @@ type ConfigFile struct {
 	HTTPHeaders map[string]string     `json:"HttpHeaders,omitempty"`
 	PsFormat    string                `json:"psFormat,omitempty"`
 	filename    string                // Note: not serialized - for internal use only
+
+	// CredentialsStore is the credential helper the credentials of all
+	// the registries are kept in, instead of the configuration file.
+	CredentialsStore string `json:"credsStore,omitempty"`
+	// CredentialHelpers are the credential helpers of some registries,
+	// by registry hostname.
+	CredentialHelpers map[string]string `json:"credHelpers,omitempty"`
 }
 
 // NewConfigFile initilizes an empty configuration file for the given filename 'fn'
@@ -96,9 +103,13 @@ func Load(configDir string) (*ConfigFile, error) {
 		}
 
 		for addr, ac := range configFile.AuthConfigs {
-			ac.Username, ac.Password, err = DecodeAuth(ac.Auth)
-			if err != nil {
-				return &configFile, err
+			// Credentials kept by credential helpers are not in the
+			// file, only their username.
+			if ac.Auth != "" {
+				ac.Username, ac.Password, err = DecodeAuth(ac.Auth)
+				if err != nil {
+					return &configFile, err
+				}
 			}
 			ac.Auth = ""
 			ac.ServerAddress = addr
@@ -164,6 +175,20 @@ func (configFile *ConfigFile) Save() error {
 	tmpAuthConfigs := make(map[string]AuthConfig, len(configFile.AuthConfigs))
 	for k, authConfig := range configFile.AuthConfigs {
 		authCopy := authConfig
+		if helper := configFile.CredentialHelper(k); helper != "" {
+			// Passwords kept in the file before the credential helper
+			// was set are moved to it.
+			if authCopy.Password != "" {
+				if err := storeCredentials(helper, k, authCopy); err != nil {
+					return err
+				}
+			}
+			// only the username is saved, the credential helper has the password
+			authCopy.Password = ""
+			authCopy.ServerAddress = ""
+			tmpAuthConfigs[k] = authCopy
+			continue
+		}
 		// encode and save the authstring, while blanking out the original fields
 		authCopy.Auth = EncodeAuth(&authCopy)
 		authCopy.Username = ""
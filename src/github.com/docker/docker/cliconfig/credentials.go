@@ -0,0 +1,159 @@
+package cliconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// credentialHelperPrefix is the prefix of the name of the credential
+	// helper binaries, docker-credential-<name>.
+	credentialHelperPrefix = "docker-credential-"
+
+	// credentialsNotFound is what credential helpers output when they have
+	// no credentials for a server.
+	credentialsNotFound = "credentials not found in native keychain"
+)
+
+// helperCredentials is how credentials are exchanged with credential
+// helpers.
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// execCredentialHelper runs the action of the credential helper name with
+// input on its standard input, and returns its standard output.
+var execCredentialHelper = func(name, action string, input io.Reader) ([]byte, error) {
+	cmd := exec.Command(credentialHelperPrefix+name, action)
+	cmd.Stdin = input
+	out, err := cmd.Output()
+	if err != nil {
+		// Credential helpers report errors on their standard output.
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return out, fmt.Errorf("%s", msg)
+		}
+		return out, fmt.Errorf("error running %s%s %s: %v", credentialHelperPrefix, name, action, err)
+	}
+	return out, nil
+}
+
+// CredentialHelper returns the name of the credential helper the credentials
+// of serverAddress are kept in, or "" if they are kept in the configuration
+// file. Helpers set for a registry in CredentialHelpers take precedence over
+// CredentialsStore.
+func (configFile *ConfigFile) CredentialHelper(serverAddress string) string {
+	if helper, ok := configFile.CredentialHelpers[serverAddress]; ok {
+		return helper
+	}
+	if helper, ok := configFile.CredentialHelpers[convertToHostname(serverAddress)]; ok {
+		return helper
+	}
+	return configFile.CredentialsStore
+}
+
+// GetAuthConfig returns the credentials of serverAddress, from its credential
+// helper if it has one. Empty credentials are returned for servers without any.
+func (configFile *ConfigFile) GetAuthConfig(serverAddress string) (AuthConfig, error) {
+	authConfig := configFile.AuthConfigs[serverAddress]
+	helper := configFile.CredentialHelper(serverAddress)
+	if helper == "" {
+		return authConfig, nil
+	}
+
+	out, err := execCredentialHelper(helper, "get", strings.NewReader(serverAddress))
+	if err != nil {
+		if strings.Contains(err.Error(), credentialsNotFound) {
+			return authConfig, nil
+		}
+		return authConfig, err
+	}
+	var creds helperCredentials
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return authConfig, fmt.Errorf("invalid response from credential helper %s: %v", helper, err)
+	}
+	authConfig.Username = creds.Username
+	authConfig.Password = creds.Secret
+	authConfig.ServerAddress = serverAddress
+	return authConfig, nil
+}
+
+// GetAllAuthConfigs returns the credentials of all the servers in the
+// configuration file or with a credential helper of their own.
+func (configFile *ConfigFile) GetAllAuthConfigs() (map[string]AuthConfig, error) {
+	authConfigs := make(map[string]AuthConfig, len(configFile.AuthConfigs))
+	for serverAddress := range configFile.AuthConfigs {
+		authConfig, err := configFile.GetAuthConfig(serverAddress)
+		if err != nil {
+			return nil, err
+		}
+		authConfigs[serverAddress] = authConfig
+	}
+	for serverAddress := range configFile.CredentialHelpers {
+		if _, ok := authConfigs[serverAddress]; ok {
+			continue
+		}
+		authConfig, err := configFile.GetAuthConfig(serverAddress)
+		if err != nil {
+			return nil, err
+		}
+		if authConfig.Username != "" {
+			authConfigs[serverAddress] = authConfig
+		}
+	}
+	return authConfigs, nil
+}
+
+// StoreAuthConfig stores the credentials of authConfig.ServerAddress in its
+// credential helper if it has one. The configuration file then only keeps the
+// username and email.
+func (configFile *ConfigFile) StoreAuthConfig(authConfig AuthConfig) error {
+	if helper := configFile.CredentialHelper(authConfig.ServerAddress); helper != "" {
+		if err := storeCredentials(helper, authConfig.ServerAddress, authConfig); err != nil {
+			return err
+		}
+		authConfig.Password = ""
+	}
+	configFile.AuthConfigs[authConfig.ServerAddress] = authConfig
+	return nil
+}
+
+// storeCredentials stores the credentials of serverAddress in the credential
+// helper.
+func storeCredentials(helper, serverAddress string, authConfig AuthConfig) error {
+	creds, err := json.Marshal(helperCredentials{
+		ServerURL: serverAddress,
+		Username:  authConfig.Username,
+		Secret:    authConfig.Password,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = execCredentialHelper(helper, "store", bytes.NewReader(creds))
+	return err
+}
+
+// EraseAuthConfig removes the credentials of serverAddress, from its
+// credential helper if it has one.
+func (configFile *ConfigFile) EraseAuthConfig(serverAddress string) error {
+	delete(configFile.AuthConfigs, serverAddress)
+	helper := configFile.CredentialHelper(serverAddress)
+	if helper == "" {
+		return nil
+	}
+	if _, err := execCredentialHelper(helper, "erase", strings.NewReader(serverAddress)); err != nil && !strings.Contains(err.Error(), credentialsNotFound) {
+		return err
+	}
+	return nil
+}
+
+// convertToHostname strips the scheme and path of a registry URL.
+func convertToHostname(url string) string {
+	stripped := strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://")
+	return strings.SplitN(stripped, "/", 2)[0]
+}
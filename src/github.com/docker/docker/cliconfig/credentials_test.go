@@ -0,0 +1,181 @@
+package cliconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeCredentialHelpers replaces the credential helpers with in memory ones
+// keeping credentials by helper and server.
+func fakeCredentialHelpers(t *testing.T) (map[string]map[string]helperCredentials, func()) {
+	stores := make(map[string]map[string]helperCredentials)
+	orig := execCredentialHelper
+	execCredentialHelper = func(name, action string, input io.Reader) ([]byte, error) {
+		in, err := ioutil.ReadAll(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stores[name] == nil {
+			stores[name] = make(map[string]helperCredentials)
+		}
+		switch action {
+		case "get":
+			creds, ok := stores[name][string(in)]
+			if !ok {
+				return []byte(credentialsNotFound), errors.New(credentialsNotFound)
+			}
+			return json.Marshal(creds)
+		case "store":
+			var creds helperCredentials
+			if err := json.Unmarshal(in, &creds); err != nil {
+				t.Fatal(err)
+			}
+			stores[name][creds.ServerURL] = creds
+		case "erase":
+			delete(stores[name], string(in))
+		default:
+			t.Fatalf("Unexpected credential helper action %s", action)
+		}
+		return nil, nil
+	}
+	return stores, func() { execCredentialHelper = orig }
+}
+
+func TestCredentialHelper(t *testing.T) {
+	config := NewConfigFile("")
+	if helper := config.CredentialHelper("https://index.docker.io/v1/"); helper != "" {
+		t.Fatalf("Expected no credential helper, got %s", helper)
+	}
+
+	config.CredentialsStore = "secretservice"
+	config.CredentialHelpers = map[string]string{"registry.example.com": "pass"}
+	for serverAddress, expected := range map[string]string{
+		"https://index.docker.io/v1/":   "secretservice",
+		"registry.example.com":          "pass",
+		"https://registry.example.com/": "pass",
+		"registry.example.com:5000":     "secretservice",
+	} {
+		if helper := config.CredentialHelper(serverAddress); helper != expected {
+			t.Fatalf("Expected credential helper %s for %s, got %s", expected, serverAddress, helper)
+		}
+	}
+}
+
+func TestCredentialHelperStoreGetErase(t *testing.T) {
+	stores, restore := fakeCredentialHelpers(t)
+	defer restore()
+
+	tmpHome, err := ioutil.TempDir("", "config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpHome)
+	config, err := Load(tmpHome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CredentialHelpers = map[string]string{"registry.example.com": "pass"}
+
+	authConfig := AuthConfig{
+		Username:      "joejoe",
+		Password:      "hello",
+		Email:         "user@example.com",
+		ServerAddress: "registry.example.com",
+	}
+	if err := config.StoreAuthConfig(authConfig); err != nil {
+		t.Fatal(err)
+	}
+	if creds := stores["pass"]["registry.example.com"]; creds.Username != "joejoe" || creds.Secret != "hello" {
+		t.Fatalf("Unexpected credentials in the credential helper: %+v", creds)
+	}
+	if err := config.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The password is not saved in the configuration file.
+	buf, err := ioutil.ReadFile(filepath.Join(tmpHome, ConfigFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "hello") || strings.Contains(string(buf), EncodeAuth(&authConfig)) {
+		t.Fatalf("Credentials saved in the configuration file: %s", buf)
+	}
+
+	config, err = Load(tmpHome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac := config.AuthConfigs["registry.example.com"]; ac.Username != "joejoe" || ac.Password != "" || ac.Email != "user@example.com" {
+		t.Fatalf("Unexpected credentials in the configuration file: %+v", ac)
+	}
+	ac, err := config.GetAuthConfig("registry.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac.Username != "joejoe" || ac.Password != "hello" || ac.Email != "user@example.com" {
+		t.Fatalf("Unexpected credentials: %+v", ac)
+	}
+	all, err := config.GetAllAuthConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all["registry.example.com"].Password != "hello" {
+		t.Fatalf("Unexpected credentials: %+v", all)
+	}
+
+	if err := config.EraseAuthConfig("registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stores["pass"]["registry.example.com"]; ok {
+		t.Fatal("Expected the credentials to be erased from the credential helper")
+	}
+	ac, err = config.GetAuthConfig("registry.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac.Username != "" || ac.Password != "" {
+		t.Fatalf("Expected no credentials, got %+v", ac)
+	}
+}
+
+func TestCredentialHelperMigratesPlaintext(t *testing.T) {
+	stores, restore := fakeCredentialHelpers(t)
+	defer restore()
+
+	tmpHome, err := ioutil.TempDir("", "config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpHome)
+	authConfig := AuthConfig{Username: "joejoe", Password: "hello"}
+	data := `{"auths":{"registry.example.com":{"auth":"` + EncodeAuth(&authConfig) + `","email":"user@example.com"}}}`
+	if err := ioutil.WriteFile(filepath.Join(tmpHome, ConfigFileName), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The credential helper is set after the password was saved in the file
+	config, err := Load(tmpHome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CredentialsStore = "pass"
+	if err := config.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if creds := stores["pass"]["registry.example.com"]; creds.Username != "joejoe" || creds.Secret != "hello" {
+		t.Fatalf("Expected the credentials to be moved to the credential helper, got %+v", creds)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(tmpHome, ConfigFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), EncodeAuth(&authConfig)) {
+		t.Fatalf("Credentials saved in the configuration file: %s", buf)
+	}
+}
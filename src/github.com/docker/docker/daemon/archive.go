@@ -2,14 +2,19 @@ package daemon
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/chrootarchive"
 	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/docker/pkg/symlink"
+	"github.com/docker/docker/volume"
 )
 
 // ErrExtractPointNotDirectory is used to convey that the operation to extract
@@ -17,6 +22,62 @@ import (
 // path does not refer to a directory.
 var ErrExtractPointNotDirectory = errors.New("extraction point is not a directory")
 
+// ExtractOptions holds the options of extracting a tar archive to a directory
+// in a container or a volume.
+type ExtractOptions struct {
+	// NoOverwriteDirNonDir makes it an error for the archive to replace an
+	// existing directory with a non-directory and vice versa.
+	NoOverwriteDirNonDir bool
+	// CopyUIDGID keeps the owners of the files in the archive, instead of
+	// giving the files to root.
+	CopyUIDGID bool
+	// Chown gives the files to the owner in the UID:GID form instead of root.
+	Chown string
+}
+
+// tarOptions returns the options of untarring an archive with these options.
+func (options *ExtractOptions) tarOptions() (*archive.TarOptions, error) {
+	tarOptions := &archive.TarOptions{
+		NoOverwriteDirNonDir: options.NoOverwriteDirNonDir,
+	}
+
+	switch {
+	case options.CopyUIDGID && options.Chown != "":
+		return nil, fmt.Errorf("bad parameter: cannot both copy the owners of the files and chown them")
+	case options.CopyUIDGID:
+		// The owners are kept when ChownOpts are not set.
+	case options.Chown != "":
+		owner, err := parseChown(options.Chown)
+		if err != nil {
+			return nil, err
+		}
+		tarOptions.ChownOpts = owner
+	default:
+		tarOptions.ChownOpts = &archive.TarChownOptions{
+			UID: 0, GID: 0, // TODO: use config.User? Remap to userns root?
+		}
+	}
+
+	return tarOptions, nil
+}
+
+// parseChown parses an owner in the UID:GID form.
+func parseChown(chown string) (*archive.TarChownOptions, error) {
+	parts := strings.Split(chown, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("bad parameter: invalid owner %q, expected UID:GID", chown)
+	}
+	uid, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad parameter: invalid UID %q", parts[0])
+	}
+	gid, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad parameter: invalid GID %q", parts[1])
+	}
+	return &archive.TarChownOptions{UID: int(uid), GID: int(gid)}, nil
+}
+
 // ContainerCopy performs a depracated operation of archiving the resource at
 // the specified path in the conatiner identified by the given name.
 func (daemon *Daemon) ContainerCopy(name string, res string) (io.ReadCloser, error) {
@@ -58,16 +119,14 @@ func (daemon *Daemon) ContainerArchivePath(name string, path string) (content io
 // ContainerExtractToDir extracts the given archive to the specified location
 // in the filesystem of the container identified by the given name. The given
 // path must be of a directory in the container. If it is not, the error will
-// be ErrExtractPointNotDirectory. If noOverwriteDirNonDir is true then it will
-// be an error if unpacking the given content would cause an existing directory
-// to be replaced with a non-directory and vice versa.
-func (daemon *Daemon) ContainerExtractToDir(name, path string, noOverwriteDirNonDir bool, content io.Reader) error {
+// be ErrExtractPointNotDirectory.
+func (daemon *Daemon) ContainerExtractToDir(name, path string, options *ExtractOptions, content io.Reader) error {
 	container, err := daemon.Get(name)
 	if err != nil {
 		return err
 	}
 
-	return container.ExtractToDir(path, noOverwriteDirNonDir, content)
+	return container.ExtractToDir(path, options, content)
 }
 
 // resolvePath resolves the given path in the container to a resource on the
@@ -75,14 +134,30 @@ func (daemon *Daemon) ContainerExtractToDir(name, path string, noOverwriteDirNon
 // the absolute path to the resource relative to the container's rootfs, and
 // a error if the path points to outside the container's rootfs.
 func (container *Container) resolvePath(path string) (resolvedPath, absPath string, err error) {
-	// Consider the given path as an absolute path in the container.
+	return resolveScopedPath(container.basefs, path)
+}
+
+// statPath is the unexported version of StatPath. Locks and mounts should
+// be aquired before calling this method and the given path should be fully
+// resolved to a path on the host corresponding to the given absolute path
+// inside the container.
+func (container *Container) statPath(resolvedPath, absPath string) (stat *types.ContainerPathStat, err error) {
+	return statScopedPath(container.basefs, resolvedPath, absPath)
+}
+
+// resolveScopedPath resolves the given path in the filesystem rooted at root
+// to a resource on the host. Returns a resolved path (absolute path to the
+// resource on the host), the absolute path to the resource relative to root,
+// and a error if the path points to outside root.
+func resolveScopedPath(root, path string) (resolvedPath, absPath string, err error) {
+	// Consider the given path as an absolute path in root.
 	absPath = archive.PreserveTrailingDotOrSeparator(filepath.Join(string(filepath.Separator), path), path)
 
 	// Split the absPath into its Directory and Base components. We will
-	// resolve the dir in the scope of the container then append the base.
+	// resolve the dir in the scope of root then append the base.
 	dirPath, basePath := filepath.Split(absPath)
 
-	resolvedDirPath, err := container.GetResourcePath(dirPath)
+	resolvedDirPath, err := symlink.FollowSymlinkInScope(filepath.Join(root, dirPath), root)
 	if err != nil {
 		return "", "", err
 	}
@@ -94,11 +169,9 @@ func (container *Container) resolvePath(path string) (resolvedPath, absPath stri
 	return resolvedPath, absPath, nil
 }
 
-// statPath is the unexported version of StatPath. Locks and mounts should
-// be aquired before calling this method and the given path should be fully
-// resolved to a path on the host corresponding to the given absolute path
-// inside the container.
-func (container *Container) statPath(resolvedPath, absPath string) (stat *types.ContainerPathStat, err error) {
+// statScopedPath stats the resource at the given resolved path, which is the
+// host path of the given absolute path in the filesystem rooted at root.
+func statScopedPath(root, resolvedPath, absPath string) (stat *types.ContainerPathStat, err error) {
 	lstat, err := os.Lstat(resolvedPath)
 	if err != nil {
 		return nil, err
@@ -106,13 +179,13 @@ func (container *Container) statPath(resolvedPath, absPath string) (stat *types.
 
 	var linkTarget string
 	if lstat.Mode()&os.ModeSymlink != 0 {
-		// Fully evaluate the symlink in the scope of the container rootfs.
-		hostPath, err := container.GetResourcePath(absPath)
+		// Fully evaluate the symlink in the scope of root.
+		hostPath, err := symlink.FollowSymlinkInScope(filepath.Join(root, absPath), root)
 		if err != nil {
 			return nil, err
 		}
 
-		linkTarget, err = filepath.Rel(container.basefs, hostPath)
+		linkTarget, err = filepath.Rel(root, hostPath)
 		if err != nil {
 			return nil, err
 		}
@@ -136,6 +209,11 @@ func (container *Container) StatPath(path string) (stat *types.ContainerPathStat
 	container.Lock()
 	defer container.Unlock()
 
+	return container.statPathLocked(path)
+}
+
+// statPathLocked is StatPath for a container that is already locked.
+func (container *Container) statPathLocked(path string) (stat *types.ContainerPathStat, err error) {
 	if err = container.Mount(); err != nil {
 		return nil, err
 	}
@@ -161,15 +239,25 @@ func (container *Container) StatPath(path string) (stat *types.ContainerPathStat
 func (container *Container) ArchivePath(path string) (content io.ReadCloser, stat *types.ContainerPathStat, err error) {
 	container.Lock()
 
-	defer func() {
-		if err != nil {
-			// Wait to unlock the container until the archive is fully read
-			// (see the ReadCloseWrapper func below) or if there is an error
-			// before that occurs.
-			container.Unlock()
-		}
-	}()
+	// Wait to unlock the container until the archive is fully read or if
+	// there is an error before that occurs.
+	content, stat, err = container.archivePathLocked(path)
+	if err != nil {
+		container.Unlock()
+		return nil, nil, err
+	}
+	data := content
+	content = ioutils.NewReadCloserWrapper(data, func() error {
+		err := data.Close()
+		container.Unlock()
+		return err
+	})
+	return content, stat, nil
+}
 
+// archivePathLocked is ArchivePath for a container that is already locked.
+// The container must stay locked until the archive is closed.
+func (container *Container) archivePathLocked(path string) (content io.ReadCloser, stat *types.ContainerPathStat, err error) {
 	if err = container.Mount(); err != nil {
 		return nil, nil, err
 	}
@@ -214,7 +302,6 @@ func (container *Container) ArchivePath(path string) (content io.ReadCloser, sta
 		err := data.Close()
 		container.UnmountVolumes(true)
 		container.Unmount()
-		container.Unlock()
 		return err
 	})
 
@@ -225,14 +312,21 @@ func (container *Container) ArchivePath(path string) (content io.ReadCloser, sta
 
 // ExtractToDir extracts the given tar archive to the specified location in the
 // filesystem of this container. The given path must be of a directory in the
-// container. If it is not, the error will be ErrExtractPointNotDirectory. If
-// noOverwriteDirNonDir is true then it will be an error if unpacking the
-// given content would cause an existing directory to be replaced with a non-
-// directory and vice versa.
-func (container *Container) ExtractToDir(path string, noOverwriteDirNonDir bool, content io.Reader) (err error) {
+// container. If it is not, the error will be ErrExtractPointNotDirectory.
+func (container *Container) ExtractToDir(path string, options *ExtractOptions, content io.Reader) (err error) {
+	tarOptions, err := options.tarOptions()
+	if err != nil {
+		return err
+	}
+
 	container.Lock()
 	defer container.Unlock()
 
+	return container.extractToDirLocked(path, tarOptions, content)
+}
+
+// extractToDirLocked is ExtractToDir for a container that is already locked.
+func (container *Container) extractToDirLocked(path string, tarOptions *archive.TarOptions, content io.Reader) (err error) {
 	if err = container.Mount(); err != nil {
 		return err
 	}
@@ -291,14 +385,7 @@ func (container *Container) ExtractToDir(path string, noOverwriteDirNonDir bool,
 		return ErrContainerRootfsReadonly
 	}
 
-	options := &archive.TarOptions{
-		ChownOpts: &archive.TarChownOptions{
-			UID: 0, GID: 0, // TODO: use config.User? Remap to userns root?
-		},
-		NoOverwriteDirNonDir: noOverwriteDirNonDir,
-	}
-
-	if err := chrootarchive.Untar(content, resolvedPath, options); err != nil {
+	if err := chrootarchive.Untar(content, resolvedPath, tarOptions); err != nil {
 		return err
 	}
 
@@ -322,3 +409,308 @@ func checkIfPathIsInAVolume(container *Container, absPath string) (bool, error)
 	}
 	return toVolume, nil
 }
+
+// archiveTarget is a container or a volume, the filesystem of which can be
+// archived and extracted to.
+type archiveTarget interface {
+	StatPath(path string) (*types.ContainerPathStat, error)
+	ArchivePath(path string) (io.ReadCloser, *types.ContainerPathStat, error)
+	ExtractToDir(path string, options *ExtractOptions, content io.Reader) error
+}
+
+// getArchiveTarget returns the container or the volume of the given endpoint
+// of a copy.
+func (daemon *Daemon) getArchiveTarget(endpoint types.ArchiveCopyEndpoint) (archiveTarget, error) {
+	switch {
+	case endpoint.Container != "" && endpoint.Volume != "":
+		return nil, fmt.Errorf("bad parameter: cannot copy from or to both a container and a volume")
+	case endpoint.Volume != "":
+		return daemon.getVolumeArchive(endpoint.Volume)
+	case endpoint.Container != "":
+		return daemon.Get(endpoint.Container)
+	}
+	return nil, fmt.Errorf("bad parameter: must specify a container or a volume")
+}
+
+// ArchiveCopy copies a filesystem resource between two containers or volumes
+// of the daemon, with the same behavior as copying it through a client with
+// ArchivePath and ExtractToDir.
+func (daemon *Daemon) ArchiveCopy(config *types.ArchiveCopyConfig) error {
+	if config.Source.Path == "" || config.Destination.Path == "" {
+		return fmt.Errorf("bad parameter: path cannot be empty")
+	}
+
+	src, err := daemon.getArchiveTarget(config.Source)
+	if err != nil {
+		return err
+	}
+	dst, err := daemon.getArchiveTarget(config.Destination)
+	if err != nil {
+		return err
+	}
+
+	buffer := bufferArchiveCopy(src, dst)
+	if !buffer {
+		// Two containers are locked for the whole copy, in the order of
+		// their IDs so that a copy the other way round cannot deadlock, and
+		// the archive is streamed from one to the other.
+		if sc, ok := src.(*Container); ok {
+			if dc, ok := dst.(*Container); ok {
+				defer lockContainers(sc, dc)()
+				src, dst = lockedContainer{sc}, lockedContainer{dc}
+			}
+		}
+	}
+
+	options := &ExtractOptions{
+		// Do not allow for an existing directory to be overwritten by a
+		// non-directory and vice versa.
+		NoOverwriteDirNonDir: true,
+		CopyUIDGID:           config.CopyUIDGID,
+		Chown:                config.Chown,
+	}
+
+	// Prepare destination copy info by stat-ing the destination path. If it
+	// is a symbolic link, it is evaluated. As when copying through a client,
+	// any error is ignored, assuming that the parent directory of the
+	// destination exists.
+	dstInfo := archive.CopyInfo{Path: config.Destination.Path}
+	dstStat, err := dst.StatPath(dstInfo.Path)
+	if err == nil && dstStat.Mode&os.ModeSymlink != 0 {
+		dstInfo.Path = dstStat.LinkTarget
+		dstStat, err = dst.StatPath(dstInfo.Path)
+	}
+	if err == nil {
+		dstInfo.Exists, dstInfo.IsDir = true, dstStat.Mode.IsDir()
+	}
+
+	content, srcStat, err := src.ArchivePath(config.Source.Path)
+	if err != nil {
+		return err
+	}
+
+	if buffer {
+		tmp, err := archive.NewTempArchive(content, "")
+		content.Close()
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		content = tmp
+	}
+	defer content.Close()
+
+	srcInfo := archive.CopyInfo{
+		Path:   config.Source.Path,
+		Exists: true,
+		IsDir:  srcStat.Mode.IsDir(),
+	}
+
+	// See comments in the implementation of `archive.PrepareArchiveCopy` for
+	// exactly what goes into deciding how and whether the source archive
+	// needs to be altered for the correct copy behavior.
+	dstDir, preparedArchive, err := archive.PrepareArchiveCopy(content, srcInfo, dstInfo)
+	if err != nil {
+		return err
+	}
+	defer preparedArchive.Close()
+
+	return dst.ExtractToDir(dstDir, options, preparedArchive)
+}
+
+// bufferArchiveCopy returns whether the archive of src must be buffered
+// before it is extracted to dst, which is the case when they are the same
+// container or volume, as the archive would change while it is read. A
+// container also stays locked until its archive is fully read.
+func bufferArchiveCopy(src, dst archiveTarget) bool {
+	switch s := src.(type) {
+	case *volumeArchive:
+		d, ok := dst.(*volumeArchive)
+		return ok && s.Name() == d.Name() && s.DriverName() == d.DriverName()
+	case *Container:
+		d, ok := dst.(*Container)
+		return ok && s == d
+	}
+	return false
+}
+
+// lockContainers locks two containers in the order of their IDs, and returns
+// the function unlocking them.
+func lockContainers(c1, c2 *Container) func() {
+	if c2.ID < c1.ID {
+		c1, c2 = c2, c1
+	}
+	c1.Lock()
+	c2.Lock()
+	return func() {
+		c2.Unlock()
+		c1.Unlock()
+	}
+}
+
+// lockedContainer is a container locked for the whole copy, which is archived
+// and extracted to without being locked again.
+type lockedContainer struct {
+	*Container
+}
+
+func (c lockedContainer) StatPath(path string) (*types.ContainerPathStat, error) {
+	return c.statPathLocked(path)
+}
+
+func (c lockedContainer) ArchivePath(path string) (io.ReadCloser, *types.ContainerPathStat, error) {
+	return c.archivePathLocked(path)
+}
+
+func (c lockedContainer) ExtractToDir(path string, options *ExtractOptions, content io.Reader) error {
+	tarOptions, err := options.tarOptions()
+	if err != nil {
+		return err
+	}
+	return c.extractToDirLocked(path, tarOptions, content)
+}
+
+// volumeArchive archives and extracts to the filesystem of a volume, paths
+// being relative to the root of the volume.
+type volumeArchive struct {
+	volume.Volume
+}
+
+// getVolumeArchive returns the volume of the given name used by a container.
+func (daemon *Daemon) getVolumeArchive(name string) (*volumeArchive, error) {
+	for _, container := range daemon.List() {
+		container.Lock()
+		for _, m := range container.MountPoints {
+			if m.Volume != nil && m.Volume.Name() == name {
+				container.Unlock()
+				return &volumeArchive{m.Volume}, nil
+			}
+		}
+		container.Unlock()
+	}
+	return nil, fmt.Errorf("no such volume: %s", name)
+}
+
+// VolumeStatPath stats the filesystem resource at the specified path in the
+// volume of the given name.
+func (daemon *Daemon) VolumeStatPath(name, path string) (*types.ContainerPathStat, error) {
+	v, err := daemon.getVolumeArchive(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.StatPath(path)
+}
+
+// VolumeArchivePath creates an archive of the filesystem resource at the
+// specified path in the volume of the given name.
+func (daemon *Daemon) VolumeArchivePath(name, path string) (io.ReadCloser, *types.ContainerPathStat, error) {
+	v, err := daemon.getVolumeArchive(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v.ArchivePath(path)
+}
+
+// VolumeExtractToDir extracts the given archive to the specified directory in
+// the volume of the given name.
+func (daemon *Daemon) VolumeExtractToDir(name, path string, options *ExtractOptions, content io.Reader) error {
+	v, err := daemon.getVolumeArchive(name)
+	if err != nil {
+		return err
+	}
+
+	return v.ExtractToDir(path, options, content)
+}
+
+// StatPath stats the filesystem resource at the specified path in this volume.
+func (v *volumeArchive) StatPath(path string) (*types.ContainerPathStat, error) {
+	root, err := v.Mount()
+	if err != nil {
+		return nil, err
+	}
+	defer v.Unmount()
+
+	resolvedPath, absPath, err := resolveScopedPath(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return statScopedPath(root, resolvedPath, absPath)
+}
+
+// ArchivePath creates an archive of the filesystem resource at the specified
+// path in this volume. The volume is unmounted when the archive is closed.
+func (v *volumeArchive) ArchivePath(path string) (content io.ReadCloser, stat *types.ContainerPathStat, err error) {
+	root, err := v.Mount()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			v.Unmount()
+		}
+	}()
+
+	resolvedPath, absPath, err := resolveScopedPath(root, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stat, err = statScopedPath(root, resolvedPath, absPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// As for containers, the archive entries are rebased to start with the
+	// requested name rather than the name the path resolved to.
+	data, err := archive.TarResourceRebase(resolvedPath, filepath.Base(absPath))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content = ioutils.NewReadCloserWrapper(data, func() error {
+		err := data.Close()
+		v.Unmount()
+		return err
+	})
+
+	return content, stat, nil
+}
+
+// ExtractToDir extracts the given tar archive to the specified directory in
+// this volume. If the path is not of a directory, the error will be
+// ErrExtractPointNotDirectory.
+func (v *volumeArchive) ExtractToDir(path string, options *ExtractOptions, content io.Reader) error {
+	tarOptions, err := options.tarOptions()
+	if err != nil {
+		return err
+	}
+
+	root, err := v.Mount()
+	if err != nil {
+		return err
+	}
+	defer v.Unmount()
+
+	// Evaluate the last path element too, so that an archive can be extracted
+	// to a symlink to a directory.
+	absPath := filepath.Join(string(filepath.Separator), path)
+	resolvedPath, err := symlink.FollowSymlinkInScope(filepath.Join(root, absPath), root)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Lstat(resolvedPath)
+	if err != nil {
+		return err
+	}
+
+	if !stat.IsDir() {
+		return ErrExtractPointNotDirectory
+	}
+
+	return chrootarchive.Untar(content, resolvedPath, tarOptions)
+}
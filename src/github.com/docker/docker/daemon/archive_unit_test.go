@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/volume/local"
+)
+
+func TestExtractOptionsOwnership(t *testing.T) {
+	options, err := (&ExtractOptions{}).tarOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.ChownOpts == nil || *options.ChownOpts != (archive.TarChownOptions{UID: 0, GID: 0}) {
+		t.Fatalf("Expected the files to be given to root, got %+v", options.ChownOpts)
+	}
+
+	options, err = (&ExtractOptions{CopyUIDGID: true}).tarOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.ChownOpts != nil {
+		t.Fatalf("Expected the owners of the files to be kept, got %+v", options.ChownOpts)
+	}
+
+	options, err = (&ExtractOptions{Chown: "1000:1001"}).tarOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.ChownOpts == nil || *options.ChownOpts != (archive.TarChownOptions{UID: 1000, GID: 1001}) {
+		t.Fatalf("Expected the files to be given to 1000:1001, got %+v", options.ChownOpts)
+	}
+
+	for _, invalid := range []ExtractOptions{
+		{CopyUIDGID: true, Chown: "1000:1000"},
+		{Chown: "1000"},
+		{Chown: "root:root"},
+		{Chown: "1000:-1"},
+	} {
+		if _, err := invalid.tarOptions(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestVolumeArchivePath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volume-archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root, err := local.New(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := root.Create("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(v.Path(), "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(v.Path(), "dir", "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Symlinks are resolved in the scope of the volume.
+	if err := os.Symlink("/../../dir", filepath.Join(v.Path(), "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	va := &volumeArchive{v}
+	stat, err := va.StatPath("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.LinkTarget != "/dir" {
+		t.Fatalf("Expected the link to resolve to /dir, got %q", stat.LinkTarget)
+	}
+
+	content, stat, err := va.ArchivePath("/link/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	if !stat.Mode.IsDir() {
+		t.Fatalf("Expected a directory, got %s", stat.Mode)
+	}
+	names := make(map[string]bool)
+	tr := tar.NewReader(content)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[hdr.Name] = true
+	}
+	if !names["link/file"] {
+		t.Fatalf("Expected the archive to hold link/file, got %v", names)
+	}
+}
+
+func TestBufferArchiveCopy(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volume-archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root, err := local.New(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := root.Create("one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := root.Create("two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := &Container{}, &Container{}
+
+	for _, test := range []struct {
+		src, dst archiveTarget
+		buffer   bool
+	}{
+		{c1, c1, true},
+		{c1, c2, false},
+		{&volumeArchive{v1}, &volumeArchive{v1}, true},
+		{&volumeArchive{v1}, &volumeArchive{v2}, false},
+		{&volumeArchive{v1}, c1, false},
+		{c1, &volumeArchive{v1}, false},
+	} {
+		if buffer := bufferArchiveCopy(test.src, test.dst); buffer != test.buffer {
+			t.Fatalf("Expected buffering a copy from %v to %v to be %v", test.src, test.dst, test.buffer)
+		}
+	}
+}
+
+func TestLockContainersOrder(t *testing.T) {
+	c1 := &Container{CommonContainer: CommonContainer{ID: "1", State: NewState()}}
+	c2 := &Container{CommonContainer: CommonContainer{ID: "2", State: NewState()}}
+
+	// Copies both ways round would deadlock if the containers were not
+	// locked in the same order.
+	done := make(chan struct{})
+	for _, pair := range [][2]*Container{{c1, c2}, {c2, c1}} {
+		go func(a, b *Container) {
+			for i := 0; i < 1000; i++ {
+				lockContainers(a, b)()
+			}
+			done <- struct{}{}
+		}(pair[0], pair[1])
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expected the containers to be locked without deadlock")
+		}
+	}
+}
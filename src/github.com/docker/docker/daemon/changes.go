@@ -1,13 +1,112 @@
 package daemon
 
-import "github.com/docker/docker/pkg/archive"
+import (
+	"os"
+	"path/filepath"
+	"strings"
 
-// ContainerChanges returns a list of container fs changes
-func (daemon *Daemon) ContainerChanges(name string) ([]archive.Change, error) {
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/fileutils"
+)
+
+// ContainerChangesConfig holds the filters of the changes of a container.
+// The patterns are globs matched against the paths of the changes and of
+// their parent directories.
+type ContainerChangesConfig struct {
+	// IncludePatterns, if any, are the patterns one of which the changes
+	// must match.
+	IncludePatterns []string
+	// ExcludePatterns are the patterns the changes must not match.
+	ExcludePatterns []string
+}
+
+// ContainerChanges calls fn with each change of the filesystem of a
+// container matching config, along with the size and the mode of the
+// changed file, as the changes are found. It stops at the first error
+// returned by fn.
+func (daemon *Daemon) ContainerChanges(name string, config *ContainerChangesConfig, fn func(*types.ContainerChange) error) error {
 	container, err := daemon.Get(name)
+	if err != nil {
+		return err
+	}
+
+	include, err := newChangesMatcher(config.IncludePatterns)
+	if err != nil {
+		return err
+	}
+	exclude, err := newChangesMatcher(config.ExcludePatterns)
+	if err != nil {
+		return err
+	}
+
+	// Like exports, the changes are walked with the container mounted
+	// rather than locked, so that a slow client does not hold the lock.
+	if err := container.Mount(); err != nil {
+		return err
+	}
+	defer container.Unmount()
+
+	return daemon.walkChanges(container, func(change archive.Change) error {
+		if (len(config.IncludePatterns) > 0 && !include.matches(change.Path)) || exclude.matches(change.Path) {
+			return nil
+		}
+		c := &types.ContainerChange{
+			Kind: int(change.Kind),
+			Path: change.Path,
+		}
+		if change.Kind != archive.ChangeDelete {
+			if fi, err := container.lstat(change.Path); err == nil {
+				c.Mode = fi.Mode()
+				if fi.Mode().IsRegular() {
+					c.Size = fi.Size()
+				}
+			}
+		}
+		return fn(c)
+	})
+}
+
+// lstat returns the FileInfo of path in the filesystem of the container,
+// which must be mounted, without following it if it is a symbolic link.
+func (container *Container) lstat(path string) (os.FileInfo, error) {
+	dir, err := container.GetResourcePath(filepath.Dir(path))
 	if err != nil {
 		return nil, err
 	}
+	return os.Lstat(filepath.Join(dir, filepath.Base(path)))
+}
 
-	return container.Changes()
+// changesMatcher matches the paths of changes against patterns.
+type changesMatcher struct {
+	patterns []string
+	patDirs  [][]string
+}
+
+func newChangesMatcher(patterns []string) (*changesMatcher, error) {
+	cleaned, patDirs, _, err := fileutils.CleanPatterns(relativePatterns(patterns))
+	if err != nil {
+		return nil, err
+	}
+	return &changesMatcher{patterns: cleaned, patDirs: patDirs}, nil
+}
+
+func (m *changesMatcher) matches(path string) bool {
+	matched, err := fileutils.OptimizedMatches(strings.TrimPrefix(filepath.Clean(path), "/"), m.patterns, m.patDirs)
+	return err == nil && matched
+}
+
+// relativePatterns returns patterns of paths in the filesystem of a
+// container relative to its root, as they are matched by the archive
+// package.
+func relativePatterns(patterns []string) []string {
+	relPatterns := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			relPatterns[i] = "!" + strings.TrimLeft(pattern[1:], "/")
+		} else {
+			relPatterns[i] = strings.TrimLeft(pattern, "/")
+		}
+	}
+	return relPatterns
 }
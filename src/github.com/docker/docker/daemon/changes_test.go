@@ -0,0 +1,27 @@
+package daemon
+
+import "testing"
+
+func TestChangesMatcher(t *testing.T) {
+	m, err := newChangesMatcher([]string{"/etc/*.conf", "var/log", "!var/log/keep"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]bool{
+		"/etc/resolv.conf":   true,
+		"/etc/hosts":         false,
+		"/etc/conf.d/a.conf": false,
+		"/var/log":           true,
+		"/var/log/app.log":   true,
+		"/var/log/keep":      false,
+		"/var/lib":           false,
+	} {
+		if matched := m.matches(path); matched != expected {
+			t.Fatalf("Expected %s matched to be %v, got %v", path, expected, matched)
+		}
+	}
+
+	if _, err := newChangesMatcher([]string{"!"}); err == nil {
+		t.Fatal("Expected an error for an invalid pattern")
+	}
+}
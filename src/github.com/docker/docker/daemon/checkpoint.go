@@ -0,0 +1,44 @@
+package daemon
+
+import "fmt"
+
+// ContainerCheckpoint checkpoints the processes of a running container,
+// stopping them unless leaveRunning is set.
+func (daemon *Daemon) ContainerCheckpoint(name string, leaveRunning bool) error {
+	container, err := daemon.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if err := container.Checkpoint(leaveRunning); err != nil {
+		return fmt.Errorf("Cannot checkpoint container %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// ContainerRestore restores the processes of a stopped container from its
+// last checkpoint.
+func (daemon *Daemon) ContainerRestore(name string) error {
+	container, err := daemon.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if container.IsRunning() {
+		return fmt.Errorf("Container %s is already running", name)
+	}
+
+	container.Lock()
+	checkpointed := container.Checkpointed
+	container.Unlock()
+	if !checkpointed {
+		return fmt.Errorf("Container %s is not checkpointed", name)
+	}
+
+	if err := container.Restore(); err != nil {
+		return fmt.Errorf("Cannot restore container %s: %s", name, err)
+	}
+
+	return nil
+}
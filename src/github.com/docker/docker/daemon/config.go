@@ -1,7 +1,11 @@
 package daemon
 
 import (
+	"time"
+
+	"github.com/docker/docker/graph"
 	"github.com/docker/docker/opts"
+	"github.com/docker/docker/pkg/archive"
 	flag "github.com/docker/docker/pkg/mflag"
 	"github.com/docker/docker/runconfig"
 )
@@ -21,6 +25,7 @@ type CommonConfig struct {
 	DisableBridge  bool
 	Dns            []string
 	DnsSearch      []string
+	DnsOptions     []string
 	EnableCors     bool
 	ExecDriver     string
 	ExecOptions    []string
@@ -35,6 +40,29 @@ type CommonConfig struct {
 	TrustKeyPath   string
 	DefaultNetwork string
 	NetworkKVStore string
+
+	// MaxConcurrentDownloads is the maximum number of layers downloaded
+	// at the same time by all pulls.
+	MaxConcurrentDownloads int
+
+	// ImageGC is the policy the daemon collects unused images with.
+	ImageGC imageGCConfig
+
+	// RequireSignature refuses to run and load images without a
+	// signature by one of the public keys in the TrustedKeys files.
+	RequireSignature bool
+	TrustedKeys      []string
+
+	// PushCompression and PushCompressionLevel are the compression the
+	// layers pushed to v2 registries are compressed with.
+	PushCompression      string
+	PushCompressionLevel int
+	// PushLayerIndex indexes the layers pushed to v2 registries, so that
+	// they can be pulled lazily.
+	PushLayerIndex bool
+	// LazyPull runs containers on pulled images before their indexed
+	// layers are downloaded.
+	LazyPull bool
 }
 
 // InstallCommonFlags adds command-line options to the top-level flag parser for
@@ -51,11 +79,24 @@ func (config *Config) InstallCommonFlags(cmd *flag.FlagSet, usageFn func(string)
 	cmd.StringVar(&config.GraphDriver, []string{"s", "-storage-driver"}, "", usageFn("Storage driver to use"))
 	cmd.StringVar(&config.ExecDriver, []string{"e", "-exec-driver"}, defaultExec, usageFn("Exec driver to use"))
 	cmd.IntVar(&config.Mtu, []string{"#mtu", "-mtu"}, 0, usageFn("Set the containers network MTU"))
+	cmd.IntVar(&config.MaxConcurrentDownloads, []string{"-max-concurrent-downloads"}, graph.DefaultMaxConcurrentDownloads, usageFn("Set the max concurrent layer downloads of all pulls"))
+	cmd.IntVar(&config.ImageGC.HighWatermark, []string{"-image-gc-high-watermark"}, 0, usageFn("Disk usage percent above which unused images are collected"))
+	cmd.IntVar(&config.ImageGC.LowWatermark, []string{"-image-gc-low-watermark"}, 0, usageFn("Disk usage percent the image collection brings the disk usage down to"))
+	cmd.DurationVar(&config.ImageGC.Interval, []string{"-image-gc-interval"}, 5*time.Minute, usageFn("Interval the disk usage is checked at for image collection"))
+	cmd.IntVar(&config.ImageGC.KeepPerRepository, []string{"-image-gc-keep"}, -1, usageFn("Number of most recent images kept per repository, -1 keeps all tagged images"))
+	cmd.DurationVar(&config.ImageGC.MinAge, []string{"-image-gc-min-age"}, 0, usageFn("Minimum age of images to collect"))
+	cmd.BoolVar(&config.RequireSignature, []string{"-require-signature"}, false, usageFn("Only run and load images signed by a trusted key"))
+	cmd.Var(opts.NewListOptsRef(&config.TrustedKeys, nil), []string{"-trusted-key"}, usageFn("Public key file to verify image signatures with"))
+	cmd.StringVar(&config.PushCompression, []string{"-push-compression"}, "gzip", usageFn("Compression of pushed layers (gzip, bzip2, xz or zstd)"))
+	cmd.IntVar(&config.PushCompressionLevel, []string{"-push-compression-level"}, archive.DefaultCompressionLevel, usageFn("Compression level of pushed layers, -1 for the default level"))
+	cmd.BoolVar(&config.PushLayerIndex, []string{"-push-layer-index"}, false, usageFn("Index pushed layers so that they can be pulled lazily"))
+	cmd.BoolVar(&config.LazyPull, []string{"-lazy-pull"}, false, usageFn("Mount the indexed layers of pulled images before they are downloaded"))
 	cmd.BoolVar(&config.EnableCors, []string{"#api-enable-cors", "#-api-enable-cors"}, false, usageFn("Enable CORS headers in the remote API, this is deprecated by --api-cors-header"))
 	cmd.StringVar(&config.CorsHeaders, []string{"-api-cors-header"}, "", usageFn("Set CORS headers in the remote API"))
 	// FIXME: why the inconsistency between "hosts" and "sockets"?
 	cmd.Var(opts.NewListOptsRef(&config.Dns, opts.ValidateIPAddress), []string{"#dns", "-dns"}, usageFn("DNS server to use"))
 	cmd.Var(opts.NewListOptsRef(&config.DnsSearch, opts.ValidateDNSSearch), []string{"-dns-search"}, usageFn("DNS search domains to use"))
+	cmd.Var(opts.NewListOptsRef(&config.DnsOptions, opts.ValidateDNSOption), []string{"-dns-opt"}, usageFn("DNS options to use"))
 	cmd.Var(opts.NewListOptsRef(&config.Labels, opts.ValidateLabel), []string{"-label"}, usageFn("Set key=value labels to the daemon"))
 	cmd.StringVar(&config.LogConfig.Type, []string{"-log-driver"}, "json-file", usageFn("Default driver for container logs"))
 	cmd.Var(opts.NewMapOpts(config.LogConfig.Config, nil), []string{"-log-opt"}, usageFn("Set log driver options"))
@@ -72,6 +72,7 @@ type CommonContainer struct {
 	Config                   *runconfig.Config
 	ImageID                  string `json:"Image"`
 	NetworkSettings          *network.Settings
+	IPAMState                *network.IPAMState `json:",omitempty"`
 	ResolvConfPath           string
 	HostnamePath             string
 	HostsPath                string
@@ -238,6 +239,18 @@ func (container *Container) GetRootResourcePath(path string) (string, error) {
 }
 
 func (container *Container) Start() (err error) {
+	return container.start(nil)
+}
+
+// Restore restores the processes of the container from its last checkpoint
+// instead of starting its command.
+func (container *Container) Restore() error {
+	return container.start(container.checkpointOptions())
+}
+
+// start starts the container, restoring its processes with restore if not
+// nil.
+func (container *Container) start(restore *execdriver.CheckpointOptions) (err error) {
 	container.Lock()
 	defer container.Unlock()
 
@@ -295,7 +308,17 @@ func (container *Container) Start() (err error) {
 	}
 
 	container.command.Mounts = mounts
-	return container.waitForStart()
+	if err := container.waitForStart(restore); err != nil {
+		return err
+	}
+
+	// Parents take their own locks while being updated, so do not hold ours.
+	go func() {
+		if err := container.updateParentsHosts(); err != nil {
+			logrus.Error(err)
+		}
+	}()
+	return nil
 }
 
 func (container *Container) Run() error {
@@ -454,6 +477,65 @@ func (container *Container) Unpause() error {
 	return nil
 }
 
+// Checkpoint dumps the processes of the container to its checkpoint
+// directory, from which Restore restores them. The processes are stopped
+// once dumped unless leaveRunning is set.
+func (container *Container) Checkpoint(leaveRunning bool) error {
+	container.Lock()
+	if !container.Running {
+		container.Unlock()
+		return ErrContainerNotRunning{container.ID}
+	}
+	if container.Paused {
+		container.Unlock()
+		return fmt.Errorf("Container %s is paused, unpause the container before checkpointing", container.ID)
+	}
+	// The images of the previous checkpoint are replaced.
+	container.Checkpointed = false
+	opts := container.checkpointOptions()
+	opts.LeaveRunning = leaveRunning
+	container.Unlock()
+
+	if err := os.RemoveAll(opts.ImagesDirectory); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.ImagesDirectory, 0700); err != nil {
+		return err
+	}
+
+	if !leaveRunning {
+		// The processes exit once dumped, they must not be restarted.
+		container.monitor.ExitOnNext()
+	}
+	if err := container.daemon.Checkpoint(container, opts); err != nil {
+		if !leaveRunning && container.IsRunning() {
+			container.monitor.resetExitOnNext()
+		}
+		return err
+	}
+	if !leaveRunning {
+		container.WaitStop(-1 * time.Second)
+	}
+
+	container.Lock()
+	defer container.Unlock()
+	container.setCheckpointed()
+	if err := container.toDisk(); err != nil {
+		return err
+	}
+	container.LogEvent("checkpoint")
+	return nil
+}
+
+// checkpointOptions returns the directories, in the root of the container,
+// its processes are checkpointed to.
+func (container *Container) checkpointOptions() *execdriver.CheckpointOptions {
+	return &execdriver.CheckpointOptions{
+		ImagesDirectory: filepath.Join(container.root, "checkpoint"),
+		WorkDirectory:   filepath.Join(container.root, "criu.work"),
+	}
+}
+
 func (container *Container) Kill() error {
 	if !container.IsRunning() {
 		return ErrContainerNotRunning{container.ID}
@@ -547,12 +629,25 @@ func (container *Container) Resize(h, w int) error {
 	return nil
 }
 
-func (container *Container) Export() (archive.Archive, error) {
+// Export returns a tar archive of the filesystem of the container. Only
+// the paths of includeFiles are archived if any, and the paths matching
+// excludePatterns are skipped.
+func (container *Container) Export(includeFiles, excludePatterns []string) (archive.Archive, error) {
 	if err := container.Mount(); err != nil {
 		return nil, err
 	}
 
-	archive, err := archive.Tar(container.basefs, archive.Uncompressed)
+	includes, err := container.exportIncludeFiles(includeFiles)
+	if err != nil {
+		container.Unmount()
+		return nil, err
+	}
+
+	archive, err := archive.TarWithOptions(container.basefs, &archive.TarOptions{
+		Compression:     archive.Uncompressed,
+		IncludeFiles:    includes,
+		ExcludePatterns: relativePatterns(excludePatterns),
+	})
 	if err != nil {
 		container.Unmount()
 		return nil, err
@@ -566,6 +661,61 @@ func (container *Container) Export() (archive.Archive, error) {
 	return arch, err
 }
 
+// exportIncludeFiles returns the paths of includeFiles relative to the root
+// of the mounted filesystem of the container, following the symbolic links
+// of their parent directories within it.
+func (container *Container) exportIncludeFiles(includeFiles []string) ([]string, error) {
+	var includes []string
+	for _, include := range includeFiles {
+		path := filepath.Clean(string(os.PathSeparator) + include)
+		if path == string(os.PathSeparator) {
+			return nil, nil
+		}
+		dir, err := container.GetResourcePath(filepath.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+		resolved := filepath.Join(dir, filepath.Base(path))
+		if _, err := os.Lstat(resolved); err != nil {
+			return nil, fmt.Errorf("Could not find the file %s in container %s", include, container.ID)
+		}
+		rel, err := filepath.Rel(container.basefs, resolved)
+		if err != nil {
+			return nil, err
+		}
+		includes = append(includes, rel)
+	}
+	return includes, nil
+}
+
+// ExportChanges returns a tar archive of the changes of the filesystem of
+// the container since its image, with whiteout files for the deleted files,
+// which can be imported as a layer on top of the image. The changes are
+// filtered like Export does.
+func (container *Container) ExportChanges(includeFiles, excludePatterns []string) (archive.Archive, error) {
+	var includes []string
+	for _, include := range includeFiles {
+		path := strings.TrimPrefix(filepath.Clean(string(os.PathSeparator)+include), string(os.PathSeparator))
+		if path == "" {
+			includes = nil
+			break
+		}
+		includes = append(includes, path)
+	}
+
+	diff, err := container.daemon.Diff(container)
+	if err != nil {
+		return nil, err
+	}
+	if len(includes) > 0 || len(excludePatterns) > 0 {
+		if diff, err = archive.FilterArchive(diff, includes, relativePatterns(excludePatterns)); err != nil {
+			return nil, err
+		}
+	}
+	container.LogEvent("export")
+	return diff, nil
+}
+
 func (container *Container) Mount() error {
 	return container.daemon.Mount(container)
 }
@@ -769,8 +919,9 @@ func (container *Container) startLogging() error {
 	return nil
 }
 
-func (container *Container) waitForStart() error {
+func (container *Container) waitForStart(restore *execdriver.CheckpointOptions) error {
 	container.monitor = newContainerMonitor(container, container.hostConfig.RestartPolicy)
+	container.monitor.restore = restore
 
 	// block until we either receive an error from the initial start of the container's
 	// process or until the process is running in the container
@@ -3,6 +3,7 @@
 package daemon
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/execdriver"
+	"github.com/docker/docker/daemon/firewall"
 	"github.com/docker/docker/daemon/network"
 	"github.com/docker/docker/links"
 	"github.com/docker/docker/pkg/archive"
@@ -28,6 +30,8 @@ import (
 	"github.com/docker/docker/runconfig"
 	"github.com/docker/docker/utils"
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/ipallocator"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/types"
@@ -116,6 +120,133 @@ func (container *Container) setupLinkedContainers() ([]string, error) {
 	return env, nil
 }
 
+// updateParentsHosts makes every running container linking to this one follow
+// it to the address it was given on this start. The parents are looked up in
+// the link graph rather than in their own state so that links are refreshed
+// even if the parents have not been started since the daemon came up. Every
+// parent that could not be updated is logged, and the error returned names
+// how many of them failed.
+func (container *Container) updateParentsHosts() error {
+	var failed int
+	for _, ref := range container.daemon.ContainerGraph().RefPaths(container.ID) {
+		if ref.ParentID == "0" {
+			continue
+		}
+
+		parent, err := container.daemon.Get(ref.ParentID)
+		if err != nil {
+			logrus.Errorf("Could not find parent %s of container %s: %v", ref.ParentID, container.ID, err)
+			failed++
+			continue
+		}
+
+		if err := parent.updateLink(ref.Name, container); err != nil {
+			logrus.Errorf("Failed to update link %s of container %s: %v", ref.Name, parent.ID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to update the links of %d parent(s) of container %s", failed, container.ID)
+	}
+	return nil
+}
+
+// updateLink points the link with the given alias at the current address of
+// child. The new link is enabled before the old one is disabled so that
+// traffic to the child is never cut off, then the hosts file and the
+// environment handed to exec sessions are rewritten. The old link is kept if
+// the hosts file cannot be rewritten, and the link_update event is only
+// logged once the link was updated.
+func (container *Container) updateLink(alias string, child *Container) error {
+	container.Lock()
+	defer container.Unlock()
+
+	if !container.Running || container.activeLinks == nil {
+		return nil
+	}
+
+	old, exists := container.activeLinks[alias]
+	childIP := child.NetworkSettings.IPAddress
+	if !exists || childIP == "" || old.ChildIP == childIP {
+		return nil
+	}
+
+	link, err := links.NewLink(
+		container.NetworkSettings.IPAddress,
+		childIP,
+		old.Name,
+		child.Config.Env,
+		child.Config.ExposedPorts,
+	)
+	if err != nil {
+		return err
+	}
+	if err := link.Enable(); err != nil {
+		return err
+	}
+	if container.HostsPath != "" && !container.hostConfig.NetworkMode.IsContainer() {
+		if err := updateHostsEntries(container.HostsPath, old.ChildIP, childIP, alias); err != nil {
+			link.Disable()
+			return err
+		}
+	}
+	old.Disable()
+	container.activeLinks[alias] = link
+
+	if container.command != nil {
+		container.command.ProcessConfig.Env = replaceLinkEnv(container.command.ProcessConfig.Env, old, link, container.Config.Env)
+	}
+
+	logrus.Debugf("Updated link %s of %s from %s to %s", alias, container.ID, old.ChildIP, childIP)
+	container.LogEvent("link_update: " + alias)
+	return nil
+}
+
+// updateHostsEntries swaps oldIP for newIP on every line of the hosts file at
+// path that names host. The file is bind mounted into the container, so it is
+// rewritten in place instead of being replaced.
+func updateHostsEntries(path, oldIP, newIP, host string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != oldIP {
+			continue
+		}
+		for _, name := range fields[1:] {
+			if name == host {
+				idx := strings.Index(line, oldIP)
+				lines[i] = line[:idx] + newIP + line[idx+len(oldIP):]
+				break
+			}
+		}
+	}
+
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// replaceLinkEnv drops the variables set by the old link from env and adds
+// the ones of the new link, keeping the user's own variables on top.
+func replaceLinkEnv(env []string, old, link *links.Link, overrides []string) []string {
+	stale := make(map[string]struct{})
+	for _, e := range old.ToEnv() {
+		stale[strings.SplitN(e, "=", 2)[0]] = struct{}{}
+	}
+
+	var result []string
+	for _, e := range env {
+		if _, exists := stale[strings.SplitN(e, "=", 2)[0]]; !exists {
+			result = append(result, e)
+		}
+	}
+	result = append(result, link.ToEnv()...)
+	return utils.ReplaceOrAppendEnvValues(result, overrides)
+}
+
 func (container *Container) createDaemonEnvironment(linkedEnv []string) []string {
 	// if a domain name was specified, append it to the hostname (see #7851)
 	fullHostname := container.Config.Hostname
@@ -367,6 +498,17 @@ func (container *Container) GetSize() (int64, int64) {
 	return sizeRw, sizeRootfs
 }
 
+// getSizeRw returns the size of the writable layer of the container.
+func (container *Container) getSizeRw() (int64, error) {
+	if err := container.Mount(); err != nil {
+		return -1, err
+	}
+	defer container.Unmount()
+
+	initID := fmt.Sprintf("%s-init", container.ID)
+	return container.daemon.driver.DiffSize(container.ID, initID)
+}
+
 // Attempt to set the network mounts given a provided destination and
 // the path to use for it; return true if the given destination was a
 // network mount file
@@ -400,6 +542,92 @@ func (container *Container) buildHostnameFile() error {
 	return ioutil.WriteFile(container.HostnamePath, []byte(container.Config.Hostname+"\n"), 0644)
 }
 
+// dnsOptions returns the resolv.conf options of the container, or the ones
+// of the daemon if it has none.
+func (container *Container) dnsOptions() []string {
+	if len(container.hostConfig.DnsOptions) > 0 {
+		return container.hostConfig.DnsOptions
+	}
+	return container.daemon.config.DnsOptions
+}
+
+// joinEndpoint joins the container to ep. libnetwork generates the
+// resolv.conf of the container from the host one and the nameservers and
+// search domains of the container; the daemon then applies the options of
+// the container to it, or puts back the file the user manages.
+func (container *Container) joinEndpoint(ep libnetwork.Endpoint, joinOptions []libnetwork.EndpointOption) error {
+	path := container.ResolvConfPath
+	before, err := ioutil.ReadFile(path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := ep.Join(container.ID, joinOptions...); err != nil {
+		return err
+	}
+
+	if container.hostConfig.DnsUserManaged && existed {
+		// The file is only generated once, after that it belongs to the user
+		return ioutil.WriteFile(path, before, 0644)
+	}
+
+	options := container.dnsOptions()
+	if len(options) == 0 || container.hostConfig.NetworkMode.IsHost() {
+		// Containers on the host network get the resolv.conf of the host
+		return nil
+	}
+	after, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	hash, err := ioutil.ReadFile(path + ".hash")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if existed && bytes.Equal(before, after) && len(hash) > 0 {
+		curHash, err := ioutils.HashData(bytes.NewReader(after))
+		if err != nil {
+			return err
+		}
+		if curHash != string(hash) {
+			// libnetwork leaves the file alone once the user changed it
+			return nil
+		}
+	}
+
+	content := setResolvOptions(after, options)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		return err
+	}
+	if len(hash) == 0 {
+		return nil
+	}
+	// Keep libnetwork updating the file when the host one changes
+	newHash, err := ioutils.HashData(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".hash", []byte(newHash), 0644)
+}
+
+// setResolvOptions replaces the options lines of the resolv.conf content with
+// one holding options, which take precedence over the options of the host.
+func setResolvOptions(content []byte, options []string) []byte {
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "options" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	result := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if result != "" {
+		result += "\n"
+	}
+	return []byte(result + "options " + strings.Join(options, " ") + "\n")
+}
+
 func (container *Container) buildJoinOptions() ([]libnetwork.EndpointOption, error) {
 	var (
 		joinOptions []libnetwork.EndpointOption
@@ -496,9 +724,9 @@ func (container *Container) buildJoinOptions() ([]libnetwork.EndpointOption, err
 			logrus.Error(err)
 		}
 
+		// The hosts files of the parents are updated by updateParentsHosts
+		// once this container is running.
 		if c != nil && !container.daemon.config.DisableBridge && container.hostConfig.NetworkMode.IsPrivate() {
-			logrus.Debugf("Update /etc/hosts of %s for alias %s with ip %s", c.ID, ref.Name, container.NetworkSettings.IPAddress)
-			joinOptions = append(joinOptions, libnetwork.JoinOptionParentUpdate(c.NetworkSettings.EndpointID, ref.Name, container.NetworkSettings.IPAddress))
 			if c.NetworkSettings.EndpointID != "" {
 				parentEndpoints = append(parentEndpoints, c.NetworkSettings.EndpointID)
 			}
@@ -685,7 +913,7 @@ func (container *Container) UpdateNetwork() error {
 		return fmt.Errorf("Update network failed: %v", err)
 	}
 
-	if err := ep.Join(container.ID, joinOptions...); err != nil {
+	if err := container.joinEndpoint(ep, joinOptions); err != nil {
 		return fmt.Errorf("endpoint join failed: %v", err)
 	}
 
@@ -696,7 +924,39 @@ func (container *Container) UpdateNetwork() error {
 	return nil
 }
 
-func (container *Container) buildCreateEndpointOptions() ([]libnetwork.EndpointOption, error) {
+// requestedAddresses returns the IPv4 and IPv6 addresses the container asks
+// the bridge driver for. Static addresses from the host config always win;
+// otherwise, if sticky is set, the addresses the container was assigned the
+// last time it ran are requested so they survive restarts.
+func (container *Container) requestedAddresses(sticky bool) (net.IP, net.IP) {
+	ip4 := net.ParseIP(container.hostConfig.IPv4Address)
+	ip6 := net.ParseIP(container.hostConfig.IPv6Address)
+	if sticky && container.IPAMState != nil {
+		if ip4 == nil {
+			ip4 = net.ParseIP(container.IPAMState.IPAddress)
+		}
+		if ip6 == nil {
+			ip6 = net.ParseIP(container.IPAMState.GlobalIPv6Address)
+		}
+	}
+	return ip4, ip6
+}
+
+// hasStickyAddresses reports whether requestedAddresses(true) would return
+// any address that was not explicitly set by the user.
+func (container *Container) hasStickyAddresses() bool {
+	if container.IPAMState == nil {
+		return false
+	}
+	return (container.hostConfig.IPv4Address == "" && container.IPAMState.IPAddress != "") ||
+		(container.hostConfig.IPv6Address == "" && container.IPAMState.GlobalIPv6Address != "")
+}
+
+func isAddressUnavailable(err error) bool {
+	return err == ipallocator.ErrIPAlreadyAllocated || err == ipallocator.ErrIPOutOfRange
+}
+
+func (container *Container) buildCreateEndpointOptions(requestAddresses, sticky bool) ([]libnetwork.EndpointOption, error) {
 	var (
 		portSpecs     = make(nat.PortSet)
 		bindings      = make(nat.PortMap)
@@ -758,16 +1018,27 @@ func (container *Container) buildCreateEndpointOptions() ([]libnetwork.EndpointO
 		libnetwork.CreateOptionPortMapping(pbList),
 		libnetwork.CreateOptionExposedPorts(exposeList))
 
+	genericOption := options.Generic{}
 	if container.Config.MacAddress != "" {
 		mac, err := net.ParseMAC(container.Config.MacAddress)
 		if err != nil {
 			return nil, err
 		}
 
-		genericOption := options.Generic{
-			netlabel.MacAddress: mac,
+		genericOption[netlabel.MacAddress] = mac
+	}
+
+	if requestAddresses {
+		ip4, ip6 := container.requestedAddresses(sticky)
+		if ip4 != nil {
+			genericOption[netlabel.IPv4Address] = ip4
+		}
+		if ip6 != nil {
+			genericOption[netlabel.IPv6Address] = ip6
 		}
+	}
 
+	if len(genericOption) > 0 {
 		createOptions = append(createOptions, libnetwork.EndpointOptionGeneric(genericOption))
 	}
 
@@ -865,7 +1136,10 @@ func (container *Container) AllocateNetwork() error {
 		}
 	}
 
-	if err := container.configureNetwork(networkName, service, networkDriver, mode.IsDefault()); err != nil {
+	// Networks of a network driver plugin are created on first use, named
+	// after the plugin
+	canCreateNetwork := mode.IsDefault() || mode.IsUserDefined()
+	if err := container.configureNetwork(networkName, service, networkDriver, canCreateNetwork); err != nil {
 		return err
 	}
 
@@ -881,6 +1155,9 @@ func (container *Container) configureNetwork(networkName, service, networkDriver
 		}
 
 		if n, err = createNetwork(controller, networkName, networkDriver); err != nil {
+			if _, ok := err.(types.NotFoundError); ok && runconfig.NetworkMode(networkDriver).IsUserDefined() {
+				return fmt.Errorf("Could not find a network driver plugin named %s: %v", networkDriver, err)
+			}
 			return err
 		}
 	}
@@ -891,13 +1168,28 @@ func (container *Container) configureNetwork(networkName, service, networkDriver
 			return err
 		}
 
-		createOptions, err := container.buildCreateEndpointOptions()
+		// Static addresses are only understood by the bridge driver
+		isBridge := runconfig.NetworkMode(networkDriver).IsBridge()
+
+		createOptions, err := container.buildCreateEndpointOptions(isBridge, true)
 		if err != nil {
 			return err
 		}
 
 		ep, err = n.CreateEndpoint(service, createOptions...)
+		if err != nil && isBridge && isAddressUnavailable(err) && container.hasStickyAddresses() {
+			// The addresses the container had before are gone, most likely
+			// taken by another container while this one was stopped.
+			logrus.Warnf("Could not reuse the previous address of container %s, allocating a new one: %v", container.ID, err)
+			if createOptions, err = container.buildCreateEndpointOptions(isBridge, false); err != nil {
+				return err
+			}
+			ep, err = n.CreateEndpoint(service, createOptions...)
+		}
 		if err != nil {
+			if isBridge && isAddressUnavailable(err) {
+				return fmt.Errorf("Could not assign the requested IP address to container %s: %v", container.ID, err)
+			}
 			return err
 		}
 	}
@@ -906,12 +1198,19 @@ func (container *Container) configureNetwork(networkName, service, networkDriver
 		return err
 	}
 
+	if runconfig.NetworkMode(networkDriver).IsBridge() {
+		container.IPAMState = &network.IPAMState{
+			IPAddress:         container.NetworkSettings.IPAddress,
+			GlobalIPv6Address: container.NetworkSettings.GlobalIPv6Address,
+		}
+	}
+
 	joinOptions, err := container.buildJoinOptions()
 	if err != nil {
 		return err
 	}
 
-	if err := ep.Join(container.ID, joinOptions...); err != nil {
+	if err := container.joinEndpoint(ep, joinOptions); err != nil {
 		return err
 	}
 
@@ -919,9 +1218,32 @@ func (container *Container) configureNetwork(networkName, service, networkDriver
 		return fmt.Errorf("Updating join info failed: %v", err)
 	}
 
+	if runconfig.NetworkMode(networkDriver).IsBridge() {
+		fwEndpoint := firewall.Endpoint{
+			ID:     container.ID,
+			IP:     container.NetworkSettings.IPAddress,
+			Labels: container.Config.Labels,
+		}
+		if err := container.daemon.firewall.Attach(container.bridgeName(networkName), fwEndpoint); err != nil {
+			return fmt.Errorf("Could not apply the firewall policy to container %s: %v", container.ID, err)
+		}
+	}
+
 	return nil
 }
 
+// bridgeName returns the name of the bridge interface of a network of the
+// bridge driver, which is named after the network unless it is the default one.
+func (container *Container) bridgeName(networkName string) string {
+	if networkName != runconfig.DefaultDaemonNetworkMode().NetworkName() {
+		return networkName
+	}
+	if container.daemon.config.Bridge.Iface != "" {
+		return container.daemon.config.Bridge.Iface
+	}
+	return bridge.DefaultBridgeName
+}
+
 func (container *Container) initializeNetworking() error {
 	var err error
 
@@ -1051,6 +1373,10 @@ func (container *Container) ReleaseNetwork() {
 
 	container.NetworkSettings = &network.Settings{}
 
+	if err := container.daemon.firewall.Detach(container.ID); err != nil {
+		logrus.Errorf("Error removing the firewall policy rules of container %s: %v", container.ID, err)
+	}
+
 	if nid == "" || eid == "" {
 		return
 	}
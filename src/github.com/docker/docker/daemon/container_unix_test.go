@@ -0,0 +1,194 @@
+// +build !windows
+
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/events"
+	"github.com/docker/docker/daemon/network"
+	"github.com/docker/docker/links"
+	"github.com/docker/docker/pkg/graphdb"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/nat"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/truncindex"
+	"github.com/docker/docker/runconfig"
+)
+
+func TestUpdateHostsEntries(t *testing.T) {
+	f, err := ioutil.TempFile("", "docker-test-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	content := "172.17.0.2\tparent\n127.0.0.1\tlocalhost\n172.17.0.3\tdb 4f1a2b3c4d5e db_1\n172.17.0.3\tdb2\n"
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := updateHostsEntries(f.Name(), "172.17.0.3", "172.17.0.9", "db"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "172.17.0.2\tparent\n127.0.0.1\tlocalhost\n172.17.0.9\tdb 4f1a2b3c4d5e db_1\n172.17.0.3\tdb2\n"
+	if string(out) != expected {
+		t.Fatalf("Expected hosts file %q, got %q", expected, string(out))
+	}
+}
+
+func TestReplaceLinkEnv(t *testing.T) {
+	ports := map[nat.Port]struct{}{nat.Port("6379/tcp"): {}}
+	old, err := links.NewLink("172.17.0.2", "172.17.0.3", "/parent/db", nil, ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := links.NewLink("172.17.0.2", "172.17.0.9", "/parent/db", nil, ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := append([]string{"PATH=/bin", "HOSTNAME=parent"}, old.ToEnv()...)
+	env = replaceLinkEnv(env, old, link, []string{"DB_PORT_6379_TCP_PORT=1234"})
+
+	values := make(map[string]string)
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		values[parts[0]] = parts[1]
+	}
+
+	if values["DB_PORT_6379_TCP_ADDR"] != "172.17.0.9" {
+		t.Fatalf("Expected link address to be updated, got %q", values["DB_PORT_6379_TCP_ADDR"])
+	}
+	if values["DB_PORT"] != "tcp://172.17.0.9:6379" {
+		t.Fatalf("Expected link port to be updated, got %q", values["DB_PORT"])
+	}
+	if values["DB_PORT_6379_TCP_PORT"] != "1234" {
+		t.Fatalf("Expected user override to be kept, got %q", values["DB_PORT_6379_TCP_PORT"])
+	}
+	if values["PATH"] != "/bin" || values["HOSTNAME"] != "parent" {
+		t.Fatalf("Expected unrelated variables to be kept, got %v", env)
+	}
+	if len(env) != len(values) {
+		t.Fatalf("Expected no duplicate variables, got %v", env)
+	}
+}
+
+func TestUpdateParentsHostsFailure(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-test-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	graph, err := graphdb.NewSqliteConn(filepath.Join(tmp, "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventsService := events.New()
+	daemon := &Daemon{
+		containers:     &contStore{s: make(map[string]*Container)},
+		idIndex:        truncindex.NewTruncIndex([]string{}),
+		containerGraph: graph,
+		EventsService:  eventsService,
+	}
+
+	newContainer := func(name, ip, hostsPath string) *Container {
+		c := &Container{
+			CommonContainer: CommonContainer{
+				ID:              stringid.GenerateRandomID(),
+				Name:            name,
+				State:           NewState(),
+				Config:          &runconfig.Config{},
+				NetworkSettings: &network.Settings{IPAddress: ip},
+				HostsPath:       hostsPath,
+				hostConfig:      &runconfig.HostConfig{},
+				daemon:          daemon,
+			},
+		}
+		daemon.containers.Add(c.ID, c)
+		daemon.idIndex.Add(c.ID)
+		if _, err := graph.Set(name, c.ID); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	child := newContainer("/db", "172.17.0.9", "")
+
+	// The hosts file of the first parent cannot be rewritten.
+	hostsPath := filepath.Join(tmp, "hosts")
+	if err := ioutil.WriteFile(hostsPath, []byte("172.17.0.3\tdb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broken := newContainer("/broken", "172.17.0.2", filepath.Join(tmp, "missing", "hosts"))
+	parent := newContainer("/parent", "172.17.0.4", hostsPath)
+	for _, p := range []*Container{broken, parent} {
+		link, err := links.NewLink(p.NetworkSettings.IPAddress, "172.17.0.3", p.Name+"/db", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		link.Enable()
+		p.Running = true
+		p.activeLinks = map[string]*links.Link{"db": link}
+		if _, err := graph.Set(p.Name+"/db", child.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, l := eventsService.Subscribe()
+	defer eventsService.Evict(l)
+
+	err = child.updateParentsHosts()
+	if err == nil || !strings.Contains(err.Error(), "1 parent(s)") {
+		t.Fatalf("Expected the update of 1 parent to fail, got %v", err)
+	}
+
+	// The link of the broken parent is kept as it was.
+	if link := broken.activeLinks["db"]; link.ChildIP != "172.17.0.3" || !link.IsEnabled {
+		t.Fatalf("Expected the old link of the broken parent to be kept, got %s", link.ChildIP)
+	}
+	if link := parent.activeLinks["db"]; link.ChildIP != "172.17.0.9" {
+		t.Fatalf("Expected the link of the parent to be updated, got %s", link.ChildIP)
+	}
+
+	// Only the parent that was updated gets an event.
+	select {
+	case ev := <-l:
+		if jm := ev.(*jsonmessage.JSONMessage); jm.ID != parent.ID || jm.Status != "link_update: db" {
+			t.Fatalf("Unexpected event %s for %s", jm.Status, jm.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an event for the updated parent")
+	}
+	select {
+	case ev := <-l:
+		t.Fatalf("Unexpected event %v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSetResolvOptions(t *testing.T) {
+	for _, c := range []struct {
+		content, expected string
+	}{
+		{"", "options ndots:2 rotate\n"},
+		{"nameserver 8.8.8.8\nsearch mydomain\n", "nameserver 8.8.8.8\nsearch mydomain\noptions ndots:2 rotate\n"},
+		{"search example.com\noptions timeout:3\nnameserver 12.34.56.78", "search example.com\nnameserver 12.34.56.78\noptions ndots:2 rotate\n"},
+		{"options timeout:3\n  options attempts:2\n# options debug\n", "# options debug\noptions ndots:2 rotate\n"},
+	} {
+		if out := string(setResolvOptions([]byte(c.content), []string{"ndots:2", "rotate"})); out != c.expected {
+			t.Fatalf("Expected %q for %q, got %q", c.expected, c.content, out)
+		}
+	}
+}
@@ -44,6 +44,7 @@ func (container *Container) setupContainerDns() error {
 	return nil
 }
 
+// updateParentsHosts does nothing, as containers cannot be linked on Windows.
 func (container *Container) updateParentsHosts() error {
 	return nil
 }
@@ -155,6 +156,12 @@ func (container *Container) GetSize() (int64, int64) {
 	return 0, 0
 }
 
+// getSizeRw returns the size of the writable layer of the container.
+func (container *Container) getSizeRw() (int64, error) {
+	// TODO Windows
+	return 0, nil
+}
+
 func (container *Container) AllocateNetwork() error {
 	return nil
 }
@@ -25,6 +25,13 @@ func (daemon *Daemon) ContainerCreate(name string, config *runconfig.Config, hos
 		return "", warnings, err
 	}
 
+	// Images that do not exist are reported by Create.
+	if img, err := daemon.repositories.LookupImage(config.Image); err == nil && img != nil {
+		if err := daemon.repositories.CheckSignature(img.ID); err != nil {
+			return "", warnings, fmt.Errorf("Refusing to run image %s: %v", config.Image, err)
+		}
+	}
+
 	container, buildWarnings, err := daemon.Create(config, hostConfig, name)
 	if err != nil {
 		if daemon.Graph().IsNotExist(err, config.Image) {
@@ -81,7 +88,7 @@ func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.Hos
 	if err := daemon.Register(container); err != nil {
 		return nil, nil, err
 	}
-	if err := daemon.createRootfs(container); err != nil {
+	if err := daemon.createRootfs(container, hostConfig.StorageOpt); err != nil {
 		return nil, nil, err
 	}
 	if err := daemon.setHostConfig(container, hostConfig); err != nil {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -18,12 +19,14 @@ import (
 	"github.com/docker/docker/daemon/events"
 	"github.com/docker/docker/daemon/execdriver"
 	"github.com/docker/docker/daemon/execdriver/execdrivers"
+	"github.com/docker/docker/daemon/firewall"
 	"github.com/docker/docker/daemon/graphdriver"
 	_ "github.com/docker/docker/daemon/graphdriver/vfs"
 	"github.com/docker/docker/daemon/logger"
 	"github.com/docker/docker/daemon/network"
 	"github.com/docker/docker/graph"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/broadcastwriter"
 	"github.com/docker/docker/pkg/fileutils"
 	"github.com/docker/docker/pkg/graphdb"
@@ -36,6 +39,7 @@ import (
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/runconfig"
 	"github.com/docker/libnetwork"
+	"github.com/docker/libtrust"
 	"github.com/opencontainers/runc/libcontainer/netlink"
 )
 
@@ -100,7 +104,11 @@ type Daemon struct {
 	RegistryService  *registry.Service
 	EventsService    *events.Events
 	netController    libnetwork.NetworkController
+	firewall         *firewall.Manager
 	root             string
+	imageGCLock      sync.Mutex
+	diskUsage        diskUsageCache
+	staticAddresses  staticAddresses
 }
 
 // Get looks for a container using the provided information, which could be
@@ -239,7 +247,9 @@ func (daemon *Daemon) ensureName(container *Container) error {
 	return nil
 }
 
-func (daemon *Daemon) restore() error {
+// restore loads the containers of the daemon. migrated maps the old IDs of
+// the images migrated to content addressable IDs to their new IDs.
+func (daemon *Daemon) restore(migrated map[string]string) error {
 	type cr struct {
 		container  *Container
 		registered bool
@@ -274,11 +284,22 @@ func (daemon *Daemon) restore() error {
 		if (container.Driver == "" && currentDriver == "aufs") || container.Driver == currentDriver {
 			logrus.Debugf("Loaded container %v", container.ID)
 
+			if imageID, ok := migrated[container.ImageID]; ok {
+				// The layers of the container are on top of the
+				// layer of the image under its old ID.
+				daemon.graph.HoldMigratedLayer(container.ImageID, container.ID)
+				container.ImageID = imageID
+				if err := container.toDisk(); err != nil {
+					logrus.Errorf("Failed to update the image of container %v: %v", container.ID, err)
+				}
+			}
+
 			containers[container.ID] = &cr{container: container}
 		} else {
 			logrus.Debugf("Cannot load container %s because it was created with another graph driver.", container.ID)
 		}
 	}
+	daemon.graph.PruneMigratedLayers()
 
 	if entities := daemon.containerGraph.List("/", -1); entities != nil {
 		for _, p := range entities.Paths() {
@@ -294,7 +315,11 @@ func (daemon *Daemon) restore() error {
 		}
 	}
 
-	group := sync.WaitGroup{}
+	var (
+		group       = sync.WaitGroup{}
+		restartLock sync.Mutex
+		restarts    []*Container
+	)
 	for _, c := range containers {
 		group.Add(1)
 
@@ -312,19 +337,42 @@ func (daemon *Daemon) restore() error {
 			if err := daemon.register(container, false); err != nil {
 				logrus.Debugf("Failed to register container %s: %s", container.ID, err)
 			}
+			if container.hostConfig != nil {
+				if err := daemon.reserveStaticAddresses(container, container.hostConfig); err != nil {
+					logrus.Errorf("Failed to reserve the static addresses of container %s: %v", container.ID, err)
+				}
+			}
 
 			// check the restart policy on the containers and restart any container with
 			// the restart policy of "always"
 			if daemon.config.AutoRestart && container.shouldRestart() {
-				logrus.Debugf("Starting container %s", container.ID)
+				restartLock.Lock()
+				restarts = append(restarts, container)
+				restartLock.Unlock()
+			}
+		}(c.container, c.registered)
+	}
+	group.Wait()
 
+	// Containers that had an address before are started first so they get it
+	// back before the others are handed the next free ones.
+	for _, pinned := range []bool{true, false} {
+		for _, container := range restarts {
+			if (container.IPAMState != nil) != pinned {
+				continue
+			}
+			group.Add(1)
+			go func(container *Container) {
+				defer group.Done()
+
+				logrus.Debugf("Starting container %s", container.ID)
 				if err := container.Start(); err != nil {
 					logrus.Debugf("Failed to start container %s: %s", container.ID, err)
 				}
-			}
-		}(c.container, c.registered)
+			}(container)
+		}
+		group.Wait()
 	}
-	group.Wait()
 
 	if !debug {
 		if logrus.GetLevel() == logrus.InfoLevel {
@@ -557,6 +605,37 @@ func NewDaemon(config *Config, registryService *registry.Service) (daemon *Daemo
 	if err := checkConfigOptions(config); err != nil {
 		return nil, err
 	}
+	if err := config.ImageGC.validate(); err != nil {
+		return nil, err
+	}
+	trustedKeys, err := loadTrustedKeys(config.TrustedKeys)
+	if err != nil {
+		return nil, err
+	}
+	if config.RequireSignature && len(trustedKeys) == 0 {
+		return nil, fmt.Errorf("--require-signature needs at least one --trusted-key")
+	}
+	pushCompression, err := archive.ParseCompression(config.PushCompression)
+	if err != nil {
+		return nil, err
+	}
+	if pushCompression == archive.Uncompressed {
+		return nil, fmt.Errorf("Pushed layers must be compressed")
+	}
+	if err := pushCompression.ValidateLevel(config.PushCompressionLevel); err != nil {
+		return nil, err
+	}
+	if err := pushCompression.CheckCommand(); err != nil {
+		return nil, err
+	}
+	for _, compression := range []archive.Compression{archive.Xz, archive.Zstd} {
+		if err := compression.CheckCommand(); err != nil {
+			logrus.Warnf("%v, layers compressed with %s cannot be pulled or loaded", err, compression.Name())
+		}
+	}
+	if config.PushLayerIndex && pushCompression != archive.Gzip {
+		return nil, fmt.Errorf("--push-layer-index needs layers to be compressed with gzip")
+	}
 
 	// Do we have a disabled network?
 	config.DisableBridge = isBridgeNetworkDisabled(config)
@@ -611,6 +690,9 @@ func NewDaemon(config *Config, registryService *registry.Service) (daemon *Daemo
 		return nil, fmt.Errorf("error initializing graphdriver: %v", err)
 	}
 	logrus.Debugf("Using graph driver %s", driver)
+	if _, ok := driver.(graphdriver.LazyDriver); config.LazyPull && !ok {
+		return nil, fmt.Errorf("--lazy-pull is not supported by the %s storage driver", driver)
+	}
 
 	d := &Daemon{}
 	d.driver = driver
@@ -649,7 +731,14 @@ func NewDaemon(config *Config, registryService *registry.Service) (daemon *Daemo
 	}
 
 	logrus.Debug("Creating images graph")
-	g, err := graph.NewGraph(filepath.Join(config.Root, "graph"), d.driver)
+	g, err := graph.NewGraph(filepath.Join(config.Root, "image", d.driver.String()), d.driver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Migrate the images of the graph created before image IDs were
+	// content addressable.
+	migrated, err := g.Migrate(filepath.Join(config.Root, "graph"))
 	if err != nil {
 		return nil, err
 	}
@@ -671,23 +760,45 @@ func NewDaemon(config *Config, registryService *registry.Service) (daemon *Daemo
 	}
 
 	eventsService := events.New()
+	if pool, ok := graphdriver.GetPoolDriver(driver); ok {
+		poolName := pool.PoolStatus().Name
+		pool.SetPoolEventsHandler(func(event string) {
+			eventsService.Log(event, poolName, driver.String())
+		})
+	}
 	logrus.Debug("Creating repository list")
 	tagCfg := &graph.TagStoreConfig{
 		Graph:    g,
 		Key:      trustKey,
 		Registry: registryService,
 		Events:   eventsService,
+
+		MaxConcurrentDownloads: config.MaxConcurrentDownloads,
+		RequireSignature:       config.RequireSignature,
+		TrustedKeys:            trustedKeys,
+		PushCompression:        pushCompression,
+		PushCompressionLevel:   config.PushCompressionLevel,
+		PushLayerIndex:         config.PushLayerIndex,
+		LazyPull:               config.LazyPull,
 	}
 	repositories, err := graph.NewTagStore(filepath.Join(config.Root, "repositories-"+d.driver.String()), tagCfg)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't create Tag store repositories-%s: %s", d.driver.String(), err)
 	}
+	if err := repositories.ReplaceImageIDs(migrated); err != nil {
+		return nil, err
+	}
 
 	d.netController, err = initNetworkController(config)
 	if err != nil {
 		return nil, fmt.Errorf("Error initializing network controller: %v", err)
 	}
 
+	d.firewall, err = initFirewall(config)
+	if err != nil {
+		return nil, err
+	}
+
 	graphdbPath := filepath.Join(config.Root, "linkgraph.db")
 	graph, err := graphdb.NewSqliteConn(graphdbPath)
 	if err != nil {
@@ -734,8 +845,11 @@ func NewDaemon(config *Config, registryService *registry.Service) (daemon *Daemo
 	d.EventsService = eventsService
 	d.root = config.Root
 	go d.execCommandGC()
+	if config.ImageGC.HighWatermark > 0 {
+		go d.imageGCLoop()
+	}
 
-	if err := d.restore(); err != nil {
+	if err := d.restore(migrated); err != nil {
 		return nil, err
 	}
 
@@ -777,6 +891,10 @@ func (daemon *Daemon) Shutdown() error {
 		}
 	}
 
+	if daemon.graph != nil {
+		daemon.graph.Cleanup()
+	}
+
 	if daemon.driver != nil {
 		if err := daemon.driver.Cleanup(); err != nil {
 			logrus.Errorf("Error during graph storage driver.Cleanup(): %v", err)
@@ -815,6 +933,14 @@ func (daemon *Daemon) Run(c *Container, pipes *execdriver.Pipes, startCallback e
 	return daemon.execDriver.Run(c.command, pipes, startCallback)
 }
 
+func (daemon *Daemon) Checkpoint(c *Container, opts *execdriver.CheckpointOptions) error {
+	return daemon.execDriver.Checkpoint(c.command, opts)
+}
+
+func (daemon *Daemon) Restore(c *Container, pipes *execdriver.Pipes, restoreCallback execdriver.StartCallback, opts *execdriver.CheckpointOptions) (execdriver.ExitStatus, error) {
+	return daemon.execDriver.Restore(c.command, pipes, restoreCallback, opts)
+}
+
 func (daemon *Daemon) Kill(c *Container, sig int) error {
 	return daemon.execDriver.Kill(c.command, sig)
 }
@@ -915,7 +1041,76 @@ func tempDir(rootDir string) (string, error) {
 	return tmpDir, system.MkdirAll(tmpDir, 0700)
 }
 
+// staticAddresses records the static addresses claimed by containers, so two
+// containers cannot be given the same one.
+type staticAddresses struct {
+	sync.Mutex
+	// owners maps the addresses to the IDs of the containers claiming them
+	owners map[string]string
+}
+
+// staticAddressKey returns the canonical form of addr, so the different ways
+// of writing an IPv6 address claim the same address.
+func staticAddressKey(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
+
+// reserveStaticAddresses claims the static addresses requested in hostConfig
+// for container, in place of the ones it claimed before. It fails if another
+// container already claims one of them.
+func (daemon *Daemon) reserveStaticAddresses(container *Container, hostConfig *runconfig.HostConfig) error {
+	var addrs []string
+	for _, addr := range []string{hostConfig.IPv4Address, hostConfig.IPv6Address} {
+		if addr != "" {
+			addrs = append(addrs, staticAddressKey(addr))
+		}
+	}
+
+	daemon.staticAddresses.Lock()
+	defer daemon.staticAddresses.Unlock()
+
+	if daemon.staticAddresses.owners == nil {
+		daemon.staticAddresses.owners = make(map[string]string)
+	}
+	for _, addr := range addrs {
+		if id, ok := daemon.staticAddresses.owners[addr]; ok && id != container.ID {
+			return fmt.Errorf("Conflict. The IP address %s is already in use by container %s.", addr, stringid.TruncateID(id))
+		}
+	}
+	daemon.releaseStaticAddressesLocked(container.ID)
+	for _, addr := range addrs {
+		daemon.staticAddresses.owners[addr] = container.ID
+	}
+	return nil
+}
+
+// releaseStaticAddresses gives up the static addresses claimed by the
+// container id.
+func (daemon *Daemon) releaseStaticAddresses(id string) {
+	daemon.staticAddresses.Lock()
+	defer daemon.staticAddresses.Unlock()
+
+	daemon.releaseStaticAddressesLocked(id)
+}
+
+func (daemon *Daemon) releaseStaticAddressesLocked(id string) {
+	for addr, owner := range daemon.staticAddresses.owners {
+		if owner == id {
+			delete(daemon.staticAddresses.owners, addr)
+		}
+	}
+}
+
 func (daemon *Daemon) setHostConfig(container *Container, hostConfig *runconfig.HostConfig) error {
+	// The addresses stay reserved if the rest fails, until the container is
+	// removed.
+	if err := daemon.reserveStaticAddresses(container, hostConfig); err != nil {
+		return err
+	}
+
 	container.Lock()
 	if err := parseSecurityOpt(container, hostConfig); err != nil {
 		container.Unlock()
@@ -964,6 +1159,20 @@ func setDefaultMtu(config *Config) {
 	}
 }
 
+// loadTrustedKeys loads the public keys image signatures are verified with
+// from files, which hold PEM encoded keys or JSON Web Key sets.
+func loadTrustedKeys(files []string) ([]libtrust.PublicKey, error) {
+	var keys []libtrust.PublicKey
+	for _, file := range files {
+		fileKeys, err := libtrust.LoadKeySetFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading trusted keys from %s: %v", file, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return keys, nil
+}
+
 var errNoDefaultRoute = errors.New("no default route was found")
 
 // getDefaultRouteMtu returns the MTU for the default route's interface.
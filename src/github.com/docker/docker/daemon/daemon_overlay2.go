@@ -0,0 +1,7 @@
+// +build !exclude_graphdriver_overlay2,linux
+
+package daemon
+
+import (
+	_ "github.com/docker/docker/daemon/graphdriver/overlay2"
+)
@@ -1,8 +1,12 @@
 package daemon
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/docker/docker/daemon/network"
 	"github.com/docker/docker/runconfig"
 )
 
@@ -37,3 +41,93 @@ func TestParseSecurityOpt(t *testing.T) {
 		t.Fatal("Expected parseSecurityOpt error, got nil")
 	}
 }
+
+func TestRequestedAddresses(t *testing.T) {
+	container := &Container{
+		CommonContainer: CommonContainer{
+			hostConfig: &runconfig.HostConfig{},
+			IPAMState: &network.IPAMState{
+				IPAddress:         "172.17.0.5",
+				GlobalIPv6Address: "2001:db8::5",
+			},
+		},
+	}
+
+	if ip4, ip6 := container.requestedAddresses(false); ip4 != nil || ip6 != nil {
+		t.Fatalf("Expected no addresses without sticky, got %v and %v", ip4, ip6)
+	}
+	if !container.hasStickyAddresses() {
+		t.Fatal("Expected container to have sticky addresses")
+	}
+	ip4, ip6 := container.requestedAddresses(true)
+	if ip4.String() != "172.17.0.5" || ip6.String() != "2001:db8::5" {
+		t.Fatalf("Expected previous addresses, got %v and %v", ip4, ip6)
+	}
+
+	container.hostConfig.IPv4Address = "172.17.0.42"
+	container.hostConfig.IPv6Address = "2001:db8::42"
+	if container.hasStickyAddresses() {
+		t.Fatal("Expected static addresses to take precedence over sticky ones")
+	}
+	for _, sticky := range []bool{true, false} {
+		ip4, ip6 := container.requestedAddresses(sticky)
+		if ip4.String() != "172.17.0.42" || ip6.String() != "2001:db8::42" {
+			t.Fatalf("Expected static addresses, got %v and %v", ip4, ip6)
+		}
+	}
+}
+
+func TestReserveStaticAddresses(t *testing.T) {
+	daemon := &Daemon{}
+	existing := &Container{CommonContainer: CommonContainer{ID: "5a4ff6a163ad4533d22d69a2b8960bf7fafdcba06e72d2febdba229008b0bf57"}}
+	if err := daemon.reserveStaticAddresses(existing, &runconfig.HostConfig{IPv4Address: "172.17.0.42", IPv6Address: "2001:db8::42"}); err != nil {
+		t.Fatal(err)
+	}
+
+	container := &Container{CommonContainer: CommonContainer{ID: "3cdbd1aa394fd68559fd1441d6eff2ab7c1e6363582c82febfaa8045df3bd8de"}}
+	if err := daemon.reserveStaticAddresses(container, &runconfig.HostConfig{IPv4Address: "172.17.0.42"}); err == nil {
+		t.Fatal("Expected a conflict for an address already in use")
+	}
+	if err := daemon.reserveStaticAddresses(container, &runconfig.HostConfig{IPv6Address: "2001:DB8:0::42"}); err == nil {
+		t.Fatal("Expected a conflict for an address already in use written differently")
+	}
+	if err := daemon.reserveStaticAddresses(container, &runconfig.HostConfig{IPv4Address: "172.17.0.43"}); err != nil {
+		t.Fatalf("Unexpected error for a free address: %v", err)
+	}
+	if err := daemon.reserveStaticAddresses(existing, &runconfig.HostConfig{IPv4Address: "172.17.0.42"}); err != nil {
+		t.Fatalf("Unexpected conflict of a container with itself: %v", err)
+	}
+
+	// The addresses a container no longer requests are given up
+	if err := daemon.reserveStaticAddresses(container, &runconfig.HostConfig{IPv6Address: "2001:db8::42"}); err != nil {
+		t.Fatalf("Unexpected conflict for an address given up: %v", err)
+	}
+
+	daemon.releaseStaticAddresses(existing.ID)
+	if err := daemon.reserveStaticAddresses(container, &runconfig.HostConfig{IPv4Address: "172.17.0.42"}); err != nil {
+		t.Fatalf("Unexpected conflict for the address of a removed container: %v", err)
+	}
+}
+
+func TestReserveStaticAddressesConcurrently(t *testing.T) {
+	daemon := &Daemon{}
+
+	var (
+		wg       sync.WaitGroup
+		reserved int32
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			container := &Container{CommonContainer: CommonContainer{ID: id}}
+			if err := daemon.reserveStaticAddresses(container, &runconfig.HostConfig{IPv4Address: "172.17.0.42"}); err == nil {
+				atomic.AddInt32(&reserved, 1)
+			}
+		}(fmt.Sprintf("container%d", i))
+	}
+	wg.Wait()
+	if reserved != 1 {
+		t.Fatalf("Expected the address to be reserved by a single container, got %d", reserved)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/autogen/dockerversion"
+	"github.com/docker/docker/daemon/firewall"
 	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/fileutils"
@@ -37,6 +38,13 @@ func (daemon *Daemon) Changes(container *Container) ([]archive.Change, error) {
 	return daemon.driver.Changes(container.ID, initID)
 }
 
+// walkChanges calls fn with each change of the filesystem of the container,
+// as the graph driver finds them.
+func (daemon *Daemon) walkChanges(container *Container, fn func(archive.Change) error) error {
+	initID := fmt.Sprintf("%s-init", container.ID)
+	return graphdriver.WalkChanges(daemon.driver, container.ID, initID, fn)
+}
+
 func (daemon *Daemon) Diff(container *Container) (archive.Archive, error) {
 	initID := fmt.Sprintf("%s-init", container.ID)
 	return daemon.driver.Diff(container.ID, initID)
@@ -67,14 +75,18 @@ func parseSecurityOpt(container *Container, config *runconfig.HostConfig) error
 	return err
 }
 
-func (daemon *Daemon) createRootfs(container *Container) error {
+func (daemon *Daemon) createRootfs(container *Container, storageOpt map[string]string) error {
 	// Step 1: create the container directory.
 	// This doubles as a barrier to avoid race conditions.
 	if err := os.Mkdir(container.root, 0700); err != nil {
 		return err
 	}
+	parent, err := daemon.graph.GraphDriverID(container.ImageID)
+	if err != nil {
+		return err
+	}
 	initID := fmt.Sprintf("%s-init", container.ID)
-	if err := daemon.driver.Create(initID, container.ImageID); err != nil {
+	if err := daemon.driver.Create(initID, parent, nil); err != nil {
 		return err
 	}
 	initPath, err := daemon.driver.Get(initID, "")
@@ -91,7 +103,7 @@ func (daemon *Daemon) createRootfs(container *Container) error {
 	// for the actual container.
 	daemon.driver.Put(initID)
 
-	if err := daemon.driver.Create(container.ID, initID); err != nil {
+	if err := daemon.driver.Create(container.ID, initID, storageOpt); err != nil {
 		return err
 	}
 	return nil
@@ -211,6 +223,9 @@ func checkConfigOptions(config *Config) error {
 	if !config.Bridge.EnableIPTables && !config.Bridge.InterContainerCommunication {
 		return fmt.Errorf("You specified --iptables=false with --icc=false. ICC uses iptables to function. Please set --icc or --iptables to true.")
 	}
+	if !config.Bridge.EnableIPTables && config.Bridge.ICCPolicy != "" {
+		return fmt.Errorf("You specified --iptables=false with --icc-policy. The policy uses iptables to function. Please set --iptables to true.")
+	}
 	if !config.Bridge.EnableIPTables && config.Bridge.EnableIPMasq {
 		config.Bridge.EnableIPMasq = false
 	}
@@ -361,6 +376,39 @@ func initNetworkController(config *Config) (libnetwork.NetworkController, error)
 	return controller, nil
 }
 
+// initFirewall loads the policy given with --icc-policy. Without a policy, the
+// chains left over by a daemon that had one are removed.
+func initFirewall(config *Config) (*firewall.Manager, error) {
+	if config.Bridge.ICCPolicy == "" {
+		m := firewall.NewManager(nil)
+		if config.Bridge.EnableIPTables {
+			if err := m.RemoveLeftoverChains(); err != nil {
+				logrus.Warnf("Failed to remove leftover firewall policy chains: %v", err)
+			}
+		}
+		return m, nil
+	}
+	policy, err := firewall.Load(config.Bridge.ICCPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return firewall.NewManager(policy), nil
+}
+
+// diskUsage returns the used space of the filesystem of path in percent, like
+// df reports it.
+func diskUsage(path string) (int, error) {
+	var buf syscall.Statfs_t
+	if err := syscall.Statfs(path, &buf); err != nil {
+		return 0, err
+	}
+	used := buf.Blocks - buf.Bfree
+	if used+buf.Bavail == 0 {
+		return 0, nil
+	}
+	return int((used*100 + used + buf.Bavail - 1) / (used + buf.Bavail)), nil
+}
+
 func initBridgeDriver(controller libnetwork.NetworkController, config *Config) error {
 	option := options.Generic{
 		"EnableIPForwarding": config.Bridge.EnableIPForward}
@@ -6,6 +6,7 @@ import (
 	"syscall"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/firewall"
 	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/daemon/graphdriver/windows"
 	"github.com/docker/docker/pkg/archive"
@@ -18,24 +19,55 @@ import (
 const DefaultVirtualSwitch = "Virtual Switch"
 
 func (daemon *Daemon) Changes(container *Container) ([]archive.Change, error) {
-	return daemon.driver.Changes(container.ID, container.ImageID)
+	parent, err := daemon.imageDriverID(container)
+	if err != nil {
+		return nil, err
+	}
+	return daemon.driver.Changes(container.ID, parent)
+}
+
+// walkChanges calls fn with each change of the filesystem of the container,
+// as the graph driver finds them.
+func (daemon *Daemon) walkChanges(container *Container, fn func(archive.Change) error) error {
+	parent, err := daemon.imageDriverID(container)
+	if err != nil {
+		return err
+	}
+	return graphdriver.WalkChanges(daemon.driver, container.ID, parent, fn)
 }
 
 func (daemon *Daemon) Diff(container *Container) (archive.Archive, error) {
-	return daemon.driver.Diff(container.ID, container.ImageID)
+	parent, err := daemon.imageDriverID(container)
+	if err != nil {
+		return nil, err
+	}
+	return daemon.driver.Diff(container.ID, parent)
+}
+
+// imageDriverID returns the ID of the top layer of the container's image in
+// the graph driver.
+func (daemon *Daemon) imageDriverID(container *Container) (string, error) {
+	if container.ImageID == "" {
+		return "", nil
+	}
+	return daemon.graph.GraphDriverID(container.ImageID)
 }
 
 func parseSecurityOpt(container *Container, config *runconfig.HostConfig) error {
 	return nil
 }
 
-func (daemon *Daemon) createRootfs(container *Container) error {
+func (daemon *Daemon) createRootfs(container *Container, storageOpt map[string]string) error {
 	// Step 1: create the container directory.
 	// This doubles as a barrier to avoid race conditions.
 	if err := os.Mkdir(container.root, 0700); err != nil {
 		return err
 	}
 
+	parent, err := daemon.imageDriverID(container)
+	if err != nil {
+		return err
+	}
 	if wd, ok := daemon.driver.(*windows.WindowsGraphDriver); ok {
 		if container.ImageID != "" {
 			// Get list of paths to parent layers.
@@ -51,17 +83,17 @@ func (daemon *Daemon) createRootfs(container *Container) error {
 			}
 			logrus.Debugf("Got image ids: %d", len(ids))
 
-			if err := hcsshim.CreateSandboxLayer(wd.Info(), container.ID, container.ImageID, wd.LayerIdsToPaths(ids)); err != nil {
+			if err := hcsshim.CreateSandboxLayer(wd.Info(), container.ID, parent, wd.LayerIdsToPaths(ids)); err != nil {
 				return err
 			}
 		} else {
-			if err := daemon.driver.Create(container.ID, container.ImageID); err != nil {
+			if err := daemon.driver.Create(container.ID, parent, storageOpt); err != nil {
 				return err
 			}
 		}
 	} else {
 		// Fall-back code path to allow the use of the VFS driver for development
-		if err := daemon.driver.Create(container.ID, container.ImageID); err != nil {
+		if err := daemon.driver.Create(container.ID, parent, storageOpt); err != nil {
 			return err
 		}
 
@@ -80,6 +112,9 @@ func (daemon *Daemon) verifyContainerSettings(hostConfig *runconfig.HostConfig,
 
 // checkConfigOptions checks for mutually incompatible config options
 func checkConfigOptions(config *Config) error {
+	if config.ImageGC.HighWatermark > 0 {
+		return fmt.Errorf("--image-gc-high-watermark is not supported on Windows")
+	}
 	return nil
 }
 
@@ -126,6 +161,15 @@ func isBridgeNetworkDisabled(config *Config) bool {
 	return false
 }
 
+func initFirewall(config *Config) (*firewall.Manager, error) {
+	return firewall.NewManager(nil), nil
+}
+
+func diskUsage(path string) (int, error) {
+	// TODO Windows.
+	return 0, fmt.Errorf("Disk usage is not supported on Windows")
+}
+
 func initNetworkController(config *Config) (libnetwork.NetworkController, error) {
 	// Set the name of the virtual switch if not specified by -b on daemon start
 	if config.Bridge.VirtualSwitchName == "" {
@@ -102,6 +102,7 @@ func (daemon *Daemon) rm(container *Container, forceRemove bool) (err error) {
 		if err != nil && forceRemove {
 			daemon.idIndex.Delete(container.ID)
 			daemon.containers.Delete(container.ID)
+			daemon.releaseStaticAddresses(container.ID)
 			os.RemoveAll(container.root)
 			container.LogEvent("destroy")
 		}
@@ -122,6 +123,7 @@ func (daemon *Daemon) rm(container *Container, forceRemove bool) (err error) {
 			return fmt.Errorf("Driver %s failed to remove init filesystem %s: %s", daemon.driver, initID, err)
 		}
 	}
+	daemon.graph.ReleaseMigratedLayers(container.ID)
 
 	if err = os.RemoveAll(container.root); err != nil {
 		return fmt.Errorf("Unable to remove filesystem for %v: %v", container.ID, err)
@@ -134,6 +136,7 @@ func (daemon *Daemon) rm(container *Container, forceRemove bool) (err error) {
 	selinuxFreeLxcContexts(container.ProcessLabel)
 	daemon.idIndex.Delete(container.ID)
 	daemon.containers.Delete(container.ID)
+	daemon.releaseStaticAddresses(container.ID)
 
 	container.LogEvent("destroy")
 	return nil
@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/directory"
+	"github.com/docker/docker/pkg/graphdb"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/volume"
+)
+
+// diskUsageMaxAge is how long the sizes of the writable layers of running
+// containers and of volumes are cached, as they change without the daemon
+// knowing. The writable layers of stopped containers are cached until they
+// are started again, the image sizes until an image is added or deleted.
+const diskUsageMaxAge = time.Minute
+
+// cachedSize is a size computed at a given time.
+type cachedSize struct {
+	size int64
+	at   time.Time
+}
+
+// diskUsageCache holds the sizes computed by SystemDiskUsage, the sizes of
+// the writable layers of containers keyed by container ID and the sizes of
+// volumes keyed by path.
+type diskUsageCache struct {
+	sync.Mutex
+	containers map[string]cachedSize
+	volumes    map[string]cachedSize
+}
+
+// SystemDiskUsage returns the disk usage of the images, the writable layers
+// and the logs of the containers, and the volumes.
+func (daemon *Daemon) SystemDiskUsage() (*types.DiskUsage, error) {
+	daemon.diskUsage.Lock()
+	defer daemon.diskUsage.Unlock()
+
+	containers := daemon.List()
+
+	images, layersSize := daemon.Repositories().ImagesDiskUsage()
+	imageContainers := make(map[string]int)
+	for _, container := range containers {
+		imageContainers[container.ImageID]++
+	}
+	for _, img := range images {
+		img.Containers = imageContainers[img.ID]
+	}
+
+	containersUsage, err := daemon.containersDiskUsage(containers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.DiskUsage{
+		LayersSize: layersSize,
+		Images:     images,
+		Containers: containersUsage,
+		Volumes:    daemon.volumesDiskUsage(containers),
+	}, nil
+}
+
+// containersDiskUsage returns the disk usage of containers. It must be called
+// with the cache locked.
+func (daemon *Daemon) containersDiskUsage(containers []*Container) ([]*types.ContainerDiskUsage, error) {
+	names := map[string][]string{}
+	if err := daemon.ContainerGraph().Walk("/", func(p string, e *graphdb.Entity) error {
+		names[e.ID()] = append(names[e.ID()], p)
+		return nil
+	}, 1); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	cache := make(map[string]cachedSize, len(containers))
+	usage := make([]*types.ContainerDiskUsage, 0, len(containers))
+	for _, container := range containers {
+		container.Lock()
+		u := &types.ContainerDiskUsage{
+			ID:      container.ID,
+			Names:   names[container.ID],
+			Image:   container.Config.Image,
+			Created: int(container.Created.Unix()),
+			Status:  container.State.String(),
+			LogSize: logSize(container.LogPath),
+		}
+		running := container.State.Running
+		lastChange := container.State.StartedAt
+		if container.State.FinishedAt.After(lastChange) {
+			lastChange = container.State.FinishedAt
+		}
+		container.Unlock()
+
+		cached, ok := daemon.diskUsage.containers[container.ID]
+		if !ok || cached.at.Before(lastChange) || (running && now.Sub(cached.at) > diskUsageMaxAge) {
+			size, err := container.getSizeRw()
+			if err != nil {
+				logrus.Errorf("Failed to compute size of container rootfs %s: %s", stringid.TruncateID(container.ID), err)
+				size = -1
+			}
+			cached = cachedSize{size: size, at: now}
+		}
+		cache[container.ID] = cached
+		u.SizeRw = cached.size
+		usage = append(usage, u)
+	}
+	// Only keep the containers that still exist.
+	daemon.diskUsage.containers = cache
+	return usage, nil
+}
+
+// volumesDiskUsage returns the disk usage of the volumes used by containers.
+// It must be called with the cache locked.
+func (daemon *Daemon) volumesDiskUsage(containers []*Container) []*types.VolumeDiskUsage {
+	volumes := make(map[string]*types.VolumeDiskUsage)
+	for _, container := range containers {
+		container.Lock()
+		for _, m := range container.MountPoints {
+			if m.Volume == nil {
+				continue
+			}
+			path := m.Volume.Path()
+			u, ok := volumes[path]
+			if !ok {
+				u = &types.VolumeDiskUsage{
+					Name:       m.Volume.Name(),
+					Driver:     m.Volume.DriverName(),
+					Mountpoint: path,
+					Size:       -1,
+				}
+				volumes[path] = u
+			}
+			u.Containers++
+		}
+		container.Unlock()
+	}
+
+	now := time.Now().UTC()
+	cache := make(map[string]cachedSize, len(volumes))
+	usage := make([]*types.VolumeDiskUsage, 0, len(volumes))
+	for path, u := range volumes {
+		if u.Driver == volume.DefaultDriverName {
+			cached, ok := daemon.diskUsage.volumes[path]
+			if !ok || now.Sub(cached.at) > diskUsageMaxAge {
+				size, err := directory.Size(path)
+				if err != nil {
+					logrus.Errorf("Failed to compute size of volume %s: %s", u.Name, err)
+					size = -1
+				}
+				cached = cachedSize{size: size, at: now}
+			}
+			cache[path] = cached
+			u.Size = cached.size
+		}
+		usage = append(usage, u)
+	}
+	daemon.diskUsage.volumes = cache
+	sort.Sort(volumesDiskUsageByName(usage))
+	return usage
+}
+
+// logSize returns the size of the log file of a container and of its
+// rotated files, or 0 for log drivers other than json-file.
+func logSize(logPath string) int64 {
+	if logPath == "" {
+		return 0
+	}
+	rotated, _ := filepath.Glob(logPath + ".*")
+	var size int64
+	for _, p := range append([]string{logPath}, rotated...) {
+		if fi, err := os.Stat(p); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+type volumesDiskUsageByName []*types.VolumeDiskUsage
+
+func (r volumesDiskUsageByName) Len() int           { return len(r) }
+func (r volumesDiskUsageByName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r volumesDiskUsageByName) Less(i, j int) bool { return r[i].Name < r[j].Name }
@@ -21,6 +21,7 @@ var (
 	ErrWaitTimeoutReached      = errors.New("Wait timeout reached")
 	ErrDriverAlreadyRegistered = errors.New("A driver already registered this docker init function")
 	ErrDriverNotFound          = errors.New("The requested docker init has not been found")
+	ErrCheckpointNotSupported  = errors.New("Checkpoint and restore are not supported by this execution driver")
 )
 
 type StartCallback func(*ProcessConfig, int)
@@ -61,6 +62,22 @@ type Driver interface {
 	Terminate(c *Command) error                   // kill it with fire
 	Clean(id string) error                        // clean all traces of container exec
 	Stats(id string) (*ResourceStats, error)      // Get resource stats for a running container
+	// Checkpoint dumps the processes of a running container to disk with CRIU
+	Checkpoint(c *Command, opts *CheckpointOptions) error
+	// Restore restores the processes of a checkpointed container, blocks until the process exits and returns the exit code
+	Restore(c *Command, pipes *Pipes, restoreCallback StartCallback, opts *CheckpointOptions) (ExitStatus, error)
+}
+
+// CheckpointOptions are the options to checkpoint and restore the processes
+// of a container.
+type CheckpointOptions struct {
+	// ImagesDirectory is the directory the process tree, memory and TCP
+	// connections are dumped to and restored from.
+	ImagesDirectory string
+	// WorkDirectory is the directory of the CRIU logs.
+	WorkDirectory string
+	// LeaveRunning leaves the container running once dumped.
+	LeaveRunning bool
 }
 
 // Network settings of the container
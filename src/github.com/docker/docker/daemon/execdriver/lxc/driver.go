@@ -551,6 +551,14 @@ func (d *driver) Terminate(c *execdriver.Command) error {
 	return KillLxc(c.ID, 9)
 }
 
+func (d *driver) Checkpoint(c *execdriver.Command, opts *execdriver.CheckpointOptions) error {
+	return execdriver.ErrCheckpointNotSupported
+}
+
+func (d *driver) Restore(c *execdriver.Command, pipes *execdriver.Pipes, restoreCallback execdriver.StartCallback, opts *execdriver.CheckpointOptions) (execdriver.ExitStatus, error) {
+	return execdriver.ExitStatus{ExitCode: -1}, execdriver.ErrCheckpointNotSupported
+}
+
 func (d *driver) version() string {
 	var (
 		version string
@@ -159,6 +159,12 @@ func (d *driver) Run(c *execdriver.Command, pipes *execdriver.Pipes, startCallba
 		return execdriver.ExitStatus{ExitCode: -1}, err
 	}
 
+	return waitContainer(c, cont, p, startCallback)
+}
+
+// waitContainer calls startCallback once the process of the container p is
+// started, and waits for it to exit.
+func waitContainer(c *execdriver.Command, cont libcontainer.Container, p *libcontainer.Process, startCallback execdriver.StartCallback) (execdriver.ExitStatus, error) {
 	if startCallback != nil {
 		pid, err := p.Pid()
 		if err != nil {
@@ -287,6 +293,63 @@ func (d *driver) Unpause(c *execdriver.Command) error {
 	return active.Resume()
 }
 
+// criuOpts returns the CRIU options of opts. Shell jobs, established TCP
+// connections, external unix sockets and file locks are dumped and restored
+// alike, so what is checkpointed can be restored.
+func criuOpts(opts *execdriver.CheckpointOptions) *libcontainer.CriuOpts {
+	return &libcontainer.CriuOpts{
+		ImagesDirectory:         opts.ImagesDirectory,
+		WorkDirectory:           opts.WorkDirectory,
+		LeaveRunning:            opts.LeaveRunning,
+		TcpEstablished:          true,
+		ExternalUnixConnections: true,
+		ShellJob:                true,
+		FileLocks:               true,
+	}
+}
+
+func (d *driver) Checkpoint(c *execdriver.Command, opts *execdriver.CheckpointOptions) error {
+	d.Lock()
+	active := d.activeContainers[c.ID]
+	d.Unlock()
+	if active == nil {
+		return fmt.Errorf("active container for %s does not exist", c.ID)
+	}
+	return active.Checkpoint(criuOpts(opts))
+}
+
+func (d *driver) Restore(c *execdriver.Command, pipes *execdriver.Pipes, restoreCallback execdriver.StartCallback, opts *execdriver.CheckpointOptions) (execdriver.ExitStatus, error) {
+	// take the Command and populate the libcontainer.Config from it
+	container, err := d.createContainer(c)
+	if err != nil {
+		return execdriver.ExitStatus{ExitCode: -1}, err
+	}
+
+	// The arguments of the process are restored along with it.
+	p := &libcontainer.Process{}
+	if err := setupPipes(container, &c.ProcessConfig, p, pipes); err != nil {
+		return execdriver.ExitStatus{ExitCode: -1}, err
+	}
+
+	cont, err := d.factory.Create(c.ID, container)
+	if err != nil {
+		return execdriver.ExitStatus{ExitCode: -1}, err
+	}
+	d.Lock()
+	d.activeContainers[c.ID] = cont
+	d.Unlock()
+	defer func() {
+		cont.Destroy()
+		d.cleanContainer(c.ID)
+	}()
+
+	if err := cont.Restore(p, criuOpts(opts)); err != nil {
+		return execdriver.ExitStatus{ExitCode: -1}, err
+	}
+
+	return waitContainer(c, cont, p, restoreCallback)
+}
+
 func (d *driver) Terminate(c *execdriver.Command) error {
 	defer d.cleanContainer(c.ID)
 	container, err := d.factory.Load(c.ID)
@@ -0,0 +1,17 @@
+// +build windows
+
+package windows
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/daemon/execdriver"
+)
+
+func (d *driver) Checkpoint(c *execdriver.Command, opts *execdriver.CheckpointOptions) error {
+	return fmt.Errorf("Windows: Containers cannot be checkpointed")
+}
+
+func (d *driver) Restore(c *execdriver.Command, pipes *execdriver.Pipes, restoreCallback execdriver.StartCallback, opts *execdriver.CheckpointOptions) (execdriver.ExitStatus, error) {
+	return execdriver.ExitStatus{ExitCode: -1}, fmt.Errorf("Windows: Containers cannot be restored")
+}
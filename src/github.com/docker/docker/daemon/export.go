@@ -3,22 +3,41 @@ package daemon
 import (
 	"fmt"
 	"io"
+
+	"github.com/docker/docker/pkg/archive"
 )
 
-func (daemon *Daemon) ContainerExport(name string, out io.Writer) error {
+// ContainerExportConfig holds the options of the export of a container.
+type ContainerExportConfig struct {
+	// IncludeFiles, if any, are the only paths exported.
+	IncludeFiles []string
+	// ExcludePatterns are the globs of the paths not exported.
+	ExcludePatterns []string
+	// Changes exports the changes of the container since its image, as a
+	// layer, instead of its whole filesystem.
+	Changes   bool
+	Outstream io.Writer
+}
+
+func (daemon *Daemon) ContainerExport(name string, config *ContainerExportConfig) error {
 	container, err := daemon.Get(name)
 	if err != nil {
 		return err
 	}
 
-	data, err := container.Export()
+	var data archive.Archive
+	if config.Changes {
+		data, err = container.ExportChanges(config.IncludeFiles, config.ExcludePatterns)
+	} else {
+		data, err = container.Export(config.IncludeFiles, config.ExcludePatterns)
+	}
 	if err != nil {
 		return fmt.Errorf("%s: %s", name, err)
 	}
 	defer data.Close()
 
 	// Stream the entire contents of the container (basically a volatile snapshot)
-	if _, err := io.Copy(out, data); err != nil {
+	if _, err := io.Copy(config.Outstream, data); err != nil {
 		return fmt.Errorf("%s: %s", name, err)
 	}
 	return nil
@@ -0,0 +1,16 @@
+package daemon
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/daemon/firewall"
+)
+
+// FirewallRules returns the iptables rules policy compiles to for the running
+// containers, without applying them. A nil policy returns the rules of the
+// policy given with --icc-policy.
+func (daemon *Daemon) FirewallRules(policy *firewall.Policy) *types.FirewallRules {
+	return &types.FirewallRules{
+		Enabled: daemon.firewall.Enabled(),
+		Bridges: daemon.firewall.Rules(policy),
+	}
+}
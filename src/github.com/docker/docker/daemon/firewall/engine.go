@@ -0,0 +1,327 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+)
+
+// chainPrefix is followed by the bridge name, which is at most 15 characters,
+// to stay below the 28 characters iptables allows for a chain name.
+const chainPrefix = "DOCKER-POL-"
+
+// spareChainPrefix names the chain new rules are built in before they replace
+// the rules in the chain of the bridge.
+const spareChainPrefix = "DOCKER-POL2-"
+
+// iptablesRaw runs iptables, it is replaced in tests.
+var iptablesRaw = iptables.Raw
+
+// Endpoint is a container attached to a bridge.
+type Endpoint struct {
+	ID     string
+	IP     string
+	Labels map[string]string
+}
+
+// ChainName returns the name of the chain the rules of bridge are compiled
+// for. They are installed in it or in its spare chain, whichever is not in use.
+func ChainName(bridge string) string {
+	return chainPrefix + bridge
+}
+
+// Compile returns the rules a policy compiles to for the endpoints attached to
+// a bridge, as arguments to iptables appending them to the chain of the bridge.
+func Compile(p *Policy, bridge string, endpoints []Endpoint) [][]string {
+	chain := ChainName(bridge)
+	eps := make([]Endpoint, len(endpoints))
+	copy(eps, endpoints)
+	sort.Sort(byID(eps))
+
+	// Replies to connections that were let through are always accepted
+	rules := [][]string{
+		{"-A", chain, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	if p == nil {
+		return rules
+	}
+
+	for _, r := range p.Rules {
+		target := "ACCEPT"
+		if r.Action == Deny {
+			target = "DROP"
+		}
+		for _, from := range eps {
+			if from.IP == "" || !r.From.Matches(from.Labels) {
+				continue
+			}
+			for _, to := range eps {
+				if to.IP == "" || to.ID == from.ID || !r.To.Matches(to.Labels) {
+					continue
+				}
+				match := []string{"-A", chain, "-s", from.IP, "-d", to.IP}
+				if len(r.Ports) == 0 {
+					rules = append(rules, append(match, "-j", target))
+					continue
+				}
+				for _, port := range r.Ports {
+					proto, dport, err := parsePort(port)
+					if err != nil {
+						// Policies are validated when they are loaded
+						continue
+					}
+					rule := append(append([]string{}, match...), "-p", proto, "--dport", dport, "-j", target)
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+	return rules
+}
+
+type byID []Endpoint
+
+func (e byID) Len() int           { return len(e) }
+func (e byID) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byID) Less(i, j int) bool { return e[i].ID < e[j].ID }
+
+// Manager keeps the chains of all bridges in sync with the policy and the
+// containers attached to them.
+type Manager struct {
+	sync.Mutex
+	policy *Policy
+	// bridges maps bridge names to the endpoints attached to them
+	bridges map[string]map[string]Endpoint
+	// active maps bridge names to the chain FORWARD sends their traffic to
+	active map[string]string
+}
+
+// NewManager returns a manager enforcing policy. With a nil policy no
+// iptables rules are installed, but the endpoints are still tracked so
+// policies can be tried out with Rules.
+func NewManager(policy *Policy) *Manager {
+	return &Manager{
+		policy:  policy,
+		bridges: make(map[string]map[string]Endpoint),
+		active:  make(map[string]string),
+	}
+}
+
+// Enabled returns true if the manager enforces a policy.
+func (m *Manager) Enabled() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.policy != nil
+}
+
+// Attach records that the endpoint is attached to bridge and updates the
+// chain of the bridge.
+func (m *Manager) Attach(bridge string, ep Endpoint) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for b, eps := range m.bridges {
+		if _, ok := eps[ep.ID]; ok && b != bridge {
+			delete(eps, ep.ID)
+			if err := m.apply(b); err != nil {
+				logrus.Errorf("Failed to update firewall policy of bridge %s: %v", b, err)
+			}
+		}
+	}
+
+	if m.bridges[bridge] == nil {
+		m.bridges[bridge] = make(map[string]Endpoint)
+	}
+	m.bridges[bridge][ep.ID] = ep
+	return m.apply(bridge)
+}
+
+// Detach removes the endpoint of a container and updates the chain of its
+// bridge.
+func (m *Manager) Detach(id string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for bridge, eps := range m.bridges {
+		if _, ok := eps[id]; ok {
+			delete(eps, id)
+			return m.apply(bridge)
+		}
+	}
+	return nil
+}
+
+// Rules returns the rules policy compiles to for every bridge, without
+// installing them. A nil policy returns the rules of the enforced policy.
+func (m *Manager) Rules(policy *Policy) map[string][]string {
+	m.Lock()
+	defer m.Unlock()
+
+	if policy == nil {
+		policy = m.policy
+	}
+
+	rules := make(map[string][]string)
+	for bridge := range m.bridges {
+		var lines []string
+		for _, r := range Compile(policy, bridge, m.endpoints(bridge)) {
+			lines = append(lines, strings.Join(r, " "))
+		}
+		rules[bridge] = lines
+	}
+	return rules
+}
+
+func (m *Manager) endpoints(bridge string) []Endpoint {
+	var eps []Endpoint
+	for _, ep := range m.bridges[bridge] {
+		eps = append(eps, ep)
+	}
+	return eps
+}
+
+// RemoveLeftoverChains removes the chains left over by a previous daemon,
+// and the jumps of FORWARD to them. It is called when the daemon starts
+// without a policy; with one, the chains are adopted by the bridges instead.
+func (m *Manager) RemoveLeftoverChains() error {
+	output, err := iptablesRaw("-t", string(iptables.Filter), "-S")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "-N" {
+			continue
+		}
+		for _, prefix := range []string{chainPrefix, spareChainPrefix} {
+			if strings.HasPrefix(fields[1], prefix) {
+				removeChain(strings.TrimPrefix(fields[1], prefix), fields[1])
+			}
+		}
+	}
+	return nil
+}
+
+// apply replaces the rules in the chain of bridge. The rules are built in a
+// spare chain which then takes the place of the active one in FORWARD, so the
+// traffic is never let through an empty or partly filled chain, and a failure
+// leaves the previous rules in place. The chains are removed once the last
+// endpoint leaves the bridge. It must be called with the manager locked.
+func (m *Manager) apply(bridge string) error {
+	empty := len(m.bridges[bridge]) == 0
+	if empty {
+		delete(m.bridges, bridge)
+	}
+	if m.policy == nil {
+		return nil
+	}
+
+	if empty {
+		delete(m.active, bridge)
+		for _, chain := range chainNames(bridge) {
+			removeChain(bridge, chain)
+		}
+		return nil
+	}
+
+	active, ok := m.active[bridge]
+	if !ok {
+		// Chains may be left over by a previous daemon
+		active = ""
+		for _, chain := range chainNames(bridge) {
+			if hasJump(bridge, chain) {
+				active = chain
+				break
+			}
+		}
+	}
+	next := chainNames(bridge)[0]
+	if active == next {
+		next = chainNames(bridge)[1]
+	}
+
+	if err := fillChain(next, Compile(m.policy, bridge, m.endpoints(bridge))); err != nil {
+		iptablesRaw("-t", string(iptables.Filter), "-F", next)
+		return err
+	}
+	if !hasJump(bridge, next) {
+		if output, err := iptablesRaw(append([]string{"-t", string(iptables.Filter), "-I", "FORWARD"}, jumpRule(bridge, next)...)...); err != nil {
+			iptablesRaw("-t", string(iptables.Filter), "-F", next)
+			return err
+		} else if len(output) != 0 {
+			iptablesRaw("-t", string(iptables.Filter), "-F", next)
+			return fmt.Errorf("Could not add firewall policy chain %s to FORWARD: %s", next, output)
+		}
+	}
+	m.active[bridge] = next
+
+	for _, chain := range chainNames(bridge) {
+		if chain != next {
+			removeChain(bridge, chain)
+		}
+	}
+	return nil
+}
+
+// chainNames returns the two chains the rules of bridge alternate between.
+func chainNames(bridge string) [2]string {
+	return [2]string{ChainName(bridge), spareChainPrefix + bridge}
+}
+
+func jumpRule(bridge, chain string) []string {
+	return []string{"-i", bridge, "-o", bridge, "-j", chain}
+}
+
+func hasJump(bridge, chain string) bool {
+	_, err := iptablesRaw(append([]string{"-t", string(iptables.Filter), "-C", "FORWARD"}, jumpRule(bridge, chain)...)...)
+	return err == nil
+}
+
+// fillChain creates chain if needed and replaces its rules with rules, which
+// are compiled for the chain of the bridge.
+func fillChain(chain string, rules [][]string) error {
+	if _, err := iptablesRaw("-t", string(iptables.Filter), "-n", "-L", chain); err != nil {
+		if output, err := iptablesRaw("-t", string(iptables.Filter), "-N", chain); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return fmt.Errorf("Could not create firewall policy chain %s: %s", chain, output)
+		}
+	}
+	if _, err := iptablesRaw("-t", string(iptables.Filter), "-F", chain); err != nil {
+		return err
+	}
+	for _, r := range rules {
+		r = append([]string{"-t", string(iptables.Filter), r[0], chain}, r[2:]...)
+		if output, err := iptablesRaw(r...); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return fmt.Errorf("Error iptables firewall policy: %s", output)
+		}
+	}
+	return nil
+}
+
+// removeChain stops sending the traffic of bridge through chain and deletes
+// it. Failures are only logged, as the chain no longer holds the policy.
+func removeChain(bridge, chain string) {
+	for hasJump(bridge, chain) {
+		if _, err := iptablesRaw(append([]string{"-t", string(iptables.Filter), "-D", "FORWARD"}, jumpRule(bridge, chain)...)...); err != nil {
+			logrus.Warnf("Failed to remove firewall policy chain %s from FORWARD: %v", chain, err)
+			return
+		}
+	}
+	if _, err := iptablesRaw("-t", string(iptables.Filter), "-n", "-L", chain); err != nil {
+		return
+	}
+	if _, err := iptablesRaw("-t", string(iptables.Filter), "-F", chain); err != nil {
+		logrus.Warnf("Failed to flush firewall policy chain %s: %v", chain, err)
+		return
+	}
+	if _, err := iptablesRaw("-t", string(iptables.Filter), "-X", chain); err != nil {
+		logrus.Warnf("Failed to delete firewall policy chain %s: %v", chain, err)
+	}
+}
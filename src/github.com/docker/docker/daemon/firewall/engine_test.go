@@ -0,0 +1,297 @@
+package firewall
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testEndpoints = []Endpoint{
+	{ID: "c3", IP: "172.17.0.4", Labels: map[string]string{"tenant": "b"}},
+	{ID: "c1", IP: "172.17.0.2", Labels: map[string]string{"tenant": "a", "tier": "web"}},
+	{ID: "c2", IP: "172.17.0.3", Labels: map[string]string{"tenant": "a", "tier": "db"}},
+}
+
+func TestCompile(t *testing.T) {
+	p := &Policy{Rules: []Rule{
+		{Action: Allow, From: Selector{"tier": "web"}, To: Selector{"tier": "db"}, Ports: []string{"5432", "8000-8010/udp"}},
+		{Action: Deny, From: Selector{"tenant": "b"}},
+	}}
+
+	expected := [][]string{
+		{"-A", "DOCKER-POL-docker0", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		{"-A", "DOCKER-POL-docker0", "-s", "172.17.0.2", "-d", "172.17.0.3", "-p", "tcp", "--dport", "5432", "-j", "ACCEPT"},
+		{"-A", "DOCKER-POL-docker0", "-s", "172.17.0.2", "-d", "172.17.0.3", "-p", "udp", "--dport", "8000:8010", "-j", "ACCEPT"},
+		{"-A", "DOCKER-POL-docker0", "-s", "172.17.0.4", "-d", "172.17.0.2", "-j", "DROP"},
+		{"-A", "DOCKER-POL-docker0", "-s", "172.17.0.4", "-d", "172.17.0.3", "-j", "DROP"},
+	}
+	if rules := Compile(p, "docker0", testEndpoints); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Expected rules\n%v\ngot\n%v", expected, rules)
+	}
+}
+
+func TestCompileWithoutPolicy(t *testing.T) {
+	rules := Compile(nil, "docker0", testEndpoints)
+	if len(rules) != 1 {
+		t.Fatalf("Expected only the conntrack rule without a policy, got %v", rules)
+	}
+}
+
+type fakeIptables struct {
+	calls  []string
+	chains map[string][]string
+	jumps  map[string]bool
+	// failOn makes the calls containing it fail
+	failOn string
+}
+
+func (f *fakeIptables) raw(args ...string) ([]byte, error) {
+	call := strings.Join(args, " ")
+	f.calls = append(f.calls, call)
+	if f.failOn != "" && strings.Contains(call, f.failOn) {
+		return nil, errNotFound
+	}
+	switch args[2] {
+	case "-S":
+		output := "-P FORWARD ACCEPT\n"
+		for chain := range f.chains {
+			output += "-N " + chain + "\n"
+		}
+		return []byte(output), nil
+	case "-n":
+		if _, ok := f.chains[args[4]]; !ok {
+			return nil, errNotFound
+		}
+	case "-N":
+		f.chains[args[3]] = nil
+	case "-F":
+		f.chains[args[3]] = nil
+	case "-X":
+		delete(f.chains, args[3])
+	case "-A":
+		f.chains[args[3]] = append(f.chains[args[3]], strings.Join(args[4:], " "))
+	case "-C":
+		if !f.jumps[strings.Join(args[4:], " ")] {
+			return nil, errNotFound
+		}
+	case "-I":
+		f.jumps[strings.Join(args[4:], " ")] = true
+	case "-D":
+		delete(f.jumps, strings.Join(args[4:], " "))
+	}
+	return nil, nil
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string { return "not found" }
+
+var errNotFound = notFoundError{}
+
+func withFakeIptables() *fakeIptables {
+	f := &fakeIptables{chains: make(map[string][]string), jumps: make(map[string]bool)}
+	iptablesRaw = f.raw
+	return f
+}
+
+func (f *fakeIptables) index(call string) int {
+	for i, c := range f.calls {
+		if c == call {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestManagerAttachDetach(t *testing.T) {
+	f := withFakeIptables()
+	m := NewManager(&Policy{Rules: []Rule{{Action: Allow, From: Selector{"tenant": "a"}, To: Selector{"tenant": "a"}}}})
+
+	if err := m.Attach("docker0", testEndpoints[1]); err != nil {
+		t.Fatal(err)
+	}
+	if !f.jumps["-i docker0 -o docker0 -j DOCKER-POL-docker0"] {
+		t.Fatal("Expected FORWARD to jump to the chain of docker0")
+	}
+	if rules := f.chains["DOCKER-POL-docker0"]; len(rules) != 1 {
+		t.Fatalf("Expected only the conntrack rule with a single container, got %v", rules)
+	}
+
+	f.calls = nil
+	if err := m.Attach("docker0", testEndpoints[2]); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"-m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"-s 172.17.0.2 -d 172.17.0.3 -j ACCEPT",
+		"-s 172.17.0.3 -d 172.17.0.2 -j ACCEPT",
+	}
+	if !reflect.DeepEqual(f.chains["DOCKER-POL2-docker0"], expected) {
+		t.Fatalf("Expected rules\n%v\ngot\n%v", expected, f.chains["DOCKER-POL2-docker0"])
+	}
+	if len(f.jumps) != 1 || !f.jumps["-i docker0 -o docker0 -j DOCKER-POL2-docker0"] {
+		t.Fatalf("Expected FORWARD to only jump to the spare chain of docker0, got %v", f.jumps)
+	}
+	if _, ok := f.chains["DOCKER-POL-docker0"]; ok {
+		t.Fatal("Expected the replaced chain to be deleted")
+	}
+	inserted := f.index("-t filter -I FORWARD -i docker0 -o docker0 -j DOCKER-POL2-docker0")
+	removed := f.index("-t filter -D FORWARD -i docker0 -o docker0 -j DOCKER-POL-docker0")
+	if inserted == -1 || removed == -1 || inserted > removed {
+		t.Fatalf("Expected the new chain to be added to FORWARD before the old one is removed, got %v", f.calls)
+	}
+
+	if err := m.Detach("c2"); err != nil {
+		t.Fatal(err)
+	}
+	if rules := f.chains["DOCKER-POL-docker0"]; len(rules) != 1 {
+		t.Fatalf("Expected the rules of a stopped container to be removed, got %v", rules)
+	}
+	if len(f.jumps) != 1 || !f.jumps["-i docker0 -o docker0 -j DOCKER-POL-docker0"] {
+		t.Fatalf("Expected FORWARD to only jump to the chain of docker0, got %v", f.jumps)
+	}
+}
+
+func TestManagerApplyFailureKeepsRules(t *testing.T) {
+	f := withFakeIptables()
+	m := NewManager(&Policy{Rules: []Rule{{Action: Allow, From: Selector{"tenant": "a"}, To: Selector{"tenant": "a"}}}})
+
+	if err := m.Attach("docker0", testEndpoints[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	f.failOn = "-s 172.17.0.3 -d 172.17.0.2"
+	if err := m.Attach("docker0", testEndpoints[2]); err == nil {
+		t.Fatal("Expected the update to fail")
+	}
+	if rules := f.chains["DOCKER-POL-docker0"]; len(rules) != 1 {
+		t.Fatalf("Expected the previous rules to be kept, got %v", rules)
+	}
+	if len(f.jumps) != 1 || !f.jumps["-i docker0 -o docker0 -j DOCKER-POL-docker0"] {
+		t.Fatalf("Expected FORWARD to still jump to the chain of docker0, got %v", f.jumps)
+	}
+	if rules := f.chains["DOCKER-POL2-docker0"]; len(rules) != 0 {
+		t.Fatalf("Expected the partly filled spare chain to be flushed, got %v", rules)
+	}
+
+	f.failOn = ""
+	if err := m.Attach("docker0", testEndpoints[2]); err != nil {
+		t.Fatal(err)
+	}
+	if rules := f.chains["DOCKER-POL2-docker0"]; len(rules) != 3 {
+		t.Fatalf("Expected the policy to be applied once iptables recovers, got %v", rules)
+	}
+}
+
+func TestManagerAdoptsLeftoverChain(t *testing.T) {
+	f := withFakeIptables()
+	f.chains["DOCKER-POL2-docker0"] = []string{"-j DROP"}
+	f.jumps["-i docker0 -o docker0 -j DOCKER-POL2-docker0"] = true
+
+	m := NewManager(&Policy{})
+	if err := m.Attach("docker0", testEndpoints[0]); err != nil {
+		t.Fatal(err)
+	}
+	if inserted := f.index("-t filter -I FORWARD -i docker0 -o docker0 -j DOCKER-POL-docker0"); inserted == -1 || inserted > f.index("-t filter -F DOCKER-POL2-docker0") {
+		t.Fatalf("Expected the leftover chain to stay in use until it is replaced, got %v", f.calls)
+	}
+	if _, ok := f.chains["DOCKER-POL2-docker0"]; ok || len(f.jumps) != 1 {
+		t.Fatalf("Expected the leftover chain to be removed, got %v %v", f.chains, f.jumps)
+	}
+}
+
+func TestManagerWithoutPolicy(t *testing.T) {
+	f := withFakeIptables()
+	m := NewManager(nil)
+
+	if m.Enabled() {
+		t.Fatal("Expected a manager without policy to be disabled")
+	}
+	for _, ep := range testEndpoints {
+		if err := m.Attach("docker0", ep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(f.calls) != 0 {
+		t.Fatalf("Expected no iptables calls without a policy, got %v", f.calls)
+	}
+
+	// Policies can still be tried out against the running containers
+	rules := m.Rules(&Policy{Rules: []Rule{{Action: Deny, From: Selector{"tenant": "b"}, To: Selector{"tier": "db"}}}})
+	expected := []string{
+		"-A DOCKER-POL-docker0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"-A DOCKER-POL-docker0 -s 172.17.0.4 -d 172.17.0.3 -j DROP",
+	}
+	if !reflect.DeepEqual(rules["docker0"], expected) {
+		t.Fatalf("Expected rules\n%v\ngot\n%v", expected, rules["docker0"])
+	}
+	if len(f.calls) != 0 {
+		t.Fatalf("Expected a dry run not to call iptables, got %v", f.calls)
+	}
+}
+
+func TestManagerMovesEndpointBetweenBridges(t *testing.T) {
+	withFakeIptables()
+	m := NewManager(&Policy{})
+
+	if err := m.Attach("docker0", testEndpoints[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Attach("br1", testEndpoints[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.bridges["docker0"]) != 0 || len(m.bridges["br1"]) != 1 {
+		t.Fatalf("Expected the endpoint to only be attached to br1, got %v", m.bridges)
+	}
+}
+
+func TestManagerRemovesChainOfEmptyBridge(t *testing.T) {
+	f := withFakeIptables()
+	m := NewManager(&Policy{})
+
+	if err := m.Attach("docker0", testEndpoints[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Attach("docker0", testEndpoints[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Detach("c3"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.chains) != 1 || len(f.jumps) != 1 {
+		t.Fatalf("Expected the chain of docker0 to be kept while it has endpoints, got %v %v", f.chains, f.jumps)
+	}
+	if err := m.Detach("c1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.chains) != 0 || len(f.jumps) != 0 {
+		t.Fatalf("Expected the chains of docker0 to be removed with its last endpoint, got %v %v", f.chains, f.jumps)
+	}
+	if _, ok := m.bridges["docker0"]; ok {
+		t.Fatal("Expected docker0 to be forgotten")
+	}
+	if rules := m.Rules(nil); len(rules) != 0 {
+		t.Fatalf("Expected no rules without endpoints, got %v", rules)
+	}
+}
+
+func TestRemoveLeftoverChains(t *testing.T) {
+	f := withFakeIptables()
+	f.chains["DOCKER-POL-docker0"] = []string{"-j DROP"}
+	f.chains["DOCKER-POL2-br1"] = []string{"-j DROP"}
+	f.chains["DOCKER"] = []string{"-j RETURN"}
+	f.jumps["-i docker0 -o docker0 -j DOCKER-POL-docker0"] = true
+	f.jumps["-i br1 -o br1 -j DOCKER-POL2-br1"] = true
+	f.jumps["-o docker0 -j DOCKER"] = true
+
+	m := NewManager(nil)
+	if err := m.RemoveLeftoverChains(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.chains) != 1 || f.chains["DOCKER"] == nil {
+		t.Fatalf("Expected only the chains of the policy to be removed, got %v", f.chains)
+	}
+	if len(f.jumps) != 1 || !f.jumps["-o docker0 -j DOCKER"] {
+		t.Fatalf("Expected only the jumps to the chains of the policy to be removed, got %v", f.jumps)
+	}
+}
@@ -0,0 +1,117 @@
+// Package firewall implements label based policies for the traffic between
+// containers attached to the same bridge. A policy is compiled into a chain of
+// iptables rules for every bridge, which is kept up to date as containers
+// start and stop.
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/docker/pkg/nat"
+	"github.com/docker/docker/pkg/parsers"
+)
+
+// Action is what happens to the traffic a rule matches.
+type Action string
+
+const (
+	// Allow accepts the traffic, even when --icc=false.
+	Allow Action = "allow"
+	// Deny drops the traffic, even when --icc=true or the containers are linked.
+	Deny Action = "deny"
+)
+
+// Selector selects the containers whose labels have all the given values. An
+// empty selector selects every container.
+type Selector map[string]string
+
+// Matches returns true if labels have every key and value of the selector.
+func (s Selector) Matches(labels map[string]string) bool {
+	for k, v := range s {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Rule allows or denies the traffic from the containers selected by From to
+// the given ports of the containers selected by To.
+type Rule struct {
+	Action Action
+	From   Selector
+	To     Selector
+	// Ports are in the format port[-port][/proto], e.g. 80/tcp or
+	// 8000-9000/udp. The protocol defaults to tcp. No ports match all
+	// traffic.
+	Ports []string `json:",omitempty"`
+}
+
+// Policy is an ordered list of rules, the first rule matching a connection
+// decides what happens to it. Connections no rule matches are handled
+// according to --icc and links.
+type Policy struct {
+	Rules []Rule
+}
+
+// Load reads a policy from the JSON file at path.
+func Load(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid firewall policy %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// Parse decodes a JSON policy and validates it.
+func Parse(r io.Reader) (*Policy, error) {
+	var p Policy
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate checks the actions and ports of all rules.
+func (p *Policy) Validate() error {
+	for i, r := range p.Rules {
+		if r.Action != Allow && r.Action != Deny {
+			return fmt.Errorf("rule %d: invalid action %q, must be %q or %q", i, r.Action, Allow, Deny)
+		}
+		for _, port := range r.Ports {
+			if _, _, err := parsePort(port); err != nil {
+				return fmt.Errorf("rule %d: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parsePort returns the protocol and the iptables --dport value of a port
+// specification.
+func parsePort(port string) (string, string, error) {
+	proto, ports := nat.SplitProtoPort(port)
+	if proto != "tcp" && proto != "udp" {
+		return "", "", fmt.Errorf("invalid port %q, the protocol must be tcp or udp", port)
+	}
+	start, end, err := parsers.ParsePortRange(ports)
+	if err != nil || start == 0 {
+		return "", "", fmt.Errorf("invalid port %q", port)
+	}
+	if start == end {
+		return proto, fmt.Sprintf("%d", start), nil
+	}
+	return proto, fmt.Sprintf("%d:%d", start, end), nil
+}
@@ -0,0 +1,65 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := Parse(strings.NewReader(`{"Rules": [
+		{"Action": "allow", "From": {"tenant": "a"}, "To": {"tenant": "a"}, "Ports": ["80", "53/udp", "8000-9000/tcp"]},
+		{"Action": "deny", "To": {"tier": "db"}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(p.Rules))
+	}
+	if p.Rules[0].Action != Allow || p.Rules[1].Action != Deny {
+		t.Fatalf("Unexpected actions: %v", p.Rules)
+	}
+	if len(p.Rules[1].From) != 0 {
+		t.Fatalf("Expected an empty From selector, got %v", p.Rules[1].From)
+	}
+}
+
+func TestParseInvalidPolicy(t *testing.T) {
+	invalid := map[string]string{
+		`{"Rules": [{"Action": "reject"}]}`:                      `invalid action "reject"`,
+		`{"Rules": [{"Action": "allow", "Ports": ["80/icmp"]}]}`: `the protocol must be tcp or udp`,
+		`{"Rules": [{"Action": "allow", "Ports": ["http"]}]}`:    `invalid port "http"`,
+		`{"Rules": [{"Action": "allow", "Ports": ["90-80"]}]}`:   `invalid port "90-80"`,
+		`{"Rules": [{"Action": "allow", "Ports": ["0/tcp"]}]}`:   `invalid port "0/tcp"`,
+		`{"Rules": [{"Action": "allow", "Ports": ["70000"]}]}`:   `invalid port "70000"`,
+		`{"Rules": [{"Action": "allow", "From": ["tenant=a"]}]}`: `cannot unmarshal`,
+	}
+	for policy, expected := range invalid {
+		if _, err := Parse(strings.NewReader(policy)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected error containing %q for %s, got %v", expected, policy, err)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"tenant": "a", "tier": "web"}
+
+	if !(Selector{}).Matches(labels) {
+		t.Fatal("Expected an empty selector to match")
+	}
+	if !(Selector{"tenant": "a"}).Matches(labels) {
+		t.Fatal("Expected tenant=a to match")
+	}
+	if !(Selector{"tenant": "a", "tier": "web"}).Matches(labels) {
+		t.Fatal("Expected tenant=a,tier=web to match")
+	}
+	if (Selector{"tenant": "b"}).Matches(labels) {
+		t.Fatal("Expected tenant=b not to match")
+	}
+	if (Selector{"tenant": "a", "zone": "1"}).Matches(labels) {
+		t.Fatal("Expected a selector with a missing label not to match")
+	}
+	if (Selector{"tenant": "a"}).Matches(nil) {
+		t.Fatal("Expected a selector not to match a container without labels")
+	}
+}
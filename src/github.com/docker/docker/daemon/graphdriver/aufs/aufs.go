@@ -177,7 +177,11 @@ func (a *Driver) Exists(id string) bool {
 
 // Three folders are created for each id
 // mnt, layers, and diff
-func (a *Driver) Create(id, parent string) error {
+func (a *Driver) Create(id, parent string, storageOpt map[string]string) error {
+	if len(storageOpt) != 0 {
+		return fmt.Errorf("--storage-opt is not supported for aufs")
+	}
+
 	if err := a.createDirsFor(id); err != nil {
 		return err
 	}
@@ -358,6 +362,16 @@ func (a *Driver) Changes(id, parent string) ([]archive.Change, error) {
 	return archive.Changes(layers, path.Join(a.rootPath(), "diff", id))
 }
 
+// WalkChanges calls fn with each change between the specified layer and its
+// parent layer as it is found.
+func (a *Driver) WalkChanges(id, parent string, fn func(archive.Change) error) error {
+	layers, err := a.getParentLayerPaths(id)
+	if err != nil {
+		return err
+	}
+	return archive.WalkChanges(layers, path.Join(a.rootPath(), "diff", id), fn)
+}
+
 func (a *Driver) getParentLayerPaths(id string) ([]string, error) {
 	parentIds, err := getParentIds(a.rootPath(), id)
 	if err != nil {
@@ -11,12 +11,17 @@ import "C"
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
+	"sync"
 	"syscall"
 	"unsafe"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/pkg/directory"
 	"github.com/docker/docker/pkg/mount"
 )
 
@@ -52,7 +57,9 @@ func Init(home string, options []string) (graphdriver.Driver, error) {
 }
 
 type Driver struct {
-	home string
+	home         string
+	sync.Mutex   // protects quotaEnabled
+	quotaEnabled bool
 }
 
 func (d *Driver) String() string {
@@ -70,8 +77,21 @@ func (d *Driver) Status() [][2]string {
 	return status
 }
 
+// GetMetadata returns the size limit and usage of a subvolume with a
+// quota as "SizeLimit" and "SizeUsed".
 func (d *Driver) GetMetadata(id string) (map[string]string, error) {
-	return nil, nil
+	size, err := d.getQuota(id)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	used, err := directory.Size(d.subvolumesDirId(id))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"SizeLimit": strconv.FormatUint(size, 10),
+		"SizeUsed":  strconv.FormatInt(used, 10),
+	}, nil
 }
 
 func (d *Driver) Cleanup() error {
@@ -170,6 +190,77 @@ func subvolDelete(path, name string) error {
 	return nil
 }
 
+func subvolEnableQuota(path string) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_quota_ctl_args
+	args.cmd = C.BTRFS_QUOTA_CTL_ENABLE
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QUOTA_CTL,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to enable btrfs quota for %s: %v", path, errno.Error())
+	}
+	return nil
+}
+
+func subvolLimitQgroup(path string, size uint64) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_qgroup_limit_args
+	args.lim.max_referenced = C.__u64(size)
+	args.lim.flags = C.BTRFS_QGROUP_LIMIT_MAX_RFER
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QGROUP_LIMIT,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to limit qgroup for %s: %v", path, errno.Error())
+	}
+	return nil
+}
+
+// subvolQgroupId returns the id of the qgroup of the subvolume at path,
+// which is the id of the subvolume.
+func subvolQgroupId(path string) (uint64, error) {
+	dir, err := openDir(path)
+	if err != nil {
+		return 0, err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_ino_lookup_args
+	args.objectid = C.BTRFS_FIRST_FREE_OBJECTID
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_INO_LOOKUP,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return 0, fmt.Errorf("Failed to lookup qgroup for %s: %v", path, errno.Error())
+	}
+	return uint64(args.treeid), nil
+}
+
+func qgroupDestroy(path string, qgroupId uint64) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_qgroup_create_args
+	args.qgroupid = C.__u64(qgroupId)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QGROUP_CREATE,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to destroy qgroup %d: %v", qgroupId, errno.Error())
+	}
+	return nil
+}
+
 func (d *Driver) subvolumesDir() string {
 	return path.Join(d.home, "subvolumes")
 }
@@ -178,7 +269,53 @@ func (d *Driver) subvolumesDirId(id string) string {
 	return path.Join(d.subvolumesDir(), id)
 }
 
-func (d *Driver) Create(id string, parent string) error {
+// The size limits of subvolumes are kept in the quotas dir, as they cannot
+// be read back from their qgroups cheaply.
+func (d *Driver) quotasDir() string {
+	return path.Join(d.home, "quotas")
+}
+
+func (d *Driver) quotasDirId(id string) string {
+	return path.Join(d.quotasDir(), id)
+}
+
+func (d *Driver) getQuota(id string) (uint64, error) {
+	b, err := ioutil.ReadFile(d.quotasDirId(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseUint(string(b), 10, 64)
+}
+
+func (d *Driver) setQuota(id string, size uint64) error {
+	d.Lock()
+	if !d.quotaEnabled {
+		if err := subvolEnableQuota(d.home); err != nil {
+			d.Unlock()
+			return err
+		}
+		d.quotaEnabled = true
+	}
+	d.Unlock()
+
+	if err := subvolLimitQgroup(d.subvolumesDirId(id), size); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.quotasDir(), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(d.quotasDirId(id), []byte(strconv.FormatUint(size, 10)), 0644)
+}
+
+func (d *Driver) Create(id string, parent string, storageOpt map[string]string) error {
+	size, err := graphdriver.ParseStorageOptSize("btrfs", storageOpt)
+	if err != nil {
+		return err
+	}
+
 	subvolumes := path.Join(d.home, "subvolumes")
 	if err := os.MkdirAll(subvolumes, 0700); err != nil {
 		return err
@@ -196,6 +333,13 @@ func (d *Driver) Create(id string, parent string) error {
 			return err
 		}
 	}
+
+	if size != 0 {
+		if err := d.setQuota(id, size); err != nil {
+			subvolDelete(subvolumes, id)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -204,9 +348,29 @@ func (d *Driver) Remove(id string) error {
 	if _, err := os.Stat(dir); err != nil {
 		return err
 	}
+
+	// The qgroup of a subvolume with a quota outlives the subvolume.
+	var qgroupId uint64
+	if size, _ := d.getQuota(id); size != 0 {
+		qid, err := subvolQgroupId(dir)
+		if err != nil {
+			logrus.Debugf("Failed to find qgroup of %s: %v", dir, err)
+		}
+		qgroupId = qid
+	}
+
 	if err := subvolDelete(d.subvolumesDir(), id); err != nil {
 		return err
 	}
+
+	if qgroupId != 0 {
+		if err := qgroupDestroy(d.home, qgroupId); err != nil {
+			logrus.Debugf("%v", err)
+		}
+	}
+	if err := os.RemoveAll(d.quotasDirId(id)); err != nil {
+		return err
+	}
 	return os.RemoveAll(dir)
 }
 
@@ -32,6 +32,9 @@ var (
 	DefaultBaseFsSize           uint64 = 100 * 1024 * 1024 * 1024
 	DefaultThinpBlockSize       uint32 = 128 // 64K = 128 512b sectors
 	DefaultUdevSyncOverride     bool   = false
+	DefaultMinFreeSpacePercent  uint32 = 10
+	DefaultWarningThreshold     uint32 = 80
+	DefaultLoopAutoExtend       uint32 = 20
 	MaxDeviceId                 int    = 0xffffff // 24 bit, pool limit
 	DeviceIdMapSz               int    = (MaxDeviceId + 1) / 8
 	// We retry device removal so many a times that even error messages
@@ -107,6 +110,16 @@ type DeviceSet struct {
 	overrideUdevSyncCheck bool
 	deferredRemove        bool   // use deferred removal
 	BaseDeviceUUID        string //save UUID of base device
+
+	// Pool monitoring, see thinpool.go
+	minFreeSpacePercent     uint32 // new devices are refused below this free space
+	warningThresholdPercent uint32 // events are logged above this usage
+	loopAutoExtend          bool   // grow the loopback files above the warning threshold
+	loopAutoExtendPercent   uint32 // how much the loopback files grow, in percent of their size
+	dataWarning             bool   // data usage above the warning threshold at the last check
+	metadataWarning         bool   // metadata usage above the warning threshold at the last check
+	poolEventsHandler       func(event string)
+	monitorDone             chan struct{}
 }
 
 type DiskUsage struct {
@@ -611,7 +624,7 @@ func (devices *DeviceSet) createRegisterDevice(hash string) (*DevInfo, error) {
 	return info, nil
 }
 
-func (devices *DeviceSet) createRegisterSnapDevice(hash string, baseInfo *DevInfo) error {
+func (devices *DeviceSet) createRegisterSnapDevice(hash string, baseInfo *DevInfo, size uint64) error {
 	deviceId, err := devices.getNextFreeDeviceId()
 	if err != nil {
 		return err
@@ -646,7 +659,7 @@ func (devices *DeviceSet) createRegisterSnapDevice(hash string, baseInfo *DevInf
 		break
 	}
 
-	if _, err := devices.registerDevice(deviceId, hash, baseInfo.Size, devices.OpenTransactionId); err != nil {
+	if _, err := devices.registerDevice(deviceId, hash, size, devices.OpenTransactionId); err != nil {
 		devicemapper.DeleteDevice(devices.getPoolDevName(), deviceId)
 		devices.markDeviceIdFree(deviceId)
 		logrus.Debugf("Error registering device: %s", err)
@@ -845,6 +858,13 @@ func minor(device uint64) uint64 {
 }
 
 func (devices *DeviceSet) ResizePool(size int64) error {
+	return devices.resizePool(size, 0)
+}
+
+// resizePool grows the data and metadata loopback files of the pool to
+// dataSize and metadataSize bytes, and reloads the pool. A size of 0 leaves
+// a file as is.
+func (devices *DeviceSet) resizePool(dataSize, metadataSize int64) error {
 	dirname := devices.loopbackDir()
 	datafilename := path.Join(dirname, "data")
 	if len(devices.dataDevice) > 0 {
@@ -861,15 +881,6 @@ func (devices *DeviceSet) ResizePool(size int64) error {
 	}
 	defer datafile.Close()
 
-	fi, err := datafile.Stat()
-	if fi == nil {
-		return err
-	}
-
-	if fi.Size() > size {
-		return fmt.Errorf("Can't shrink file")
-	}
-
 	dataloopback := devicemapper.FindLoopDeviceFor(datafile)
 	if dataloopback == nil {
 		return fmt.Errorf("Unable to find loopback mount for: %s", datafilename)
@@ -888,14 +899,15 @@ func (devices *DeviceSet) ResizePool(size int64) error {
 	}
 	defer metadataloopback.Close()
 
-	// Grow loopback file
-	if err := datafile.Truncate(size); err != nil {
-		return fmt.Errorf("Unable to grow loopback file: %s", err)
+	if dataSize > 0 {
+		if err := growLoopbackFile(datafile, dataloopback, dataSize); err != nil {
+			return err
+		}
 	}
-
-	// Reload size for loopback device
-	if err := devicemapper.LoopbackSetCapacity(dataloopback); err != nil {
-		return fmt.Errorf("Unable to update loopback capacity: %s", err)
+	if metadataSize > 0 {
+		if err := growLoopbackFile(metadatafile, metadataloopback, metadataSize); err != nil {
+			return err
+		}
 	}
 
 	// Suspend the pool
@@ -916,6 +928,31 @@ func (devices *DeviceSet) ResizePool(size int64) error {
 	return nil
 }
 
+// growLoopbackFile grows file to size bytes and updates the capacity of
+// its loopback device.
+func growLoopbackFile(file, loopback *os.File, size int64) error {
+	fi, err := file.Stat()
+	if fi == nil {
+		return err
+	}
+
+	if fi.Size() > size {
+		return fmt.Errorf("Can't shrink file")
+	}
+
+	// Grow loopback file
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("Unable to grow loopback file: %s", err)
+	}
+
+	// Reload size for loopback device
+	if err := devicemapper.LoopbackSetCapacity(loopback); err != nil {
+		return fmt.Errorf("Unable to update loopback capacity: %s", err)
+	}
+
+	return nil
+}
+
 func (devices *DeviceSet) loadTransactionMetaData() error {
 	jsonData, err := ioutil.ReadFile(devices.transactionMetaFile())
 	if err != nil {
@@ -1389,9 +1426,13 @@ func (devices *DeviceSet) initDevmapper(doInit bool) error {
 	return nil
 }
 
-func (devices *DeviceSet) AddDevice(hash, baseHash string) error {
-	logrus.Debugf("[deviceset] AddDevice(hash=%s basehash=%s)", hash, baseHash)
-	defer logrus.Debugf("[deviceset] AddDevice(hash=%s basehash=%s) END", hash, baseHash)
+// AddDevice adds a device and registers in the hash. The device is a
+// snapshot of baseHash of size bytes, or of the size of baseHash when size
+// is 0. The filesystem of a device larger than baseHash is grown to the
+// size of the device.
+func (devices *DeviceSet) AddDevice(hash, baseHash string, size uint64) error {
+	logrus.Debugf("[deviceset] AddDevice(hash=%s basehash=%s size=%d)", hash, baseHash, size)
+	defer logrus.Debugf("[deviceset] AddDevice(hash=%s basehash=%s size=%d) END", hash, baseHash, size)
 
 	baseInfo, err := devices.lookupDevice(baseHash)
 	if err != nil {
@@ -1408,10 +1449,78 @@ func (devices *DeviceSet) AddDevice(hash, baseHash string) error {
 		return fmt.Errorf("device %s already exists", hash)
 	}
 
-	if err := devices.createRegisterSnapDevice(hash, baseInfo); err != nil {
+	if err := devices.ensurePoolFreeSpace(); err != nil {
+		return err
+	}
+
+	if size == 0 {
+		size = baseInfo.Size
+	}
+	if size < baseInfo.Size {
+		return fmt.Errorf("Container size cannot be smaller than %s", units.HumanSize(float64(baseInfo.Size)))
+	}
+
+	if err := devices.createRegisterSnapDevice(hash, baseInfo, size); err != nil {
+		return err
+	}
+
+	if size > baseInfo.Size {
+		info, err := devices.lookupDevice(hash)
+		if err != nil {
+			return err
+		}
+		if err := devices.growFS(info); err != nil {
+			devices.deleteDevice(info)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// growFS grows the filesystem of the device of info, which was snapshotted
+// from a smaller device, to the size of the device.
+func (devices *DeviceSet) growFS(info *DevInfo) error {
+	if err := devices.activateDeviceIfNeeded(info); err != nil {
+		return fmt.Errorf("Error activating devmapper device: %s", err)
+	}
+	defer devices.deactivateDevice(info)
+
+	fstype, err := ProbeFsType(info.DevName())
+	if err != nil {
+		return err
+	}
+
+	fsMountPoint, err := ioutil.TempDir(devices.root, "growfs-")
+	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(fsMountPoint)
+
+	options := ""
+	if fstype == "xfs" {
+		// XFS needs nouuid or it can't mount filesystems with the same fs
+		options = joinMountOptions(options, "nouuid")
+	}
+	options = joinMountOptions(options, devices.mountOptions)
 
+	if err := syscall.Mount(info.DevName(), fsMountPoint, fstype, syscall.MS_MGC_VAL, options); err != nil {
+		return fmt.Errorf("Error mounting '%s' on '%s': %s", info.DevName(), fsMountPoint, err)
+	}
+	defer syscall.Unmount(fsMountPoint, syscall.MNT_DETACH)
+
+	switch fstype {
+	case "ext4":
+		if out, err := exec.Command("resize2fs", info.DevName()).CombinedOutput(); err != nil {
+			return fmt.Errorf("Failed to grow rootfs: %s: %v", string(out), err)
+		}
+	case "xfs":
+		if out, err := exec.Command("xfs_growfs", fsMountPoint).CombinedOutput(); err != nil {
+			return fmt.Errorf("Failed to grow rootfs: %s: %v", string(out), err)
+		}
+	default:
+		return fmt.Errorf("Unsupported filesystem type %s", fstype)
+	}
 	return nil
 }
 
@@ -1588,6 +1697,10 @@ func (devices *DeviceSet) Shutdown() error {
 	logrus.Debugf("[devmapper] Shutting down DeviceSet: %s", devices.root)
 	defer logrus.Debugf("[deviceset %s] Shutdown() END", devices.devicePrefix)
 
+	if devices.monitorDone != nil {
+		close(devices.monitorDone)
+	}
+
 	var devs []*DevInfo
 
 	devices.devicesLock.Lock()
@@ -1858,6 +1971,44 @@ func (devices *DeviceSet) isRealFile(loopFile string) (bool, error) {
 	return false, nil
 }
 
+// poolSpace returns the data and metadata space of the pool in bytes, and
+// its block size. The available space of loopback files is limited by the
+// free space of their filesystem. It must be called with the lock held.
+func (devices *DeviceSet) poolSpace() (data, metadata DiskUsage, blockSize uint64, err error) {
+	totalSizeInSectors, _, dataUsed, dataTotal, metadataUsed, metadataTotal, err := devices.poolStatus()
+	if err != nil {
+		return data, metadata, 0, err
+	}
+
+	// Convert from blocks to bytes
+	blockSizeInSectors := totalSizeInSectors / dataTotal
+
+	data.Used = dataUsed * blockSizeInSectors * 512
+	data.Total = dataTotal * blockSizeInSectors * 512
+	data.Available = data.Total - data.Used
+
+	// metadata blocks are always 4k
+	metadata.Used = metadataUsed * 4096
+	metadata.Total = metadataTotal * 4096
+	metadata.Available = metadata.Total - metadata.Used
+
+	if check, _ := devices.isRealFile(devices.dataLoopFile); check {
+		actualSpace, err := devices.getUnderlyingAvailableSpace(devices.dataLoopFile)
+		if err == nil && actualSpace < data.Available {
+			data.Available = actualSpace
+		}
+	}
+
+	if check, _ := devices.isRealFile(devices.metadataLoopFile); check {
+		actualSpace, err := devices.getUnderlyingAvailableSpace(devices.metadataLoopFile)
+		if err == nil && actualSpace < metadata.Available {
+			metadata.Available = actualSpace
+		}
+	}
+
+	return data, metadata, blockSizeInSectors * 512, nil
+}
+
 // Status returns the current status of this deviceset
 func (devices *DeviceSet) Status() *Status {
 	devices.Lock()
@@ -1873,35 +2024,10 @@ func (devices *DeviceSet) Status() *Status {
 	status.UdevSyncSupported = devicemapper.UdevSyncSupported()
 	status.DeferredRemoveEnabled = devices.deferredRemove
 
-	totalSizeInSectors, _, dataUsed, dataTotal, metadataUsed, metadataTotal, err := devices.poolStatus()
-	if err == nil {
-		// Convert from blocks to bytes
-		blockSizeInSectors := totalSizeInSectors / dataTotal
-
-		status.Data.Used = dataUsed * blockSizeInSectors * 512
-		status.Data.Total = dataTotal * blockSizeInSectors * 512
-		status.Data.Available = status.Data.Total - status.Data.Used
-
-		// metadata blocks are always 4k
-		status.Metadata.Used = metadataUsed * 4096
-		status.Metadata.Total = metadataTotal * 4096
-		status.Metadata.Available = status.Metadata.Total - status.Metadata.Used
-
-		status.SectorSize = blockSizeInSectors * 512
-
-		if check, _ := devices.isRealFile(devices.dataLoopFile); check {
-			actualSpace, err := devices.getUnderlyingAvailableSpace(devices.dataLoopFile)
-			if err == nil && actualSpace < status.Data.Available {
-				status.Data.Available = actualSpace
-			}
-		}
-
-		if check, _ := devices.isRealFile(devices.metadataLoopFile); check {
-			actualSpace, err := devices.getUnderlyingAvailableSpace(devices.metadataLoopFile)
-			if err == nil && actualSpace < status.Metadata.Available {
-				status.Metadata.Available = actualSpace
-			}
-		}
+	if data, metadata, blockSize, err := devices.poolSpace(); err == nil {
+		status.Data = data
+		status.Metadata = metadata
+		status.SectorSize = blockSize
 	}
 
 	return status
@@ -1935,6 +2061,10 @@ func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error
 		doBlkDiscard:          true,
 		thinpBlockSize:        DefaultThinpBlockSize,
 		deviceIdMap:           make([]byte, DeviceIdMapSz),
+
+		minFreeSpacePercent:     DefaultMinFreeSpacePercent,
+		warningThresholdPercent: DefaultWarningThreshold,
+		loopAutoExtendPercent:   DefaultLoopAutoExtend,
 	}
 
 	foundBlkDiscard := false
@@ -2003,6 +2133,33 @@ func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error
 				return nil, err
 			}
 
+		case "dm.min_free_space":
+			devices.minFreeSpacePercent, err = parsePercent(val)
+			if err != nil {
+				return nil, err
+			}
+
+		case "dm.pool_warning_threshold":
+			devices.warningThresholdPercent, err = parsePercent(val)
+			if err != nil {
+				return nil, err
+			}
+
+		case "dm.loop_auto_extend":
+			devices.loopAutoExtend, err = strconv.ParseBool(val)
+			if err != nil {
+				return nil, err
+			}
+
+		case "dm.loop_auto_extend_percent":
+			devices.loopAutoExtendPercent, err = parsePercent(val)
+			if err != nil {
+				return nil, err
+			}
+			if devices.loopAutoExtendPercent == 0 {
+				return nil, fmt.Errorf("dm.loop_auto_extend_percent must be more than 0%%")
+			}
+
 		default:
 			return nil, fmt.Errorf("Unknown option %s\n", key)
 		}
@@ -2013,9 +2170,16 @@ func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error
 		devices.doBlkDiscard = false
 	}
 
+	if devices.loopAutoExtend && (devices.dataDevice != "" || devices.metadataDevice != "" || devices.thinPoolDevice != "") {
+		return nil, fmt.Errorf("dm.loop_auto_extend can only be used with loopback files")
+	}
+
 	if err := devices.initDevmapper(doInit); err != nil {
 		return nil, err
 	}
 
+	devices.monitorDone = make(chan struct{})
+	go devices.monitorPool()
+
 	return devices, nil
 }
@@ -79,6 +79,9 @@ func (d *Driver) Status() [][2]string {
 		{"Metadata Space Available", fmt.Sprintf("%s", units.HumanSize(float64(s.Metadata.Available)))},
 		{"Udev Sync Supported", fmt.Sprintf("%v", s.UdevSyncSupported)},
 		{"Deferred Removal Enabled", fmt.Sprintf("%v", s.DeferredRemoveEnabled)},
+		{"Minimum Free Space", fmt.Sprintf("%d%%", d.DeviceSet.minFreeSpacePercent)},
+		{"Usage Warning Threshold", fmt.Sprintf("%d%%", d.DeviceSet.warningThresholdPercent)},
+		{"Loop Auto Extend", fmt.Sprintf("%v", d.DeviceSet.loopAutoExtend)},
 	}
 	if len(s.DataLoopback) > 0 {
 		status = append(status, [2]string{"Data loop file", s.DataLoopback})
@@ -103,6 +106,15 @@ func (d *Driver) GetMetadata(id string) (map[string]string, error) {
 	metadata["DeviceId"] = strconv.Itoa(m.deviceId)
 	metadata["DeviceSize"] = strconv.FormatUint(m.deviceSize, 10)
 	metadata["DeviceName"] = m.deviceName
+
+	// The size of a device is its limit, the blocks mapped in an active
+	// device are its usage.
+	metadata["SizeLimit"] = strconv.FormatUint(m.deviceSize, 10)
+	if d.DeviceSet.HasActivatedDevice(id) {
+		if status, err := d.DeviceSet.GetDeviceStatus(id); err == nil {
+			metadata["SizeUsed"] = strconv.FormatUint(status.MappedSectors*512, 10)
+		}
+	}
 	return metadata, nil
 }
 
@@ -116,8 +128,13 @@ func (d *Driver) Cleanup() error {
 	return err
 }
 
-func (d *Driver) Create(id, parent string) error {
-	if err := d.DeviceSet.AddDevice(id, parent); err != nil {
+func (d *Driver) Create(id, parent string, storageOpt map[string]string) error {
+	size, err := graphdriver.ParseStorageOptSize("devicemapper", storageOpt)
+	if err != nil {
+		return err
+	}
+
+	if err := d.DeviceSet.AddDevice(id, parent, size); err != nil {
 		return err
 	}
 
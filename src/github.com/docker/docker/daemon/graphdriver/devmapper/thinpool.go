@@ -0,0 +1,212 @@
+// +build linux
+
+package devmapper
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/pkg/units"
+)
+
+// poolMonitorInterval is how often the usage of the thin pool is checked.
+var poolMonitorInterval = 10 * time.Second
+
+// Events of the thin pool, logged with its name.
+const (
+	// The data usage of the pool crossed the warning threshold.
+	poolDataWarningEvent = "pool_data_warning"
+	// The metadata usage of the pool crossed the warning threshold.
+	poolMetadataWarningEvent = "pool_metadata_warning"
+	// The loopback files of the pool were extended.
+	poolExtendEvent = "pool_extend"
+)
+
+// parsePercent parses a percentage between 0 and 100, with or without the
+// percent sign.
+func parsePercent(val string) (uint32, error) {
+	percent, err := strconv.ParseUint(strings.TrimSuffix(val, "%"), 10, 32)
+	if err != nil || percent > 100 {
+		return 0, fmt.Errorf("Invalid percentage %s", val)
+	}
+	return uint32(percent), nil
+}
+
+// belowMinFreeSpace returns whether the free space of usage is below the
+// minimum free space of the pool. The free space of the filesystem of
+// loopback files is not taken into account, as they are sparse.
+func (devices *DeviceSet) belowMinFreeSpace(usage DiskUsage) bool {
+	return (usage.Total-usage.Used)*100 < usage.Total*uint64(devices.minFreeSpacePercent)
+}
+
+// aboveWarningThreshold returns whether usage is above the warning
+// threshold of the pool.
+func (devices *DeviceSet) aboveWarningThreshold(usage DiskUsage) bool {
+	return devices.warningThresholdPercent > 0 && usage.Used*100 >= usage.Total*uint64(devices.warningThresholdPercent)
+}
+
+// ensurePoolFreeSpace returns an error when the free data or metadata space
+// of the pool is below the minimum free space, after trying to extend the
+// pool if enabled. It must be called with the lock held.
+func (devices *DeviceSet) ensurePoolFreeSpace() error {
+	if devices.minFreeSpacePercent == 0 {
+		return nil
+	}
+
+	data, metadata, _, err := devices.poolSpace()
+	if err != nil {
+		return err
+	}
+	if devices.loopAutoExtend && (devices.belowMinFreeSpace(data) || devices.belowMinFreeSpace(metadata)) {
+		if err := devices.extendPool(data, metadata); err != nil {
+			logrus.Warnf("devmapper: Failed to extend thin pool %s: %s", devices.getPoolName(), err)
+		} else if data, metadata, _, err = devices.poolSpace(); err != nil {
+			return err
+		}
+	}
+
+	if devices.belowMinFreeSpace(data) {
+		return fmt.Errorf("Thin pool %s has %s of free data space, less than the minimum of %d%%. Create more free space in the thin pool or use the dm.min_free_space option to change this behavior", devices.getPoolName(), units.HumanSize(float64(data.Total-data.Used)), devices.minFreeSpacePercent)
+	}
+	if devices.belowMinFreeSpace(metadata) {
+		return fmt.Errorf("Thin pool %s has %s of free metadata space, less than the minimum of %d%%. Create more free space in the thin pool or use the dm.min_free_space option to change this behavior", devices.getPoolName(), units.HumanSize(float64(metadata.Total-metadata.Used)), devices.minFreeSpacePercent)
+	}
+	return nil
+}
+
+// extendPool grows by loopAutoExtendPercent the loopback files of the pool
+// whose usage is above the warning threshold or whose free space is below
+// the minimum. It must be called with the lock held.
+func (devices *DeviceSet) extendPool(data, metadata DiskUsage) error {
+	dataSize, err := devices.extendedLoopFileSize(devices.dataLoopFile, data)
+	if err != nil {
+		return err
+	}
+	metadataSize, err := devices.extendedLoopFileSize(devices.metadataLoopFile, metadata)
+	if err != nil {
+		return err
+	}
+	if dataSize == 0 && metadataSize == 0 {
+		return nil
+	}
+
+	logrus.Infof("devmapper: Extending thin pool %s", devices.getPoolName())
+	return devices.resizePool(dataSize, metadataSize)
+}
+
+// extendedLoopFileSize returns the size loopFile is extended to given its
+// usage, or 0 when it does not need to be extended.
+func (devices *DeviceSet) extendedLoopFileSize(loopFile string, usage DiskUsage) (int64, error) {
+	if !devices.aboveWarningThreshold(usage) && !devices.belowMinFreeSpace(usage) {
+		return 0, nil
+	}
+
+	fi, err := os.Stat(loopFile)
+	if err != nil {
+		return 0, err
+	}
+	growth := fi.Size() * int64(devices.loopAutoExtendPercent) / 100
+
+	// Extending a sparse file does not allocate its blocks, but they cannot
+	// be written once the filesystem of the file is full.
+	available, err := devices.getUnderlyingAvailableSpace(loopFile)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(growth) > available {
+		return 0, fmt.Errorf("Not enough space on the filesystem of %s to extend it by %s", loopFile, units.HumanSize(float64(growth)))
+	}
+	return fi.Size() + growth, nil
+}
+
+// monitorPool checks the usage of the pool every poolMonitorInterval until
+// the deviceset is shut down.
+func (devices *DeviceSet) monitorPool() {
+	ticker := time.NewTicker(poolMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			devices.checkPool()
+		case <-devices.monitorDone:
+			return
+		}
+	}
+}
+
+// checkPool logs an event when the data or metadata usage of the pool
+// crosses the warning threshold, and extends the pool above it if enabled.
+func (devices *DeviceSet) checkPool() {
+	devices.Lock()
+
+	data, metadata, _, err := devices.poolSpace()
+	if err != nil {
+		devices.Unlock()
+		logrus.Debugf("devmapper: Failed to get the status of thin pool %s: %s", devices.getPoolName(), err)
+		return
+	}
+
+	var events []string
+	dataWarning, metadataWarning := devices.aboveWarningThreshold(data), devices.aboveWarningThreshold(metadata)
+	if dataWarning && !devices.dataWarning {
+		logrus.Warnf("devmapper: Thin pool %s has used %s of %s of data space", devices.getPoolName(), units.HumanSize(float64(data.Used)), units.HumanSize(float64(data.Total)))
+		events = append(events, poolDataWarningEvent)
+	}
+	if metadataWarning && !devices.metadataWarning {
+		logrus.Warnf("devmapper: Thin pool %s has used %s of %s of metadata space", devices.getPoolName(), units.HumanSize(float64(metadata.Used)), units.HumanSize(float64(metadata.Total)))
+		events = append(events, poolMetadataWarningEvent)
+	}
+	devices.dataWarning, devices.metadataWarning = dataWarning, metadataWarning
+
+	if devices.loopAutoExtend && (dataWarning || metadataWarning) {
+		if err := devices.extendPool(data, metadata); err != nil {
+			logrus.Warnf("devmapper: Failed to extend thin pool %s: %s", devices.getPoolName(), err)
+		} else {
+			events = append(events, poolExtendEvent)
+			if data, metadata, _, err = devices.poolSpace(); err == nil {
+				devices.dataWarning, devices.metadataWarning = devices.aboveWarningThreshold(data), devices.aboveWarningThreshold(metadata)
+			}
+		}
+	}
+
+	handler := devices.poolEventsHandler
+	devices.Unlock()
+
+	if handler != nil {
+		for _, event := range events {
+			handler(event)
+		}
+	}
+}
+
+// PoolStatus returns the status of the thin pool.
+func (devices *DeviceSet) PoolStatus() *graphdriver.PoolStatus {
+	devices.Lock()
+	defer devices.Unlock()
+
+	status := &graphdriver.PoolStatus{
+		Name:                    devices.getPoolName(),
+		MinFreeSpacePercent:     devices.minFreeSpacePercent,
+		WarningThresholdPercent: devices.warningThresholdPercent,
+		AutoExtend:              devices.loopAutoExtend,
+	}
+	if data, metadata, _, err := devices.poolSpace(); err == nil {
+		status.Data = graphdriver.PoolUsage(data)
+		status.Metadata = graphdriver.PoolUsage(metadata)
+	}
+	return status
+}
+
+// SetPoolEventsHandler sets the function called with the events of the
+// thin pool.
+func (devices *DeviceSet) SetPoolEventsHandler(handler func(event string)) {
+	devices.Lock()
+	devices.poolEventsHandler = handler
+	devices.Unlock()
+}
@@ -0,0 +1,51 @@
+// +build linux
+
+package devmapper
+
+import "testing"
+
+func TestParsePercent(t *testing.T) {
+	for val, expected := range map[string]uint32{"0": 0, "10": 10, "10%": 10, "100%": 100} {
+		percent, err := parsePercent(val)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", val, err)
+		}
+		if percent != expected {
+			t.Fatalf("Expected %s to be %d, got %d", val, expected, percent)
+		}
+	}
+	for _, val := range []string{"", "%", "-1", "101%", "10.5%", "ten"} {
+		if _, err := parsePercent(val); err == nil {
+			t.Fatalf("Expected %q to be an invalid percentage", val)
+		}
+	}
+}
+
+func TestPoolThresholds(t *testing.T) {
+	devices := &DeviceSet{minFreeSpacePercent: 10, warningThresholdPercent: 80}
+
+	for _, c := range []struct {
+		used, available uint64
+		warning, full   bool
+	}{
+		{used: 0, available: 100},
+		{used: 79, available: 21},
+		{used: 80, available: 20, warning: true},
+		{used: 91, available: 9, warning: true, full: true},
+		// The free space of the filesystem of loopback files is ignored.
+		{used: 50, available: 1},
+	} {
+		usage := DiskUsage{Used: c.used, Total: 100, Available: c.available}
+		if warning := devices.aboveWarningThreshold(usage); warning != c.warning {
+			t.Fatalf("Expected usage %+v above the warning threshold to be %v", usage, c.warning)
+		}
+		if full := devices.belowMinFreeSpace(usage); full != c.full {
+			t.Fatalf("Expected usage %+v below the minimum free space to be %v", usage, c.full)
+		}
+	}
+
+	devices.warningThresholdPercent = 0
+	if devices.aboveWarningThreshold(DiskUsage{Used: 100, Total: 100}) {
+		t.Fatal("Expected no warning with a threshold of 0")
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/units"
 )
 
 type FsMagic uint32
@@ -39,8 +40,9 @@ type ProtoDriver interface {
 	// String returns a string representation of this driver.
 	String() string
 	// Create creates a new, empty, filesystem layer with the
-	// specified id and parent. Parent may be "".
-	Create(id, parent string) error
+	// specified id and parent. Parent may be "". storageOpt holds
+	// the storage options of the layer, e.g. its "size", and may be nil.
+	Create(id, parent string, storageOpt map[string]string) error
 	// Remove attempts to remove the filesystem layer with this id.
 	Remove(id string) error
 	// Get returns the mountpoint for the layered filesystem referred
@@ -85,10 +87,104 @@ type Driver interface {
 	DiffSize(id, parent string) (size int64, err error)
 }
 
+// ChangesWalker is implemented by the drivers that report the changes of a
+// layer as they walk it, e.g. to stream them.
+type ChangesWalker interface {
+	// WalkChanges calls fn with each change between the layer id and its
+	// parent layer, which may be "", as it is found. It stops at the first
+	// error returned by fn.
+	WalkChanges(id, parent string, fn func(archive.Change) error) error
+}
+
+// WalkChanges calls fn with each change between the layer id and its parent
+// layer, as it is found by the driver if it is a ChangesWalker, or once the
+// driver listed them otherwise.
+func WalkChanges(driver Driver, id, parent string, fn func(archive.Change) error) error {
+	if walker, ok := driver.(ChangesWalker); ok {
+		return walker.WalkChanges(id, parent, fn)
+	}
+	changes, err := driver.Changes(id, parent)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PoolUsage is the usage of the data or metadata space of a storage pool,
+// in bytes.
+type PoolUsage struct {
+	Used      uint64
+	Total     uint64
+	Available uint64
+}
+
+// PoolStatus is the status of the storage pool of a driver.
+type PoolStatus struct {
+	Name     string
+	Data     PoolUsage
+	Metadata PoolUsage
+	// MinFreeSpacePercent is the free data or metadata space, in percent
+	// of the pool, below which new layers are refused.
+	MinFreeSpacePercent uint32
+	// WarningThresholdPercent is the data or metadata usage, in percent of
+	// the pool, above which an event is logged.
+	WarningThresholdPercent uint32
+	// AutoExtend is set when the pool is extended above the warning
+	// threshold.
+	AutoExtend bool
+}
+
+// PoolDriver is implemented by the drivers storing layers in a storage
+// pool of limited space, whose usage they monitor.
+type PoolDriver interface {
+	// PoolStatus returns the status of the pool.
+	PoolStatus() *PoolStatus
+	// SetPoolEventsHandler sets the function called with the events of
+	// the pool, e.g. when its usage crosses the warning threshold.
+	SetPoolEventsHandler(handler func(event string))
+}
+
+// LazyDriver is implemented by the drivers whose layers can be served by a
+// filesystem mounted over the files of the layer alone, e.g. to run
+// containers on layers that are still being pulled.
+type LazyDriver interface {
+	// DiffPath returns the directory holding the files of the layer id,
+	// and the format of the whiteouts in it.
+	DiffPath(id string) (string, archive.WhiteoutFormat, error)
+}
+
 func init() {
 	drivers = make(map[string]InitFunc)
 }
 
+// ParseStorageOptSize returns the size in bytes given by the "size"
+// storage option of a layer, or 0 when it is not set. Any other option is
+// an error, driver names the driver in it.
+func ParseStorageOptSize(driver string, storageOpt map[string]string) (uint64, error) {
+	var size uint64
+	for key, val := range storageOpt {
+		switch strings.ToLower(key) {
+		case "size":
+			s, err := units.RAMInBytes(val)
+			if err != nil {
+				return 0, err
+			}
+			if s <= 0 {
+				return 0, fmt.Errorf("Invalid storage size: %s", val)
+			}
+			size = uint64(s)
+		default:
+			return 0, fmt.Errorf("Unknown option %s for %s storage driver", key, driver)
+		}
+	}
+	return size, nil
+}
+
 func Register(name string, initFunc InitFunc) error {
 	if _, exists := drivers[name]; exists {
 		return fmt.Errorf("Name already registered %s", name)
@@ -98,10 +194,16 @@ func Register(name string, initFunc InitFunc) error {
 	return nil
 }
 
+// GetDriver initializes the driver of the given name in home. It is either a
+// driver registered in the daemon, or a GraphDriver plugin.
 func GetDriver(name, home string, options []string) (Driver, error) {
 	if initFunc, exists := drivers[name]; exists {
 		return initFunc(filepath.Join(home, name), options)
 	}
+	driver, err := lookupPlugin(name, filepath.Join(home, name), options)
+	if err != ErrNotSupported {
+		return driver, err
+	}
 	logrus.Errorf("Failed to GetDriver graph %s %s", name, home)
 	return nil, ErrNotSupported
 }
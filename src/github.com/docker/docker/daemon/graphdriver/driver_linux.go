@@ -33,6 +33,7 @@ var (
 		"zfs",
 		"devicemapper",
 		"overlay",
+		"overlay2",
 		"vfs",
 	}
 
@@ -31,6 +31,17 @@ func NaiveDiffDriver(driver ProtoDriver) Driver {
 	return &naiveDiffDriver{ProtoDriver: driver}
 }
 
+// GetPoolDriver returns the PoolDriver implemented by driver, or by the
+// ProtoDriver it wraps.
+func GetPoolDriver(driver Driver) (PoolDriver, bool) {
+	if gdw, ok := driver.(*naiveDiffDriver); ok {
+		p, ok := gdw.ProtoDriver.(PoolDriver)
+		return p, ok
+	}
+	p, ok := driver.(PoolDriver)
+	return p, ok
+}
+
 // Diff produces an archive of the changes between the specified
 // layer and its parent layer which may be "".
 func (gdw *naiveDiffDriver) Diff(id, parent string) (arch archive.Archive, err error) {
@@ -106,6 +117,30 @@ func (gdw *naiveDiffDriver) Changes(id, parent string) ([]archive.Change, error)
 	return archive.ChangesDirs(layerFs, parentFs)
 }
 
+// WalkChanges calls fn with each change between the specified layer and its
+// parent layer as it is found, comparing their filesystems.
+func (gdw *naiveDiffDriver) WalkChanges(id, parent string, fn func(archive.Change) error) error {
+	driver := gdw.ProtoDriver
+
+	layerFs, err := driver.Get(id, "")
+	if err != nil {
+		return err
+	}
+	defer driver.Put(id)
+
+	parentFs := ""
+
+	if parent != "" {
+		parentFs, err = driver.Get(parent, "")
+		if err != nil {
+			return err
+		}
+		defer driver.Put(parent)
+	}
+
+	return archive.WalkChangesDirs(layerFs, parentFs, fn)
+}
+
 // ApplyDiff extracts the changeset from the given diff into the
 // layer with the specified id and parent, returning the size of the
 // new layer in bytes.
@@ -151,7 +151,7 @@ func DriverTestCreateEmpty(t *testing.T, drivername string) {
 	driver := GetDriver(t, drivername)
 	defer PutDriver(t)
 
-	if err := driver.Create("empty", ""); err != nil {
+	if err := driver.Create("empty", "", nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -189,7 +189,7 @@ func createBase(t *testing.T, driver graphdriver.Driver, name string) {
 	oldmask := syscall.Umask(0)
 	defer syscall.Umask(oldmask)
 
-	if err := driver.Create(name, ""); err != nil {
+	if err := driver.Create(name, "", nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -255,7 +255,7 @@ func DriverTestCreateSnap(t *testing.T, drivername string) {
 
 	createBase(t, driver, "Base")
 
-	if err := driver.Create("Snap", "Base"); err != nil {
+	if err := driver.Create("Snap", "Base", nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -269,3 +269,38 @@ func DriverTestCreateSnap(t *testing.T, drivername string) {
 		t.Fatal(err)
 	}
 }
+
+func DriverTestDiffApply(t *testing.T, drivername string) {
+	driver := GetDriver(t, drivername)
+	defer PutDriver(t)
+
+	createBase(t, driver, "Base")
+
+	arch, err := driver.Diff("Base", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer arch.Close()
+
+	if err := driver.Create("Applied", "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := driver.ApplyDiff("Applied", "", arch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 {
+		t.Fatal("Expected the size of the applied diff")
+	}
+
+	verifyBase(t, driver, "Applied")
+
+	if err := driver.Remove("Applied"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := driver.Remove("Base"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -9,11 +9,13 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"sync"
 	"syscall"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/daemon/graphdriver/quota"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/chrootarchive"
 	"github.com/opencontainers/runc/libcontainer/label"
@@ -43,6 +45,11 @@ func NaiveDiffDriverWithApply(driver ApplyDiffProtoDriver) graphdriver.Driver {
 	}
 }
 
+// WalkChanges walks the changes with the naive diff driver.
+func (d *naiveDiffDriverWithApply) WalkChanges(id, parent string, fn func(archive.Change) error) error {
+	return graphdriver.WalkChanges(d.Driver, id, parent, fn)
+}
+
 func (d *naiveDiffDriverWithApply) ApplyDiff(id, parent string, diff archive.ArchiveReader) (int64, error) {
 	b, err := d.applyDiff.ApplyDiff(id, parent, diff)
 	if err == ErrApplyDiffFallback {
@@ -88,6 +95,7 @@ type Driver struct {
 	home       string
 	sync.Mutex // Protects concurrent modification to active
 	active     map[string]*ActiveMount
+	quotaCtl   *quota.Control
 }
 
 var backingFs = "<unknown>"
@@ -133,6 +141,14 @@ func Init(home string, options []string) (graphdriver.Driver, error) {
 		active: make(map[string]*ActiveMount),
 	}
 
+	// Sizes of layers can be limited when the backing filesystem is xfs
+	// mounted with project quotas.
+	if backingFs == "xfs" {
+		if d.quotaCtl, err = quota.NewControl(home); err != nil {
+			logrus.Debugf("'%s' project quotas are not supported: %v", d, err)
+		}
+	}
+
 	return NaiveDiffDriverWithApply(d), nil
 }
 
@@ -192,6 +208,15 @@ func (d *Driver) GetMetadata(id string) (map[string]string, error) {
 	metadata["WorkDir"] = path.Join(dir, "work")
 	metadata["MergedDir"] = path.Join(dir, "merged")
 
+	if d.quotaCtl != nil && d.quotaCtl.HasQuota(dir) {
+		q, err := d.quotaCtl.GetQuota(dir)
+		if err != nil {
+			return nil, err
+		}
+		metadata["SizeLimit"] = strconv.FormatUint(q.Size, 10)
+		metadata["SizeUsed"] = strconv.FormatUint(q.Used, 10)
+	}
+
 	return metadata, nil
 }
 
@@ -199,7 +224,15 @@ func (d *Driver) Cleanup() error {
 	return nil
 }
 
-func (d *Driver) Create(id string, parent string) (retErr error) {
+func (d *Driver) Create(id string, parent string, storageOpt map[string]string) (retErr error) {
+	size, err := graphdriver.ParseStorageOptSize("overlay", storageOpt)
+	if err != nil {
+		return err
+	}
+	if size != 0 && d.quotaCtl == nil {
+		return fmt.Errorf("--storage-opt size is only supported for overlay over xfs with the 'pquota' mount option")
+	}
+
 	dir := d.dir(id)
 	if err := os.MkdirAll(path.Dir(dir), 0700); err != nil {
 		return err
@@ -215,6 +248,14 @@ func (d *Driver) Create(id string, parent string) (retErr error) {
 		}
 	}()
 
+	// The quota is set before anything is created in the layer, so that
+	// everything in it inherits the project id.
+	if size != 0 {
+		if err := d.quotaCtl.SetQuota(dir, size); err != nil {
+			return err
+		}
+	}
+
 	// Toplevel images are just a "root" dir
 	if parent == "" {
 		if err := os.Mkdir(path.Join(dir, "root"), 0755); err != nil {
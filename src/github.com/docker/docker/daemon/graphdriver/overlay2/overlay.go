@@ -0,0 +1,531 @@
+// +build linux
+
+package overlay2
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/daemon/graphdriver/quota"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/chrootarchive"
+	"github.com/docker/docker/pkg/directory"
+	"github.com/docker/docker/pkg/parsers/kernel"
+	"github.com/opencontainers/runc/libcontainer/label"
+)
+
+// This backend uses the overlay union filesystem with multiple lower
+// directories, which is supported by the kernel as of 4.0.
+
+// Each layer has a "diff" directory, which holds the files of the layer,
+// and a "link" file, which holds the short name of the layer. The short
+// name is a symlink in the "l" directory of the driver home pointing to
+// the "diff" directory of the layer. It keeps the mount options of layers
+// with many parents under the page size limit.
+
+// A layer with a parent also has a "lower" file, which holds the short
+// names of all its parents joined by ":", nearest parent first, as well as
+// "merged" and "work" directories. The layer is mounted in the "merged"
+// directory, with its "diff" directory as the upper directory and its
+// parents as the lower directories. The "work" directory is needed for
+// overlay to work.
+
+// A layer without a parent is not mounted, its "diff" directory is used
+// as is.
+
+const (
+	linkDir   = "l"
+	lowerFile = "lower"
+	maxDepth  = 128
+
+	// idLength is the length of the short names of layers. 26 base32
+	// characters encode 128 bits of randomness.
+	idLength = 26
+)
+
+type ActiveMount struct {
+	count   int
+	path    string
+	mounted bool
+}
+
+// Driver contains information about the home directory and the list of
+// active mounts that are created using this driver.
+type Driver struct {
+	home       string
+	sync.Mutex // Protects concurrent modification to active
+	active     map[string]*ActiveMount
+	quotaCtl   *quota.Control
+}
+
+var backingFs = "<unknown>"
+
+func init() {
+	graphdriver.Register("overlay2", Init)
+}
+
+// Init returns the overlay2 driver for the given home directory. It
+// returns ErrNotSupported when the kernel has no overlay support or does
+// not support multiple lower directories.
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	if err := supportsOverlay(); err != nil {
+		return nil, graphdriver.ErrNotSupported
+	}
+
+	// Multiple lower directories need kernel 4.0.0 or later.
+	v, err := kernel.GetKernelVersion()
+	if err != nil {
+		return nil, err
+	}
+	if kernel.CompareKernelVersion(v, &kernel.KernelVersionInfo{Kernel: 4, Major: 0, Minor: 0}) < 0 {
+		logrus.Errorf("'overlay2' requires kernel 4.0 or later to use multiple lower directories.")
+		return nil, graphdriver.ErrNotSupported
+	}
+
+	fsMagic, err := graphdriver.GetFSMagic(home)
+	if err != nil {
+		return nil, err
+	}
+	if fsName, ok := graphdriver.FsNames[fsMagic]; ok {
+		backingFs = fsName
+	}
+
+	// check if they are running over btrfs or aufs
+	switch fsMagic {
+	case graphdriver.FsMagicBtrfs:
+		logrus.Error("'overlay2' is not supported over btrfs.")
+		return nil, graphdriver.ErrIncompatibleFS
+	case graphdriver.FsMagicAufs:
+		logrus.Error("'overlay2' is not supported over aufs.")
+		return nil, graphdriver.ErrIncompatibleFS
+	case graphdriver.FsMagicZfs:
+		logrus.Error("'overlay2' is not supported over zfs.")
+		return nil, graphdriver.ErrIncompatibleFS
+	}
+
+	// Create the driver home dir
+	if err := os.MkdirAll(path.Join(home, linkDir), 0700); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	d := &Driver{
+		home:   home,
+		active: make(map[string]*ActiveMount),
+	}
+
+	// Sizes of layers can be limited when the backing filesystem is xfs
+	// mounted with project quotas.
+	if backingFs == "xfs" {
+		if d.quotaCtl, err = quota.NewControl(home); err != nil {
+			logrus.Debugf("'%s' project quotas are not supported: %v", d, err)
+		}
+	}
+
+	return d, nil
+}
+
+func supportsOverlay() error {
+	// We can try to modprobe overlay first before looking at
+	// proc/filesystems for when overlay is supported
+	exec.Command("modprobe", "overlay").Run()
+
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if s.Text() == "nodev\toverlay" {
+			return nil
+		}
+	}
+	logrus.Error("'overlay' not found as a supported filesystem on this host. Please ensure kernel is new enough and has overlay support loaded.")
+	return graphdriver.ErrNotSupported
+}
+
+func (d *Driver) String() string {
+	return "overlay2"
+}
+
+func (d *Driver) Status() [][2]string {
+	return [][2]string{
+		{"Backing Filesystem", backingFs},
+	}
+}
+
+// GetMetadata returns the directories of the layer: "UpperDir" always, and
+// "LowerDir", "WorkDir" and "MergedDir" when the layer has a parent. The
+// size limit and usage of a layer with a quota are returned as "SizeLimit"
+// and "SizeUsed".
+func (d *Driver) GetMetadata(id string) (map[string]string, error) {
+	dir := d.dir(id)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{
+		"UpperDir": path.Join(dir, "diff"),
+	}
+
+	lowers, err := d.getLowerDirs(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(lowers) > 0 {
+		metadata["LowerDir"] = strings.Join(lowers, ":")
+		metadata["WorkDir"] = path.Join(dir, "work")
+		metadata["MergedDir"] = path.Join(dir, "merged")
+	}
+
+	if d.quotaCtl != nil && d.quotaCtl.HasQuota(dir) {
+		q, err := d.quotaCtl.GetQuota(dir)
+		if err != nil {
+			return nil, err
+		}
+		metadata["SizeLimit"] = strconv.FormatUint(q.Size, 10)
+		metadata["SizeUsed"] = strconv.FormatUint(q.Used, 10)
+	}
+
+	return metadata, nil
+}
+
+func (d *Driver) Cleanup() error {
+	return nil
+}
+
+// Create creates a new, empty layer with the specified id and parent.
+func (d *Driver) Create(id string, parent string, storageOpt map[string]string) (retErr error) {
+	size, err := graphdriver.ParseStorageOptSize("overlay2", storageOpt)
+	if err != nil {
+		return err
+	}
+	if size != 0 && d.quotaCtl == nil {
+		return fmt.Errorf("--storage-opt size is only supported for overlay2 over xfs with the 'pquota' mount option")
+	}
+
+	dir := d.dir(id)
+	if err := os.MkdirAll(path.Dir(dir), 0700); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+
+	defer func() {
+		// Clean up on failure
+		if retErr != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	// The quota is set before anything is created in the layer, so that
+	// everything in it inherits the project id.
+	if size != 0 {
+		if err := d.quotaCtl.SetQuota(dir, size); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(path.Join(dir, "diff"), 0755); err != nil {
+		return err
+	}
+
+	lid := generateID()
+	if err := os.Symlink(path.Join("..", id, "diff"), path.Join(d.home, linkDir, lid)); err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			os.Remove(path.Join(d.home, linkDir, lid))
+		}
+	}()
+
+	// Write link id to link file
+	if err := ioutil.WriteFile(path.Join(dir, "link"), []byte(lid), 0644); err != nil {
+		return err
+	}
+
+	// if no parent directory, done
+	if parent == "" {
+		return nil
+	}
+
+	if err := os.Mkdir(path.Join(dir, "work"), 0700); err != nil {
+		return err
+	}
+	if err := os.Mkdir(path.Join(dir, "merged"), 0700); err != nil {
+		return err
+	}
+
+	lower, err := d.getLower(parent)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, lowerFile), []byte(lower), 0666)
+}
+
+// getLower returns the lower file content of a layer whose parent is
+// parent.
+func (d *Driver) getLower(parent string) (string, error) {
+	parentDir := d.dir(parent)
+
+	// Ensure parent exists
+	if _, err := os.Lstat(parentDir); err != nil {
+		return "", err
+	}
+
+	parentLink, err := ioutil.ReadFile(path.Join(parentDir, "link"))
+	if err != nil {
+		return "", err
+	}
+	lowers := []string{path.Join(linkDir, string(parentLink))}
+
+	parentLower, err := ioutil.ReadFile(path.Join(parentDir, lowerFile))
+	if err == nil {
+		parentLowers := strings.Split(string(parentLower), ":")
+		lowers = append(lowers, parentLowers...)
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(lowers) > maxDepth {
+		return "", fmt.Errorf("max depth of %d exceeded", maxDepth)
+	}
+	return strings.Join(lowers, ":"), nil
+}
+
+// getLowerDirs returns the absolute diff directories of the parents of
+// the layer, nearest parent first.
+func (d *Driver) getLowerDirs(id string) ([]string, error) {
+	var lowersArray []string
+	lowers, err := ioutil.ReadFile(path.Join(d.dir(id), lowerFile))
+	if err == nil {
+		for _, s := range strings.Split(string(lowers), ":") {
+			lp, err := os.Readlink(path.Join(d.home, s))
+			if err != nil {
+				return nil, err
+			}
+			lowersArray = append(lowersArray, path.Clean(path.Join(d.home, linkDir, lp)))
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return lowersArray, nil
+}
+
+func (d *Driver) dir(id string) string {
+	return path.Join(d.home, id)
+}
+
+// Remove cleans the directories and the short name of the layer.
+func (d *Driver) Remove(id string) error {
+	dir := d.dir(id)
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	lid, err := ioutil.ReadFile(path.Join(dir, "link"))
+	if err == nil && len(lid) > 0 {
+		if err := os.RemoveAll(path.Join(d.home, linkDir, string(lid))); err != nil {
+			logrus.Debugf("Failed to remove link: %v", err)
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// Get creates and mounts the required file system for the given id and
+// returns the mount path.
+func (d *Driver) Get(id string, mountLabel string) (string, error) {
+	// Protect the d.active from concurrent access
+	d.Lock()
+	defer d.Unlock()
+
+	mount := d.active[id]
+	if mount != nil {
+		mount.count++
+		return mount.path, nil
+	}
+
+	mount = &ActiveMount{count: 1}
+
+	dir := d.dir(id)
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+
+	diffDir := path.Join(dir, "diff")
+	lowers, err := ioutil.ReadFile(path.Join(dir, lowerFile))
+	if err != nil {
+		// If no lower, just return diff directory
+		if os.IsNotExist(err) {
+			mount.path = diffDir
+			d.active[id] = mount
+			return mount.path, nil
+		}
+		return "", err
+	}
+
+	mergedDir := path.Join(dir, "merged")
+	if err := d.mount(id, string(lowers), mountLabel); err != nil {
+		return "", fmt.Errorf("error creating overlay mount to %s: %v", mergedDir, err)
+	}
+	mount.path = mergedDir
+	mount.mounted = true
+	d.active[id] = mount
+
+	return mount.path, nil
+}
+
+// mount mounts the layer on its "merged" directory. The lower directories
+// are given by absolute paths when the mount options fit in a page, and by
+// their short names relative to the driver home otherwise.
+func (d *Driver) mount(id, lowers, mountLabel string) error {
+	var (
+		dir       = d.dir(id)
+		splitLows = strings.Split(lowers, ":")
+		absLowers = make([]string, len(splitLows))
+	)
+	for i, s := range splitLows {
+		absLowers[i] = path.Join(d.home, s)
+	}
+
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(absLowers, ":"), path.Join(dir, "diff"), path.Join(dir, "work"))
+	mountData := label.FormatMountLabel(opts, mountLabel)
+	if len(mountData) <= syscall.Getpagesize() {
+		return syscall.Mount("overlay", path.Join(dir, "merged"), "overlay", 0, mountData)
+	}
+
+	// The absolute paths are too long, mount from the driver home so the
+	// short names can be used as relative paths.
+	opts = fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowers, path.Join(id, "diff"), path.Join(id, "work"))
+	mountData = label.FormatMountLabel(opts, mountLabel)
+	if len(mountData) > syscall.Getpagesize() {
+		return fmt.Errorf("cannot mount layer, mount label too large %d", len(mountData))
+	}
+	return mountFrom(d.home, "overlay", path.Join(id, "merged"), "overlay", mountData)
+}
+
+// Put unmounts the mount path created for the given id.
+func (d *Driver) Put(id string) error {
+	// Protect the d.active from concurrent access
+	d.Lock()
+	defer d.Unlock()
+
+	mount := d.active[id]
+	if mount == nil {
+		logrus.Debugf("Put on a non-mounted device %s", id)
+		// but it might be still here
+		if d.Exists(id) {
+			mergedDir := path.Join(d.dir(id), "merged")
+			err := syscall.Unmount(mergedDir, 0)
+			if err != nil {
+				logrus.Debugf("Failed to unmount %s overlay: %v", id, err)
+			}
+		}
+		return nil
+	}
+
+	mount.count--
+	if mount.count > 0 {
+		return nil
+	}
+
+	defer delete(d.active, id)
+	if mount.mounted {
+		err := syscall.Unmount(mount.path, 0)
+		if err != nil {
+			logrus.Debugf("Failed to unmount %s overlay: %v", id, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Exists checks to see if the id is already mounted.
+func (d *Driver) Exists(id string) bool {
+	_, err := os.Stat(d.dir(id))
+	return err == nil
+}
+
+// ApplyDiff applies the new layer into a root
+func (d *Driver) ApplyDiff(id string, parent string, diff archive.ArchiveReader) (size int64, err error) {
+	applyDir := path.Join(d.dir(id), "diff")
+
+	logrus.Debugf("Applying tar in %s", applyDir)
+	// Overlay doesn't need the parent id to apply the diff
+	if err := chrootarchive.UntarUncompressed(diff, applyDir, &archive.TarOptions{
+		WhiteoutFormat: archive.OverlayWhiteoutFormat,
+	}); err != nil {
+		return 0, err
+	}
+
+	return d.DiffSize(id, parent)
+}
+
+// DiffPath returns the "diff" directory of the layer, whose whiteouts are
+// in the format of overlay.
+func (d *Driver) DiffPath(id string) (string, archive.WhiteoutFormat, error) {
+	return path.Join(d.dir(id), "diff"), archive.OverlayWhiteoutFormat, nil
+}
+
+// DiffSize calculates the changes between the specified id
+// and its parent and returns the size in bytes of the changes
+// relative to its base filesystem directory.
+func (d *Driver) DiffSize(id, parent string) (size int64, err error) {
+	// Overlay doesn't need the parent layer to calculate the diff size.
+	return directory.Size(path.Join(d.dir(id), "diff"))
+}
+
+// Diff produces an archive of the changes between the specified
+// layer and its parent layer which may be "".
+func (d *Driver) Diff(id, parent string) (archive.Archive, error) {
+	// Overlay doesn't need the parent layer to produce a diff.
+	return archive.TarWithOptions(path.Join(d.dir(id), "diff"), &archive.TarOptions{
+		Compression:    archive.Uncompressed,
+		WhiteoutFormat: archive.OverlayWhiteoutFormat,
+	})
+}
+
+// Changes produces a list of changes between the specified layer
+// and its parent layer. If parent is "", then all changes will be ADD changes.
+func (d *Driver) Changes(id, parent string) ([]archive.Change, error) {
+	// Overlay doesn't have snapshots, so we need to get changes from all
+	// parent layers.
+	layers, err := d.getLowerDirs(id)
+	if err != nil {
+		return nil, err
+	}
+	return archive.OverlayChanges(layers, path.Join(d.dir(id), "diff"))
+}
+
+// WalkChanges calls fn with each change between the specified layer and its
+// parent layer as it is found.
+func (d *Driver) WalkChanges(id, parent string, fn func(archive.Change) error) error {
+	layers, err := d.getLowerDirs(id)
+	if err != nil {
+		return err
+	}
+	return archive.WalkOverlayChanges(layers, path.Join(d.dir(id), "diff"), fn)
+}
+
+// generateID creates a new random short name for a layer.
+func generateID() string {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err) // This shouldn't happen
+	}
+	return base32.StdEncoding.EncodeToString(b)[:idLength]
+}
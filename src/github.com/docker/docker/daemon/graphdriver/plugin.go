@@ -0,0 +1,63 @@
+// +build daemon
+
+package graphdriver
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/plugins"
+)
+
+// pluginDriver is the driver of a plugin implementing the diffs. It uses
+// the naive changes of the layers, which the plugin API does not expose.
+type pluginDriver struct {
+	Driver
+	proxy *graphDriverProxy
+}
+
+// Diff produces an archive of the changes between the specified
+// layer and its parent layer which may be "".
+func (d *pluginDriver) Diff(id, parent string) (archive.Archive, error) {
+	return d.proxy.Diff(id, parent)
+}
+
+// ApplyDiff extracts the changeset from the given diff into the
+// layer with the specified id and parent, returning the size of the
+// new layer in bytes.
+func (d *pluginDriver) ApplyDiff(id, parent string, diff archive.ArchiveReader) (int64, error) {
+	return d.proxy.ApplyDiff(id, parent, diff)
+}
+
+// WalkChanges walks the naive changes of the layers.
+func (d *pluginDriver) WalkChanges(id, parent string, fn func(archive.Change) error) error {
+	return WalkChanges(d.Driver, id, parent, fn)
+}
+
+// lookupPlugin returns the driver of the GraphDriver plugin of the given
+// name, initialized in home with the given options. It is ErrNotSupported
+// if there is no such plugin.
+func lookupPlugin(name, home string, options []string) (Driver, error) {
+	pl, err := plugins.Get(name, "GraphDriver")
+	if err != nil {
+		if err == plugins.ErrNotFound {
+			return nil, ErrNotSupported
+		}
+		return nil, fmt.Errorf("Error looking up graphdriver plugin %s: %v", name, err)
+	}
+	return newPluginDriver(name, home, options, pl.Client)
+}
+
+// newPluginDriver initializes the driver of a plugin in home. The NaiveDiffDriver
+// provides the diffs when the plugin does not implement them.
+func newPluginDriver(name, home string, options []string, client pluginClient) (Driver, error) {
+	proxy := &graphDriverProxy{name: name, client: client}
+	diff, err := proxy.Init(home, options)
+	if err != nil {
+		return nil, err
+	}
+	if diff {
+		return &pluginDriver{Driver: NaiveDiffDriver(proxy), proxy: proxy}, nil
+	}
+	return NaiveDiffDriver(proxy), nil
+}
@@ -0,0 +1,9 @@
+// +build !daemon
+
+package graphdriver
+
+// lookupPlugin returns ErrNotSupported, as plugins are only used by the
+// daemon.
+func lookupPlugin(name, home string, options []string) (Driver, error) {
+	return nil, ErrNotSupported
+}
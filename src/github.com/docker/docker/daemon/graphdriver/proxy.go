@@ -0,0 +1,151 @@
+package graphdriver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/docker/docker/pkg/archive"
+)
+
+type pluginClient interface {
+	// Call calls the specified method with the specified arguments for the plugin.
+	Call(string, interface{}, interface{}) error
+	// Stream calls the specified method with the specified arguments for the
+	// plugin and returns the response body.
+	Stream(string, interface{}) (io.ReadCloser, error)
+	// SendFile calls the specified method with the given data as the request
+	// body, and decodes the response into ret.
+	SendFile(string, io.Reader, interface{}) error
+}
+
+// graphDriverProxy implements ProtoDriver, and the diffs, over the
+// GraphDriver plugin API.
+type graphDriverProxy struct {
+	name   string
+	client pluginClient
+}
+
+type graphDriverRequest struct {
+	ID         string            `json:",omitempty"`
+	Parent     string            `json:",omitempty"`
+	MountLabel string            `json:",omitempty"`
+	StorageOpt map[string]string `json:",omitempty"`
+}
+
+type graphDriverResponse struct {
+	Err      string            `json:",omitempty"`
+	Dir      string            `json:",omitempty"`
+	Exists   bool              `json:",omitempty"`
+	Status   [][2]string       `json:",omitempty"`
+	Metadata map[string]string `json:",omitempty"`
+	Size     int64             `json:",omitempty"`
+	// Diff is set by the plugins implementing GraphDriver.Diff and
+	// GraphDriver.ApplyDiff.
+	Diff bool `json:",omitempty"`
+}
+
+type graphDriverInitRequest struct {
+	Home string
+	Opts []string
+}
+
+// call calls the method of the GraphDriver API with the given request, and
+// returns the error of the response, if any.
+func (d *graphDriverProxy) call(method string, req interface{}, ret *graphDriverResponse) error {
+	if err := d.client.Call("GraphDriver."+method, req, ret); err != nil {
+		return err
+	}
+	if ret.Err != "" {
+		return errors.New(ret.Err)
+	}
+	return nil
+}
+
+// Init initializes the driver of the plugin in home with the given options,
+// and returns whether the plugin implements the diffs.
+func (d *graphDriverProxy) Init(home string, opts []string) (diff bool, err error) {
+	var ret graphDriverResponse
+	if err := d.call("Init", graphDriverInitRequest{Home: home, Opts: opts}, &ret); err != nil {
+		return false, err
+	}
+	return ret.Diff, nil
+}
+
+func (d *graphDriverProxy) String() string {
+	return d.name
+}
+
+func (d *graphDriverProxy) Create(id, parent string, storageOpt map[string]string) error {
+	var ret graphDriverResponse
+	return d.call("Create", graphDriverRequest{ID: id, Parent: parent, StorageOpt: storageOpt}, &ret)
+}
+
+func (d *graphDriverProxy) Remove(id string) error {
+	var ret graphDriverResponse
+	return d.call("Remove", graphDriverRequest{ID: id}, &ret)
+}
+
+func (d *graphDriverProxy) Get(id, mountLabel string) (string, error) {
+	var ret graphDriverResponse
+	if err := d.call("Get", graphDriverRequest{ID: id, MountLabel: mountLabel}, &ret); err != nil {
+		return "", err
+	}
+	return ret.Dir, nil
+}
+
+func (d *graphDriverProxy) Put(id string) error {
+	var ret graphDriverResponse
+	return d.call("Put", graphDriverRequest{ID: id}, &ret)
+}
+
+func (d *graphDriverProxy) Exists(id string) bool {
+	var ret graphDriverResponse
+	if err := d.call("Exists", graphDriverRequest{ID: id}, &ret); err != nil {
+		return false
+	}
+	return ret.Exists
+}
+
+func (d *graphDriverProxy) Status() [][2]string {
+	var ret graphDriverResponse
+	if err := d.call("Status", graphDriverRequest{}, &ret); err != nil {
+		return [][2]string{{"Plugin Error", err.Error()}}
+	}
+	return ret.Status
+}
+
+func (d *graphDriverProxy) GetMetadata(id string) (map[string]string, error) {
+	var ret graphDriverResponse
+	if err := d.call("GetMetadata", graphDriverRequest{ID: id}, &ret); err != nil {
+		return nil, err
+	}
+	return ret.Metadata, nil
+}
+
+func (d *graphDriverProxy) Cleanup() error {
+	var ret graphDriverResponse
+	return d.call("Cleanup", graphDriverRequest{}, &ret)
+}
+
+// Diff returns the archive of the changes of the layer id since parent,
+// streamed by the plugin.
+func (d *graphDriverProxy) Diff(id, parent string) (archive.Archive, error) {
+	return d.client.Stream("GraphDriver.Diff", graphDriverRequest{ID: id, Parent: parent})
+}
+
+// ApplyDiff sends the diff to the plugin, to be extracted to the layer id.
+// The ID and the parent are given in the query of the request, the body of
+// which is the diff.
+func (d *graphDriverProxy) ApplyDiff(id, parent string, diff archive.ArchiveReader) (int64, error) {
+	query := url.Values{"id": {id}, "parent": {parent}}
+	var ret graphDriverResponse
+	if err := d.client.SendFile(fmt.Sprintf("GraphDriver.ApplyDiff?%s", query.Encode()), diff, &ret); err != nil {
+		return 0, err
+	}
+	if ret.Err != "" {
+		return 0, errors.New(ret.Err)
+	}
+	return ret.Size, nil
+}
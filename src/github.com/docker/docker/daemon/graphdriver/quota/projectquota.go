@@ -0,0 +1,295 @@
+// +build linux
+
+// Package quota limits the size of directories with XFS project quotas.
+//
+// Each directory with a quota gets its own project id, which is inherited
+// by the files and directories created in it. The filesystem has to be
+// mounted with the "pquota" or "prjquota" option.
+package quota
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// fsIocFsGetXattr and fsIocFsSetXattr are FS_IOC_FSGETXATTR and
+	// FS_IOC_FSSETXATTR of <linux/fs.h>.
+	fsIocFsGetXattr = 0x801c581f
+	fsIocFsSetXattr = 0x401c5820
+
+	// fsXflagProjInherit makes new files inherit the project id of their
+	// directory.
+	fsXflagProjInherit = 0x00000200
+
+	// qXGetQuota and qXSetQLim are the XFS quotactl commands of
+	// <linux/dqblk_xfs.h>, prjQuota is PRJQUOTA of <linux/quota.h>.
+	qXGetQuota = 0x5803
+	qXSetQLim  = 0x5804
+	prjQuota   = 2
+
+	fsDquotVersion = 1
+	fsProjQuota    = 2
+	fsDqBSoft      = 1 << 2
+	fsDqBHard      = 1 << 3
+
+	// quotas are counted in basic blocks of 512 bytes.
+	basicBlockSize = 512
+)
+
+// fsXattr is struct fsxattr of <linux/fs.h>.
+type fsXattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// fsDiskQuota is struct fs_disk_quota of <linux/dqblk_xfs.h>.
+type fsDiskQuota struct {
+	version      int8
+	flags        int8
+	fieldmask    uint16
+	id           uint32
+	blkHardlimit uint64
+	blkSoftlimit uint64
+	inoHardlimit uint64
+	inoSoftlimit uint64
+	bcount       uint64
+	icount       uint64
+	itimer       int32
+	btimer       int32
+	iwarns       uint16
+	bwarns       uint16
+	padding2     int32
+	rtbHardlimit uint64
+	rtbSoftlimit uint64
+	rtbcount     uint64
+	rtbtimer     int32
+	rtbwarns     uint16
+	padding3     int16
+	padding4     [8]byte
+}
+
+// Quota is the size limit of a directory, and its usage, in bytes.
+type Quota struct {
+	Size uint64
+	Used uint64
+}
+
+// Control sets and reads the quotas of the directories under a base
+// directory.
+type Control struct {
+	sync.Mutex        // protects nextProjectID and quotas
+	backingFsBlockDev string
+	nextProjectID     uint32
+	quotas            map[string]uint32
+}
+
+// NewControl returns a Control for the directories under basePath. It
+// fails when the filesystem of basePath does not support project quotas.
+//
+// The project id of basePath is reserved, its sub directories get project
+// ids starting from the next one. The project ids already in use under
+// basePath are reloaded so quotas survive daemon restarts.
+func NewControl(basePath string) (*Control, error) {
+	minProjectID, err := getProjectID(basePath)
+	if err != nil {
+		return nil, err
+	}
+	minProjectID++
+
+	backingFsBlockDev, err := makeBackingFsDev(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Setting a quota on the base project id checks that the filesystem
+	// supports project quotas at all.
+	if err := setProjectQuota(backingFsBlockDev, minProjectID, 0); err != nil {
+		return nil, err
+	}
+
+	q := &Control{
+		backingFsBlockDev: backingFsBlockDev,
+		nextProjectID:     minProjectID + 1,
+		quotas:            make(map[string]uint32),
+	}
+
+	if err := q.findNextProjectID(basePath, minProjectID); err != nil {
+		return nil, err
+	}
+
+	logrus.Debugf("NewControl(%s): nextProjectID = %d", basePath, q.nextProjectID)
+	return q, nil
+}
+
+// SetQuota assigns a project id to targetPath, unless it already has one,
+// and limits the size of the project to size bytes.
+func (q *Control) SetQuota(targetPath string, size uint64) error {
+	q.Lock()
+	projectID, ok := q.quotas[targetPath]
+	if !ok {
+		projectID = q.nextProjectID
+		if err := setProjectID(targetPath, projectID); err != nil {
+			q.Unlock()
+			return err
+		}
+		q.quotas[targetPath] = projectID
+		q.nextProjectID++
+	}
+	q.Unlock()
+
+	logrus.Debugf("SetQuota(%s, %d): projectID=%d", targetPath, size, projectID)
+	return setProjectQuota(q.backingFsBlockDev, projectID, size)
+}
+
+// GetQuota returns the quota of targetPath. It fails when targetPath has
+// no quota.
+func (q *Control) GetQuota(targetPath string) (*Quota, error) {
+	q.Lock()
+	projectID, ok := q.quotas[targetPath]
+	q.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("quota not found for path: %s", targetPath)
+	}
+
+	var d fsDiskQuota
+	if err := quotactl(qXGetQuota, q.backingFsBlockDev, projectID, &d); err != nil {
+		return nil, fmt.Errorf("Failed to get quota limit for projid %d on %s: %v", projectID, q.backingFsBlockDev, err)
+	}
+	return &Quota{
+		Size: d.blkHardlimit * basicBlockSize,
+		Used: d.bcount * basicBlockSize,
+	}, nil
+}
+
+// HasQuota reports whether targetPath has a quota.
+func (q *Control) HasQuota(targetPath string) bool {
+	q.Lock()
+	defer q.Unlock()
+	_, ok := q.quotas[targetPath]
+	return ok
+}
+
+// findNextProjectID reloads the project ids of the directories under
+// basePath and moves nextProjectID past them.
+func (q *Control) findNextProjectID(basePath string, minProjectID uint32) error {
+	files, err := ioutil.ReadDir(basePath)
+	if err != nil {
+		return fmt.Errorf("read directory failed: %s", basePath)
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		p := filepath.Join(basePath, file.Name())
+		projid, err := getProjectID(p)
+		if err != nil {
+			return err
+		}
+		// Lower project ids are inherited from basePath or reserved, not
+		// assigned by SetQuota.
+		if projid <= minProjectID {
+			continue
+		}
+		q.quotas[p] = projid
+		if q.nextProjectID <= projid {
+			q.nextProjectID = projid + 1
+		}
+	}
+	return nil
+}
+
+func setProjectQuota(backingFsBlockDev string, projectID uint32, size uint64) error {
+	d := fsDiskQuota{
+		version:      fsDquotVersion,
+		flags:        fsProjQuota,
+		fieldmask:    fsDqBHard | fsDqBSoft,
+		id:           projectID,
+		blkHardlimit: size / basicBlockSize,
+		blkSoftlimit: size / basicBlockSize,
+	}
+	if err := quotactl(qXSetQLim, backingFsBlockDev, projectID, &d); err != nil {
+		return fmt.Errorf("Failed to set quota limit for projid %d on %s: %v", projectID, backingFsBlockDev, err)
+	}
+	return nil
+}
+
+func quotactl(cmd int, special string, id uint32, d *fsDiskQuota) error {
+	p, err := syscall.BytePtrFromString(special)
+	if err != nil {
+		return err
+	}
+	// QCMD(cmd, PRJQUOTA)
+	qcmd := uintptr(cmd<<8 | prjQuota&0xff)
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, qcmd, uintptr(unsafe.Pointer(p)), uintptr(id), uintptr(unsafe.Pointer(d)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func getProjectID(targetPath string) (uint32, error) {
+	var fsx fsXattr
+	if err := fsxattr(targetPath, fsIocFsGetXattr, &fsx); err != nil {
+		return 0, fmt.Errorf("Failed to get projid for %s: %v", targetPath, err)
+	}
+	return fsx.projid, nil
+}
+
+func setProjectID(targetPath string, projectID uint32) error {
+	var fsx fsXattr
+	if err := fsxattr(targetPath, fsIocFsGetXattr, &fsx); err != nil {
+		return fmt.Errorf("Failed to get projid for %s: %v", targetPath, err)
+	}
+	fsx.projid = projectID
+	fsx.xflags |= fsXflagProjInherit
+	if err := fsxattr(targetPath, fsIocFsSetXattr, &fsx); err != nil {
+		return fmt.Errorf("Failed to set projid for %s: %v", targetPath, err)
+	}
+	return nil
+}
+
+func fsxattr(targetPath string, req uintptr, fsx *fsXattr) error {
+	dir, err := os.Open(targetPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dir.Fd(), req, uintptr(unsafe.Pointer(fsx)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeBackingFsDev creates a block device node for the filesystem of home,
+// which quotactl needs to address the filesystem.
+func makeBackingFsDev(home string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(home, &stat); err != nil {
+		return "", err
+	}
+
+	backingFsBlockDev := path.Join(home, "backingFsBlockDev")
+	// Re-create just in case someone copied the home directory over to a
+	// new device
+	syscall.Unlink(backingFsBlockDev)
+	if err := syscall.Mknod(backingFsBlockDev, syscall.S_IFBLK|0600, int(stat.Dev)); err != nil {
+		return "", fmt.Errorf("Failed to mknod %s: %v", backingFsBlockDev, err)
+	}
+
+	return backingFsBlockDev, nil
+}
@@ -0,0 +1,206 @@
+// Package refplugin is a reference GraphDriver plugin. It serves the plugin
+// API with a driver registered in the daemon, e.g. to test the support of
+// graphdriver plugins.
+package refplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/docker/docker/daemon/graphdriver"
+)
+
+const pluginContentType = "application/vnd.docker.plugins.v1+json"
+
+type request struct {
+	ID         string
+	Parent     string
+	MountLabel string
+	StorageOpt map[string]string
+}
+
+type initRequest struct {
+	Home string
+	Opts []string
+}
+
+type response struct {
+	Err      string            `json:",omitempty"`
+	Dir      string            `json:",omitempty"`
+	Exists   bool              `json:",omitempty"`
+	Status   [][2]string       `json:",omitempty"`
+	Metadata map[string]string `json:",omitempty"`
+	Size     int64             `json:",omitempty"`
+	Diff     bool              `json:",omitempty"`
+}
+
+// Plugin serves the GraphDriver plugin API with a driver of the daemon.
+type Plugin struct {
+	name string
+	diff bool
+	mux  *http.ServeMux
+
+	mu     sync.Mutex
+	driver graphdriver.Driver
+	calls  map[string]int
+}
+
+// New returns a plugin serving the driver of the given name, initialized
+// again on every GraphDriver.Init. The plugin implements the diffs with the
+// driver if diff is set, and leaves them to the daemon otherwise.
+func New(name string, diff bool) *Plugin {
+	p := &Plugin{
+		name:  name,
+		diff:  diff,
+		mux:   http.NewServeMux(),
+		calls: make(map[string]int),
+	}
+
+	p.mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, map[string][]string{"Implements": {"GraphDriver"}})
+	})
+	p.mux.HandleFunc("/GraphDriver.Init", p.init)
+	p.handle("Create", func(d graphdriver.Driver, req *request) (*response, error) {
+		return &response{}, d.Create(req.ID, req.Parent, req.StorageOpt)
+	})
+	p.handle("Remove", func(d graphdriver.Driver, req *request) (*response, error) {
+		return &response{}, d.Remove(req.ID)
+	})
+	p.handle("Get", func(d graphdriver.Driver, req *request) (*response, error) {
+		dir, err := d.Get(req.ID, req.MountLabel)
+		return &response{Dir: dir}, err
+	})
+	p.handle("Put", func(d graphdriver.Driver, req *request) (*response, error) {
+		return &response{}, d.Put(req.ID)
+	})
+	p.handle("Exists", func(d graphdriver.Driver, req *request) (*response, error) {
+		return &response{Exists: d.Exists(req.ID)}, nil
+	})
+	p.handle("Status", func(d graphdriver.Driver, req *request) (*response, error) {
+		return &response{Status: d.Status()}, nil
+	})
+	p.handle("GetMetadata", func(d graphdriver.Driver, req *request) (*response, error) {
+		metadata, err := d.GetMetadata(req.ID)
+		return &response{Metadata: metadata}, err
+	})
+	p.handle("Cleanup", func(d graphdriver.Driver, req *request) (*response, error) {
+		return &response{}, d.Cleanup()
+	})
+	if diff {
+		p.mux.HandleFunc("/GraphDriver.Diff", p.diffLayer)
+		p.mux.HandleFunc("/GraphDriver.ApplyDiff", p.applyDiff)
+	}
+
+	return p
+}
+
+// ServeHTTP serves the plugin API.
+func (p *Plugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// Calls returns the number of calls of the given method of the GraphDriver
+// API, e.g. "Diff".
+func (p *Plugin) Calls(method string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls[method]
+}
+
+// getDriver returns the initialized driver, counting a call of method.
+func (p *Plugin) getDriver(method string) (graphdriver.Driver, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls[method]++
+	if p.driver == nil {
+		return nil, fmt.Errorf("%s is not initialized", p.name)
+	}
+	return p.driver, nil
+}
+
+func (p *Plugin) init(w http.ResponseWriter, r *http.Request) {
+	var req initRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls["Init"]++
+	driver, err := graphdriver.GetDriver(p.name, req.Home, req.Opts)
+	if err != nil {
+		writeResponse(w, &response{Err: err.Error()})
+		return
+	}
+	p.driver = driver
+	writeResponse(w, &response{Diff: p.diff})
+}
+
+// handle serves the given method of the GraphDriver API with fn.
+func (p *Plugin) handle(method string, fn func(graphdriver.Driver, *request) (*response, error)) {
+	p.mux.HandleFunc("/GraphDriver."+method, func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		driver, err := p.getDriver(method)
+		if err != nil {
+			writeResponse(w, &response{Err: err.Error()})
+			return
+		}
+		ret, err := fn(driver, &req)
+		if err != nil {
+			ret.Err = err.Error()
+		}
+		writeResponse(w, ret)
+	})
+}
+
+// diffLayer streams the diff of a layer, or fails with an HTTP error.
+func (p *Plugin) diffLayer(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	driver, err := p.getDriver("Diff")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	arch, err := driver.Diff(req.ID, req.Parent)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer arch.Close()
+	w.Header().Set("Content-Type", "application/x-tar")
+	io.Copy(w, arch)
+}
+
+// applyDiff applies the diff in the request body to the layer given in the
+// query.
+func (p *Plugin) applyDiff(w http.ResponseWriter, r *http.Request) {
+	driver, err := p.getDriver("ApplyDiff")
+	if err != nil {
+		writeResponse(w, &response{Err: err.Error()})
+		return
+	}
+	query := r.URL.Query()
+	size, err := driver.ApplyDiff(query.Get("id"), query.Get("parent"), r.Body)
+	if err != nil {
+		writeResponse(w, &response{Err: err.Error()})
+		return
+	}
+	writeResponse(w, &response{Size: size})
+}
+
+func writeResponse(w http.ResponseWriter, ret interface{}) {
+	w.Header().Set("Content-Type", pluginContentType)
+	json.NewEncoder(w).Encode(ret)
+}
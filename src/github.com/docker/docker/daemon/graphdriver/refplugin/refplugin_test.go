@@ -0,0 +1,91 @@
+// +build linux,daemon
+
+package refplugin
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/daemon/graphdriver/graphtest"
+	_ "github.com/docker/docker/daemon/graphdriver/vfs"
+	"github.com/docker/docker/pkg/reexec"
+)
+
+func init() {
+	reexec.Init()
+}
+
+// serve serves the plugin on the socket of the given name, where the daemon
+// discovers plugins.
+func serve(t *testing.T, name string, plugin *Plugin) func() {
+	if os.Getuid() != 0 {
+		t.Skip("Serving plugins requires root")
+	}
+	if err := os.MkdirAll("/run/docker/plugins", 0755); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join("/run/docker/plugins", name+".sock")
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, plugin)
+	return func() {
+		l.Close()
+		os.Remove(socket)
+	}
+}
+
+var (
+	naivePlugin = New("vfs", false)
+	diffPlugin  = New("vfs", true)
+	stopPlugin  func()
+)
+
+// This avoids creating a new driver for each test if all tests are run
+// Make sure to put new tests between TestRefPluginSetup and TestRefPluginTeardown
+func TestRefPluginSetup(t *testing.T) {
+	stopPlugin = serve(t, "graphtest-vfs", naivePlugin)
+	graphtest.GetDriver(t, "graphtest-vfs")
+}
+
+func TestRefPluginCreateEmpty(t *testing.T) {
+	graphtest.DriverTestCreateEmpty(t, "graphtest-vfs")
+}
+
+func TestRefPluginCreateBase(t *testing.T) {
+	graphtest.DriverTestCreateBase(t, "graphtest-vfs")
+}
+
+func TestRefPluginCreateSnap(t *testing.T) {
+	graphtest.DriverTestCreateSnap(t, "graphtest-vfs")
+}
+
+func TestRefPluginNaiveDiffApply(t *testing.T) {
+	graphtest.DriverTestDiffApply(t, "graphtest-vfs")
+	if naivePlugin.Calls("Get") == 0 {
+		t.Fatal("Expected the daemon to diff the layers of the plugin")
+	}
+}
+
+func TestRefPluginTeardown(t *testing.T) {
+	graphtest.PutDriver(t)
+	if stopPlugin != nil {
+		stopPlugin()
+	}
+}
+
+func TestRefPluginDiffApply(t *testing.T) {
+	defer serve(t, "graphtest-vfs-diff", diffPlugin)()
+	graphtest.GetDriver(t, "graphtest-vfs-diff")
+	defer graphtest.PutDriver(t)
+
+	graphtest.DriverTestDiffApply(t, "graphtest-vfs-diff")
+	if diffPlugin.Calls("Diff") != 1 || diffPlugin.Calls("ApplyDiff") != 1 {
+		t.Fatal("Expected the plugin to diff its layers")
+	}
+}
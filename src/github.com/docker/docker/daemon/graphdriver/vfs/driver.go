@@ -21,10 +21,12 @@ func Init(home string, options []string) (graphdriver.Driver, error) {
 	d := &Driver{
 		home: home,
 	}
+	setupDriverQuota(d)
 	return graphdriver.NaiveDiffDriver(d), nil
 }
 
 type Driver struct {
+	driverQuota
 	home string
 }
 
@@ -37,14 +39,19 @@ func (d *Driver) Status() [][2]string {
 }
 
 func (d *Driver) GetMetadata(id string) (map[string]string, error) {
-	return nil, nil
+	return d.quotaMetadata(d.dir(id))
 }
 
 func (d *Driver) Cleanup() error {
 	return nil
 }
 
-func (d *Driver) Create(id, parent string) error {
+func (d *Driver) Create(id, parent string, storageOpt map[string]string) error {
+	size, err := graphdriver.ParseStorageOptSize("vfs", storageOpt)
+	if err != nil {
+		return err
+	}
+
 	dir := d.dir(id)
 	if err := system.MkdirAll(filepath.Dir(dir), 0700); err != nil {
 		return err
@@ -52,6 +59,12 @@ func (d *Driver) Create(id, parent string) error {
 	if err := os.Mkdir(dir, 0755); err != nil {
 		return err
 	}
+	if size != 0 {
+		if err := d.setupQuota(dir, size); err != nil {
+			os.Remove(dir)
+			return err
+		}
+	}
 	opts := []string{"level:s0"}
 	if _, mountLabel, err := label.InitLabels(opts); err == nil {
 		label.SetFileLabel(dir, mountLabel)
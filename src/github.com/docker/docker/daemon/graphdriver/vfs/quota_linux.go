@@ -0,0 +1,51 @@
+// +build linux
+
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/graphdriver/quota"
+)
+
+type driverQuota struct {
+	quotaCtl *quota.Control
+}
+
+func setupDriverQuota(d *Driver) {
+	if err := os.MkdirAll(filepath.Join(d.home, "dir"), 0700); err != nil {
+		logrus.Debugf("vfs: cannot create %s: %v", filepath.Join(d.home, "dir"), err)
+		return
+	}
+	quotaCtl, err := quota.NewControl(filepath.Join(d.home, "dir"))
+	if err != nil {
+		logrus.Debugf("vfs: project quotas are not supported: %v", err)
+		return
+	}
+	d.quotaCtl = quotaCtl
+}
+
+func (d *Driver) setupQuota(dir string, size uint64) error {
+	if d.quotaCtl == nil {
+		return fmt.Errorf("--storage-opt size is only supported for vfs over xfs with the 'pquota' mount option")
+	}
+	return d.quotaCtl.SetQuota(dir, size)
+}
+
+func (d *Driver) quotaMetadata(dir string) (map[string]string, error) {
+	if d.quotaCtl == nil || !d.quotaCtl.HasQuota(dir) {
+		return nil, nil
+	}
+	q, err := d.quotaCtl.GetQuota(dir)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"SizeLimit": strconv.FormatUint(q.Size, 10),
+		"SizeUsed":  strconv.FormatUint(q.Used, 10),
+	}, nil
+}
@@ -0,0 +1,17 @@
+package vfs
+
+import "fmt"
+
+type driverQuota struct {
+}
+
+func setupDriverQuota(d *Driver) {
+}
+
+func (d *Driver) setupQuota(dir string, size uint64) error {
+	return fmt.Errorf("--storage-opt size is not supported for vfs on this platform")
+}
+
+func (d *Driver) quotaMetadata(dir string) (map[string]string, error) {
+	return nil, nil
+}
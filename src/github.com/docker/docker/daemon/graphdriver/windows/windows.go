@@ -90,7 +90,10 @@ func (d *WindowsGraphDriver) Exists(id string) bool {
 	return result
 }
 
-func (d *WindowsGraphDriver) Create(id, parent string) error {
+func (d *WindowsGraphDriver) Create(id, parent string, storageOpt map[string]string) error {
+	if len(storageOpt) != 0 {
+		return fmt.Errorf("--storage-opt is not supported for windows")
+	}
 	return hcsshim.CreateLayer(d.info, id, parent)
 }
 
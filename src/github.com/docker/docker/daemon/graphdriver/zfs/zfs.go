@@ -189,11 +189,23 @@ func (d *Driver) Status() [][2]string {
 	}
 }
 
+// GetMetadata returns the quota and usage of a filesystem with a quota as
+// "SizeLimit" and "SizeUsed".
 func (d *Driver) GetMetadata(id string) (map[string]string, error) {
-	return nil, nil
+	dataset, err := zfs.GetDataset(d.ZfsPath(id))
+	if err != nil {
+		return nil, err
+	}
+	if dataset.Quota == 0 {
+		return nil, nil
+	}
+	return map[string]string{
+		"SizeLimit": strconv.FormatUint(dataset.Quota, 10),
+		"SizeUsed":  strconv.FormatUint(dataset.Used, 10),
+	}, nil
 }
 
-func (d *Driver) cloneFilesystem(name, parentName string) error {
+func (d *Driver) cloneFilesystem(name, parentName string, properties map[string]string) error {
 	snapshotName := fmt.Sprintf("%d", time.Now().Nanosecond())
 	parentDataset := zfs.Dataset{Name: parentName}
 	snapshot, err := parentDataset.Snapshot(snapshotName /*recursive */, false)
@@ -201,7 +213,7 @@ func (d *Driver) cloneFilesystem(name, parentName string) error {
 		return err
 	}
 
-	_, err = snapshot.Clone(name, map[string]string{"mountpoint": "legacy"})
+	_, err = snapshot.Clone(name, properties)
 	if err == nil {
 		d.Lock()
 		d.filesystemsCache[name] = true
@@ -223,8 +235,13 @@ func (d *Driver) MountPath(id string) string {
 	return path.Join(d.options.mountPath, "graph", getMountpoint(id))
 }
 
-func (d *Driver) Create(id string, parent string) error {
-	err := d.create(id, parent)
+func (d *Driver) Create(id string, parent string, storageOpt map[string]string) error {
+	size, err := graphdriver.ParseStorageOptSize("zfs", storageOpt)
+	if err != nil {
+		return err
+	}
+
+	err = d.create(id, parent, size)
 	if err == nil {
 		return nil
 	}
@@ -243,13 +260,16 @@ func (d *Driver) Create(id string, parent string) error {
 	}
 
 	// retry
-	return d.create(id, parent)
+	return d.create(id, parent, size)
 }
 
-func (d *Driver) create(id, parent string) error {
+func (d *Driver) create(id, parent string, size uint64) error {
 	name := d.ZfsPath(id)
+	mountoptions := map[string]string{"mountpoint": "legacy"}
+	if size != 0 {
+		mountoptions["quota"] = strconv.FormatUint(size, 10)
+	}
 	if parent == "" {
-		mountoptions := map[string]string{"mountpoint": "legacy"}
 		fs, err := zfs.CreateFilesystem(name, mountoptions)
 		if err == nil {
 			d.Lock()
@@ -258,7 +278,7 @@ func (d *Driver) create(id, parent string) error {
 		}
 		return err
 	}
-	return d.cloneFilesystem(name, d.ZfsPath(parent))
+	return d.cloneFilesystem(name, d.ZfsPath(parent), mountoptions)
 }
 
 func (d *Driver) Remove(id string) error {
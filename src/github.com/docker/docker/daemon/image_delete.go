@@ -90,7 +90,14 @@ func (daemon *Daemon) imgDeleteHelper(name string, list *[]types.ImageDelete, fi
 		return nil
 	}
 
-	if len(repos) <= 1 || (len(repoAndTags) <= 1 && deleteByID) {
+	// The image is also deleted when only digests are left once it is untagged
+	otherRefs := []string{}
+	for _, ref := range repos {
+		if ref != utils.ImageReference(repoName, tag) {
+			otherRefs = append(otherRefs, ref)
+		}
+	}
+	if len(repos) <= 1 || (len(repoAndTags) <= 1 && deleteByID) || (!deleteByID && onlyDigestReferences(otherRefs)) {
 		if err := daemon.canDeleteImage(img.ID, force); err != nil {
 			return err
 		}
@@ -112,6 +119,21 @@ func (daemon *Daemon) imgDeleteHelper(name string, list *[]types.ImageDelete, fi
 		}
 	}
 	tags = daemon.Repositories().ByID()[img.ID]
+	if !deleteByID && onlyDigestReferences(tags) {
+		// The digests an image was pulled or pushed with do not keep it
+		// once its last tag is removed.
+		for _, name := range tags {
+			digestRepo, dgst := parsers.ParseRepositoryTag(name)
+			if _, err := daemon.Repositories().Delete(digestRepo, dgst); err != nil {
+				return err
+			}
+			*list = append(*list, types.ImageDelete{
+				Untagged: name,
+			})
+			daemon.EventsService.Log("untag", img.ID, "")
+		}
+		tags = nil
+	}
 	if (len(tags) <= 1 && repoName == "") || len(tags) == 0 {
 		if len(byParents[img.ID]) == 0 {
 			if err := daemon.Repositories().DeleteAll(img.ID); err != nil {
@@ -137,6 +159,17 @@ func (daemon *Daemon) imgDeleteHelper(name string, list *[]types.ImageDelete, fi
 	return nil
 }
 
+// onlyDigestReferences returns true if the image references refs are all
+// references by digest.
+func onlyDigestReferences(refs []string) bool {
+	for _, ref := range refs {
+		if !strings.Contains(ref, "@") {
+			return false
+		}
+	}
+	return len(refs) > 0
+}
+
 func (daemon *Daemon) canDeleteImage(imgID string, force bool) error {
 	if daemon.Graph().IsHeld(imgID) {
 		return fmt.Errorf("Conflict, cannot delete because %s is held by an ongoing pull or build", stringid.TruncateID(imgID))
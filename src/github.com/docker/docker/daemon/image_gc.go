@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// imageGCConfig holds the image garbage collection policy of the daemon.
+type imageGCConfig struct {
+	// HighWatermark is the disk usage of the daemon root, in percent,
+	// above which images are collected periodically. 0 disables the
+	// periodic collection.
+	HighWatermark int
+	// LowWatermark is the disk usage, in percent, the periodic collection
+	// brings the disk usage down to.
+	LowWatermark int
+	// Interval is how often the disk usage is checked.
+	Interval time.Duration
+	// KeepPerRepository is the number of most recent images kept in each
+	// repository. Tagged images are never collected if it is negative.
+	KeepPerRepository int
+	// MinAge is how old images must be to be collected.
+	MinAge time.Duration
+}
+
+func (config *imageGCConfig) validate() error {
+	if config.HighWatermark < 0 || config.HighWatermark > 100 {
+		return fmt.Errorf("Invalid image GC high watermark %d, must be between 0 and 100", config.HighWatermark)
+	}
+	if config.LowWatermark == 0 {
+		config.LowWatermark = config.HighWatermark
+	}
+	if config.LowWatermark < 0 || config.LowWatermark > config.HighWatermark {
+		return fmt.Errorf("Invalid image GC low watermark %d, must be between 0 and the high watermark", config.LowWatermark)
+	}
+	if config.HighWatermark > 0 && config.Interval <= 0 {
+		return fmt.Errorf("Invalid image GC interval %s", config.Interval)
+	}
+	if config.MinAge < 0 {
+		return fmt.Errorf("Invalid image GC minimum age %s", config.MinAge)
+	}
+	return nil
+}
+
+// imageGC collects the images the image GC policy does not keep, oldest first,
+// until the disk usage of the daemon root is at most lowWatermark percent. All
+// of them are collected if lowWatermark is negative. Images are untagged and
+// deleted like with ImageDelete, along with the parent layers no other image
+// uses.
+func (daemon *Daemon) imageGC(lowWatermark int) ([]types.ImageDelete, error) {
+	daemon.imageGCLock.Lock()
+	defer daemon.imageGCLock.Unlock()
+
+	list := []types.ImageDelete{}
+	for {
+		candidates := gcCandidates(daemon.Graph().Map(), daemon.Graph().ByParent(), daemon.Repositories().ByID(), daemon.usedImages(), &daemon.config.ImageGC, time.Now())
+		collected := 0
+		for _, img := range candidates {
+			if lowWatermark >= 0 {
+				usage, err := diskUsage(daemon.root)
+				if err != nil {
+					return list, err
+				}
+				if usage <= lowWatermark {
+					return list, nil
+				}
+			}
+			if daemon.Graph().IsHeld(img.ID) {
+				continue
+			}
+			deleted, err := daemon.gcImage(img)
+			list = append(list, deleted...)
+			if err != nil {
+				// The image may have been used since the candidates
+				// were selected.
+				logrus.Debugf("Image GC skipped %s: %v", stringid.TruncateID(img.ID), err)
+				continue
+			}
+			collected++
+		}
+		// Collecting images may make their tagged parents candidates.
+		if collected == 0 {
+			return list, nil
+		}
+	}
+}
+
+// gcImage untags and deletes img.
+func (daemon *Daemon) gcImage(img *image.Image) ([]types.ImageDelete, error) {
+	if err := daemon.canDeleteImage(img.ID, false); err != nil {
+		return nil, err
+	}
+	list := []types.ImageDelete{}
+	for _, name := range daemon.Repositories().ByID()[img.ID] {
+		repoName, ref := parsers.ParseRepositoryTag(name)
+		deleted, err := daemon.Repositories().Delete(repoName, ref)
+		if err != nil {
+			return list, err
+		}
+		if deleted {
+			list = append(list, types.ImageDelete{Untagged: name})
+			daemon.EventsService.Log("untag", img.ID, "")
+		}
+	}
+	if err := daemon.imgDeleteHelper(img.ID, &list, true, false, false); err != nil {
+		return list, err
+	}
+	return list, nil
+}
+
+// usedImages returns the images used by containers, along with their parents.
+func (daemon *Daemon) usedImages() map[string]struct{} {
+	used := make(map[string]struct{})
+	for _, container := range daemon.List() {
+		for id := container.ImageID; id != ""; {
+			if _, ok := used[id]; ok {
+				break
+			}
+			used[id] = struct{}{}
+			img, err := daemon.Graph().Get(id)
+			if err != nil {
+				break
+			}
+			id = img.Parent
+		}
+	}
+	return used
+}
+
+// gcCandidates returns the images the image GC policy config does not keep,
+// oldest first. byID holds the tags and digests of the images and used the images
+// containers use. Only images without children are returned, as the parents of
+// an image are deleted along with it if nothing else uses them.
+func gcCandidates(images map[string]*image.Image, byParent map[string][]*image.Image, byID map[string][]string, used map[string]struct{}, config *imageGCConfig, now time.Time) []*image.Image {
+	repositories := make(map[string][]*image.Image)
+	for id, names := range byID {
+		img, ok := images[id]
+		if !ok {
+			continue
+		}
+		seen := make(map[string]struct{})
+		for _, name := range names {
+			// Digests pin images the same way tags do.
+			repoName, _ := parsers.ParseRepositoryTag(name)
+			if _, ok := seen[repoName]; !ok {
+				seen[repoName] = struct{}{}
+				repositories[repoName] = append(repositories[repoName], img)
+			}
+		}
+	}
+
+	kept := make(map[string]struct{})
+	for _, repoImages := range repositories {
+		sort.Sort(sort.Reverse(byCreated(repoImages)))
+		for i, img := range repoImages {
+			if config.KeepPerRepository < 0 || i < config.KeepPerRepository {
+				kept[img.ID] = struct{}{}
+			}
+		}
+	}
+
+	var candidates []*image.Image
+	for id, img := range images {
+		if _, ok := used[id]; ok {
+			continue
+		}
+		if _, ok := kept[id]; ok {
+			continue
+		}
+		if len(byParent[id]) > 0 || now.Sub(img.Created) < config.MinAge {
+			continue
+		}
+		candidates = append(candidates, img)
+	}
+	sort.Sort(byCreated(candidates))
+	return candidates
+}
+
+type byCreated []*image.Image
+
+func (r byCreated) Len() int           { return len(r) }
+func (r byCreated) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byCreated) Less(i, j int) bool { return r[i].Created.Before(r[j].Created) }
+
+// ImageGC collects the images the image GC policy of the daemon does not
+// keep, whatever the disk usage is.
+func (daemon *Daemon) ImageGC() ([]types.ImageDelete, error) {
+	return daemon.imageGC(-1)
+}
+
+// imageGCLoop collects images periodically when the disk usage of the
+// daemon root goes above the high watermark.
+func (daemon *Daemon) imageGCLoop() {
+	config := daemon.config.ImageGC
+	for range time.Tick(config.Interval) {
+		usage, err := diskUsage(daemon.root)
+		if err != nil {
+			logrus.Errorf("Image GC could not get the disk usage of %s: %v", daemon.root, err)
+			continue
+		}
+		if usage < config.HighWatermark {
+			continue
+		}
+		logrus.Infof("Disk usage of %s is %d%%, collecting images", daemon.root, usage)
+		list, err := daemon.imageGC(config.LowWatermark)
+		if err != nil {
+			logrus.Errorf("Image GC failed: %v", err)
+		}
+		logrus.Infof("Image GC untagged or deleted %d images", len(list))
+	}
+}
@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/image"
+)
+
+func TestImageGCConfigValidate(t *testing.T) {
+	valid := []imageGCConfig{
+		{},
+		{HighWatermark: 90, Interval: time.Minute},
+		{HighWatermark: 90, LowWatermark: 80, Interval: time.Minute},
+		{KeepPerRepository: 3, MinAge: time.Hour},
+	}
+	for _, config := range valid {
+		if err := config.validate(); err != nil {
+			t.Fatalf("Expected %+v to be valid: %v", config, err)
+		}
+		if config.LowWatermark > config.HighWatermark || (config.HighWatermark > 0 && config.LowWatermark == 0) {
+			t.Fatalf("Unexpected low watermark in %+v", config)
+		}
+	}
+
+	invalid := []imageGCConfig{
+		{HighWatermark: 101, Interval: time.Minute},
+		{HighWatermark: -1},
+		{HighWatermark: 80, LowWatermark: 90, Interval: time.Minute},
+		{HighWatermark: 90},
+		{MinAge: -time.Hour},
+	}
+	for _, config := range invalid {
+		if err := config.validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestGCCandidates(t *testing.T) {
+	now := time.Now()
+	images := make(map[string]*image.Image)
+	byParent := make(map[string][]*image.Image)
+	addImage := func(id, parent string, age time.Duration) {
+		img := &image.Image{ID: id, Parent: parent, Created: now.Add(-age)}
+		images[id] = img
+		if parent != "" {
+			byParent[parent] = append(byParent[parent], img)
+		}
+	}
+	addImage("base", "", 10*time.Hour)
+	addImage("app1", "base", 9*time.Hour)
+	addImage("app2", "base", 8*time.Hour)
+	addImage("app3", "base", 7*time.Hour)
+	addImage("dangling", "", 6*time.Hour)
+	addImage("recent", "", time.Minute)
+	addImage("used", "", 5*time.Hour)
+	addImage("pinned", "", 4*time.Hour)
+	byID := map[string][]string{
+		"base":   {"base:latest"},
+		"app1":   {"app:1"},
+		"app2":   {"app:2", "other:latest"},
+		"app3":   {"app:3", "app:latest", "app@sha256:4fe4b8ad2bbcf9e1bf44f2a4c7ac2e1a5b1cf0eb5b7b6e5ecd8d0e0d6f3f5b1a"},
+		"pinned": {"app@sha256:bc8813ea7b3603864987522f02a76101c17ad122e1c46d790efc0fca78ca7bfb"},
+	}
+	used := map[string]struct{}{"used": {}}
+
+	candidates := func(config imageGCConfig) []string {
+		var ids []string
+		for _, img := range gcCandidates(images, byParent, byID, used, &config, now) {
+			ids = append(ids, img.ID)
+		}
+		return ids
+	}
+	check := func(config imageGCConfig, expected ...string) {
+		ids := candidates(config)
+		if len(ids) != len(expected) {
+			t.Fatalf("Expected candidates %v with %+v, got %v", expected, config, ids)
+		}
+		for i := range ids {
+			if ids[i] != expected[i] {
+				t.Fatalf("Expected candidates %v with %+v, got %v", expected, config, ids)
+			}
+		}
+	}
+
+	// Tagged images are kept, and so are images only referred to by digest
+	check(imageGCConfig{KeepPerRepository: -1}, "dangling", "recent")
+	check(imageGCConfig{KeepPerRepository: -1, MinAge: time.Hour}, "dangling")
+	// The most recent images of each repository are kept, the image pinned
+	// by digest in app and app2 as it is the only image of other.
+	check(imageGCConfig{KeepPerRepository: 1, MinAge: time.Hour}, "app1", "app3", "dangling")
+	check(imageGCConfig{KeepPerRepository: 0, MinAge: time.Hour}, "app1", "app2", "app3", "dangling", "pinned")
+}
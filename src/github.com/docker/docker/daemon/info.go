@@ -8,6 +8,7 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/autogen/dockerversion"
+	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/pkg/fileutils"
 	"github.com/docker/docker/pkg/parsers/kernel"
 	"github.com/docker/docker/pkg/parsers/operatingsystem"
@@ -85,6 +86,18 @@ func (daemon *Daemon) SystemInfo() (*types.Info, error) {
 		ExperimentalBuild:  utils.ExperimentalBuild(),
 	}
 
+	if pool, ok := graphdriver.GetPoolDriver(daemon.GraphDriver()); ok {
+		s := pool.PoolStatus()
+		v.DriverPool = &types.PoolStatus{
+			Name:                    s.Name,
+			Data:                    types.PoolUsage(s.Data),
+			Metadata:                types.PoolUsage(s.Metadata),
+			MinFreeSpacePercent:     s.MinFreeSpacePercent,
+			WarningThresholdPercent: s.WarningThresholdPercent,
+			AutoExtend:              s.AutoExtend,
+		}
+	}
+
 	// TODO Windows. Refactor this more once sysinfo is refactored into
 	// platform specific code. On Windows, sysinfo.cgroupMemInfo and
 	// sysinfo.cgroupCpuInfo will be nil otherwise and cause a SIGSEGV if
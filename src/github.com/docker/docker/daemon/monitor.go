@@ -53,6 +53,11 @@ type containerMonitor struct {
 
 	// lastStartTime is the time which the monitor last exec'd the container's process
 	lastStartTime time.Time
+
+	// restore holds the options to restore the processes of the container
+	// from a checkpoint the first time it is started, instead of running
+	// its command. It is restarted from its command.
+	restore *execdriver.CheckpointOptions
 }
 
 // newContainerMonitor returns an initialized containerMonitor for the provided container
@@ -82,6 +87,17 @@ func (m *containerMonitor) ExitOnNext() {
 	m.mux.Unlock()
 }
 
+// resetExitOnNext undoes ExitOnNext when the container keeps running after
+// all, so that its restart policy applies again.
+func (m *containerMonitor) resetExitOnNext() {
+	m.mux.Lock()
+	if m.shouldStop {
+		m.shouldStop = false
+		m.stopChan = make(chan struct{})
+	}
+	m.mux.Unlock()
+}
+
 // Close closes the container's resources such as networking allocations and
 // unmounts the contatiner's root filesystem
 func (m *containerMonitor) Close() error {
@@ -134,11 +150,16 @@ func (m *containerMonitor) Start() error {
 
 		pipes := execdriver.NewPipes(m.container.stdin, m.container.stdout, m.container.stderr, m.container.Config.OpenStdin)
 
-		m.container.LogEvent("start")
-
 		m.lastStartTime = time.Now()
 
-		if exitStatus, err = m.container.daemon.Run(m.container, pipes, m.callback); err != nil {
+		if m.restore != nil && m.container.RestartCount == 0 {
+			m.container.LogEvent("restore")
+			exitStatus, err = m.container.daemon.Restore(m.container, pipes, m.callback, m.restore)
+		} else {
+			m.container.LogEvent("start")
+			exitStatus, err = m.container.daemon.Run(m.container, pipes, m.callback)
+		}
+		if err != nil {
 			// if we receive an internal error from the initial start of a container then lets
 			// return it instead of entering the restart loop
 			if m.container.RestartCount == 0 {
@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/docker/docker/runconfig"
+)
+
+func TestMonitorResetExitOnNext(t *testing.T) {
+	m := newContainerMonitor(&Container{}, runconfig.RestartPolicy{Name: "always"})
+	m.ExitOnNext()
+	if m.shouldRestart(0) {
+		t.Fatal("Container should not be restarted after ExitOnNext")
+	}
+	m.resetExitOnNext()
+	if !m.shouldRestart(0) {
+		t.Fatal("Container should be restarted by its policy after resetExitOnNext")
+	}
+	// The container can be stopped again
+	m.ExitOnNext()
+	select {
+	case <-m.stopChan:
+	default:
+		t.Fatal("Stop channel should be closed")
+	}
+}
@@ -29,3 +29,11 @@ type Settings struct {
 	SecondaryIPAddresses   []Address
 	SecondaryIPv6Addresses []Address
 }
+
+// IPAMState stores the addresses last assigned to a container on the default
+// bridge. Unlike Settings it is kept when the network is released, so the
+// container can ask for the same addresses the next time it is started.
+type IPAMState struct {
+	IPAddress         string `json:",omitempty"`
+	GlobalIPv6Address string `json:",omitempty"`
+}
@@ -22,6 +22,8 @@ type State struct {
 	Error             string // contains last known error when starting the container
 	StartedAt         time.Time
 	FinishedAt        time.Time
+	Checkpointed      bool // the processes of the container were checkpointed and can be restored
+	CheckpointedAt    time.Time
 	waitChan          chan struct{}
 }
 
@@ -56,6 +58,10 @@ func (s *State) String() string {
 		return "Created"
 	}
 
+	if s.Checkpointed {
+		return fmt.Sprintf("Checkpointed %s ago", units.HumanDuration(time.Now().UTC().Sub(s.CheckpointedAt)))
+	}
+
 	if s.FinishedAt.IsZero() {
 		return ""
 	}
@@ -83,6 +89,10 @@ func (s *State) StateString() string {
 		return "created"
 	}
 
+	if s.Checkpointed {
+		return "checkpointed"
+	}
+
 	return "exited"
 }
 
@@ -92,6 +102,7 @@ func isValidStateString(s string) bool {
 		s != "running" &&
 		s != "dead" &&
 		s != "created" &&
+		s != "checkpointed" &&
 		s != "exited" {
 		return false
 	}
@@ -182,6 +193,7 @@ func (s *State) setRunning(pid int) {
 	s.ExitCode = 0
 	s.Pid = pid
 	s.StartedAt = time.Now().UTC()
+	s.Checkpointed = false
 	close(s.waitChan) // fire waiters for start
 	s.waitChan = make(chan struct{})
 }
@@ -220,6 +232,12 @@ func (s *State) SetRestarting(exitStatus *execdriver.ExitStatus) {
 	s.Unlock()
 }
 
+// setCheckpointed marks the processes of the container as checkpointed.
+func (s *State) setCheckpointed() {
+	s.Checkpointed = true
+	s.CheckpointedAt = time.Now().UTC()
+}
+
 // setError sets the container's error state. This is useful when we want to
 // know the error that occurred when container transits to another state
 // when inspecting it
@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -102,3 +103,23 @@ func TestStateTimeoutWait(t *testing.T) {
 	}
 
 }
+
+func TestStateCheckpointed(t *testing.T) {
+	s := NewState()
+	s.SetRunning(100)
+	s.setCheckpointed()
+	if s.StateString() != "running" {
+		t.Fatalf("Expected a container left running to be running, got %s", s.StateString())
+	}
+	s.SetStopped(&execdriver.ExitStatus{ExitCode: 137})
+	if s.StateString() != "checkpointed" {
+		t.Fatalf("Expected state checkpointed, got %s", s.StateString())
+	}
+	if !strings.HasPrefix(s.String(), "Checkpointed") {
+		t.Fatalf("Expected status to start with Checkpointed, got %s", s.String())
+	}
+	s.SetRunning(101)
+	if s.Checkpointed {
+		t.Fatal("Expected a restarted container not to be checkpointed")
+	}
+}
@@ -23,6 +23,7 @@ func (a byName) Less(i, j int) bool { return a[i].name < a[j].name }
 var dockerCommands = []command{
 	{"attach", "Attach to a running container"},
 	{"build", "Build an image from a Dockerfile"},
+	{"checkpoint", "Checkpoint one or more running containers"},
 	{"commit", "Create a new image from a container's changes"},
 	{"cp", "Copy files/folders from a container to a HOSTDIR or to STDOUT"},
 	{"create", "Create a new container"},
@@ -47,14 +48,17 @@ var dockerCommands = []command{
 	{"push", "Push an image or a repository to a registry"},
 	{"rename", "Rename a container"},
 	{"restart", "Restart a running container"},
+	{"restore", "Restore one or more checkpointed containers"},
 	{"rm", "Remove one or more containers"},
 	{"rmi", "Remove one or more images"},
 	{"run", "Run a command in a new container"},
 	{"save", "Save an image(s) to a tar archive"},
 	{"search", "Search the Docker Hub for images"},
+	{"sign", "Sign an image with a local key"},
 	{"start", "Start one or more stopped containers"},
 	{"stats", "Display a live stream of container(s) resource usage statistics"},
 	{"stop", "Stop a running container"},
+	{"system", "Manage Docker"},
 	{"tag", "Tag an image into a repository"},
 	{"top", "Display the running processes of a container"},
 	{"unpause", "Unpause all processes within a container"},
@@ -0,0 +1,138 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/registry/api/v2"
+)
+
+// rangeRepository is a v2 repository whose blobs resume their downloads at
+// an offset with HTTP range requests, which the readers of the registry
+// client do not support.
+type rangeRepository struct {
+	distribution.Repository
+	client *http.Client
+	ub     *v2.URLBuilder
+}
+
+func (r *rangeRepository) Blobs(ctx context.Context) distribution.BlobStore {
+	return &rangeBlobStore{
+		BlobStore: r.Repository.Blobs(ctx),
+		client:    r.client,
+		ub:        r.ub,
+		name:      r.Name(),
+	}
+}
+
+type rangeBlobStore struct {
+	distribution.BlobStore
+	client *http.Client
+	ub     *v2.URLBuilder
+	name   string
+}
+
+func (bs *rangeBlobStore) Open(ctx context.Context, dgst digest.Digest) (distribution.ReadSeekCloser, error) {
+	desc, err := bs.Stat(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	blobURL, err := bs.ub.BuildBlobURL(bs.name, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{client: bs.client, url: blobURL, size: desc.Size}, nil
+}
+
+// rangeReader reads a blob of size bytes at url, requesting it from its
+// offset when it is first read after a seek.
+type rangeReader struct {
+	client *http.Client
+	url    string
+	size   int64
+	offset int64
+	rc     io.ReadCloser
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.rc == nil {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		rc, err := r.open()
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR:
+		offset += r.offset
+	case os.SEEK_END:
+		offset += r.size
+	default:
+		return r.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return r.offset, errors.New("cannot seek to negative position")
+	}
+	if offset != r.offset && r.rc != nil {
+		r.rc.Close()
+		r.rc = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *rangeReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+// open requests the blob from the current offset. When the registry ignores
+// the range and sends the whole blob, the bytes before the offset are skipped.
+func (r *rangeReader) open() (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if r.offset > 0 {
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, r.offset); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status fetching blob: %v", resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,57 @@
+package graph
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRangeReaderResumes(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	for _, ranges := range []bool{true, false} {
+		var requested []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.Header.Get("Range"))
+			if ranges {
+				http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(content))
+				return
+			}
+			w.Write(content)
+		}))
+
+		r := &rangeReader{client: http.DefaultClient, url: srv.URL, size: int64(len(content))}
+		if _, err := r.Seek(10, os.SEEK_SET); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "abcdef" {
+			t.Fatalf("Expected the blob from its offset, got %q (range support: %v)", b, ranges)
+		}
+		if len(requested) != 1 || requested[0] != "bytes=10-" {
+			t.Fatalf("Expected a range request from the offset, got %q", requested)
+		}
+	}
+}
+
+func TestRangeReaderSeekEnd(t *testing.T) {
+	r := &rangeReader{client: http.DefaultClient, url: "http://invalid.invalid/", size: 16}
+	if offset, err := r.Seek(0, os.SEEK_END); err != nil || offset != 16 {
+		t.Fatalf("Expected offset 16, got %d: %v", offset, err)
+	}
+	// Nothing is left to request at the end of the blob
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Fatalf("Expected EOF, got %d bytes: %v", n, err)
+	}
+	if _, err := r.Seek(-1, os.SEEK_SET); err == nil {
+		t.Fatal("Expected an error seeking to a negative position")
+	}
+}
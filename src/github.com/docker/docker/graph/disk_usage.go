@@ -0,0 +1,150 @@
+package graph
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/utils"
+)
+
+// imageLayer is a layer of an image, identified by its ID in the graph
+// driver, which images with the same layer share.
+type imageLayer struct {
+	cacheID string
+	size    int64
+}
+
+// imageLayersCache holds the layers of each image, from the top one down to
+// the base one, as long as the generation of the graph does not change.
+type imageLayersCache struct {
+	sync.Mutex
+	valid      bool
+	generation uint64
+	images     map[string]*image.Image
+	layers     map[string][]imageLayer
+}
+
+// imageLayers returns the images of the graph and their layers, and caches
+// them until an image is registered or deleted.
+func (s *TagStore) imageLayers() (map[string]*image.Image, map[string][]imageLayer) {
+	c := &s.layersCache
+	c.Lock()
+	defer c.Unlock()
+
+	generation := s.graph.Generation()
+	if c.valid && c.generation == generation {
+		return c.images, c.layers
+	}
+
+	images := s.graph.Map()
+	cacheIDs := make(map[string]string, len(images))
+	for id := range images {
+		cacheID, err := s.graph.GraphDriverID(id)
+		if err != nil {
+			logrus.Warnf("couldn't get the layer of %s: %s", id, err)
+			continue
+		}
+		cacheIDs[id] = cacheID
+	}
+
+	layers := make(map[string][]imageLayer, len(images))
+	for id, img := range images {
+		var chain []imageLayer
+		for img != nil {
+			if cacheID, ok := cacheIDs[img.ID]; ok {
+				chain = append(chain, imageLayer{cacheID: cacheID, size: img.Size})
+			}
+			img = images[img.Parent]
+		}
+		layers[id] = chain
+	}
+
+	c.valid = true
+	c.generation = generation
+	c.images = images
+	c.layers = layers
+	return images, layers
+}
+
+// ImagesDiskUsage returns the disk usage of the images listed by Images,
+// tagged images and images without children, and the size of all the layers
+// of the graph. The layers an image shares with other listed images are
+// counted in its SharedSize, the others in its UniqueSize.
+func (s *TagStore) ImagesDiskUsage() ([]*types.ImageDiskUsage, int64) {
+	images, layers := s.imageLayers()
+
+	listed := make(map[string]*types.ImageDiskUsage)
+	s.Lock()
+	for repoName, repository := range s.Repositories {
+		for ref, id := range repository {
+			if _, ok := images[id]; !ok {
+				continue
+			}
+			u, ok := listed[id]
+			if !ok {
+				u = &types.ImageDiskUsage{ID: id, RepoTags: []string{}}
+				listed[id] = u
+			}
+			if !utils.DigestReference(ref) {
+				u.RepoTags = append(u.RepoTags, utils.ImageReference(repoName, ref))
+			}
+		}
+	}
+	s.Unlock()
+	children := make(map[string]struct{})
+	for _, img := range images {
+		children[img.Parent] = struct{}{}
+	}
+	for id := range images {
+		if _, ok := children[id]; ok {
+			continue
+		}
+		if _, ok := listed[id]; !ok {
+			listed[id] = &types.ImageDiskUsage{ID: id, RepoTags: []string{"<none>:<none>"}}
+		}
+	}
+
+	var (
+		layersSize int64
+		refs       = make(map[string]int)
+		seen       = make(map[string]struct{})
+	)
+	for _, chain := range layers {
+		for _, l := range chain {
+			if _, ok := seen[l.cacheID]; !ok {
+				seen[l.cacheID] = struct{}{}
+				layersSize += l.size
+			}
+		}
+	}
+	for id := range listed {
+		for _, l := range layers[id] {
+			refs[l.cacheID]++
+		}
+	}
+
+	usage := make([]*types.ImageDiskUsage, 0, len(listed))
+	for id, u := range listed {
+		u.Created = int(images[id].Created.Unix())
+		for _, l := range layers[id] {
+			u.Size += l.size
+			if refs[l.cacheID] > 1 {
+				u.SharedSize += l.size
+			}
+		}
+		u.UniqueSize = u.Size - u.SharedSize
+		sort.Strings(u.RepoTags)
+		usage = append(usage, u)
+	}
+	sort.Sort(sort.Reverse(imagesDiskUsageByCreated(usage)))
+	return usage, layersSize
+}
+
+type imagesDiskUsageByCreated []*types.ImageDiskUsage
+
+func (r imagesDiskUsageByCreated) Len() int           { return len(r) }
+func (r imagesDiskUsageByCreated) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r imagesDiskUsageByCreated) Less(i, j int) bool { return r[i].Created < r[j].Created }
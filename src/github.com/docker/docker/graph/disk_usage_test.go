@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/utils"
+)
+
+func findImageDiskUsage(usage []*types.ImageDiskUsage, id string) *types.ImageDiskUsage {
+	for _, u := range usage {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+func TestImagesDiskUsage(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	// The official and private test images have the same layer.
+	usage, layersSize := store.ImagesDiskUsage()
+	if len(usage) != 2 {
+		t.Fatalf("Expected 2 images, got %d", len(usage))
+	}
+	if layersSize <= 0 {
+		t.Fatalf("Expected a positive layers size, got %d", layersSize)
+	}
+	for _, u := range usage {
+		if u.Size != layersSize || u.SharedSize != layersSize || u.UniqueSize != 0 {
+			t.Fatalf("Expected image %s to share its %d bytes, got size %d, shared %d, unique %d", u.ID, layersSize, u.Size, u.SharedSize, u.UniqueSize)
+		}
+	}
+	if u := findImageDiskUsage(usage, testOfficialImageID); u == nil || len(u.RepoTags) != 1 || u.RepoTags[0] != testOfficialImageName+":latest" {
+		t.Fatalf("Expected %s to be tagged %s:latest, got %v", testOfficialImageID, testOfficialImageName, u)
+	}
+
+	layerData, err := archive.Generate("child", "child content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := store.graph.Register(v1ImageDescriptor{&image.Image{Comment: "child", Parent: testOfficialImageID}}, layerData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, childLayersSize := store.ImagesDiskUsage()
+	if len(usage) != 3 {
+		t.Fatalf("Expected 3 images, got %d", len(usage))
+	}
+	if childLayersSize != layersSize+child.Size {
+		t.Fatalf("Expected layers size %d, got %d", layersSize+child.Size, childLayersSize)
+	}
+	u := findImageDiskUsage(usage, child.ID)
+	if u == nil {
+		t.Fatalf("Expected the untagged child image %s to be listed", child.ID)
+	}
+	if u.SharedSize != layersSize || u.UniqueSize != child.Size || len(u.RepoTags) != 1 || u.RepoTags[0] != "<none>:<none>" {
+		t.Fatalf("Expected the child image to share %d bytes and have %d unique ones, got %+v", layersSize, child.Size, u)
+	}
+
+	if err := store.graph.Delete(child.ID); err != nil {
+		t.Fatal(err)
+	}
+	usage, layersSize2 := store.ImagesDiskUsage()
+	if len(usage) != 2 || layersSize2 != layersSize {
+		t.Fatalf("Expected the usage to be updated after deleting the child image, got %d images and %d bytes", len(usage), layersSize2)
+	}
+}
@@ -0,0 +1,182 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/system"
+)
+
+const (
+	// DefaultMaxConcurrentDownloads is the default number of blobs the
+	// daemon downloads at the same time.
+	DefaultMaxConcurrentDownloads = 3
+
+	maxDownloadAttempts = 5
+	partialSuffix       = ".partial"
+	// partialDownloadTTL is how long partially downloaded blobs are kept
+	// across daemon restarts to resume their downloads.
+	partialDownloadTTL = 24 * time.Hour
+)
+
+// downloadFunc downloads a blob to f. If f holds the blob up to offset from
+// an interrupted download, the download resumes from there when the registry
+// supports it; otherwise downloadFunc truncates f and starts over. attempt
+// counts the attempts of the download from 1.
+type downloadFunc func(f *os.File, offset int64, attempt int) error
+
+// downloadManager downloads the blobs of pulls into a temporary store. A blob
+// is only downloaded once when several pulls need it at the same time, and
+// the number of concurrent downloads of the daemon is limited. Failed
+// downloads are retried from where they stopped.
+type downloadManager struct {
+	root  string
+	slots chan struct{}
+
+	sync.Mutex
+	downloads map[string]*blobDownload
+}
+
+// blobDownload is the download of a blob, shared by the pulls using it.
+type blobDownload struct {
+	// done is closed when the download finished.
+	done chan struct{}
+	err  error
+	// path is the file holding the blob once it is downloaded.
+	path string
+	refs int
+}
+
+func newDownloadManager(root string, maxConcurrent int) (*downloadManager, error) {
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxConcurrentDownloads
+	}
+	if err := system.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	// Blobs of pulls that were running when the daemon stopped are not
+	// used anymore, only keep recent partial downloads to resume them.
+	dir, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range dir {
+		if strings.HasSuffix(fi.Name(), partialSuffix) && time.Since(fi.ModTime()) < partialDownloadTTL {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, fi.Name())); err != nil {
+			return nil, err
+		}
+	}
+	return &downloadManager{
+		root:      root,
+		slots:     make(chan struct{}, maxConcurrent),
+		downloads: make(map[string]*blobDownload),
+	}, nil
+}
+
+// download returns the download of the blob key once it finished. The blob is
+// downloaded with fn unless another pull is downloading it already, in which
+// case waiting is called before waiting for that download. A pull waiting for
+// a download that fails downloads the blob itself. The caller must release
+// the download when it does not use the blob anymore.
+func (m *downloadManager) download(key string, fn downloadFunc, waiting func()) (*blobDownload, error) {
+	for retried := false; ; retried = true {
+		m.Lock()
+		d, shared := m.downloads[key]
+		if !shared {
+			d = &blobDownload{done: make(chan struct{})}
+			m.downloads[key] = d
+			go m.run(key, d, fn)
+		}
+		d.refs++
+		m.Unlock()
+
+		if shared && waiting != nil {
+			waiting()
+		}
+		<-d.done
+		if d.err == nil {
+			return d, nil
+		}
+		m.release(key, d)
+		if !shared || retried {
+			return nil, d.err
+		}
+		logrus.Debugf("Download of %s by another pull failed, downloading it: %v", key, d.err)
+	}
+}
+
+// release releases a download returned by download. The blob is removed once
+// no pull uses it anymore.
+func (m *downloadManager) release(key string, d *blobDownload) {
+	m.Lock()
+	defer m.Unlock()
+	d.refs--
+	if d.refs > 0 || m.downloads[key] != d {
+		return
+	}
+	delete(m.downloads, key)
+	if err := os.Remove(d.path); err != nil {
+		logrus.Errorf("Failed to remove downloaded blob %s: %v", d.path, err)
+	}
+}
+
+func (m *downloadManager) run(key string, d *blobDownload, fn downloadFunc) {
+	err := m.fetch(key, fn)
+
+	m.Lock()
+	if err != nil {
+		// A failed download is started over by the next pull of the blob.
+		delete(m.downloads, key)
+		d.err = err
+	} else {
+		d.path = m.path(key)
+	}
+	m.Unlock()
+	close(d.done)
+}
+
+// fetch downloads the blob key with fn, retrying failed attempts from where
+// they stopped.
+func (m *downloadManager) fetch(key string, fn downloadFunc) error {
+	partial := m.path(key) + partialSuffix
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for attempt := 1; ; attempt++ {
+		m.slots <- struct{}{}
+		offset, err := f.Seek(0, os.SEEK_END)
+		if err == nil {
+			if offset > 0 {
+				logrus.Debugf("Resuming download of %s at %d bytes", key, offset)
+			}
+			err = fn(f, offset, attempt)
+		}
+		<-m.slots
+		if err == nil {
+			break
+		}
+		if attempt == maxDownloadAttempts {
+			return err
+		}
+		logrus.Debugf("Error downloading %s (attempt %d): %v", key, attempt, err)
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+	}
+	return os.Rename(partial, m.path(key))
+}
+
+// path returns the file the blob key is stored at.
+func (m *downloadManager) path(key string) string {
+	dgst, _ := digest.FromBytes([]byte(key))
+	return filepath.Join(m.root, dgst.Hex())
+}
@@ -0,0 +1,156 @@
+package graph
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempDownloadManager(t *testing.T, maxConcurrent int) *downloadManager {
+	tmp, err := ioutil.TempDir("", "docker-downloads-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newDownloadManager(tmp, maxConcurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDownloadShared(t *testing.T) {
+	m := tempDownloadManager(t, 0)
+	defer os.RemoveAll(m.root)
+
+	var (
+		calls   int
+		started = make(chan struct{})
+		finish  = make(chan struct{})
+	)
+	fn := func(f *os.File, offset int64, attempt int) error {
+		calls++
+		close(started)
+		<-finish
+		_, err := f.WriteString("blob")
+		return err
+	}
+
+	first := make(chan *blobDownload)
+	go func() {
+		d, err := m.download("key", fn, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		first <- d
+	}()
+	<-started
+
+	waited := false
+	go func() {
+		// Wait for the second pull to find the download in progress
+		for {
+			m.Lock()
+			refs := m.downloads["key"].refs
+			m.Unlock()
+			if refs == 2 {
+				close(finish)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	d, err := m.download("key", fn, func() { waited = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != <-first {
+		t.Fatal("Pulls of the same blob should share the download")
+	}
+	if calls != 1 || !waited {
+		t.Fatalf("Expected one download and a waiting pull, got %d downloads", calls)
+	}
+	if content, err := ioutil.ReadFile(d.path); err != nil || string(content) != "blob" {
+		t.Fatalf("Unexpected downloaded blob %q: %v", content, err)
+	}
+
+	m.release("key", d)
+	if _, err := os.Stat(d.path); err != nil {
+		t.Fatal("Blob should be kept while a pull uses it")
+	}
+	m.release("key", d)
+	if _, err := os.Stat(d.path); !os.IsNotExist(err) {
+		t.Fatalf("Blob should be removed when no pull uses it: %v", err)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	m := tempDownloadManager(t, 0)
+	defer os.RemoveAll(m.root)
+
+	var offsets []int64
+	fn := func(f *os.File, offset int64, attempt int) error {
+		offsets = append(offsets, offset)
+		if offset == 0 {
+			if _, err := f.WriteString("bl"); err != nil {
+				return err
+			}
+			return errors.New("connection reset")
+		}
+		_, err := f.WriteString("ob")
+		return err
+	}
+	d, err := m.download("key", fn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.release("key", d)
+	if len(offsets) != 2 || offsets[1] != 2 {
+		t.Fatalf("Expected the download to resume at 2 bytes, got attempts at %v", offsets)
+	}
+	if content, err := ioutil.ReadFile(d.path); err != nil || string(content) != "blob" {
+		t.Fatalf("Unexpected downloaded blob %q: %v", content, err)
+	}
+}
+
+func TestDownloadMaxConcurrent(t *testing.T) {
+	m := tempDownloadManager(t, 2)
+	defer os.RemoveAll(m.root)
+
+	var (
+		mu              sync.Mutex
+		running, maxRun int
+		wg              sync.WaitGroup
+	)
+	fn := func(f *os.File, offset int64, attempt int) error {
+		mu.Lock()
+		running++
+		if running > maxRun {
+			maxRun = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			d, err := m.download(key, fn, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			m.release(key, d)
+		}(key)
+	}
+	wg.Wait()
+	if maxRun > 2 {
+		t.Fatalf("Expected at most 2 concurrent downloads, got %d", maxRun)
+	}
+}
@@ -2,12 +2,14 @@ package graph
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/parsers"
 	"github.com/docker/docker/registry"
@@ -18,9 +20,15 @@ import (
 // uncompressed tar ball.
 // name is the set of tags to export.
 // out is the writer where the images are written to.
+// Compression and CompressionLevel compress the layers in the tar ball.
+// Format is the layout of the tar ball, ExportFormatDocker by default.
 type ImageExportConfig struct {
 	Names     []string
 	Outstream io.Writer
+
+	Compression      archive.Compression
+	CompressionLevel int
+	Format           string
 }
 
 func (s *TagStore) ImageExport(imageExportConfig *ImageExportConfig) error {
@@ -32,6 +40,20 @@ func (s *TagStore) ImageExport(imageExportConfig *ImageExportConfig) error {
 	}
 	defer os.RemoveAll(tempdir)
 
+	// The images of an OCI image layout are exported once all of them are
+	// known, as they share blobs.
+	var ociImages []string
+	exportImage := func(name string) error {
+		switch imageExportConfig.Format {
+		case "", ExportFormatDocker:
+			return s.exportImage(name, tempdir, imageExportConfig)
+		case ExportFormatOCI:
+			ociImages = append(ociImages, name)
+			return nil
+		}
+		return fmt.Errorf("Unsupported image export format %s", imageExportConfig.Format)
+	}
+
 	rootRepoMap := map[string]Repository{}
 	addKey := func(name string, tag string, id string) {
 		logrus.Debugf("add key [%s:%s]", name, tag)
@@ -49,7 +71,7 @@ func (s *TagStore) ImageExport(imageExportConfig *ImageExportConfig) error {
 			// this is a base repo name, like 'busybox'
 			for tag, id := range rootRepo {
 				addKey(name, tag, id)
-				if err := s.exportImage(id, tempdir); err != nil {
+				if err := exportImage(id); err != nil {
 					return err
 				}
 			}
@@ -68,21 +90,25 @@ func (s *TagStore) ImageExport(imageExportConfig *ImageExportConfig) error {
 				if len(repoTag) > 0 {
 					addKey(repoName, repoTag, img.ID)
 				}
-				if err := s.exportImage(img.ID, tempdir); err != nil {
+				if err := exportImage(img.ID); err != nil {
 					return err
 				}
 
 			} else {
 				// this must be an ID that didn't get looked up just right?
-				if err := s.exportImage(name, tempdir); err != nil {
+				if err := exportImage(name); err != nil {
 					return err
 				}
 			}
 		}
 		logrus.Debugf("End Serializing %s", name)
 	}
-	// write repositories, if there is something to write
-	if len(rootRepoMap) > 0 {
+	if imageExportConfig.Format == ExportFormatOCI {
+		if err := s.exportOCILayout(tempdir, ociImages, rootRepoMap, imageExportConfig); err != nil {
+			return err
+		}
+	} else if len(rootRepoMap) > 0 {
+		// write repositories, if there is something to write
 		f, err := os.OpenFile(filepath.Join(tempdir, "repositories"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 		if err != nil {
 			f.Close()
@@ -112,7 +138,7 @@ func (s *TagStore) ImageExport(imageExportConfig *ImageExportConfig) error {
 }
 
 // FIXME: this should be a top-level function, not a class method
-func (s *TagStore) exportImage(name, tempdir string) error {
+func (s *TagStore) exportImage(name, tempdir string, config *ImageExportConfig) error {
 	for n := name; n != ""; {
 		img, err := s.LookupImage(n)
 
@@ -132,7 +158,7 @@ func (s *TagStore) exportImage(name, tempdir string) error {
 			return err
 		}
 
-		imageInspectRaw, err := json.Marshal(img)
+		imageInspectRaw, err := s.exportImageJSON(img)
 		if err != nil {
 			return err
 		}
@@ -151,12 +177,30 @@ func (s *TagStore) exportImage(name, tempdir string) error {
 			logrus.Warnf("%d byes should have been written instead %d have been written", written, len(imageInspectRaw))
 		}
 
-		// serialize filesystem
+		// serialize the detached signatures
+		if err := s.exportSignatures(img.ID, tmpImageDir); err != nil {
+			return err
+		}
+
+		// serialize filesystem, keeping the name of the layer when it is
+		// compressed as load detects the compression
 		fsTar, err := os.Create(filepath.Join(tmpImageDir, "layer.tar"))
 		if err != nil {
 			return err
 		}
-		if err := s.ImageTarLayer(n, fsTar); err != nil {
+		layer, err := archive.CompressStreamLevel(fsTar, config.Compression, config.CompressionLevel)
+		if err != nil {
+			fsTar.Close()
+			return err
+		}
+		err = s.ImageTarLayer(n, layer)
+		if closeErr := layer.Close(); err == nil {
+			err = closeErr
+		}
+		if closeErr := fsTar.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
 			return err
 		}
 
@@ -164,3 +208,47 @@ func (s *TagStore) exportImage(name, tempdir string) error {
 	}
 	return nil
 }
+
+// exportSignatures writes the detached signatures of the image id to dir, as
+// a JSON array.
+func (s *TagStore) exportSignatures(id, dir string) error {
+	signatures, err := s.graph.Signatures(id)
+	if err != nil || len(signatures) == 0 {
+		return err
+	}
+	var list []json.RawMessage
+	for _, signature := range signatures {
+		list = append(list, signature)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "signatures"), data, os.FileMode(0644))
+}
+
+// exportImageJSON returns the config of img with the ID of the image and its
+// parent added, so that the image is loaded with the same ID.
+func (s *TagStore) exportImageJSON(img *image.Image) ([]byte, error) {
+	config, err := s.graph.RawJSON(img.ID)
+	if err != nil {
+		return nil, err
+	}
+	var c map[string]*json.RawMessage
+	if err := json.Unmarshal(config, &c); err != nil {
+		return nil, err
+	}
+	id, err := json.Marshal(img.ID)
+	if err != nil {
+		return nil, err
+	}
+	c["id"] = (*json.RawMessage)(&id)
+	if img.Parent != "" {
+		parent, err := json.Marshal(img.Parent)
+		if err != nil {
+			return nil, err
+		}
+		c["parent"] = (*json.RawMessage)(&parent)
+	}
+	return json.Marshal(c)
+}
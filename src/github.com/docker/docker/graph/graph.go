@@ -22,26 +22,23 @@ import (
 	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
 	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/pkg/system"
+	"github.com/docker/docker/pkg/tarindex"
 	"github.com/docker/docker/pkg/truncindex"
 	"github.com/docker/docker/runconfig"
 	"github.com/vbatts/tar-split/tar/asm"
 	"github.com/vbatts/tar-split/tar/storage"
 )
 
-// v1ImageDescriptor is a non-content-addressable image descriptor
+// v1ImageDescriptor registers an image from the image structure.
 type v1ImageDescriptor struct {
 	img *image.Image
 }
 
-// ID returns the image ID specified in the image structure.
-func (img v1ImageDescriptor) ID() string {
-	return img.img.ID
-}
-
 // Parent returns the parent ID specified in the image structure.
 func (img v1ImageDescriptor) Parent() string {
 	return img.img.Parent
@@ -52,6 +49,24 @@ func (img v1ImageDescriptor) MarshalConfig() ([]byte, error) {
 	return json.Marshal(img.img)
 }
 
+// v1CompatibilityDescriptor registers an image from its JSON as found in a
+// registry or an image tarball. The IDs in the JSON are ignored, the image is
+// registered on top of parent, which must already be in the graph.
+type v1CompatibilityDescriptor struct {
+	parent          string
+	v1Compatibility []byte
+}
+
+// Parent returns the ID of the image in the graph the image is registered on.
+func (img v1CompatibilityDescriptor) Parent() string {
+	return img.parent
+}
+
+// MarshalConfig returns the JSON of the image.
+func (img v1CompatibilityDescriptor) MarshalConfig() ([]byte, error) {
+	return img.v1Compatibility, nil
+}
+
 // The type is used to protect pulling or building related image
 // layers from deleteing when filtered by dangling=true
 // The key of layers is the images ID which is pulling or building
@@ -96,22 +111,59 @@ func (r *retainedLayers) Exists(layerID string) bool {
 }
 
 // A Graph is a store for versioned filesystem images and the relationship between them.
+//
+// Images are identified by the digest of their config, and their layers by
+// the chain ID of the layer and all the layers below it, so the same content
+// always ends up with the same IDs. Layers are stored in the graph driver
+// under a random ID, and shared by all the images with the same chain ID.
 type Graph struct {
 	root       string
 	idIndex    *truncindex.TruncIndex
 	driver     graphdriver.Driver
 	imageMutex imageMutex // protect images in driver.
 	retained   *retainedLayers
+	// storeMutex protects the layer references while images are
+	// registered and deleted.
+	storeMutex sync.Mutex
+	// layerRefs counts the images using each layer, keyed by chain ID.
+	layerRefs map[digest.Digest]int
+	// migratedLayers are the layers of the graph driver kept by a
+	// migration for the layers and containers on top of them, keyed by
+	// cache ID. It is protected by storeMutex.
+	migratedLayers map[string]*migratedLayer
+	// generation is incremented each time an image is registered or
+	// deleted, so what is computed from the images can be cached.
+	generation uint64
+	// lazyMutex protects lazyLayers, the lazily pulled layers whose blobs
+	// are mounted, keyed by cache ID.
+	lazyMutex  sync.Mutex
+	lazyLayers map[string]*lazyLayer
 }
 
-// file names for ./graph/<ID>/
+// directory names in the graph root
+const (
+	imagesDirName   = "imagedb"
+	layersDirName   = "layerdb"
+	v1IDsDirName    = "v1ids"
+	diffIDsDirName  = "diffids"
+	migratedDirName = "migrated"
+)
+
+// file names for ./imagedb/<ID>/
 const (
 	jsonFileName            = "json"
-	layersizeFileName       = "layersize"
+	layerFileName           = "layer"
 	digestFileName          = "checksum"
-	tarDataFileName         = "tar-data.json.gz"
 	v1CompatibilityFileName = "v1Compatibility"
-	parentFileName          = "parent"
+	signaturesFileName      = "signatures"
+)
+
+// file names for ./layerdb/<chain ID>/
+const (
+	cacheIDFileName   = "cache-id"
+	diffIDFileName    = "diff"
+	layersizeFileName = "layersize"
+	tarDataFileName   = "tar-data.json.gz"
 )
 
 var (
@@ -129,19 +181,32 @@ func NewGraph(root string, driver graphdriver.Driver) (*Graph, error) {
 		return nil, err
 	}
 	// Create the root directory if it doesn't exists
-	if err := system.MkdirAll(root, 0700); err != nil && !os.IsExist(err) {
-		return nil, err
+	for _, dir := range []string{imagesDirName, layersDirName, v1IDsDirName, diffIDsDirName, migratedDirName} {
+		if err := system.MkdirAll(filepath.Join(root, dir), 0700); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
 	}
 
 	graph := &Graph{
-		root:     abspath,
-		idIndex:  truncindex.NewTruncIndex([]string{}),
-		driver:   driver,
-		retained: &retainedLayers{layerHolders: make(map[string]map[string]struct{})},
+		root:      abspath,
+		idIndex:   truncindex.NewTruncIndex([]string{}),
+		driver:    driver,
+		retained:  &retainedLayers{layerHolders: make(map[string]map[string]struct{})},
+		layerRefs: make(map[digest.Digest]int),
+
+		migratedLayers: make(map[string]*migratedLayer),
+
+		lazyLayers: make(map[string]*lazyLayer),
 	}
 	if err := graph.restore(); err != nil {
 		return nil, err
 	}
+	if err := graph.restoreMigratedLayers(); err != nil {
+		return nil, err
+	}
+	if err := graph.restoreLazyLayers(); err != nil {
+		return nil, err
+	}
 	return graph, nil
 }
 
@@ -151,16 +216,23 @@ func (graph *Graph) IsHeld(layerID string) bool {
 }
 
 func (graph *Graph) restore() error {
-	dir, err := ioutil.ReadDir(graph.root)
+	dir, err := ioutil.ReadDir(filepath.Join(graph.root, imagesDirName))
 	if err != nil {
 		return err
 	}
 	var ids = []string{}
 	for _, v := range dir {
 		id := v.Name()
-		if graph.driver.Exists(id) {
-			ids = append(ids, id)
+		chainID, err := graph.getImageLayer(id)
+		if err != nil {
+			logrus.Debugf("Skipping image %s without a layer: %v", id, err)
+			continue
 		}
+		if !graph.layerExists(chainID) {
+			continue
+		}
+		ids = append(ids, id)
+		graph.layerRefs[chainID]++
 	}
 
 	baseIds, err := graph.restoreBaseImages()
@@ -199,28 +271,47 @@ func (graph *Graph) Get(name string) (*image.Image, error) {
 	if err != nil {
 		return nil, err
 	}
-	if img.ID != id {
-		return nil, fmt.Errorf("Image stored at '%s' has wrong id '%s'", id, img.ID)
-	}
 
 	if img.Size < 0 {
-		size, err := graph.driver.DiffSize(img.ID, img.Parent)
+		cacheID, parentCacheID, err := graph.driverIDs(img)
+		if err != nil {
+			return nil, err
+		}
+		size, err := graph.driver.DiffSize(cacheID, parentCacheID)
 		if err != nil {
 			return nil, fmt.Errorf("unable to calculate size of image id %q: %s", img.ID, err)
 		}
 
 		img.Size = size
-		if err := graph.saveSize(graph.imageRoot(id), int(img.Size)); err != nil {
+		chainID, err := graph.getImageLayer(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := graph.saveSize(graph.layerRoot(chainID), int(img.Size)); err != nil {
 			return nil, err
 		}
 	}
 	return img, nil
 }
 
+// GetByV1ID returns the image that was registered with the given v1 ID, the
+// ID of an image in a registry that is not content addressable or in an
+// image tarball. The v1 ID of an image created by the daemon is its ID.
+func (graph *Graph) GetByV1ID(v1ID string) (*image.Image, error) {
+	if id, err := ioutil.ReadFile(filepath.Join(graph.root, v1IDsDirName, v1ID)); err == nil {
+		if img, err := graph.Get(string(id)); err == nil {
+			return img, nil
+		}
+	}
+	if err := image.ValidateID(v1ID); err != nil {
+		return nil, err
+	}
+	return graph.Get(v1ID)
+}
+
 // Create creates a new image and registers it in the graph.
 func (graph *Graph) Create(layerData archive.ArchiveReader, containerID, containerImage, comment, author string, containerConfig, config *runconfig.Config) (*image.Image, error) {
 	img := &image.Image{
-		ID:            stringid.GenerateRandomID(),
 		Comment:       comment,
 		Created:       time.Now().UTC(),
 		DockerVersion: dockerversion.VERSION,
@@ -228,44 +319,93 @@ func (graph *Graph) Create(layerData archive.ArchiveReader, containerID, contain
 		Config:        config,
 		Architecture:  runtime.GOARCH,
 		OS:            runtime.GOOS,
+		Parent:        containerImage,
 	}
 
 	if containerID != "" {
-		img.Parent = containerImage
 		img.Container = containerID
 		img.ContainerConfig = *containerConfig
 	}
 
-	if err := graph.Register(v1ImageDescriptor{img}, layerData); err != nil {
+	return graph.Register(v1ImageDescriptor{img}, layerData)
+}
+
+// Register imports a pre-existing image into the graph and returns it. The
+// ID of the image is the digest of its config, which includes the diff ID of
+// the layer and the ID of the parent. If the image is already registered, the
+// existing image is returned.
+func (graph *Graph) Register(im image.ImageDescriptor, layerData archive.ArchiveReader) (*image.Image, error) {
+	parent, err := graph.getParentImage(im)
+	if err != nil {
 		return nil, err
 	}
-	return img, nil
+	l, err := graph.createLayer(parent, layerData)
+	if err != nil {
+		return nil, err
+	}
+	return graph.register(im, parent, l)
 }
 
-// Register imports a pre-existing image into the graph.
-// Returns nil if the image is already registered.
-func (graph *Graph) Register(im image.ImageDescriptor, layerData archive.ArchiveReader) (err error) {
-	imgID := im.ID()
-
-	if err := image.ValidateID(imgID); err != nil {
-		return err
+// registerOnLayer registers an image whose layer is already in the graph.
+func (graph *Graph) registerOnLayer(im image.ImageDescriptor, chainID digest.Digest) (*image.Image, error) {
+	parent, err := graph.getParentImage(im)
+	if err != nil {
+		return nil, err
 	}
+	l, err := graph.getLayer(chainID)
+	if err != nil {
+		return nil, err
+	}
+	return graph.register(im, parent, l)
+}
 
-	// We need this entire operation to be atomic within the engine. Note that
-	// this doesn't mean Register is fully safe yet.
-	graph.imageMutex.Lock(imgID)
-	defer graph.imageMutex.Unlock(imgID)
+func (graph *Graph) getParentImage(im image.ImageDescriptor) (*image.Image, error) {
+	if im.Parent() == "" {
+		return nil, nil
+	}
+	parent, err := graph.Get(im.Parent())
+	if err != nil {
+		return nil, fmt.Errorf("Could not find parent image %s: %v", im.Parent(), err)
+	}
+	return parent, nil
+}
 
-	return graph.register(im, layerData)
+// rootLayer is a layer of an image being registered.
+type rootLayer struct {
+	chainID digest.Digest
+	diffID  digest.Digest
+	// cacheID is the ID of the layer in the graph driver.
+	cacheID string
+	size    int64
+	// root is the temporary directory holding the metadata of a layer that
+	// is not in the graph yet, or empty for a layer already in the graph.
+	root string
+	// keep is set for layers that are not removed from the graph if the
+	// image using them cannot be registered, e.g. migrated layers.
+	keep bool
 }
 
-func (graph *Graph) register(im image.ImageDescriptor, layerData archive.ArchiveReader) (err error) {
-	imgID := im.ID()
+// createLayer applies layerData to a new layer on top of the layer of parent.
+// The layer is added to the graph when an image using it is registered.
+func (graph *Graph) createLayer(parent *image.Image, layerData archive.ArchiveReader) (l *rootLayer, err error) {
+	var (
+		parentChainID digest.Digest
+		parentCacheID string
+	)
+	if parent != nil {
+		if parentChainID, err = graph.getImageLayer(parent.ID); err != nil {
+			return nil, err
+		}
+		if parentCacheID, err = graph.getCacheID(parentChainID); err != nil {
+			return nil, err
+		}
+	}
 
-	// Skip register if image is already registered
-	if graph.Exists(imgID) {
-		return nil
+	tmp, err := graph.mktemp("")
+	if err != nil {
+		return nil, fmt.Errorf("mktemp failed: %s", err)
 	}
+	cacheID := stringid.GenerateRandomID()
 
 	// The returned `error` must be named in this function's signature so that
 	// `err` is not shadowed in this deferred cleanup.
@@ -273,62 +413,183 @@ func (graph *Graph) register(im image.ImageDescriptor, layerData archive.Archive
 		// If any error occurs, remove the new dir from the driver.
 		// Don't check for errors since the dir might not have been created.
 		if err != nil {
-			graph.driver.Remove(imgID)
+			graph.driver.Remove(cacheID)
+			os.RemoveAll(tmp)
 		}
 	}()
 
-	// Ensure that the image root does not exist on the filesystem
-	// when it is not registered in the graph.
-	// This is common when you switch from one graph driver to another
-	if err := os.RemoveAll(graph.imageRoot(imgID)); err != nil && !os.IsNotExist(err) {
-		return err
+	// Create root filesystem in the driver
+	if err := createRootFilesystemInDriver(graph, cacheID, parentCacheID, layerData); err != nil {
+		return nil, err
+	}
+	var (
+		size   int64
+		diffID = image.EmptyLayerDiffID
+	)
+	// Apply the diff/layer
+	if layerData != nil {
+		if size, diffID, err = graph.storeLayer(cacheID, parentCacheID, layerData, tmp); err != nil {
+			return nil, err
+		}
+	}
+	return &rootLayer{
+		chainID: image.ChainID(parentChainID, diffID),
+		diffID:  diffID,
+		cacheID: cacheID,
+		size:    size,
+		root:    tmp,
+	}, nil
+}
+
+// getLayer returns the layer with the given chain ID.
+func (graph *Graph) getLayer(chainID digest.Digest) (*rootLayer, error) {
+	root := graph.layerRoot(chainID)
+	cacheID, err := graph.getCacheID(chainID)
+	if err != nil {
+		return nil, err
+	}
+	diffID, err := ioutil.ReadFile(filepath.Join(root, diffIDFileName))
+	if err != nil {
+		return nil, err
+	}
+	l := &rootLayer{
+		chainID: chainID,
+		cacheID: cacheID,
+		size:    -1,
 	}
+	if l.diffID, err = digest.ParseDigest(string(diffID)); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
 
-	// If the driver has this ID but the graph doesn't, remove it from the driver to start fresh.
-	// (the graph is the source of truth).
-	// Ignore errors, since we don't know if the driver correctly returns ErrNotExist.
-	// (FIXME: make that mandatory for drivers).
-	graph.driver.Remove(imgID)
+// register stores the config of an image using layer l, and the layer itself
+// if it is not in the graph yet. A new layer is dropped if another image
+// added a layer with the same chain ID in the meantime.
+func (graph *Graph) register(im image.ImageDescriptor, parent *image.Image, l *rootLayer) (img *image.Image, err error) {
+	graph.storeMutex.Lock()
+	defer graph.storeMutex.Unlock()
+
+	if l.root != "" {
+		defer os.RemoveAll(l.root)
+		if graph.layerExists(l.chainID) {
+			logrus.Debugf("Layer %s is already in the graph", l.chainID)
+			if !l.keep {
+				graph.removeDriverLayer(l.cacheID)
+			}
+		} else if err := graph.storeLayerMetadata(l); err != nil {
+			if !l.keep {
+				graph.removeDriverLayer(l.cacheID)
+			}
+			return nil, err
+		}
+	} else if !graph.layerExists(l.chainID) {
+		return nil, fmt.Errorf("layer %s does not exist", l.chainID)
+	}
+	defer func() {
+		// Remove a layer that was added for an image that could not be
+		// registered.
+		if err != nil && !l.keep && graph.layerRefs[l.chainID] == 0 {
+			graph.removeLayer(l.chainID)
+		}
+	}()
+
+	var parentID digest.Digest
+	if parent != nil {
+		if _, err := os.Stat(graph.imageRoot(parent.ID)); err != nil {
+			return nil, fmt.Errorf("Could not find parent image %s: %v", parent.ID, err)
+		}
+		parentID = digest.NewDigestFromHex(string(digest.Canonical), parent.ID)
+	}
+
+	v1Config, err := im.MarshalConfig()
+	if err != nil {
+		return nil, err
+	}
+	config, err := image.MakeImageConfig(v1Config, l.diffID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	dgst, err := image.StrongID(config)
+	if err != nil {
+		return nil, err
+	}
+	imgID := dgst.Hex()
+
+	// Skip register if image is already registered
+	if _, err := os.Stat(graph.imageRoot(imgID)); err == nil {
+		return graph.Get(imgID)
+	}
 
 	tmp, err := graph.mktemp("")
 	defer os.RemoveAll(tmp)
 	if err != nil {
-		return fmt.Errorf("mktemp failed: %s", err)
+		return nil, fmt.Errorf("mktemp failed: %s", err)
+	}
+	if err := ioutil.WriteFile(jsonPath(tmp), config, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, layerFileName), []byte(l.chainID), 0600); err != nil {
+		return nil, err
 	}
+	// Commit
+	if err := os.Rename(tmp, graph.imageRoot(imgID)); err != nil {
+		return nil, err
+	}
+	graph.idIndex.Add(imgID)
+	graph.layerRefs[l.chainID]++
+	graph.generation++
 
-	parent := im.Parent()
+	return graph.Get(imgID)
+}
 
-	// Create root filesystem in the driver
-	if err := createRootFilesystemInDriver(graph, imgID, parent, layerData); err != nil {
+// storeLayerMetadata moves the metadata of a new layer into the graph. It must
+// be called with the store locked.
+func (graph *Graph) storeLayerMetadata(l *rootLayer) error {
+	if err := ioutil.WriteFile(filepath.Join(l.root, cacheIDFileName), []byte(l.cacheID), 0600); err != nil {
 		return err
 	}
-
-	// Apply the diff/layer
-	config, err := im.MarshalConfig()
-	if err != nil {
+	if err := ioutil.WriteFile(filepath.Join(l.root, diffIDFileName), []byte(l.diffID), 0600); err != nil {
 		return err
 	}
-	if err := graph.storeImage(imgID, parent, config, layerData, tmp); err != nil {
-		return err
+	if l.size >= 0 {
+		if err := graph.saveSize(l.root, int(l.size)); err != nil {
+			return err
+		}
 	}
-	// Commit
-	if err := os.Rename(tmp, graph.imageRoot(imgID)); err != nil {
+	root := graph.layerRoot(l.chainID)
+	// Ensure that the layer root does not exist on the filesystem when the
+	// layer is not in the graph, e.g. after switching graph drivers.
+	if err := os.RemoveAll(root); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	graph.idIndex.Add(imgID)
-	return nil
+	return os.Rename(l.root, root)
+}
+
+// removeLayer removes a layer no image uses anymore from the graph and the
+// graph driver. It must be called with the store locked.
+func (graph *Graph) removeLayer(chainID digest.Digest) {
+	delete(graph.layerRefs, chainID)
+	if cacheID, err := graph.getCacheID(chainID); err == nil {
+		graph.removeDriverLayer(cacheID)
+	}
+	if err := os.RemoveAll(graph.layerRoot(chainID)); err != nil {
+		logrus.Errorf("Failed to remove layer %s: %v", chainID, err)
+	}
+	graph.releaseMigratedLayers(chainID.String())
 }
 
 func createRootFilesystemInDriver(graph *Graph, id, parent string, layerData archive.ArchiveReader) error {
-	if err := graph.driver.Create(id, parent); err != nil {
+	if err := graph.driver.Create(id, parent, nil); err != nil {
 		return fmt.Errorf("Driver %s failed to create image rootfs %s: %s", graph.driver, id, err)
 	}
 	return nil
 }
 
 // TempLayerArchive creates a temporary archive of the given image's filesystem layer.
-//   The archive is stored on disk and will be automatically deleted as soon as has been read.
-//   If output is not nil, a human-readable progress bar will be written to it.
+//
+//	The archive is stored on disk and will be automatically deleted as soon as has been read.
+//	If output is not nil, a human-readable progress bar will be written to it.
 func (graph *Graph) TempLayerArchive(id string, sf *streamformatter.StreamFormatter, output io.Writer) (*archive.TempArchive, error) {
 	image, err := graph.Get(id)
 	if err != nil {
@@ -372,13 +633,18 @@ func (graph *Graph) newTempFile() (*os.File, error) {
 	return ioutil.TempFile(tmp, "")
 }
 
-func bufferToFile(f *os.File, src io.Reader) (int64, digest.Digest, error) {
-	var (
-		h = sha256.New()
-		w = gzip.NewWriter(io.MultiWriter(f, h))
-	)
-	_, err := io.Copy(w, src)
-	w.Close()
+// bufferToFile compresses src to f, and returns the size and digest of the
+// compressed data.
+func bufferToFile(f *os.File, src io.Reader, compression archive.Compression, level int) (int64, digest.Digest, error) {
+	h := sha256.New()
+	w, err := archive.CompressStreamLevel(ioutils.NopWriteCloser(io.MultiWriter(f, h)), compression, level)
+	if err != nil {
+		return 0, "", err
+	}
+	_, err = io.Copy(w, src)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return 0, "", err
 	}
@@ -392,14 +658,40 @@ func bufferToFile(f *os.File, src io.Reader) (int64, digest.Digest, error) {
 	return n, digest.NewDigest("sha256", h), nil
 }
 
-// Delete atomically removes an image from the graph.
+// bufferIndexedToFile compresses src to f with gzip, indexing its entries so
+// that it can be pulled lazily, and returns the size and digest of the
+// compressed data, and the digest of its index.
+func bufferIndexedToFile(f *os.File, src io.Reader, level int) (int64, digest.Digest, digest.Digest, error) {
+	h := sha256.New()
+	indexDigest, err := tarindex.Compress(io.MultiWriter(f, h), src, level, tarindex.DefaultChunkSize)
+	if err != nil {
+		return 0, "", "", err
+	}
+	n, err := f.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return 0, "", "", err
+	}
+	return n, digest.NewDigest("sha256", h), indexDigest, nil
+}
+
+// Delete atomically removes an image from the graph. Its layer is removed
+// when no other image uses it.
 func (graph *Graph) Delete(name string) error {
 	id, err := graph.idIndex.Get(name)
 	if err != nil {
 		return err
 	}
+
+	graph.storeMutex.Lock()
+	defer graph.storeMutex.Unlock()
+
+	chainID, layerErr := graph.getImageLayer(id)
 	tmp, err := graph.mktemp("")
 	graph.idIndex.Delete(id)
+	graph.generation++
 	if err == nil {
 		if err := os.Rename(graph.imageRoot(id), tmp); err != nil {
 			// On err make tmp point to old dir and cleanup unused tmp dir
@@ -411,11 +703,23 @@ func (graph *Graph) Delete(name string) error {
 		tmp = graph.imageRoot(id)
 	}
 	// Remove rootfs data from the driver
-	graph.driver.Remove(id)
+	if layerErr == nil {
+		if graph.layerRefs[chainID]--; graph.layerRefs[chainID] <= 0 {
+			graph.removeLayer(chainID)
+		}
+	}
 	// Remove the trashed image directory
 	return os.RemoveAll(tmp)
 }
 
+// Generation returns a number that changes each time an image is registered
+// in or deleted from the graph.
+func (graph *Graph) Generation() uint64 {
+	graph.storeMutex.Lock()
+	defer graph.storeMutex.Unlock()
+	return graph.generation
+}
+
 // Map returns a list of all images in the graph, addressable by ID.
 func (graph *Graph) Map() map[string]*image.Image {
 	images := make(map[string]*image.Image)
@@ -484,47 +788,95 @@ func (graph *Graph) Heads() map[string]*image.Image {
 }
 
 func (graph *Graph) imageRoot(id string) string {
-	return filepath.Join(graph.root, id)
+	return filepath.Join(graph.root, imagesDirName, id)
+}
+
+func (graph *Graph) layerRoot(chainID digest.Digest) string {
+	return filepath.Join(graph.root, layersDirName, chainID.Hex())
+}
+
+// getImageLayer returns the chain ID of the layer of an image.
+func (graph *Graph) getImageLayer(id string) (digest.Digest, error) {
+	chainID, err := ioutil.ReadFile(filepath.Join(graph.imageRoot(id), layerFileName))
+	if err != nil {
+		return "", err
+	}
+	return digest.ParseDigest(string(chainID))
+}
+
+// getCacheID returns the ID of a layer in the graph driver.
+func (graph *Graph) getCacheID(chainID digest.Digest) (string, error) {
+	cacheID, err := ioutil.ReadFile(filepath.Join(graph.layerRoot(chainID), cacheIDFileName))
+	if err != nil {
+		return "", err
+	}
+	return string(cacheID), nil
+}
+
+// layerExists returns true if the layer is in the graph and the graph driver.
+func (graph *Graph) layerExists(chainID digest.Digest) bool {
+	cacheID, err := graph.getCacheID(chainID)
+	return err == nil && graph.driver.Exists(cacheID)
+}
+
+// driverIDs returns the IDs of the layer of an image and of the layer of its
+// parent in the graph driver.
+func (graph *Graph) driverIDs(img *image.Image) (string, string, error) {
+	cacheID, err := graph.GraphDriverID(img.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if img.Parent == "" {
+		return cacheID, "", nil
+	}
+	parentCacheID, err := graph.GraphDriverID(img.Parent)
+	if err != nil {
+		return "", "", err
+	}
+	return cacheID, parentCacheID, nil
+}
+
+// GraphDriverID returns the ID the graph driver stores the layer of an image
+// under, to create the layers of containers on top of it.
+func (graph *Graph) GraphDriverID(id string) (string, error) {
+	chainID, err := graph.getImageLayer(id)
+	if err != nil {
+		return "", err
+	}
+	return graph.getCacheID(chainID)
 }
 
 // loadImage fetches the image with the given id from the graph.
 func (graph *Graph) loadImage(id string) (*image.Image, error) {
 	root := graph.imageRoot(id)
 
-	// Open the JSON file to decode by streaming
-	jsonSource, err := os.Open(jsonPath(root))
+	config, err := ioutil.ReadFile(jsonPath(root))
 	if err != nil {
 		return nil, err
 	}
-	defer jsonSource.Close()
-
-	img := &image.Image{}
-	dec := json.NewDecoder(jsonSource)
-
-	// Decode the JSON data
-	if err := dec.Decode(img); err != nil {
+	if dgst, err := image.StrongID(config); err != nil {
 		return nil, err
+	} else if dgst.Hex() != id {
+		return nil, fmt.Errorf("Image stored at '%s' has wrong digest '%s'", id, dgst)
 	}
 
-	if img.ID == "" {
-		img.ID = id
+	img, err := image.NewImgJSON(config)
+	if err != nil {
+		return nil, err
 	}
-
-	if img.Parent == "" && img.ParentID != "" && img.ParentID.Validate() == nil {
+	img.ID = id
+	if img.ParentID != "" {
+		if err := img.ParentID.Validate(); err != nil {
+			return nil, err
+		}
 		img.Parent = img.ParentID.Hex()
 	}
 
-	// compatibilityID for parent
-	parent, err := ioutil.ReadFile(filepath.Join(root, parentFileName))
-	if err == nil && len(parent) > 0 {
-		img.Parent = string(parent)
-	}
-
-	if err := image.ValidateID(img.ID); err != nil {
+	chainID, err := graph.getImageLayer(id)
+	if err != nil {
 		return nil, err
 	}
-
-	if buf, err := ioutil.ReadFile(filepath.Join(root, layersizeFileName)); err != nil {
+	if buf, err := ioutil.ReadFile(filepath.Join(graph.layerRoot(chainID), layersizeFileName)); err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
 		}
@@ -565,7 +917,38 @@ func (graph *Graph) setLayerDigest(id string, dgst digest.Digest) error {
 	if err := ioutil.WriteFile(filepath.Join(root, digestFileName), []byte(dgst.String()), 0600); err != nil {
 		return fmt.Errorf("Error storing digest in %s/%s: %s", root, digestFileName, err)
 	}
-	return nil
+	img, err := graph.loadImage(id)
+	if err != nil {
+		return err
+	}
+	return graph.setDiffIDByDigest(dgst, img.LayerID)
+}
+
+// setDiffIDByDigest records the diff ID of the layer with the given digest in
+// a registry, so pulls can find layers that are already in the graph before
+// downloading them.
+func (graph *Graph) setDiffIDByDigest(dgst, diffID digest.Digest) error {
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	dir := filepath.Join(graph.root, diffIDsDirName, string(dgst.Algorithm()))
+	if err := system.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, dgst.Hex()), []byte(diffID), 0600)
+}
+
+// getDiffIDByDigest returns the diff ID of the layer with the given digest in
+// a registry.
+func (graph *Graph) getDiffIDByDigest(dgst digest.Digest) (digest.Digest, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	diffID, err := ioutil.ReadFile(filepath.Join(graph.root, diffIDsDirName, string(dgst.Algorithm()), dgst.Hex()))
+	if err != nil {
+		return "", err
+	}
+	return digest.ParseDigest(string(diffID))
 }
 
 // GetDigest gets the digest for the provide image layer id.
@@ -598,7 +981,22 @@ func (graph *Graph) SetV1CompatibilityConfig(id string, data []byte) error {
 }
 func (graph *Graph) setV1CompatibilityConfig(id string, data []byte) error {
 	root := graph.imageRoot(id)
-	return ioutil.WriteFile(filepath.Join(root, v1CompatibilityFileName), data, 0600)
+	if err := ioutil.WriteFile(filepath.Join(root, v1CompatibilityFileName), data, 0600); err != nil {
+		return err
+	}
+
+	// Index the v1 ID so the image is found by GetByV1ID
+	var v1 struct{ ID string }
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return err
+	}
+	if v1.ID == "" || v1.ID == id {
+		return nil
+	}
+	if err := image.ValidateID(v1.ID); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(graph.root, v1IDsDirName, v1.ID), []byte(id), 0600)
 }
 
 // GetV1CompatibilityConfig reads the v1Compatibility JSON data for the image
@@ -615,6 +1013,47 @@ func (graph *Graph) getV1CompatibilityConfig(id string) ([]byte, error) {
 	return ioutil.ReadFile(filepath.Join(root, v1CompatibilityFileName))
 }
 
+// AddSignature stores a detached signature of the image by the key keyID,
+// replacing any previous signature of the image by the same key.
+func (graph *Graph) AddSignature(id, keyID string, signature []byte) error {
+	graph.imageMutex.Lock(id)
+	defer graph.imageMutex.Unlock(id)
+
+	signatures, err := graph.getSignatures(id)
+	if err != nil {
+		return err
+	}
+	signatures[keyID] = json.RawMessage(signature)
+	data, err := json.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(graph.imageRoot(id), signaturesFileName), data, 0600)
+}
+
+// Signatures returns the detached signatures of the image, by key ID.
+func (graph *Graph) Signatures(id string) (map[string]json.RawMessage, error) {
+	graph.imageMutex.Lock(id)
+	defer graph.imageMutex.Unlock(id)
+
+	return graph.getSignatures(id)
+}
+
+func (graph *Graph) getSignatures(id string) (map[string]json.RawMessage, error) {
+	signatures := make(map[string]json.RawMessage)
+	data, err := ioutil.ReadFile(filepath.Join(graph.imageRoot(id), signaturesFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return signatures, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &signatures); err != nil {
+		return nil, err
+	}
+	return signatures, nil
+}
+
 // GenerateV1CompatibilityChain makes sure v1Compatibility JSON data exists
 // for the image. If it doesn't it generates and stores it for the image and
 // all of it's parents based on the image config JSON.
@@ -674,11 +1113,13 @@ func jsonPath(root string) string {
 	return filepath.Join(root, jsonFileName)
 }
 
-func (graph *Graph) disassembleAndApplyTarLayer(id, parent string, layerData archive.ArchiveReader, root string) (size int64, err error) {
+// disassembleAndApplyTarLayer applies layerData to the layer id in the graph
+// driver, and returns the size and the diff ID of the layer.
+func (graph *Graph) disassembleAndApplyTarLayer(id, parent string, layerData archive.ArchiveReader, root string) (size int64, diffID digest.Digest, err error) {
 	// this is saving the tar-split metadata
 	mf, err := os.OpenFile(filepath.Join(root, tarDataFileName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 	mfz := gzip.NewWriter(mf)
 	metaPacker := storage.NewJSONPacker(mfz)
@@ -687,26 +1128,43 @@ func (graph *Graph) disassembleAndApplyTarLayer(id, parent string, layerData arc
 
 	inflatedLayerData, err := archive.DecompressStream(layerData)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
+	digester := digest.Canonical.New()
 
 	// we're passing nil here for the file putter, because the ApplyDiff will
 	// handle the extraction of the archive
-	rdr, err := asm.NewInputTarStream(inflatedLayerData, metaPacker, nil)
+	rdr, err := asm.NewInputTarStream(io.TeeReader(inflatedLayerData, digester.Hash()), metaPacker, nil)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 
 	if size, err = graph.driver.ApplyDiff(id, parent, archive.ArchiveReader(rdr)); err != nil {
-		return 0, err
+		return 0, "", err
+	}
+	// The padding after the end of the archive is part of the diff ID
+	if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+		return 0, "", err
 	}
 
-	return
+	return size, digester.Digest(), nil
 }
 
 func (graph *Graph) assembleTarLayer(img *image.Image) (archive.Archive, error) {
-	root := graph.imageRoot(img.ID)
-	mFileName := filepath.Join(root, tarDataFileName)
+	chainID, err := graph.getImageLayer(img.ID)
+	if err != nil {
+		return nil, err
+	}
+	cacheID, err := graph.getCacheID(chainID)
+	if err != nil {
+		return nil, err
+	}
+	return graph.assembleTar(cacheID, filepath.Join(graph.layerRoot(chainID), tarDataFileName))
+}
+
+// assembleTar reassembles the tar stream of the layer cacheID in the graph
+// driver from the tar-split metadata in mFileName.
+func (graph *Graph) assembleTar(cacheID, mFileName string) (archive.Archive, error) {
 	mf, err := os.Open(mFileName)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -721,7 +1179,7 @@ func (graph *Graph) assembleTarLayer(img *image.Image) (archive.Archive, error)
 	go func() {
 		defer mf.Close()
 		// let's reassemble!
-		logrus.Debugf("[graph] TarLayer with reassembly: %s", img.ID)
+		logrus.Debugf("[graph] TarLayer with reassembly: %s", cacheID)
 		mfz, err := gzip.NewReader(mf)
 		if err != nil {
 			pW.CloseWithError(fmt.Errorf("[graph] error with %s:  %s", mFileName, err))
@@ -729,17 +1187,23 @@ func (graph *Graph) assembleTarLayer(img *image.Image) (archive.Archive, error)
 		}
 		defer mfz.Close()
 
-		// get our relative path to the container
-		fsLayer, err := graph.driver.Get(img.ID, "")
-		if err != nil {
-			pW.CloseWithError(err)
-			return
+		// get our relative path to the container. The files of a lazily
+		// pulled layer are read where its blob is mounted, as the layer
+		// cannot be the upper layer of a mount.
+		var fsLayer string
+		if l := graph.lazyLayer(cacheID); l != nil {
+			fsLayer = l.mountpoint
+		} else {
+			if fsLayer, err = graph.driver.Get(cacheID, ""); err != nil {
+				pW.CloseWithError(err)
+				return
+			}
+			defer graph.driver.Put(cacheID)
 		}
-		defer graph.driver.Put(img.ID)
 
 		metaUnpacker := storage.NewJSONUnpacker(mfz)
 		fileGetter := storage.NewPathFileGetter(fsLayer)
-		logrus.Debugf("[graph] %s is at %q", img.ID, fsLayer)
+		logrus.Debugf("[graph] %s is at %q", cacheID, fsLayer)
 		ots := asm.NewOutputTarStream(fileGetter, metaUnpacker)
 		defer ots.Close()
 		if _, err := io.Copy(pW, ots); err != nil {
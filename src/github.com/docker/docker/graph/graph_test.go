@@ -1,18 +1,25 @@
 package graph
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/docker/distribution/digest"
 	"github.com/docker/docker/autogen/dockerversion"
 	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/tarindex"
 )
 
 func TestMount(t *testing.T) {
@@ -42,7 +49,11 @@ func TestMount(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if _, err := driver.Get(image.ID, ""); err != nil {
+	cacheID, err := graph.GraphDriverID(image.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := driver.Get(cacheID, ""); err != nil {
 		t.Fatal(err)
 	}
 
@@ -73,18 +84,19 @@ func TestInterruptedRegister(t *testing.T) {
 		Created: time.Now(),
 	}
 	w.CloseWithError(errors.New("But I'm not a tarball!")) // (Nobody's perfect, darling)
-	graph.Register(v1ImageDescriptor{image}, badArchive)
-	if _, err := graph.Get(image.ID); err == nil {
-		t.Fatal("Image should not exist after Register is interrupted")
+	if _, err := graph.Register(v1ImageDescriptor{image}, badArchive); err == nil {
+		t.Fatal("Register should fail when interrupted")
 	}
+	assertNImages(graph, t, 0)
 	// Registering the same image again should succeed if the first register was interrupted
 	goodArchive, err := fakeTar()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := graph.Register(v1ImageDescriptor{image}, goodArchive); err != nil {
+	if _, err := graph.Register(v1ImageDescriptor{image}, goodArchive); err != nil {
 		t.Fatal(err)
 	}
+	assertNImages(graph, t, 1)
 }
 
 // FIXME: Do more extensive tests (ex: create multiple, delete, recreate;
@@ -118,6 +130,48 @@ func TestGraphCreate(t *testing.T) {
 	}
 }
 
+func TestGraphCreateWithParent(t *testing.T) {
+	graph, driver := tempGraph(t)
+	defer nukeGraph(graph)
+	parent := createTestImage(graph, t)
+
+	// Add a file and remove one of the parent with a whiteout
+	archive, err := archive.Generate("etc/hosts", "127.0.0.1 localhost", "etc/.wh.passwd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := graph.Create(archive, "", parent.ID, "Testing", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Parent != parent.ID {
+		t.Fatalf("Wrong parent: should be '%s', not '%s'", parent.ID, img.Parent)
+	}
+	if img.Container != "" {
+		t.Fatalf("Expected no container, got '%s'", img.Container)
+	}
+
+	cacheID, err := graph.GraphDriverID(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := driver.Get(cacheID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Put(cacheID)
+	for _, name := range []string{"etc/hosts", "etc/postgres/postgres.conf"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Fatalf("Expected %s in the image: %v", name, err)
+		}
+	}
+	for _, name := range []string{"etc/passwd", "etc/.wh.passwd"} {
+		if _, err := os.Stat(filepath.Join(root, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s not to be in the image: %v", name, err)
+		}
+	}
+}
+
 func TestRegister(t *testing.T) {
 	graph, _ := tempGraph(t)
 	defer nukeGraph(graph)
@@ -130,7 +184,7 @@ func TestRegister(t *testing.T) {
 		Comment: "testing",
 		Created: time.Now(),
 	}
-	err = graph.Register(v1ImageDescriptor{image}, archive)
+	img, err := graph.Register(v1ImageDescriptor{image}, archive)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -138,11 +192,11 @@ func TestRegister(t *testing.T) {
 	if l := len(images); l != 1 {
 		t.Fatalf("Wrong number of images. Should be %d, not %d", 1, l)
 	}
-	if resultImg, err := graph.Get(image.ID); err != nil {
+	if resultImg, err := graph.Get(img.ID); err != nil {
 		t.Fatal(err)
 	} else {
-		if resultImg.ID != image.ID {
-			t.Fatalf("Wrong image ID. Should be '%s', not '%s'", image.ID, resultImg.ID)
+		if resultImg.ID != img.ID {
+			t.Fatalf("Wrong image ID. Should be '%s', not '%s'", img.ID, resultImg.ID)
 		}
 		if resultImg.Comment != image.Comment {
 			t.Fatalf("Wrong image comment. Should be '%s', not '%s'", image.Comment, resultImg.Comment)
@@ -212,9 +266,13 @@ func TestDelete(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Test delete twice (pull -> rm -> pull -> rm)
-	if err := graph.Register(v1ImageDescriptor{img1}, archive); err != nil {
+	img2, err := graph.Register(v1ImageDescriptor{img1}, archive)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if img2.ID != img1.ID {
+		t.Fatalf("Registering the same image again should return ID %s, not %s", img1.ID, img2.ID)
+	}
 	if err := graph.Delete(img1.ID); err != nil {
 		t.Fatal(err)
 	}
@@ -234,36 +292,261 @@ func TestByParent(t *testing.T) {
 		Created: time.Now(),
 		Parent:  "",
 	}
+	parent, err := graph.Register(v1ImageDescriptor{parentImage}, archive1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	childImage1 := &image.Image{
 		ID:      stringid.GenerateRandomID(),
 		Comment: "child1",
 		Created: time.Now(),
-		Parent:  parentImage.ID,
+		Parent:  parent.ID,
 	}
 	childImage2 := &image.Image{
 		ID:      stringid.GenerateRandomID(),
 		Comment: "child2",
 		Created: time.Now(),
-		Parent:  parentImage.ID,
+		Parent:  parent.ID,
+	}
+	_, err = graph.Register(v1ImageDescriptor{childImage1}, archive2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = graph.Register(v1ImageDescriptor{childImage2}, archive3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byParent := graph.ByParent()
+	numChildren := len(byParent[parent.ID])
+	if numChildren != 2 {
+		t.Fatalf("Expected 2 children, found %d", numChildren)
+	}
+}
+
+// Test that images with the same config and layers have the same ID, and that
+// images with the same layers share them in the graph driver
+func TestRegisterContentAddressable(t *testing.T) {
+	graph, _ := tempGraph(t)
+	defer nukeGraph(graph)
+	created := time.Now()
+
+	var ids []string
+	for _, comment := range []string{"testing", "testing", "other"} {
+		archive, err := fakeTar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := graph.Register(v1ImageDescriptor{&image.Image{
+			ID:      stringid.GenerateRandomID(),
+			Comment: comment,
+			Created: created,
+		}}, archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, img.ID)
+	}
+	if ids[0] != ids[1] {
+		t.Fatalf("Images with the same content should have the same ID, got %s and %s", ids[0], ids[1])
 	}
+	if ids[0] == ids[2] {
+		t.Fatalf("Images with different configs should have different IDs, got %s", ids[0])
+	}
+	assertNImages(graph, t, 2)
 
-	err := graph.Register(v1ImageDescriptor{parentImage}, archive1)
+	cacheID, err := graph.GraphDriverID(ids[0])
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = graph.Register(v1ImageDescriptor{childImage1}, archive2)
+	otherCacheID, err := graph.GraphDriverID(ids[2])
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = graph.Register(v1ImageDescriptor{childImage2}, archive3)
+	if cacheID != otherCacheID {
+		t.Fatalf("Images with the same layer should share it, got %s and %s", cacheID, otherCacheID)
+	}
+
+	// The layer stays until no image uses it anymore
+	if err := graph.Delete(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !graph.driver.Exists(cacheID) {
+		t.Fatal("Layer used by an image should not be removed")
+	}
+	if err := graph.Delete(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if graph.driver.Exists(cacheID) {
+		t.Fatal("Layer not used by any image should be removed")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	graph, driver := tempGraph(t)
+	defer nukeGraph(graph)
+	oldRoot, err := ioutil.TempDir("", "docker-graph-old-")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(oldRoot)
 
-	byParent := graph.ByParent()
-	numChildren := len(byParent[parentImage.ID])
-	if numChildren != 2 {
-		t.Fatalf("Expected 2 children, found %d", numChildren)
+	// Store a parent and a child image in the old layout
+	oldIDs := []string{stringid.GenerateRandomID(), stringid.GenerateRandomID()}
+	var parent string
+	for _, id := range oldIDs {
+		archive, err := fakeTar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := driver.Create(id, parent, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := driver.ApplyDiff(id, parent, archive); err != nil {
+			t.Fatal(err)
+		}
+		imgJSON, err := json.Marshal(&image.Image{
+			ID:            id,
+			Parent:        parent,
+			Comment:       "testing",
+			Created:       time.Now(),
+			DockerVersion: dockerversion.VERSION,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(oldRoot, id), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(jsonPath(filepath.Join(oldRoot, id)), imgJSON, 0600); err != nil {
+			t.Fatal(err)
+		}
+		parent = id
+	}
+
+	migrated, err := graph.Migrate(oldRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != 2 {
+		t.Fatalf("Expected 2 migrated images, got %d", len(migrated))
+	}
+	assertNImages(graph, t, 2)
+	if _, err := os.Stat(oldRoot); !os.IsNotExist(err) {
+		t.Fatalf("Old graph should be removed after migration: %v", err)
+	}
+
+	child, err := graph.GetByV1ID(oldIDs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.ID != migrated[oldIDs[1]] {
+		t.Fatalf("Expected image %s for v1 ID %s, got %s", migrated[oldIDs[1]], oldIDs[1], child.ID)
+	}
+	if child.Parent != migrated[oldIDs[0]] {
+		t.Fatalf("Expected parent %s, got %s", migrated[oldIDs[0]], child.Parent)
+	}
+	// The layers stay in the graph driver under their old IDs
+	if cacheID, err := graph.GraphDriverID(child.ID); err != nil {
+		t.Fatal(err)
+	} else if cacheID != oldIDs[1] {
+		t.Fatalf("Expected layer %s, got %s", oldIDs[1], cacheID)
+	}
+}
+
+func TestMigrateDuplicateLayer(t *testing.T) {
+	graph, driver := tempGraph(t)
+	defer nukeGraph(graph)
+	oldRoot, err := ioutil.TempDir("", "docker-graph-old-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(oldRoot)
+
+	// Two empty images with the same content, the second one with a child.
+	// The first one is migrated first and keeps its layer.
+	ids := []string{stringid.GenerateRandomID(), stringid.GenerateRandomID(), stringid.GenerateRandomID()}
+	sort.Strings(ids)
+	dup, child := ids[:2], ids[2]
+	mtime := time.Unix(1000000000, 0)
+	for _, img := range []image.Image{{ID: dup[0]}, {ID: dup[1]}, {ID: child, Parent: dup[1]}} {
+		if err := driver.Create(img.ID, img.Parent, nil); err != nil {
+			t.Fatal(err)
+		}
+		if img.Parent != "" {
+			archive, err := fakeTar()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := driver.ApplyDiff(img.ID, img.Parent, archive); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			dir, err := driver.Get(img.ID, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Chtimes(dir, mtime, mtime)
+			driver.Put(img.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		img.Created = time.Now()
+		img.DockerVersion = dockerversion.VERSION
+		imgJSON, err := json.Marshal(&img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(oldRoot, img.ID), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(jsonPath(filepath.Join(oldRoot, img.ID)), imgJSON, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrated, err := graph.Migrate(oldRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != 3 {
+		t.Fatalf("Expected 3 migrated images, got %d", len(migrated))
+	}
+	// The second layer is only kept for its child and a container
+	graph.HoldMigratedLayer(dup[1], "container")
+	graph.PruneMigratedLayers()
+	if cacheID, err := graph.GraphDriverID(migrated[dup[1]]); err != nil || cacheID != dup[0] {
+		t.Fatalf("Expected the images with the same content to share layer %s, got %s: %v", dup[0], cacheID, err)
+	}
+	if !driver.Exists(dup[1]) {
+		t.Fatal("Migrated layer with children should be kept")
+	}
+
+	// The migrated layers are restored with the graph
+	restored, err := NewGraph(graph.root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.migratedLayers) != 1 || len(restored.migratedLayers[dup[1]].Children) != 2 {
+		t.Fatalf("Expected a migrated layer with 2 children, got %v", restored.migratedLayers)
+	}
+
+	if err := graph.Delete(migrated[child]); err != nil {
+		t.Fatal(err)
+	}
+	if !driver.Exists(dup[1]) {
+		t.Fatal("Migrated layer used by a container should be kept")
+	}
+	graph.ReleaseMigratedLayers("container")
+	if driver.Exists(dup[1]) {
+		t.Fatal("Migrated layer should be removed with its last child")
+	}
+	if _, err := os.Stat(filepath.Join(graph.root, migratedDirName, dup[1])); !os.IsNotExist(err) {
+		t.Fatalf("Expected the migrated layer record to be removed: %v", err)
+	}
+	if !driver.Exists(dup[0]) {
+		t.Fatal("Layer of the graph should not be removed")
 	}
 }
 
@@ -306,3 +589,80 @@ func nukeGraph(graph *Graph) {
 	graph.driver.Cleanup()
 	os.RemoveAll(graph.root)
 }
+
+func TestBufferToFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "docker-graph-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, dgst, err := bufferToFile(f, layer, archive.Gzip, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file is rewound, and the digest is the one of the compressed data.
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != size {
+		t.Fatalf("Expected %d bytes, got %d", size, len(data))
+	}
+	expected, err := digest.FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected != dgst {
+		t.Fatalf("Expected digest %s, got %s", expected, dgst)
+	}
+	if c := archive.DetectCompression(data); c != archive.Gzip {
+		t.Fatalf("Expected the layer to be compressed with gzip, got %s", c.Name())
+	}
+
+	if _, _, err := bufferToFile(f, layer, archive.Gzip, 12); err == nil {
+		t.Fatal("Expected an invalid compression level to be refused")
+	}
+}
+
+func TestBufferIndexedToFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "docker-graph-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, dgst, indexDigest, err := bufferIndexedToFile(f, layer, archive.DefaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := digest.FromBytes(data); int64(len(data)) != size || expected != dgst {
+		t.Fatalf("Expected %d bytes with digest %s, got %d bytes with digest %s", len(data), expected, size, dgst)
+	}
+	// The layer is indexed, and its diff ID is the one of the original layer.
+	index, err := tarindex.ReadIndex(bytes.NewReader(data), size, indexDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err = fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffID, _ := digest.FromReader(layer); index.DiffID != diffID {
+		t.Fatalf("Expected diff ID %s, got %s", diffID, index.DiffID)
+	}
+}
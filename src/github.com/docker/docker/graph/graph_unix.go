@@ -3,13 +3,13 @@
 package graph
 
 import (
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/system"
@@ -77,38 +77,11 @@ func (graph *Graph) restoreBaseImages() ([]string, error) {
 	return nil, nil
 }
 
-// storeImage stores file system layer data for the given image to the
-// graph's storage driver. Image metadata is stored in a file
-// at the specified root directory.
-func (graph *Graph) storeImage(id, parent string, config []byte, layerData archive.ArchiveReader, root string) (err error) {
-	var size int64
-	// Store the layer. If layerData is not nil, unpack it into the new layer
-	if layerData != nil {
-		if size, err = graph.disassembleAndApplyTarLayer(id, parent, layerData, root); err != nil {
-			return err
-		}
-	}
-
-	if err := graph.saveSize(root, int(size)); err != nil {
-		return err
-	}
-
-	if err := ioutil.WriteFile(jsonPath(root), config, 0600); err != nil {
-		return err
-	}
-
-	// If image is pointing to a parent via CompatibilityID write the reference to disk
-	img, err := image.NewImgJSON(config)
-	if err != nil {
-		return err
-	}
-
-	if img.ParentID.Validate() == nil && parent != img.ParentID.Hex() {
-		if err := ioutil.WriteFile(filepath.Join(root, parentFileName), []byte(parent), 0600); err != nil {
-			return err
-		}
-	}
-	return nil
+// storeLayer stores file system layer data for the given layer to the
+// graph's storage driver. Layer metadata is stored in a file at the specified
+// root directory.
+func (graph *Graph) storeLayer(id, parent string, layerData archive.ArchiveReader, root string) (int64, digest.Digest, error) {
+	return graph.disassembleAndApplyTarLayer(id, parent, layerData, root)
 }
 
 // TarLayer returns a tar archive of the image's filesystem layer.
@@ -116,7 +89,11 @@ func (graph *Graph) TarLayer(img *image.Image) (arch archive.Archive, err error)
 	rdr, err := graph.assembleTarLayer(img)
 	if err != nil {
 		logrus.Debugf("[graph] TarLayer with traditional differ: %s", img.ID)
-		return graph.driver.Diff(img.ID, img.Parent)
+		cacheID, parentCacheID, err := graph.driverIDs(img)
+		if err != nil {
+			return nil, err
+		}
+		return graph.driver.Diff(cacheID, parentCacheID)
 	}
 	return rdr, nil
 }
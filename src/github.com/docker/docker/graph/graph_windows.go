@@ -3,10 +3,12 @@
 package graph
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
-	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
 	"github.com/docker/docker/daemon/graphdriver/windows"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
@@ -23,79 +25,79 @@ func (graph *Graph) restoreBaseImages() ([]string, error) {
 	return nil, nil
 }
 
-// ParentLayerIds returns a list of all parent image IDs for the given image.
+// ParentLayerIds returns the IDs of the layers of the given image and all its
+// parents in the graph driver.
 func (graph *Graph) ParentLayerIds(img *image.Image) (ids []string, err error) {
 	for i := img; i != nil && err == nil; i, err = graph.GetParent(i) {
-		ids = append(ids, i.ID)
+		cacheID, err := graph.GraphDriverID(i.ID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, cacheID)
 	}
 
 	return
 }
 
-// storeImage stores file system layer data for the given image to the
-// graph's storage driver. Image metadata is stored in a file
-// at the specified root directory.
-func (graph *Graph) storeImage(id, parent string, config []byte, layerData archive.ArchiveReader, root string) (err error) {
-	var size int64
+// storeLayer stores file system layer data for the given layer to the
+// graph's storage driver. Layer metadata is stored in a file at the specified
+// root directory.
+func (graph *Graph) storeLayer(id, parent string, layerData archive.ArchiveReader, root string) (size int64, diffID digest.Digest, err error) {
 	if wd, ok := graph.driver.(*windows.WindowsGraphDriver); ok {
-		// Store the layer. If layerData is not nil and this isn't a base image,
-		// unpack it into the new layer
-		if layerData != nil && parent != "" {
-			var ids []string
-			parentImg, err := graph.Get(parent)
-			if err != nil {
-				return err
-			}
+		inflatedLayerData, err := archive.DecompressStream(layerData)
+		if err != nil {
+			return 0, "", err
+		}
+		digester := digest.Canonical.New()
+		rdr := io.TeeReader(inflatedLayerData, digester.Hash())
 
-			ids, err = graph.ParentLayerIds(parentImg)
+		// Store the layer. If this isn't a base image, unpack it into the
+		// new layer
+		if parent != "" {
+			ids, err := graph.parentLayerIdsByCacheID(parent)
 			if err != nil {
-				return err
+				return 0, "", err
 			}
-
-			if size, err = wd.Import(id, layerData, wd.LayerIdsToPaths(ids)); err != nil {
-				return err
+			if size, err = wd.Import(id, rdr, wd.LayerIdsToPaths(ids)); err != nil {
+				return 0, "", err
 			}
 		}
-
-	} else {
-		// We keep this functionality here so that we can still work with the
-		// VFS driver during development. This will not be used for actual running
-		// of Windows containers. Without this code, it would not be possible to
-		// docker pull using the VFS driver.
-
-		// Store the layer. If layerData is not nil, unpack it into the new layer
-		if layerData != nil {
-			if size, err = graph.disassembleAndApplyTarLayer(id, parent, layerData, root); err != nil {
-				return err
-			}
-		}
-
-		if err := graph.saveSize(root, size); err != nil {
-			return err
-		}
-
-		if err := ioutil.WriteFile(jsonPath(root), config, 0600); err != nil {
-			return err
+		// The diff ID covers the whole layer, including the padding after
+		// the end of the archive
+		if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+			return 0, "", err
 		}
+		return size, digester.Digest(), nil
+	}
 
-		// If image is pointing to a parent via CompatibilityID write the reference to disk
-		img, err := image.NewImgJSON(config)
-		if err != nil {
-			return err
-		}
+	// We keep this functionality here so that we can still work with the
+	// VFS driver during development. This will not be used for actual running
+	// of Windows containers. Without this code, it would not be possible to
+	// docker pull using the VFS driver.
+	return graph.disassembleAndApplyTarLayer(id, parent, layerData, root)
+}
 
-		if img.ParentID.Validate() == nil && parent != img.ParentID.Hex() {
-			if err := ioutil.WriteFile(filepath.Join(root, parentFileName), []byte(parent), 0600); err != nil {
-				return err
-			}
+// parentLayerIdsByCacheID returns the IDs of the layer cacheID and all the
+// layers below it in the graph driver.
+func (graph *Graph) parentLayerIdsByCacheID(cacheID string) ([]string, error) {
+	var found *image.Image
+	graph.walkAll(func(img *image.Image) {
+		if id, err := graph.GraphDriverID(img.ID); found == nil && err == nil && id == cacheID {
+			found = img
 		}
-
-		return nil
+	})
+	if found == nil {
+		return nil, fmt.Errorf("no image uses layer %s", cacheID)
 	}
+	return graph.ParentLayerIds(found)
 }
 
 // TarLayer returns a tar archive of the image's filesystem layer.
 func (graph *Graph) TarLayer(img *image.Image) (arch archive.Archive, err error) {
+	cacheID, parentCacheID, err := graph.driverIDs(img)
+	if err != nil {
+		return nil, err
+	}
 	if wd, ok := graph.driver.(*windows.WindowsGraphDriver); ok {
 		var ids []string
 		if img.Parent != "" {
@@ -110,7 +112,7 @@ func (graph *Graph) TarLayer(img *image.Image) (arch archive.Archive, err error)
 			}
 		}
 
-		return wd.Export(img.ID, wd.LayerIdsToPaths(ids))
+		return wd.Export(cacheID, wd.LayerIdsToPaths(ids))
 	} else {
 		// We keep this functionality here so that we can still work with the VFS
 		// driver during development. VFS is not supported (and just will not work)
@@ -118,7 +120,7 @@ func (graph *Graph) TarLayer(img *image.Image) (arch archive.Archive, err error)
 		rdr, err := graph.assembleTarLayer(img)
 		if err != nil {
 			logrus.Debugf("[graph] TarLayer with traditional differ: %s", img.ID)
-			return graph.driver.Diff(img.ID, img.Parent)
+			return graph.driver.Diff(cacheID, parentCacheID)
 		}
 		return rdr, nil
 	}
@@ -14,7 +14,9 @@ import (
 )
 
 type ImageImportConfig struct {
-	Changes         []string
+	Changes []string
+	// Parent is the ID of the image the imported layer is applied on.
+	Parent          string
 	InConfig        io.ReadCloser
 	OutStream       io.Writer
 	ContainerConfig *runconfig.Config
@@ -57,7 +59,7 @@ func (s *TagStore) Import(src string, repo string, tag string, imageImportConfig
 		archive = progressReader
 	}
 
-	img, err := s.graph.Create(archive, "", "", "Imported from "+src, "", nil, imageImportConfig.ContainerConfig)
+	img, err := s.graph.Create(archive, "", imageImportConfig.Parent, "Imported from "+src, "", nil, imageImportConfig.ContainerConfig)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,826 @@
+package graph
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/lazyfs"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/system"
+	"github.com/docker/docker/pkg/tarindex"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// Layers pulled lazily are mounted over their blob before it is downloaded,
+// with the files of the layer read from the blob on demand, while the whole
+// blob is fetched in the background. The blob of such a layer is cached in
+// ./_lazy/<cache ID>/ in the graph root, along with the state of its fetch,
+// until the daemon restarts with the blob complete and applies it to the
+// layer like a downloaded blob. The fetch of an incomplete blob is resumed
+// from the repository it was pulled from when the daemon restarts.
+//
+// The index of the blob is only trusted, and the layer mounted, if its digest
+// is in the v1Compatibility of the layer in the signed manifest, where pushes
+// of indexed layers record it. The chunks of files read from the blob are
+// checked against their digests in the index.
+
+const (
+	lazyDirName = "_lazy"
+
+	// file names for ./_lazy/<cache ID>/
+	lazyBlobFileName  = "blob"
+	lazyStateFileName = "state.json"
+
+	// lazyBlockSize is the size of the blocks blobs are fetched in.
+	lazyBlockSize = 256 << 10
+	// lazyFetchBlocks is the number of blocks fetched at once in the
+	// background.
+	lazyFetchBlocks = 16
+
+	// layerIndexKey is the key of the digest of the index of an indexed
+	// layer in its v1Compatibility.
+	layerIndexKey = "layer_index"
+)
+
+// lazyRetryDelay is the delay before the second attempt to fetch a blob in
+// the background, which grows with each attempt.
+var lazyRetryDelay = time.Second
+
+// errLazyNoSource is returned when reading a part of a blob that was not
+// fetched while the blob cannot be fetched, e.g. after a daemon restart
+// until its fetch is resumed or the image is pulled again.
+var errLazyNoSource = errors.New("lazy pull: blob is not available until the image is pulled again")
+
+// errLazyCorrupted is returned when a fetched blob does not match its digest.
+var errLazyCorrupted = errors.New("lazy pull: blob does not match its digest")
+
+// openBlobFunc opens a blob in a registry, to read it from offset.
+type openBlobFunc func(offset int64) (io.ReadCloser, error)
+
+// lazyBlob is a blob of a registry cached in a local file, whose blocks are
+// fetched when they are first read.
+type lazyBlob struct {
+	root string
+	f    *os.File
+
+	// mu serializes fetches and protects the fields below.
+	mu sync.Mutex
+	// open is nil when the blob cannot be fetched.
+	open  openBlobFunc
+	state lazyState
+}
+
+// lazyState is the state of a lazy blob, stored along with it.
+type lazyState struct {
+	Digest digest.Digest
+	Size   int64
+	// IndexDigest is the digest of the index of the blob.
+	IndexDigest digest.Digest
+	// DiffID, ChainID and ParentCacheID are set once a layer is mounted
+	// over the blob.
+	DiffID        digest.Digest `json:",omitempty"`
+	ChainID       digest.Digest `json:",omitempty"`
+	ParentCacheID string        `json:",omitempty"`
+	// Repository and Endpoint are the local name of the repository and the
+	// URL of the registry endpoint the blob is pulled from, to resume its
+	// fetch when the daemon restarts.
+	Repository string `json:",omitempty"`
+	Endpoint   string `json:",omitempty"`
+	// Fetched holds a bit for each block of the blob that was fetched.
+	Fetched []byte
+	// Missing is the number of blocks that were not fetched.
+	Missing int
+}
+
+// newLazyBlob returns a blob of dgst and size, with an index of digest
+// indexDigest, fetched with open and cached under a new directory of the
+// graph. The name of the directory is the ID the layer mounted over the blob
+// gets in the graph driver.
+func (graph *Graph) newLazyBlob(dgst digest.Digest, size int64, indexDigest digest.Digest, open openBlobFunc) (*lazyBlob, error) {
+	root := filepath.Join(graph.root, lazyDirName, stringid.GenerateRandomID())
+	if err := system.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(root, lazyBlobFileName), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		os.RemoveAll(root)
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		os.RemoveAll(root)
+		return nil, err
+	}
+	blocks := int((size + lazyBlockSize - 1) / lazyBlockSize)
+	return &lazyBlob{
+		root: root,
+		f:    f,
+		open: open,
+		state: lazyState{
+			Digest:      dgst,
+			Size:        size,
+			IndexDigest: indexDigest,
+			Fetched:     make([]byte, (blocks+7)/8),
+			Missing:     blocks,
+		},
+	}, nil
+}
+
+// loadLazyBlob loads the blob cached in root, which cannot be fetched until
+// it gets a source again.
+func loadLazyBlob(root string) (*lazyBlob, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, lazyStateFileName))
+	if err != nil {
+		return nil, err
+	}
+	b := &lazyBlob{root: root}
+	if err := json.Unmarshal(data, &b.state); err != nil {
+		return nil, err
+	}
+	if b.f, err = os.OpenFile(filepath.Join(root, lazyBlobFileName), os.O_RDWR, 0); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// cacheID returns the ID of the layer mounted over the blob.
+func (b *lazyBlob) cacheID() string {
+	return filepath.Base(b.root)
+}
+
+// ReadAt reads the blob at off, fetching the blocks that were not fetched
+// yet.
+func (b *lazyBlob) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.state.Size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > b.state.Size {
+		end = b.state.Size
+	}
+	if err := b.fetch(off/lazyBlockSize, (end+lazyBlockSize-1)/lazyBlockSize); err != nil {
+		return 0, err
+	}
+	n, err := b.f.ReadAt(p[:end-off], off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// fetch fetches the blocks from first up to last that were not fetched yet.
+func (b *lazyBlob) fetch(first, last int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for block := first; block < last; {
+		if b.fetched(block) {
+			block++
+			continue
+		}
+		end := block + 1
+		for end < last && !b.fetched(end) {
+			end++
+		}
+		if err := b.fetchRange(block, end); err != nil {
+			return err
+		}
+		block = end
+	}
+	return nil
+}
+
+// fetchRange fetches the blocks from first up to last. It must be called with
+// the blob locked.
+func (b *lazyBlob) fetchRange(first, last int64) error {
+	if b.open == nil {
+		return errLazyNoSource
+	}
+	offset, end := first*lazyBlockSize, last*lazyBlockSize
+	if end > b.state.Size {
+		end = b.state.Size
+	}
+	rc, err := b.open(offset)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	buf := make([]byte, end-offset)
+	if _, err := io.ReadFull(rc, buf); err != nil {
+		return err
+	}
+	if _, err := b.f.WriteAt(buf, offset); err != nil {
+		return err
+	}
+	for block := first; block < last; block++ {
+		b.state.Fetched[block/8] |= 1 << uint(block%8)
+		b.state.Missing--
+	}
+	return nil
+}
+
+func (b *lazyBlob) fetched(block int64) bool {
+	return b.state.Fetched[block/8]&(1<<uint(block%8)) != 0
+}
+
+// fetchAll fetches the blocks that were not fetched yet, until stop is
+// closed.
+func (b *lazyBlob) fetchAll(stop <-chan struct{}) error {
+	blocks := (b.state.Size + lazyBlockSize - 1) / lazyBlockSize
+	for block := int64(0); block < blocks; block += lazyFetchBlocks {
+		select {
+		case <-stop:
+			return errors.New("lazy pull: fetch stopped")
+		default:
+		}
+		last := block + lazyFetchBlocks
+		if last > blocks {
+			last = blocks
+		}
+		if err := b.fetch(block, last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset drops the fetched blocks of the blob, to fetch it again.
+func (b *lazyBlob) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.state.Fetched {
+		b.state.Fetched[i] = 0
+	}
+	b.state.Missing = int((b.state.Size + lazyBlockSize - 1) / lazyBlockSize)
+}
+
+// setSource sets the function the blob is fetched with.
+func (b *lazyBlob) setSource(open openBlobFunc) {
+	b.mu.Lock()
+	b.open = open
+	b.mu.Unlock()
+}
+
+// setOrigin sets the repository and the registry endpoint the blob is
+// fetched from.
+func (b *lazyBlob) setOrigin(repository, endpoint string) {
+	b.mu.Lock()
+	b.state.Repository = repository
+	b.state.Endpoint = endpoint
+	b.mu.Unlock()
+}
+
+// hasSource returns true if the blob can be fetched.
+func (b *lazyBlob) hasSource() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open != nil
+}
+
+// save stores the state of the blob.
+func (b *lazyBlob) save() error {
+	b.mu.Lock()
+	data, err := json.Marshal(b.state)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(b.root, lazyStateFileName), data, 0600)
+}
+
+// verify checks the digest of the fetched blob.
+func (b *lazyBlob) verify() error {
+	verifier, err := digest.NewDigestVerifier(b.state.Digest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(verifier, io.NewSectionReader(b.f, 0, b.state.Size)); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("filesystem layer verification failed for digest %s", b.state.Digest)
+	}
+	return nil
+}
+
+// remove removes the blob from the graph.
+func (b *lazyBlob) remove() {
+	b.f.Close()
+	if err := os.RemoveAll(b.root); err != nil {
+		logrus.Errorf("Failed to remove lazily pulled blob %s: %v", b.root, err)
+	}
+}
+
+// lazyLayer is a layer in the graph driver mounted over a lazy blob.
+type lazyLayer struct {
+	blob   *lazyBlob
+	server *lazyfs.Server
+	// mountpoint is the directory of the layer the blob is mounted on.
+	mountpoint string
+	// stop is closed to stop fetching the blob, and done when the fetch
+	// returned or if the blob is not being fetched.
+	stop chan struct{}
+	done chan struct{}
+	// stopped is set when the fetch gave up, to be restarted by the next
+	// pull of the image. It is protected by the lazy layers lock.
+	stopped bool
+}
+
+// readLazyIndex returns the index of the blob, or tarindex.ErrNotIndexed if
+// it cannot be pulled lazily.
+func readLazyIndex(b *lazyBlob) (*tarindex.Index, error) {
+	if b.state.IndexDigest == "" {
+		return nil, tarindex.ErrNotIndexed
+	}
+	index, err := tarindex.ReadIndex(b, b.state.Size, b.state.IndexDigest)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range index.Entries {
+		if !e.Indexed() {
+			return nil, tarindex.ErrNotIndexed
+		}
+	}
+	return index, nil
+}
+
+// layerIndexDigest returns the digest of the index of a layer recorded in its
+// v1Compatibility, or an empty digest if the layer is not indexed.
+func layerIndexDigest(v1Compatibility []byte) digest.Digest {
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(v1Compatibility, &config); err != nil {
+		return ""
+	}
+	var dgst digest.Digest
+	if err := json.Unmarshal(config[layerIndexKey], &dgst); err != nil || dgst.Validate() != nil {
+		return ""
+	}
+	return dgst
+}
+
+// SetLayerIndexDigest records the digest of the index of the layer of the
+// image id in its v1Compatibility, so that the index is anchored in the
+// manifests the image is pushed with. An empty digest removes the digest of a
+// layer that is not indexed anymore.
+func (graph *Graph) SetLayerIndexDigest(id string, dgst digest.Digest) error {
+	data, err := graph.GenerateV1CompatibilityChain(id)
+	if err != nil {
+		return err
+	}
+	if layerIndexDigest(data) == dgst {
+		return nil
+	}
+
+	graph.imageMutex.Lock(id)
+	defer graph.imageMutex.Unlock(id)
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	if dgst == "" {
+		delete(config, layerIndexKey)
+	} else {
+		value, err := json.Marshal(dgst)
+		if err != nil {
+			return err
+		}
+		config[layerIndexKey] = value
+	}
+	if data, err = json.Marshal(config); err != nil {
+		return err
+	}
+	return graph.setV1CompatibilityConfig(id, data)
+}
+
+// lazyDriver returns the graph driver as a LazyDriver, if it supports lazy
+// pulls.
+func (graph *Graph) lazyDriver() (graphdriver.LazyDriver, bool) {
+	driver, ok := graph.driver.(graphdriver.LazyDriver)
+	return driver, ok
+}
+
+// registerLazy registers an image whose layer is mounted over the blob b with
+// the given index, and starts fetching the blob in the background. The blob
+// is removed if the image cannot be registered.
+func (graph *Graph) registerLazy(im image.ImageDescriptor, b *lazyBlob, index *tarindex.Index) (*image.Image, error) {
+	parent, err := graph.getParentImage(im)
+	if err != nil {
+		b.remove()
+		return nil, err
+	}
+	l, err := graph.createLazyLayer(parent, b, index)
+	if err != nil {
+		b.remove()
+		return nil, err
+	}
+	img, err := graph.register(im, parent, l)
+	if err != nil {
+		return nil, err
+	}
+	graph.lazyMutex.Lock()
+	// The layer is gone if another image added the same layer meanwhile.
+	if ll := graph.lazyLayers[l.cacheID]; ll != nil {
+		graph.startLazyFetch(ll)
+	}
+	graph.lazyMutex.Unlock()
+	return img, nil
+}
+
+// createLazyLayer mounts the blob b with the given index on a new layer on
+// top of the layer of parent.
+func (graph *Graph) createLazyLayer(parent *image.Image, b *lazyBlob, index *tarindex.Index) (l *rootLayer, err error) {
+	driver, ok := graph.lazyDriver()
+	if !ok {
+		return nil, fmt.Errorf("%s does not support lazy pulls", graph.driver)
+	}
+	var (
+		parentChainID digest.Digest
+		parentCacheID string
+	)
+	if parent != nil {
+		if parentChainID, err = graph.getImageLayer(parent.ID); err != nil {
+			return nil, err
+		}
+		if parentCacheID, err = graph.getCacheID(parentChainID); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp, err := graph.mktemp("")
+	if err != nil {
+		return nil, fmt.Errorf("mktemp failed: %s", err)
+	}
+	cacheID := b.cacheID()
+	defer func() {
+		if err != nil {
+			graph.removeDriverLayer(cacheID)
+			os.RemoveAll(tmp)
+		}
+	}()
+
+	if err := createRootFilesystemInDriver(graph, cacheID, parentCacheID, nil); err != nil {
+		return nil, err
+	}
+	chainID := image.ChainID(parentChainID, index.DiffID)
+	b.state.DiffID = index.DiffID
+	b.state.ChainID = chainID
+	b.state.ParentCacheID = parentCacheID
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+	if err := graph.mountLazyLayer(driver, cacheID, b, index); err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for _, e := range index.Entries {
+		if e.Type == tar.TypeReg || e.Type == tar.TypeRegA {
+			size += e.Size
+		}
+	}
+	return &rootLayer{
+		chainID: chainID,
+		diffID:  index.DiffID,
+		cacheID: cacheID,
+		size:    size,
+		root:    tmp,
+	}, nil
+}
+
+// mountLazyLayer mounts the blob b with the given index on the layer cacheID.
+func (graph *Graph) mountLazyLayer(driver graphdriver.LazyDriver, cacheID string, b *lazyBlob, index *tarindex.Index) error {
+	dir, format, err := driver.DiffPath(cacheID)
+	if err != nil {
+		return err
+	}
+	server, err := lazyfs.Mount(dir, index, b, format)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	close(done)
+	graph.lazyMutex.Lock()
+	graph.lazyLayers[cacheID] = &lazyLayer{
+		blob:       b,
+		server:     server,
+		mountpoint: dir,
+		stop:       make(chan struct{}),
+		done:       done,
+	}
+	graph.lazyMutex.Unlock()
+	return nil
+}
+
+// startLazyFetch starts fetching the blob of the layer l in the background.
+// It must be called with the lazy layers locked.
+func (graph *Graph) startLazyFetch(l *lazyLayer) {
+	l.done = make(chan struct{})
+	go graph.fetchLazyLayer(l, l.done)
+}
+
+// fetchLazyLayer fetches the blob of the layer l in the background. Fetches
+// that fail are retried, and a fetch stops when the blob cannot be fetched
+// anymore, e.g. after a daemon restart, or after too many attempts, until it
+// is resumed by a pull.
+func (graph *Graph) fetchLazyLayer(l *lazyLayer, done chan struct{}) {
+	defer close(done)
+
+	for attempt := 1; ; attempt++ {
+		err := l.blob.fetchAll(l.stop)
+		if err == nil {
+			break
+		}
+		select {
+		case <-l.stop:
+			return
+		default:
+		}
+		if err == errLazyNoSource || attempt >= maxDownloadAttempts {
+			logrus.Errorf("Failed to fetch lazily pulled blob %s: %v", l.blob.state.Digest, err)
+			graph.lazyMutex.Lock()
+			l.stopped = true
+			graph.lazyMutex.Unlock()
+			return
+		}
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(time.Duration(attempt) * lazyRetryDelay):
+		}
+	}
+
+	if err := graph.completeLazyLayer(l); err != nil {
+		// The files served stay trusted, as their chunks are checked
+		// against the index, but the blob cannot be applied to the layer.
+		// It is dropped and fetched again by the next pull of the image.
+		logrus.Errorf("Lazily pulled blob %s is corrupted, it has to be pulled again: %v", l.blob.state.Digest, err)
+		l.blob.reset()
+		if err := l.blob.save(); err != nil {
+			logrus.Errorf("Failed to save the state of lazily pulled layer %s: %v", l.blob.cacheID(), err)
+		}
+		graph.lazyMutex.Lock()
+		l.stopped = true
+		graph.lazyMutex.Unlock()
+	}
+}
+
+// completeLazyLayer verifies the fetched blob of the layer l, and stores the
+// tar-split metadata of the layer.
+func (graph *Graph) completeLazyLayer(l *lazyLayer) error {
+	b := l.blob
+	if err := b.verify(); err != nil {
+		return err
+	}
+	tmp, err := graph.mktemp("")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	diffID, err := disassembleTarLayer(io.NewSectionReader(b.f, 0, b.state.Size), tmp)
+	if err != nil {
+		return err
+	}
+	if diffID != b.state.DiffID {
+		return fmt.Errorf("layer %s has diff ID %s instead of %s", b.state.Digest, diffID, b.state.DiffID)
+	}
+	if err := b.save(); err != nil {
+		return err
+	}
+
+	// The layer is removed from the graph after it is unmounted.
+	graph.lazyMutex.Lock()
+	defer graph.lazyMutex.Unlock()
+	if graph.lazyLayers[b.cacheID()] != l {
+		return nil
+	}
+	logrus.Debugf("Lazily pulled blob %s is complete", b.state.Digest)
+	return os.Rename(filepath.Join(tmp, tarDataFileName), filepath.Join(graph.layerRoot(b.state.ChainID), tarDataFileName))
+}
+
+// disassembleTarLayer stores the tar-split metadata of the compressed layer
+// in layerData in root, and returns the diff ID of the layer.
+func disassembleTarLayer(layerData io.Reader, root string) (digest.Digest, error) {
+	mf, err := os.OpenFile(filepath.Join(root, tarDataFileName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return "", err
+	}
+	defer mf.Close()
+	mfz := gzip.NewWriter(mf)
+	defer mfz.Close()
+
+	inflatedLayerData, err := archive.DecompressStream(layerData)
+	if err != nil {
+		return "", err
+	}
+	defer inflatedLayerData.Close()
+	digester := digest.Canonical.New()
+	rdr, err := asm.NewInputTarStream(io.TeeReader(inflatedLayerData, digester.Hash()), storage.NewJSONPacker(mfz), nil)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(ioutil.Discard, rdr); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
+// lazyLayer returns the lazily pulled layer cacheID, or nil if the layer is
+// not lazily pulled.
+func (graph *Graph) lazyLayer(cacheID string) *lazyLayer {
+	graph.lazyMutex.Lock()
+	defer graph.lazyMutex.Unlock()
+	return graph.lazyLayers[cacheID]
+}
+
+// resumeLazyLayer resumes fetching the blob dgst of the layer chainID with
+// open, from the given repository and endpoint, if the layer is lazily pulled
+// and its fetch was stopped or gave up.
+func (graph *Graph) resumeLazyLayer(chainID, dgst digest.Digest, repository, endpoint string, open openBlobFunc) {
+	cacheID, err := graph.getCacheID(chainID)
+	if err != nil {
+		return
+	}
+	graph.lazyMutex.Lock()
+	defer graph.lazyMutex.Unlock()
+	l := graph.lazyLayers[cacheID]
+	if l == nil || l.blob.state.Digest != dgst || (l.blob.hasSource() && !l.stopped) {
+		return
+	}
+	logrus.Debugf("Resuming the fetch of lazily pulled blob %s", dgst)
+	l.stopped = false
+	l.blob.setOrigin(repository, endpoint)
+	l.blob.setSource(open)
+	graph.startLazyFetch(l)
+}
+
+// stoppedLazyBlobs returns the state of the blobs of the lazily pulled layers
+// whose fetch was stopped or gave up.
+func (graph *Graph) stoppedLazyBlobs() []lazyState {
+	graph.lazyMutex.Lock()
+	defer graph.lazyMutex.Unlock()
+	var states []lazyState
+	for _, l := range graph.lazyLayers {
+		if l.blob.hasSource() && !l.stopped {
+			continue
+		}
+		l.blob.mu.Lock()
+		states = append(states, l.blob.state)
+		l.blob.mu.Unlock()
+	}
+	return states
+}
+
+// unmountLazyLayer stops fetching the blob of the layer cacheID and unmounts
+// it, if the layer is lazily pulled. The blob is removed with remove, or kept
+// for when the daemon restarts.
+func (graph *Graph) unmountLazyLayer(cacheID string, remove bool) {
+	graph.lazyMutex.Lock()
+	l := graph.lazyLayers[cacheID]
+	delete(graph.lazyLayers, cacheID)
+	var done chan struct{}
+	if l != nil {
+		done = l.done
+	}
+	graph.lazyMutex.Unlock()
+	if l == nil {
+		return
+	}
+
+	close(l.stop)
+	<-done
+	if err := l.server.Unmount(); err != nil {
+		logrus.Errorf("Failed to unmount lazily pulled layer %s: %v", cacheID, err)
+	}
+	<-l.server.Done()
+	if remove {
+		l.blob.remove()
+		return
+	}
+	if err := l.blob.save(); err != nil {
+		logrus.Errorf("Failed to save the state of lazily pulled layer %s: %v", cacheID, err)
+	}
+	l.blob.f.Close()
+}
+
+// removeDriverLayer removes the layer cacheID from the graph driver.
+func (graph *Graph) removeDriverLayer(cacheID string) {
+	graph.unmountLazyLayer(cacheID, true)
+	graph.driver.Remove(cacheID)
+}
+
+// Cleanup unmounts the lazily pulled layers, keeping what was fetched of their
+// blobs for when the daemon restarts.
+func (graph *Graph) Cleanup() {
+	graph.lazyMutex.Lock()
+	var cacheIDs []string
+	for cacheID := range graph.lazyLayers {
+		cacheIDs = append(cacheIDs, cacheID)
+	}
+	graph.lazyMutex.Unlock()
+	for _, cacheID := range cacheIDs {
+		graph.unmountLazyLayer(cacheID, false)
+	}
+}
+
+// restoreLazyLayers applies the complete blobs of lazily pulled layers to the
+// layers, and mounts the layers whose blobs are incomplete or could not be
+// applied again. The tag store resumes the fetch of these blobs.
+func (graph *Graph) restoreLazyLayers() error {
+	root := filepath.Join(graph.root, lazyDirName)
+	dir, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range dir {
+		cacheID := fi.Name()
+		if err := graph.restoreLazyLayer(filepath.Join(root, cacheID)); err != nil {
+			logrus.Errorf("Failed to restore lazily pulled layer %s: %v", cacheID, err)
+		}
+	}
+	return nil
+}
+
+func (graph *Graph) restoreLazyLayer(root string) error {
+	cacheID := filepath.Base(root)
+	b, err := loadLazyBlob(root)
+	if err != nil || b.state.ChainID == "" || !graph.layerExists(b.state.ChainID) {
+		// The layer was not registered, or was removed.
+		if b != nil {
+			b.f.Close()
+		}
+		return os.RemoveAll(root)
+	}
+
+	var applyErr error
+	if b.state.Missing == 0 {
+		applyErr = graph.applyLazyBlob(cacheID, b)
+		if applyErr == nil {
+			b.remove()
+			return nil
+		}
+		// The layer is served from the blob again until it can be applied.
+		logrus.Errorf("Failed to apply lazily pulled blob %s to layer %s: %v", b.state.Digest, cacheID, applyErr)
+	}
+
+	driver, ok := graph.lazyDriver()
+	if !ok {
+		b.f.Close()
+		return fmt.Errorf("%s does not support lazy pulls", graph.driver)
+	}
+	index, err := readLazyIndex(b)
+	if err != nil {
+		b.f.Close()
+		return err
+	}
+	if applyErr == errLazyCorrupted {
+		// The blob is fetched again when the image is pulled again.
+		logrus.Errorf("Lazily pulled blob %s is corrupted, it has to be pulled again", b.state.Digest)
+		b.reset()
+	}
+	if err := graph.mountLazyLayer(driver, cacheID, b, index); err != nil {
+		b.f.Close()
+		return err
+	}
+	return nil
+}
+
+// applyLazyBlob applies the complete blob b to the layer cacheID, like a
+// downloaded blob.
+func (graph *Graph) applyLazyBlob(cacheID string, b *lazyBlob) error {
+	if err := b.verify(); err != nil {
+		logrus.Debug(err)
+		return errLazyCorrupted
+	}
+	tmp, err := graph.mktemp("")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	_, diffID, err := graph.disassembleAndApplyTarLayer(cacheID, b.state.ParentCacheID, io.NewSectionReader(b.f, 0, b.state.Size), tmp)
+	if err != nil {
+		return err
+	}
+	if diffID != b.state.DiffID {
+		return fmt.Errorf("layer %s has diff ID %s instead of %s", b.state.Digest, diffID, b.state.DiffID)
+	}
+	logrus.Debugf("Applied lazily pulled blob %s to layer %s", b.state.Digest, cacheID)
+	return os.Rename(filepath.Join(tmp, tarDataFileName), filepath.Join(graph.layerRoot(b.state.ChainID), tarDataFileName))
+}
@@ -0,0 +1,454 @@
+package graph
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+)
+
+// testLazyDriver mounts lazy layers on the directories of the vfs driver. As
+// these only hold the files of the lazy layer, it only supports lazy layers
+// without a parent.
+type testLazyDriver struct {
+	graphdriver.Driver
+	home string
+}
+
+func (d *testLazyDriver) DiffPath(id string) (string, archive.WhiteoutFormat, error) {
+	return filepath.Join(d.home, "vfs", "dir", id), archive.AUFSWhiteoutFormat, nil
+}
+
+// tempLazyGraph returns a graph supporting lazy pulls in a new directory.
+func tempLazyGraph(t *testing.T) (*Graph, graphdriver.Driver) {
+	if os.Getuid() != 0 {
+		t.Skip("Lazy pulls require root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("FUSE is not supported")
+	}
+	tmp, err := ioutil.TempDir("", "docker-graph-lazy-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vfs, err := graphdriver.GetDriver("vfs", tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := &testLazyDriver{Driver: vfs, home: tmp}
+	graph, err := NewGraph(tmp, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph, driver
+}
+
+func TestRegisterLazy(t *testing.T) {
+	graph, driver := tempLazyGraph(t)
+	defer nukeGraph(graph)
+
+	blob, diffID, indexDigest := indexedLayer(t, 4<<20)
+	s := newBlobServer(blob)
+	defer s.Close()
+	dgst, err := digest.FromBytes(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := graph.newLazyBlob(dgst, int64(len(blob)), indexDigest, s.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := readLazyIndex(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := graph.registerLazy(v1ImageDescriptor{&image.Image{}}, b, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.LayerID != diffID {
+		t.Fatalf("Expected diff ID %s, got %s", diffID, img.LayerID)
+	}
+
+	// A container runs on the files of the layer.
+	cacheID, err := graph.GraphDriverID(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Create("container", cacheID, nil); err != nil {
+		t.Fatal(err)
+	}
+	rootfs, err := driver.Get("container", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(rootfs, "data"))
+	driver.Put("container")
+	driver.Remove("container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4<<20 {
+		t.Fatalf("Expected 4MB of data, got %d bytes", len(data))
+	}
+
+	// The layer has the same tar stream as a downloaded one once the blob
+	// is fetched.
+	chainID, err := graph.getImageLayer(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if _, err := os.Stat(filepath.Join(graph.layerRoot(chainID), tarDataFileName)); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal("The blob was not fetched in the background")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	arch, err := graph.TarLayer(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarDiffID, err := digest.FromReader(arch)
+	arch.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tarDiffID != diffID {
+		t.Fatalf("Expected diff ID %s, got %s", diffID, tarDiffID)
+	}
+
+	// The complete blob is applied to the layer when the daemon restarts.
+	graph.Cleanup()
+	graph, err = NewGraph(graph.root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.lazyLayer(cacheID) != nil {
+		t.Fatal("Expected the layer not to be lazy anymore")
+	}
+	if dir, err := ioutil.ReadDir(filepath.Join(graph.root, lazyDirName)); err != nil || len(dir) != 0 {
+		t.Fatalf("Expected the blob to be removed, got %v: %v", dir, err)
+	}
+	dir, _, err := driver.(graphdriver.LazyDriver).DiffPath(cacheID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := ioutil.ReadFile(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(applied, data) {
+		t.Fatal("Unexpected contents of the applied layer")
+	}
+
+	if err := graph.Delete(img.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreIncompleteLazyLayer(t *testing.T) {
+	graph, driver := tempLazyGraph(t)
+	defer nukeGraph(graph)
+
+	blob, _, indexDigest := indexedLayer(t, 4<<20)
+	s := newBlobServer(blob)
+	defer s.Close()
+	dgst, err := digest.FromBytes(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The registry goes away before the blob is fetched.
+	b, err := graph.newLazyBlob(dgst, int64(len(blob)), indexDigest, s.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := readLazyIndex(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.setSource(nil)
+	b.setOrigin("foo/bar", "https://registry.example.com")
+	img, err := graph.registerLazy(v1ImageDescriptor{&image.Image{}}, b, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheID, err := graph.GraphDriverID(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The layer is mounted again when the daemon restarts, and its fetch
+	// is resumed from the repository it was pulled from.
+	graph.Cleanup()
+	graph, err = NewGraph(graph.root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer graph.Cleanup()
+	l := graph.lazyLayer(cacheID)
+	if l == nil {
+		t.Fatal("Expected the layer to be mounted")
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(l.mountpoint, "data")); err == nil {
+		t.Fatal("Expected the layer not to be readable without the registry")
+	}
+	chainID, err := graph.getImageLayer(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := graph.stoppedLazyBlobs()
+	if len(stopped) != 1 {
+		t.Fatalf("Expected 1 stopped blob, got %d", len(stopped))
+	}
+	if st := stopped[0]; st.Digest != dgst || st.ChainID != chainID || st.Repository != "foo/bar" || st.Endpoint != "https://registry.example.com" {
+		t.Fatalf("Unexpected state of the stopped blob: %+v", st)
+	}
+	graph.resumeLazyLayer(chainID, dgst, "foo/baz", "https://mirror.example.com", s.open)
+	if stopped := graph.stoppedLazyBlobs(); len(stopped) != 0 {
+		t.Fatalf("Expected no stopped blob after resuming, got %d", len(stopped))
+	}
+	if l.blob.state.Repository != "foo/baz" || l.blob.state.Endpoint != "https://mirror.example.com" {
+		t.Fatalf("Expected the origin of the resumed fetch to be recorded, got %s at %s", l.blob.state.Repository, l.blob.state.Endpoint)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(l.mountpoint, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4<<20 {
+		t.Fatalf("Expected 4MB of data, got %d bytes", len(data))
+	}
+
+	// Deleting the image unmounts the layer and removes its blob.
+	if err := graph.Delete(img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if graph.lazyLayer(cacheID) != nil {
+		t.Fatal("Expected the layer to be unmounted")
+	}
+	if _, err := os.Stat(b.root); !os.IsNotExist(err) {
+		t.Fatalf("Expected the blob to be removed, got %v", err)
+	}
+}
+
+func TestRestoreLazyLayerApplyFailure(t *testing.T) {
+	graph, driver := tempLazyGraph(t)
+	defer nukeGraph(graph)
+
+	blob, _, indexDigest := indexedLayer(t, 4<<20)
+	s := newBlobServer(blob)
+	defer s.Close()
+	dgst, err := digest.FromBytes(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := graph.newLazyBlob(dgst, int64(len(blob)), indexDigest, s.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := readLazyIndex(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := graph.registerLazy(v1ImageDescriptor{&image.Image{}}, b, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheID, err := graph.GraphDriverID(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-graph.lazyLayer(cacheID).done
+	graph.Cleanup()
+
+	// The complete blob cannot be applied, its layer has another diff ID.
+	b, err = loadLazyBlob(b.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.state.DiffID = digest.Digest("sha256:" + strings.Repeat("0", 64))
+	if err := b.save(); err != nil {
+		t.Fatal(err)
+	}
+	b.f.Close()
+
+	// The layer is served from the blob again instead of being left empty.
+	graph, err = NewGraph(graph.root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer graph.Cleanup()
+	l := graph.lazyLayer(cacheID)
+	if l == nil {
+		t.Fatal("Expected the layer to be mounted again")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(l.mountpoint, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 4<<20 {
+		t.Fatalf("Expected 4MB of data, got %d bytes", len(data))
+	}
+	if _, err := os.Stat(b.root); err != nil {
+		t.Fatalf("Expected the blob to be kept: %v", err)
+	}
+
+	if err := graph.Delete(img.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLazyLayerCorrupted(t *testing.T) {
+	graph, _ := tempLazyGraph(t)
+	defer nukeGraph(graph)
+
+	blob, _, indexDigest := indexedLayer(t, 1<<20)
+	s := newBlobServer(blob)
+	defer s.Close()
+	// The blob fetched does not match the digest in the manifest.
+	dgst, err := digest.FromBytes([]byte("corrupted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := graph.newLazyBlob(dgst, int64(len(blob)), indexDigest, s.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := readLazyIndex(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := graph.registerLazy(v1ImageDescriptor{&image.Image{}}, b, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer graph.Delete(img.ID)
+	cacheID, err := graph.GraphDriverID(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID, err := graph.getImageLayer(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := graph.lazyLayer(cacheID)
+	if l == nil {
+		t.Fatal("Expected the layer to be mounted")
+	}
+	waitLazyFetch := func() bool {
+		graph.lazyMutex.Lock()
+		done := l.done
+		graph.lazyMutex.Unlock()
+		<-done
+		graph.lazyMutex.Lock()
+		defer graph.lazyMutex.Unlock()
+		return l.stopped
+	}
+
+	// The blob is dropped, and the layer is not completed.
+	if !waitLazyFetch() {
+		t.Fatal("Expected the fetch of the corrupted blob to stop")
+	}
+	if b.state.Missing == 0 {
+		t.Fatal("Expected the corrupted blob to be dropped")
+	}
+	if _, err := os.Stat(filepath.Join(graph.layerRoot(chainID), tarDataFileName)); !os.IsNotExist(err) {
+		t.Fatalf("Expected the layer not to be completed, got %v", err)
+	}
+
+	// The next pull of the image fetches the blob again, and the layer
+	// still serves its files.
+	requests := s.requests()
+	graph.resumeLazyLayer(chainID, dgst, "", "", s.open)
+	waitLazyFetch()
+	if s.requests() == requests {
+		t.Fatal("Expected the blob to be fetched again")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(l.mountpoint, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1<<20 {
+		t.Fatalf("Expected 1MB of data, got %d bytes", len(data))
+	}
+}
+
+func TestLazyLayerFetchGaveUp(t *testing.T) {
+	graph, _ := tempLazyGraph(t)
+	defer nukeGraph(graph)
+	defer func(delay time.Duration) { lazyRetryDelay = delay }(lazyRetryDelay)
+	lazyRetryDelay = time.Millisecond
+
+	blob, _, indexDigest := indexedLayer(t, 1<<20)
+	s := newBlobServer(blob)
+	defer s.Close()
+	dgst, err := digest.FromBytes(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := graph.newLazyBlob(dgst, int64(len(blob)), indexDigest, s.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := readLazyIndex(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The registry fails every fetch after the index.
+	var attempts int
+	b.setSource(func(offset int64) (io.ReadCloser, error) {
+		attempts++
+		return nil, errors.New("registry unavailable")
+	})
+	img, err := graph.registerLazy(v1ImageDescriptor{&image.Image{}}, b, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer graph.Delete(img.ID)
+	cacheID, err := graph.GraphDriverID(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainID, err := graph.getImageLayer(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := graph.lazyLayer(cacheID)
+	if l == nil {
+		t.Fatal("Expected the layer to be mounted")
+	}
+	waitLazyFetch := func() {
+		graph.lazyMutex.Lock()
+		done := l.done
+		graph.lazyMutex.Unlock()
+		<-done
+	}
+
+	waitLazyFetch()
+	graph.lazyMutex.Lock()
+	stopped := l.stopped
+	graph.lazyMutex.Unlock()
+	if !stopped || attempts != maxDownloadAttempts {
+		t.Fatalf("Expected the fetch to give up after %d attempts, got %d", maxDownloadAttempts, attempts)
+	}
+
+	// The next pull of the image restarts the fetch, though the blob
+	// still has a source.
+	graph.resumeLazyLayer(chainID, dgst, "", "", s.open)
+	waitLazyFetch()
+	if _, err := os.Stat(filepath.Join(graph.layerRoot(chainID), tarDataFileName)); err != nil {
+		t.Fatalf("Expected the blob to be fetched: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package graph
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/tarindex"
+)
+
+// blobServer is a stand-in for a registry serving a blob, with range
+// requests.
+type blobServer struct {
+	*httptest.Server
+	blob []byte
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newBlobServer(blob []byte) *blobServer {
+	s := &blobServer{blob: blob}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(s.blob))
+	}))
+	return s
+}
+
+// open opens the blob at offset, like a blob in a registry.
+func (s *blobServer) open(offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// requests returns the number of requests of the blob.
+func (s *blobServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.ranges)
+}
+
+// indexedLayer returns an indexed layer holding the file "data" of size
+// bytes, its diff ID and the digest of its index.
+func indexedLayer(t *testing.T, size int) ([]byte, digest.Digest, digest.Digest) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "data", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(size)})
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	tw.Write(data)
+	tw.Close()
+	diffID, err := digest.FromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blob bytes.Buffer
+	indexDigest, err := tarindex.Compress(&blob, &buf, gzip.DefaultCompression, 64<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob.Bytes(), diffID, indexDigest
+}
+
+func TestLazyBlob(t *testing.T) {
+	graph, _ := tempGraph(t)
+	defer nukeGraph(graph)
+
+	blob, diffID, indexDigest := indexedLayer(t, 4<<20)
+	s := newBlobServer(blob)
+	defer s.Close()
+	dgst, err := digest.FromBytes(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := graph.newLazyBlob(dgst, int64(len(blob)), indexDigest, s.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.remove()
+
+	// Only the end of the blob is fetched for the index.
+	index, err := readLazyIndex(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.DiffID != diffID {
+		t.Fatalf("Expected diff ID %s, got %s", diffID, index.DiffID)
+	}
+	if b.state.Missing == 0 {
+		t.Fatal("Expected the blob to be partially fetched")
+	}
+	requests := s.requests()
+	if _, err := tarindex.ReadChunk(b, index.Entries[0].Chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if s.requests() != requests+1 {
+		t.Fatalf("Expected a single request for a chunk, got %d", s.requests()-requests)
+	}
+
+	if err := b.fetchAll(make(chan struct{})); err != nil {
+		t.Fatal(err)
+	}
+	if b.state.Missing != 0 {
+		t.Fatalf("Expected the blob to be fetched, %d blocks are missing", b.state.Missing)
+	}
+	if err := b.verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state is kept, but the blob cannot be fetched anymore once loaded.
+	if err := b.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadLazyBlob(b.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.f.Close()
+	if loaded.state.Missing != 0 || loaded.hasSource() {
+		t.Fatalf("Unexpected loaded blob %+v", loaded.state)
+	}
+	loaded.reset()
+	if _, err := loaded.ReadAt(make([]byte, 10), 0); err != errLazyNoSource {
+		t.Fatalf("Expected errLazyNoSource, got %v", err)
+	}
+	if filepath.Base(filepath.Dir(b.root)) != lazyDirName {
+		t.Fatalf("Unexpected blob directory %s", b.root)
+	}
+}
+
+func TestLazyIndexDigest(t *testing.T) {
+	graph, _ := tempGraph(t)
+	defer nukeGraph(graph)
+
+	blob, _, indexDigest := indexedLayer(t, 1<<20)
+	s := newBlobServer(blob)
+	defer s.Close()
+	dgst, err := digest.FromBytes(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is only trusted with the digest from the manifest.
+	for _, other := range []digest.Digest{"", dgst} {
+		b, err := graph.newLazyBlob(dgst, int64(len(blob)), other, s.open)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = readLazyIndex(b)
+		b.remove()
+		if err == nil {
+			t.Fatalf("Expected the index to be refused with digest %q", other)
+		}
+	}
+
+	// Pushes record the digest of the index in the v1Compatibility of the
+	// layer.
+	img := createTestImage(graph, t)
+	if err := graph.SetLayerIndexDigest(img.ID, indexDigest); err != nil {
+		t.Fatal(err)
+	}
+	data, err := graph.GetV1CompatibilityConfig(img.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dgst := layerIndexDigest(data); dgst != indexDigest {
+		t.Fatalf("Expected index digest %s, got %s in %s", indexDigest, dgst, data)
+	}
+	if err := graph.SetLayerIndexDigest(img.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = graph.GetV1CompatibilityConfig(img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if dgst := layerIndexDigest(data); dgst != "" {
+		t.Fatalf("Expected the index digest to be removed, got %s", dgst)
+	}
+}
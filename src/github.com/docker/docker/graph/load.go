@@ -4,6 +4,7 @@ package graph
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -16,7 +17,8 @@ import (
 )
 
 // Loads a set of images into the repository. This is the complementary of ImageExport.
-// The input stream is an uncompressed tar ball containing images and metadata.
+// The input stream is an uncompressed tar ball containing images and metadata,
+// in the legacy layout or an OCI image layout.
 func (s *TagStore) Load(inTar io.ReadCloser, outStream io.Writer) error {
 	tmpImageDir, err := ioutil.TempDir("", "docker-import-")
 	if err != nil {
@@ -41,20 +43,32 @@ func (s *TagStore) Load(inTar io.ReadCloser, outStream io.Writer) error {
 	if err := chrootarchive.Untar(inTar, repoDir, &archive.TarOptions{ExcludePatterns: excludes}); err != nil {
 		return err
 	}
+	if isOCILayout(repoDir) {
+		return s.loadOCILayout(repoDir, images, outStream)
+	}
 
 	dirs, err := ioutil.ReadDir(repoDir)
 	if err != nil {
 		return err
 	}
 
+	// loaded maps the IDs of the images in the tarball to their IDs in the
+	// graph, which differ for images saved by older versions.
+	loaded := make(map[string]string)
 	for _, d := range dirs {
 		if d.IsDir() {
-			if err := s.recursiveLoad(d.Name(), tmpImageDir); err != nil {
+			if _, err := s.recursiveLoad(d.Name(), tmpImageDir, loaded); err != nil {
+				s.checkFailedLoad(loaded, images)
 				return err
 			}
 		}
 	}
 
+	// The images are only tagged once they passed the signature policy.
+	if err := s.checkLoadedSignatures(loaded, images); err != nil {
+		return err
+	}
+
 	reposJSONFile, err := os.Open(filepath.Join(tmpImageDir, "repo", "repositories"))
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -71,6 +85,9 @@ func (s *TagStore) Load(inTar io.ReadCloser, outStream io.Writer) error {
 
 	for imageName, tagMap := range repositories {
 		for tag, address := range tagMap {
+			if id, ok := loaded[address]; ok {
+				address = id
+			}
 			if err := s.SetLoad(imageName, tag, address, true, outStream); err != nil {
 				return err
 			}
@@ -80,56 +97,164 @@ func (s *TagStore) Load(inTar io.ReadCloser, outStream io.Writer) error {
 	return nil
 }
 
-func (s *TagStore) recursiveLoad(address, tmpImageDir string) error {
-	if _, err := s.LookupImage(address); err != nil {
-		logrus.Debugf("Loading %s", address)
+// recursiveLoad loads the image stored at address in the tarball, and its
+// parents, and returns the ID of the image in the graph.
+func (s *TagStore) recursiveLoad(address, tmpImageDir string, loaded map[string]string) (string, error) {
+	if id, ok := loaded[address]; ok {
+		return id, nil
+	}
+	if img, err := s.graph.GetByV1ID(address); err == nil {
+		loaded[address] = img.ID
+		return img.ID, nil
+	}
+	logrus.Debugf("Loading %s", address)
 
-		imageJson, err := ioutil.ReadFile(filepath.Join(tmpImageDir, "repo", address, "json"))
-		if err != nil {
-			logrus.Debugf("Error reading json: %v", err)
-			return err
-		}
+	imageJson, err := ioutil.ReadFile(filepath.Join(tmpImageDir, "repo", address, "json"))
+	if err != nil {
+		logrus.Debugf("Error reading json: %v", err)
+		return "", err
+	}
 
-		layer, err := os.Open(filepath.Join(tmpImageDir, "repo", address, "layer.tar"))
-		if err != nil {
-			logrus.Debugf("Error reading embedded tar: %v", err)
-			return err
+	layer, err := os.Open(filepath.Join(tmpImageDir, "repo", address, "layer.tar"))
+	if err != nil {
+		logrus.Debugf("Error reading embedded tar: %v", err)
+		return "", err
+	}
+	defer layer.Close()
+	img, err := image.NewImgJSON(imageJson)
+	if err != nil {
+		logrus.Debugf("Error unmarshalling json: %v", err)
+		return "", err
+	}
+	if err := image.ValidateID(img.ID); err != nil {
+		logrus.Debugf("Error validating ID: %v", err)
+		return "", err
+	}
+
+	// ensure no two downloads of the same layer happen at the same time
+	if c, err := s.poolAdd("pull", "layer:"+img.ID); err != nil {
+		if c != nil {
+			logrus.Debugf("Image (id: %s) load is already running, waiting: %v", img.ID, err)
+			<-c
+			return s.recursiveLoad(address, tmpImageDir, loaded)
 		}
-		img, err := image.NewImgJSON(imageJson)
-		if err != nil {
-			logrus.Debugf("Error unmarshalling json: %v", err)
-			return err
+
+		return "", err
+	}
+
+	defer s.poolRemove("pull", "layer:"+img.ID)
+
+	var parentID string
+	if img.Parent != "" {
+		if parentID, err = s.recursiveLoad(img.Parent, tmpImageDir, loaded); err != nil {
+			return "", err
 		}
-		if err := image.ValidateID(img.ID); err != nil {
-			logrus.Debugf("Error validating ID: %v", err)
-			return err
+	}
+	newImg, err := s.graph.Register(v1CompatibilityDescriptor{parentID, imageJson}, layer)
+	if err != nil {
+		return "", err
+	}
+	// Record the image right away, so the signature policy also applies
+	// to it if the rest of the load fails.
+	loaded[address] = newImg.ID
+	if newImg.ID != img.ID {
+		// Keep the JSON the image was saved with, so it can be pushed to a
+		// registry with its original ID.
+		if err := s.graph.SetV1CompatibilityConfig(newImg.ID, imageJson); err != nil {
+			return "", err
 		}
+	}
+	if err := s.loadSignatures(newImg.ID, filepath.Join(tmpImageDir, "repo", address)); err != nil {
+		return "", err
+	}
+	logrus.Debugf("Completed processing %s", address)
 
-		// ensure no two downloads of the same layer happen at the same time
-		if c, err := s.poolAdd("pull", "layer:"+img.ID); err != nil {
-			if c != nil {
-				logrus.Debugf("Image (id: %s) load is already running, waiting: %v", img.ID, err)
-				<-c
-				return nil
-			}
+	return newImg.ID, nil
+}
 
-			return err
+// loadSignatures stores the detached signatures saved in dir with the image
+// id. Signatures of images saved by older versions, whose ID changed, do not
+// apply and are skipped.
+func (s *TagStore) loadSignatures(id, dir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "signatures"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var signatures []json.RawMessage
+	if err := json.Unmarshal(data, &signatures); err != nil {
+		return err
+	}
+	for _, signature := range signatures {
+		if _, err := s.addSignature(id, signature); err != nil {
+			logrus.Debugf("Skipping signature of %s: %v", id, err)
 		}
+	}
+	return nil
+}
 
-		defer s.poolRemove("pull", "layer:"+img.ID)
+// checkFailedLoad enforces the signature policy on the images registered by a
+// load that failed, so the images it would have refused do not stay in the
+// graph.
+func (s *TagStore) checkFailedLoad(loaded map[string]string, existing map[string]*image.Image) {
+	if err := s.checkLoadedSignatures(loaded, existing); err != nil {
+		logrus.Debugf("Deleted the images of the failed load: %v", err)
+	}
+}
 
-		if img.Parent != "" {
-			if !s.graph.Exists(img.Parent) {
-				if err := s.recursiveLoad(img.Parent, tmpImageDir); err != nil {
-					return err
-				}
-			}
+// checkLoadedSignatures enforces the signature policy on the images loaded
+// that were not in the graph before. Only the images without children need to
+// be signed, as the ID of an image covers its parents. The loaded images are
+// deleted if one of them is refused.
+func (s *TagStore) checkLoadedSignatures(loaded map[string]string, existing map[string]*image.Image) error {
+	if !s.requireSignature {
+		return nil
+	}
+	added := make(map[string]*image.Image)
+	parents := make(map[string]struct{})
+	for _, id := range loaded {
+		if _, ok := existing[id]; ok {
+			continue
 		}
-		if err := s.graph.Register(v1ImageDescriptor{img}, layer); err != nil {
+		img, err := s.graph.Get(id)
+		if err != nil {
 			return err
 		}
+		added[id] = img
+		parents[img.Parent] = struct{}{}
 	}
-	logrus.Debugf("Completed processing %s", address)
 
-	return nil
+	var refused error
+	for id := range added {
+		if _, ok := parents[id]; ok {
+			continue
+		}
+		if err := s.CheckSignature(id); err != nil {
+			refused = fmt.Errorf("Refusing to load image %s: %v", id, err)
+			break
+		}
+	}
+	if refused == nil {
+		return nil
+	}
+
+	// Delete the children before their parents.
+	for len(added) > 0 {
+		parents := make(map[string]struct{})
+		for _, img := range added {
+			parents[img.Parent] = struct{}{}
+		}
+		for id := range added {
+			if _, ok := parents[id]; ok {
+				continue
+			}
+			if err := s.graph.Delete(id); err != nil {
+				logrus.Errorf("Error deleting refused image %s: %v", id, err)
+			}
+			delete(added, id)
+		}
+	}
+	return refused
 }
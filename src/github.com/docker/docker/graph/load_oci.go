@@ -0,0 +1,246 @@
+// +build linux windows
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/registry"
+)
+
+// isOCILayout returns whether dir holds an OCI image layout.
+func isOCILayout(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ociLayoutFileName))
+	return err == nil
+}
+
+// loadOCILayout loads the images of the OCI image layout in dir, and tags the
+// ones referenced by name in its index.
+func (s *TagStore) loadOCILayout(dir string, existing map[string]*image.Image, outStream io.Writer) error {
+	var layout ociLayout
+	data, err := ioutil.ReadFile(filepath.Join(dir, ociLayoutFileName))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return fmt.Errorf("invalid OCI image layout: %v", err)
+	}
+	if !strings.HasPrefix(layout.ImageLayoutVersion, "1.") {
+		return fmt.Errorf("unsupported OCI image layout version %s", layout.ImageLayoutVersion)
+	}
+	var index ociIndex
+	if data, err = ioutil.ReadFile(filepath.Join(dir, ociIndexFileName)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("invalid OCI image index: %v", err)
+	}
+
+	blobs := ociBlobs{dir}
+	loaded := make(map[string]string)
+	refs := make(map[string]string)
+	for _, desc := range index.Manifests {
+		if desc.MediaType != mediaTypeOCIManifest {
+			logrus.Debugf("Skipping %s of media type %s", desc.Digest, desc.MediaType)
+			continue
+		}
+		var m ociManifest
+		if err := blobs.readJSON(desc.Digest, &m); err != nil {
+			s.checkFailedLoad(loaded, existing)
+			return err
+		}
+		id, err := s.loadOCIManifest(blobs, &m, loaded)
+		if err != nil {
+			s.checkFailedLoad(loaded, existing)
+			return err
+		}
+		if ref := desc.Annotations[annotationRefName]; ref != "" {
+			refs[ref] = id
+		}
+	}
+
+	// The images are only tagged once they passed the signature policy.
+	if err := s.checkLoadedSignatures(loaded, existing); err != nil {
+		return err
+	}
+
+	for ref, id := range refs {
+		repoName, tag := parsers.ParseRepositoryTag(ref)
+		if tag == "" {
+			tag = DEFAULTTAG
+		}
+		if err := s.SetLoad(registry.NormalizeLocalName(repoName), tag, id, true, outStream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadOCIManifest loads the image of manifest m with its parents, and returns
+// its ID. The images loaded are added to loaded.
+func (s *TagStore) loadOCIManifest(blobs ociBlobs, m *ociManifest, loaded map[string]string) (string, error) {
+	var (
+		id  string
+		err error
+	)
+	switch m.Config.MediaType {
+	case mediaTypeImageConfig:
+		id, err = s.loadOCIImageConfigs(blobs, m, loaded)
+	case mediaTypeOCIConfig:
+		id, err = s.loadOCIConfig(blobs, m, loaded)
+	default:
+		return "", fmt.Errorf("unsupported image config media type %s", m.Config.MediaType)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if dgst := m.Annotations[annotationSignatures]; dgst != "" {
+		var signatures []json.RawMessage
+		if err := blobs.readJSON(digest.Digest(dgst), &signatures); err != nil {
+			return "", err
+		}
+		for _, signature := range signatures {
+			if _, err := s.addSignature(id, signature); err != nil {
+				logrus.Debugf("Skipping signature of %s: %v", id, err)
+			}
+		}
+	}
+	return id, nil
+}
+
+// loadOCIImageConfigs loads an image exported by ImageExport, whose config is
+// the one of the image in the graph. The configs of its parents are found
+// from the parent_id of each config, and they are registered with the same
+// IDs.
+func (s *TagStore) loadOCIImageConfigs(blobs ociBlobs, m *ociManifest, loaded map[string]string) (string, error) {
+	var configs [][]byte
+	for dgst := m.Config.Digest; dgst != ""; {
+		config, err := blobs.read(dgst)
+		if err != nil {
+			return "", err
+		}
+		var c struct {
+			ParentID digest.Digest `json:"parent_id"`
+		}
+		if err := json.Unmarshal(config, &c); err != nil {
+			return "", err
+		}
+		configs = append([][]byte{config}, configs...)
+		dgst = c.ParentID
+	}
+	if len(configs) != len(m.Layers) {
+		return "", fmt.Errorf("image %s has %d layers, the manifest %d", m.Config.Digest.Hex(), len(configs), len(m.Layers))
+	}
+
+	var parentID string
+	for i, config := range configs {
+		dgst, err := image.StrongID(config)
+		if err != nil {
+			return "", err
+		}
+		id := dgst.Hex()
+		if !s.graph.Exists(id) {
+			img, err := s.registerOCILayer(blobs, parentID, config, m.Layers[i])
+			if err != nil {
+				return "", err
+			}
+			loaded[dgst.String()] = img.ID
+			if img.ID != id {
+				return "", fmt.Errorf("image %s was loaded as %s, its layer does not match its config", id, img.ID)
+			}
+		}
+		loaded[dgst.String()] = id
+		parentID = id
+	}
+	return parentID, nil
+}
+
+// loadOCIConfig loads an image with an OCI image config, exported by other
+// tools, as one image per layer. The config of the image applies to the last
+// one.
+func (s *TagStore) loadOCIConfig(blobs ociBlobs, m *ociManifest, loaded map[string]string) (string, error) {
+	var config ociImageConfig
+	if err := blobs.readJSON(m.Config.Digest, &config); err != nil {
+		return "", err
+	}
+	if len(config.RootFS.DiffIDs) != len(m.Layers) {
+		return "", fmt.Errorf("image %s has %d layers, the manifest %d", m.Config.Digest, len(config.RootFS.DiffIDs), len(m.Layers))
+	}
+
+	var (
+		parentID string
+		chainID  digest.Digest
+	)
+	for i, layer := range m.Layers {
+		v1 := map[string]interface{}{
+			"created":      config.Created,
+			"os":           config.OS,
+			"architecture": config.Architecture,
+		}
+		if i == len(m.Layers)-1 {
+			v1["author"] = config.Author
+			v1["config"] = config.Config
+		}
+		v1Config, err := json.Marshal(v1)
+		if err != nil {
+			return "", err
+		}
+		img, err := s.registerOCILayer(blobs, parentID, v1Config, layer)
+		if err != nil {
+			return "", err
+		}
+		loaded[img.ID] = img.ID
+		chainID = image.ChainID(chainID, config.RootFS.DiffIDs[i])
+		if imgChainID, err := s.graph.getImageLayer(img.ID); err != nil {
+			return "", err
+		} else if imgChainID != chainID {
+			return "", fmt.Errorf("layer %s does not match diff ID %s", layer.Digest, config.RootFS.DiffIDs[i])
+		}
+		parentID = img.ID
+	}
+	return parentID, nil
+}
+
+// registerOCILayer registers the image with config v1Config and the layer
+// blob layer on top of the image parentID.
+func (s *TagStore) registerOCILayer(blobs ociBlobs, parentID string, v1Config []byte, layer ociDescriptor) (*image.Image, error) {
+	if !strings.HasPrefix(layer.MediaType, mediaTypeOCILayer) && !strings.HasPrefix(layer.MediaType, mediaTypeDockerLayer) {
+		return nil, fmt.Errorf("unsupported layer media type %s", layer.MediaType)
+	}
+	p, err := blobs.path(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The layer is verified before it is registered.
+	verifier, err := digest.NewDigestVerifier(layer.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(verifier, f); err != nil {
+		return nil, err
+	}
+	if !verifier.Verified() {
+		return nil, fmt.Errorf("layer %s does not match its digest", layer.Digest)
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	return s.graph.Register(v1CompatibilityDescriptor{parentID, v1Config}, f)
+}
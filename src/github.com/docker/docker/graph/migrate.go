@@ -0,0 +1,350 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/fileutils"
+)
+
+// errNotInDriver is returned when an image of the old graph was created with
+// another graph driver.
+var errNotInDriver = errors.New("layer is not in the graph driver")
+
+// Migrate moves the images of a graph in the layout used before image IDs
+// were content addressable, stored at root, into the graph. The layers of the
+// images are kept in the graph driver under their old IDs. Only the images
+// whose layers are in the graph driver are migrated, as the old graph is
+// shared by all graph drivers; root is removed once it is empty. Images that
+// cannot be migrated are logged and left in place.
+//
+// The layer of an image whose content is already in the graph under another
+// cache ID is kept in the graph driver, as the layers of its children and of
+// the containers created from it are on top of it, and removed with the last
+// of them. HoldMigratedLayer must be called for the containers before
+// PruneMigratedLayers removes the layers without children.
+//
+// Migrate returns the IDs of the migrated images, keyed by their old IDs.
+func (graph *Graph) Migrate(root string) (map[string]string, error) {
+	migrated := make(map[string]string)
+	dir, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return migrated, nil
+		}
+		return nil, err
+	}
+
+	failed := make(map[string]error)
+	for _, v := range dir {
+		if !v.IsDir() || image.ValidateID(v.Name()) != nil {
+			continue
+		}
+		if _, err := graph.migrateImage(root, v.Name(), migrated, failed); err != nil && err != errNotInDriver {
+			logrus.Errorf("Failed to migrate image %s: %v", v.Name(), err)
+		}
+	}
+	if len(migrated) > 0 {
+		logrus.Infof("Migrated %d images to content addressable IDs", len(migrated))
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, "_tmp")); err != nil {
+		return nil, err
+	}
+	// The old graph still holds the images of other graph drivers if it
+	// is not empty.
+	if err := os.Remove(root); err != nil {
+		logrus.Debugf("Keeping %s: %v", root, err)
+	}
+	return migrated, nil
+}
+
+// migrateImage migrates the image id of the old graph at root after its
+// parents and returns its new ID.
+func (graph *Graph) migrateImage(root, id string, migrated map[string]string, failed map[string]error) (string, error) {
+	if newID, ok := migrated[id]; ok {
+		return newID, nil
+	}
+	if err, ok := failed[id]; ok {
+		return "", err
+	}
+	newID, err := graph.migrateImageDir(root, id, migrated, failed)
+	if err != nil {
+		failed[id] = err
+		return "", err
+	}
+	migrated[id] = newID
+	return newID, nil
+}
+
+func (graph *Graph) migrateImageDir(root, id string, migrated map[string]string, failed map[string]error) (string, error) {
+	if !graph.driver.Exists(id) {
+		return "", errNotInDriver
+	}
+	oldRoot := filepath.Join(root, id)
+
+	oldJSON, err := ioutil.ReadFile(jsonPath(oldRoot))
+	if err != nil {
+		return "", err
+	}
+	img, err := image.NewImgJSON(oldJSON)
+	if err != nil {
+		return "", err
+	}
+	// Images pulled from a registry by content address keep the JSON
+	// they were pulled with, the JSON of other images has their old ID.
+	v1Compatibility, err := ioutil.ReadFile(filepath.Join(oldRoot, v1CompatibilityFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		v1Compatibility = oldJSON
+	}
+
+	var (
+		parent        *image.Image
+		parentID      string
+		parentChainID digest.Digest
+	)
+	if img.Parent != "" {
+		parentID, err = graph.migrateImage(root, img.Parent, migrated, failed)
+		if err != nil {
+			return "", fmt.Errorf("Could not migrate parent image %s: %v", img.Parent, err)
+		}
+		if parent, err = graph.Get(parentID); err != nil {
+			return "", err
+		}
+		if parentChainID, err = graph.getImageLayer(parentID); err != nil {
+			return "", err
+		}
+	}
+
+	diffID, err := graph.migrateDiffID(oldRoot, id, img.Parent)
+	if err != nil {
+		return "", err
+	}
+	chainID := image.ChainID(parentChainID, diffID)
+
+	var l *rootLayer
+	if graph.layerExists(chainID) {
+		// The layer of the image stays in the graph driver while layers
+		// or containers on top of it use it.
+		if l, err = graph.getLayer(chainID); err != nil {
+			return "", err
+		}
+		l.keep = true
+	} else {
+		tmp, err := graph.mktemp("")
+		if err != nil {
+			return "", fmt.Errorf("mktemp failed: %s", err)
+		}
+		defer os.RemoveAll(tmp)
+		l = &rootLayer{
+			chainID: chainID,
+			diffID:  diffID,
+			cacheID: id,
+			size:    -1,
+			root:    tmp,
+			keep:    true,
+		}
+		if buf, err := ioutil.ReadFile(filepath.Join(oldRoot, layersizeFileName)); err == nil {
+			if l.size, err = strconv.ParseInt(string(buf), 10, 64); err != nil {
+				l.size = -1
+			}
+		}
+		if _, err := fileutils.CopyFile(filepath.Join(oldRoot, tarDataFileName), filepath.Join(tmp, tarDataFileName)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	newImg, err := graph.register(v1CompatibilityDescriptor{parentID, v1Compatibility}, parent, l)
+	if err != nil {
+		return "", err
+	}
+	if err := graph.SetV1CompatibilityConfig(newImg.ID, v1Compatibility); err != nil {
+		return "", err
+	}
+	if checksum, err := ioutil.ReadFile(filepath.Join(oldRoot, digestFileName)); err == nil {
+		if dgst, err := digest.ParseDigest(string(checksum)); err == nil {
+			if err := graph.SetLayerDigest(newImg.ID, dgst); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := graph.trackMigratedLayer(id, img.Parent, l); err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(oldRoot); err != nil {
+		logrus.Errorf("Failed to remove %s: %v", oldRoot, err)
+	}
+	logrus.Debugf("Migrated image %s to %s", id, newImg.ID)
+	return newImg.ID, nil
+}
+
+// migrateDiffID computes the diff ID of the layer id in the graph driver. The
+// tar stream is reassembled from the tar-split metadata of the old graph if
+// there is any, so that it matches the stream the layer was pulled with.
+func (graph *Graph) migrateDiffID(oldRoot, id, parent string) (digest.Digest, error) {
+	rdr, err := graph.assembleTar(id, filepath.Join(oldRoot, tarDataFileName))
+	if err != nil {
+		logrus.Debugf("Computing the diff ID of %s with the traditional differ", id)
+		if rdr, err = graph.driver.Diff(id, parent); err != nil {
+			return "", err
+		}
+	}
+	defer rdr.Close()
+	digester := digest.Canonical.New()
+	if _, err := io.Copy(digester.Hash(), rdr); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
+// migratedLayer is a layer of the graph driver kept by a migration although
+// no layer of the graph uses it.
+type migratedLayer struct {
+	// Children are the chain IDs of the layers, the cache IDs of the
+	// migrated layers and the IDs of the containers on top of the layer
+	// in the graph driver.
+	Children []string
+}
+
+// trackMigratedLayer records the layer id of the graph driver as a migrated
+// layer if the layer l of its image has another cache ID, and the layer of
+// the image as a child of the layer parent if it is a migrated layer.
+func (graph *Graph) trackMigratedLayer(id, parent string, l *rootLayer) error {
+	graph.storeMutex.Lock()
+	defer graph.storeMutex.Unlock()
+
+	child := l.chainID.String()
+	if l.cacheID != id {
+		if _, ok := graph.migratedLayers[id]; !ok {
+			graph.migratedLayers[id] = &migratedLayer{}
+			if err := graph.saveMigratedLayer(id); err != nil {
+				return err
+			}
+		}
+		child = id
+	}
+	if parent == "" {
+		return nil
+	}
+	return graph.addMigratedChild(parent, child)
+}
+
+// HoldMigratedLayer keeps the layer cacheID of the graph driver while the
+// container containerID uses it, if it is a migrated layer.
+func (graph *Graph) HoldMigratedLayer(cacheID, containerID string) {
+	graph.storeMutex.Lock()
+	defer graph.storeMutex.Unlock()
+	if err := graph.addMigratedChild(cacheID, containerID); err != nil {
+		logrus.Errorf("Failed to hold migrated layer %s for container %s: %v", cacheID, containerID, err)
+	}
+}
+
+// ReleaseMigratedLayers removes the container containerID from the children
+// of the migrated layers, and the layers it was the last child of.
+func (graph *Graph) ReleaseMigratedLayers(containerID string) {
+	graph.storeMutex.Lock()
+	defer graph.storeMutex.Unlock()
+	graph.releaseMigratedLayers(containerID)
+}
+
+// PruneMigratedLayers removes the migrated layers without children.
+func (graph *Graph) PruneMigratedLayers() {
+	graph.storeMutex.Lock()
+	defer graph.storeMutex.Unlock()
+	for cacheID, m := range graph.migratedLayers {
+		if len(m.Children) == 0 {
+			graph.removeMigratedLayer(cacheID)
+		}
+	}
+}
+
+// addMigratedChild adds child to the children of the layer cacheID if it is
+// a migrated layer. It must be called with the store locked.
+func (graph *Graph) addMigratedChild(cacheID, child string) error {
+	m, ok := graph.migratedLayers[cacheID]
+	if !ok {
+		return nil
+	}
+	for _, c := range m.Children {
+		if c == child {
+			return nil
+		}
+	}
+	m.Children = append(m.Children, child)
+	return graph.saveMigratedLayer(cacheID)
+}
+
+// releaseMigratedLayers removes child from the children of the migrated
+// layers, and the layers it was the last child of. It must be called with the
+// store locked.
+func (graph *Graph) releaseMigratedLayers(child string) {
+	for cacheID, m := range graph.migratedLayers {
+		for i, c := range m.Children {
+			if c != child {
+				continue
+			}
+			m.Children = append(m.Children[:i], m.Children[i+1:]...)
+			if len(m.Children) == 0 {
+				graph.removeMigratedLayer(cacheID)
+			} else if err := graph.saveMigratedLayer(cacheID); err != nil {
+				logrus.Errorf("Failed to save migrated layer %s: %v", cacheID, err)
+			}
+			break
+		}
+	}
+}
+
+// removeMigratedLayer removes the migrated layer cacheID from the graph
+// driver, and releases its parent. It must be called with the store locked.
+func (graph *Graph) removeMigratedLayer(cacheID string) {
+	logrus.Debugf("Removing migrated layer %s", cacheID)
+	delete(graph.migratedLayers, cacheID)
+	graph.removeDriverLayer(cacheID)
+	if err := os.Remove(filepath.Join(graph.root, migratedDirName, cacheID)); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("Failed to remove migrated layer %s: %v", cacheID, err)
+	}
+	graph.releaseMigratedLayers(cacheID)
+}
+
+func (graph *Graph) saveMigratedLayer(cacheID string) error {
+	data, err := json.Marshal(graph.migratedLayers[cacheID])
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(graph.root, migratedDirName, cacheID), data, 0600)
+}
+
+func (graph *Graph) restoreMigratedLayers() error {
+	root := filepath.Join(graph.root, migratedDirName)
+	dir, err := ioutil.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, fi := range dir {
+		data, err := ioutil.ReadFile(filepath.Join(root, fi.Name()))
+		if err != nil {
+			return err
+		}
+		m := &migratedLayer{}
+		if err := json.Unmarshal(data, m); err != nil {
+			logrus.Errorf("Failed to restore migrated layer %s: %v", fi.Name(), err)
+			continue
+		}
+		graph.migratedLayers[fi.Name()] = m
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/docker/utils"
+)
+
+// The formats of the tar balls images are exported to.
+const (
+	// ExportFormatDocker is the legacy layout of one directory per image
+	// with its json, layer.tar and VERSION, and a repositories file.
+	ExportFormatDocker = "docker"
+	// ExportFormatOCI is an OCI image layout, of blobs addressed by digest,
+	// manifests and an index.
+	ExportFormatOCI = "oci"
+)
+
+const (
+	ociLayoutFileName = "oci-layout"
+	ociIndexFileName  = "index.json"
+	ociBlobsDirName   = "blobs"
+	ociLayoutVersion  = "1.0.0"
+
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer    = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeDockerLayer = "application/vnd.docker.image.rootfs.diff.tar"
+	// mediaTypeImageConfig is the config of an image in the graph, whose
+	// digest is the ID of the image, and which refers to the config of its
+	// parent by digest.
+	mediaTypeImageConfig = "application/vnd.docker.container.image.v1+json"
+
+	// annotationRefName is the reference of an image in the index.
+	annotationRefName = "org.opencontainers.image.ref.name"
+	// annotationSignatures refers to the blob of the detached signatures
+	// of an image, as a JSON array.
+	annotationSignatures = "com.docker.image.signatures"
+)
+
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ociImageConfig holds the fields of an OCI image config used to load images
+// exported by other tools.
+type ociImageConfig struct {
+	Created      *time.Time       `json:"created,omitempty"`
+	Author       string           `json:"author,omitempty"`
+	Architecture string           `json:"architecture"`
+	OS           string           `json:"os"`
+	Config       *json.RawMessage `json:"config,omitempty"`
+	RootFS       struct {
+		Type    string          `json:"type"`
+		DiffIDs []digest.Digest `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// ociLayerMediaType returns the media type of the layers compressed with
+// compression.
+func ociLayerMediaType(compression archive.Compression) (string, error) {
+	switch compression {
+	case archive.Uncompressed:
+		return mediaTypeOCILayer, nil
+	case archive.Gzip:
+		return mediaTypeOCILayer + "+gzip", nil
+	case archive.Zstd:
+		return mediaTypeOCILayer + "+zstd", nil
+	}
+	return "", fmt.Errorf("OCI image layouts do not support %s compression", compression.Name())
+}
+
+// ociBlobs reads and writes the blobs of the image layout in dir.
+type ociBlobs struct {
+	dir string
+}
+
+func (b ociBlobs) path(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(b.dir, ociBlobsDirName, string(dgst.Algorithm()), dgst.Hex()), nil
+}
+
+// write stores a blob written by fn, and returns its descriptor.
+func (b ociBlobs) write(mediaType string, fn func(io.Writer) error) (ociDescriptor, error) {
+	dir := filepath.Join(b.dir, ociBlobsDirName, string(digest.Canonical))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return ociDescriptor{}, err
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	defer os.Remove(f.Name())
+
+	digester := digest.Canonical.New()
+	err = fn(io.MultiWriter(f, digester.Hash()))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	desc := ociDescriptor{MediaType: mediaType, Digest: digester.Digest(), Size: fi.Size()}
+	p, err := b.path(desc.Digest)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return desc, os.Rename(f.Name(), p)
+}
+
+func (b ociBlobs) writeBytes(mediaType string, data []byte) (ociDescriptor, error) {
+	return b.write(mediaType, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (b ociBlobs) writeJSON(mediaType string, v interface{}) (ociDescriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return b.writeBytes(mediaType, data)
+}
+
+// read returns the content of the blob dgst, after verifying its digest.
+func (b ociBlobs) read(dgst digest.Digest) ([]byte, error) {
+	p, err := b.path(dgst)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := digest.NewDigestVerifier(dgst)
+	if err != nil {
+		return nil, err
+	}
+	verifier.Write(data)
+	if !verifier.Verified() {
+		return nil, fmt.Errorf("blob %s does not match its digest", dgst)
+	}
+	return data, nil
+}
+
+func (b ociBlobs) readJSON(dgst digest.Digest, v interface{}) error {
+	data, err := b.read(dgst)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// exportOCILayout writes the images to an OCI image layout in dir, with a
+// manifest per image. The manifests of the images in repositories are
+// referenced by name in the index.
+func (s *TagStore) exportOCILayout(dir string, images []string, repositories map[string]Repository, config *ImageExportConfig) error {
+	layerMediaType, err := ociLayerMediaType(config.Compression)
+	if err != nil {
+		return err
+	}
+	var (
+		blobs     = ociBlobs{dir}
+		layers    = make(map[string]ociDescriptor)
+		manifests = make(map[string]ociDescriptor)
+		named     = make(map[string]bool)
+		index     = ociIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex}
+	)
+
+	// manifest writes the manifest of the image id, with the config and
+	// layer of the image and of its parents.
+	manifest := func(id string) (ociDescriptor, error) {
+		if desc, ok := manifests[id]; ok {
+			return desc, nil
+		}
+		m := ociManifest{SchemaVersion: 2, MediaType: mediaTypeOCIManifest}
+		for n := id; n != ""; {
+			img, err := s.graph.Get(n)
+			if err != nil {
+				return ociDescriptor{}, err
+			}
+			configDesc, err := s.exportOCIConfig(blobs, img.ID)
+			if err != nil {
+				return ociDescriptor{}, err
+			}
+			if n == id {
+				m.Config = configDesc
+			}
+			layer, ok := layers[img.ID]
+			if !ok {
+				layer, err = blobs.write(layerMediaType, func(w io.Writer) error {
+					cw, err := archive.CompressStreamLevel(ioutils.NopWriteCloser(w), config.Compression, config.CompressionLevel)
+					if err != nil {
+						return err
+					}
+					err = s.ImageTarLayer(img.ID, cw)
+					if closeErr := cw.Close(); err == nil {
+						err = closeErr
+					}
+					return err
+				})
+				if err != nil {
+					return ociDescriptor{}, err
+				}
+				layers[img.ID] = layer
+			}
+			m.Layers = append([]ociDescriptor{layer}, m.Layers...)
+			n = img.Parent
+		}
+		if signatures, err := s.exportOCISignatures(blobs, id); err != nil {
+			return ociDescriptor{}, err
+		} else if signatures != "" {
+			m.Annotations = map[string]string{annotationSignatures: signatures.String()}
+		}
+		desc, err := blobs.writeJSON(mediaTypeOCIManifest, m)
+		if err != nil {
+			return ociDescriptor{}, err
+		}
+		manifests[id] = desc
+		return desc, nil
+	}
+
+	// Sort the references so that the index does not depend on the order
+	// of the maps. Digests are referenced as repo@digest, as on the command
+	// line.
+	refs := make(map[string]string)
+	var names []string
+	for repoName, repo := range repositories {
+		for tag, id := range repo {
+			ref := repoName + ":" + tag
+			if utils.DigestReference(tag) {
+				ref = repoName + "@" + tag
+			}
+			refs[ref] = id
+			names = append(names, ref)
+		}
+	}
+	sort.Strings(names)
+	for _, ref := range names {
+		id := refs[ref]
+		desc, err := manifest(id)
+		if err != nil {
+			return err
+		}
+		desc.Annotations = map[string]string{annotationRefName: ref}
+		index.Manifests = append(index.Manifests, desc)
+		named[id] = true
+	}
+	for _, name := range images {
+		img, err := s.LookupImage(name)
+		if err != nil || img == nil {
+			return fmt.Errorf("No such image: %s", name)
+		}
+		if named[img.ID] {
+			continue
+		}
+		desc, err := manifest(img.ID)
+		if err != nil {
+			return err
+		}
+		index.Manifests = append(index.Manifests, desc)
+		named[img.ID] = true
+	}
+
+	data, err := json.Marshal(ociLayout{ImageLayoutVersion: ociLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ociLayoutFileName), data, 0644); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(index); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, ociIndexFileName), data, 0644)
+}
+
+// exportOCIConfig writes the config of the image id, whose digest is the ID
+// of the image.
+func (s *TagStore) exportOCIConfig(blobs ociBlobs, id string) (ociDescriptor, error) {
+	config, err := s.graph.RawJSON(id)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return blobs.writeBytes(mediaTypeImageConfig, config)
+}
+
+// exportOCISignatures writes the detached signatures of the image id, and
+// returns the digest of their blob, or nothing when the image is not signed.
+func (s *TagStore) exportOCISignatures(blobs ociBlobs, id string) (digest.Digest, error) {
+	signatures, err := s.graph.Signatures(id)
+	if err != nil || len(signatures) == 0 {
+		return "", err
+	}
+	var list []json.RawMessage
+	for _, signature := range signatures {
+		list = append(list, signature)
+	}
+	desc, err := blobs.writeJSON("application/json", list)
+	return desc.Digest, err
+}
@@ -0,0 +1,273 @@
+package graph
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/utils"
+	"github.com/docker/libtrust"
+)
+
+// ociTar returns a tar ball of the files, mapping names to contents.
+func ociTar(t *testing.T, files map[string][]byte) io.ReadCloser {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return ioutil.NopCloser(&buf)
+}
+
+func ociBlob(t *testing.T, files map[string][]byte, mediaType string, data []byte) ociDescriptor {
+	dgst, err := digest.FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["blobs/sha256/"+dgst.Hex()] = data
+	return ociDescriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+func ociJSONBlob(t *testing.T, files map[string][]byte, mediaType string, v interface{}) ociDescriptor {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ociBlob(t, files, mediaType, data)
+}
+
+func TestExportLoadOCILayout(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddSignature(testOfficialImageName, signImage(t, testOfficialImageID, key)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := store.ImageExport(&ImageExportConfig{
+		Names:            []string{testOfficialImageName},
+		Outstream:        &buf,
+		Compression:      archive.Gzip,
+		CompressionLevel: archive.DefaultCompressionLevel,
+		Format:           ExportFormatOCI,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var index ociIndex
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == "repositories" || hdr.Name == testOfficialImageID+"/layer.tar" {
+			t.Fatalf("Unexpected %s in an OCI image layout", hdr.Name)
+		}
+		if hdr.Name == ociIndexFileName {
+			if err := json.NewDecoder(tr).Decode(&index); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Annotations[annotationRefName] != testOfficialImageName+":"+DEFAULTTAG {
+		t.Fatalf("Unexpected index %+v", index)
+	}
+
+	tmp2, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp2)
+	store2 := mkTestTagStore(tmp2, t)
+	defer store2.graph.driver.Cleanup()
+	if err := store2.graph.Delete(testOfficialImageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store2.Load(ioutil.NopCloser(&buf), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	img, err := store2.LookupImage(testOfficialImageName)
+	if err != nil || img == nil {
+		t.Fatalf("Expected %s to be loaded: %v", testOfficialImageName, err)
+	}
+	if img.ID != testOfficialImageID {
+		t.Fatalf("Expected the image to be loaded as %s, got %s", testOfficialImageID, img.ID)
+	}
+	if keyIDs, err := store2.signedBy(img.ID); err != nil || len(keyIDs) != 1 || keyIDs[0] != key.KeyID() {
+		t.Fatalf("Expected the signature to be loaded, got %v: %v", keyIDs, err)
+	}
+}
+
+func TestExportLoadOCILayoutDigest(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	var buf bytes.Buffer
+	if err := store.ImageExport(&ImageExportConfig{
+		Names:            []string{testPrivateImageName},
+		Outstream:        &buf,
+		Compression:      archive.Gzip,
+		CompressionLevel: archive.DefaultCompressionLevel,
+		Format:           ExportFormatOCI,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var index ociIndex
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == ociIndexFileName {
+			if err := json.NewDecoder(tr).Decode(&index); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var refs []string
+	for _, desc := range index.Manifests {
+		refs = append(refs, desc.Annotations[annotationRefName])
+	}
+	expected := []string{
+		testPrivateImageName + ":" + DEFAULTTAG,
+		testPrivateImageName + "@" + testPrivateImageDigest,
+	}
+	if len(refs) != len(expected) || refs[0] != expected[0] || refs[1] != expected[1] {
+		t.Fatalf("Expected the references %v, got %v", expected, refs)
+	}
+
+	tmp2, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp2)
+	store2 := mkTestTagStore(tmp2, t)
+	defer store2.graph.driver.Cleanup()
+	if _, err := store2.Delete(testPrivateImageName, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store2.graph.Delete(testPrivateImageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store2.Load(ioutil.NopCloser(&buf), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := store2.Get(testPrivateImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo) != 2 || repo[DEFAULTTAG] != testPrivateImageID || repo[testPrivateImageDigest] != testPrivateImageID {
+		t.Fatalf("Expected %s to be loaded with its digest, got %v", testPrivateImageName, repo)
+	}
+}
+
+func TestLoadOCILayoutConfig(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerData, err := ioutil.ReadAll(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffID, err := digest.FromBytes(layerData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout := func(diffID digest.Digest) map[string][]byte {
+		files := map[string][]byte{
+			ociLayoutFileName: []byte(`{"imageLayoutVersion": "1.0.0"}`),
+		}
+		config := map[string]interface{}{
+			"architecture": "amd64",
+			"os":           "linux",
+			"config":       map[string]interface{}{"Env": []string{"FOO=bar"}},
+			"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []digest.Digest{diffID}},
+		}
+		manifest := ociManifest{
+			SchemaVersion: 2,
+			Config:        ociJSONBlob(t, files, mediaTypeOCIConfig, config),
+			Layers:        []ociDescriptor{ociBlob(t, files, mediaTypeOCILayer, layerData)},
+		}
+		desc := ociJSONBlob(t, files, mediaTypeOCIManifest, manifest)
+		desc.Annotations = map[string]string{annotationRefName: "example.com/foo:1.0"}
+		index, err := json.Marshal(ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{desc}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[ociIndexFileName] = index
+		return files
+	}
+
+	if err := store.Load(ociTar(t, layout(testPrivateImageDigest)), ioutil.Discard); err == nil {
+		t.Fatal("Expected a layer that does not match its diff ID to be refused")
+	}
+	// The layer blob is replaced by another archive with the same files
+	files := layout(diffID)
+	layerDigest, err := digest.FromBytes(layerData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["blobs/sha256/"+layerDigest.Hex()] = append(append([]byte{}, layerData...), make([]byte, 512)...)
+	if err := store.Load(ociTar(t, files), ioutil.Discard); err == nil || !strings.Contains(err.Error(), "does not match its digest") {
+		t.Fatalf("Expected a layer that does not match its digest to be refused, got %v", err)
+	}
+	if err := store.Load(ociTar(t, layout(diffID)), ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	img, err := store.LookupImage("example.com/foo:1.0")
+	if err != nil || img == nil {
+		t.Fatalf("Expected example.com/foo:1.0 to be loaded: %v", err)
+	}
+	if img.Config == nil || len(img.Config.Env) != 1 || img.Config.Env[0] != "FOO=bar" {
+		t.Fatalf("Unexpected config %+v", img.Config)
+	}
+}
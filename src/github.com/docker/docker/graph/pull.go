@@ -15,6 +15,10 @@ type ImagePullConfig struct {
 	MetaHeaders map[string][]string
 	AuthConfig  *cliconfig.AuthConfig
 	OutStream   io.Writer
+
+	// ProgressSummary writes the aggregate progress of the layers
+	// periodically, for clients that understand it.
+	ProgressSummary bool
 }
 
 type Puller interface {
@@ -91,8 +95,11 @@ func (s *TagStore) Pull(image string, tag string, imagePullConfig *ImagePullConf
 			lastErr = err
 			continue
 		}
-		if fallback, err := puller.Pull(tag); err != nil {
-			if fallback {
+		fallback, err := puller.Pull(tag)
+		s.registryService.ReportMirror(endpoint, err)
+		if err != nil {
+			// Any error of a mirror falls back to the next endpoint.
+			if fallback || endpoint.Mirror {
 				if _, ok := err.(registry.ErrNoSupport); !ok {
 					// Because we found an error that's not ErrNoSupport, discard all subsequent ErrNoSupport errors.
 					discardNoSupportErrors = true
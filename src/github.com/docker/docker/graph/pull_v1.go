@@ -3,14 +3,16 @@ package graph
 import (
 	"errors"
 	"fmt"
-	"net"
+	"io"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/registry/client/transport"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
 	"github.com/docker/docker/pkg/stringid"
@@ -25,6 +27,8 @@ type v1Puller struct {
 	sf       *streamformatter.StreamFormatter
 	repoInfo *registry.RepositoryInfo
 	session  *registry.Session
+	// tracker keeps the progress of the layers of the repository.
+	tracker *progressreader.Tracker
 }
 
 func (p *v1Puller) Pull(tag string) (fallback bool, err error) {
@@ -69,6 +73,12 @@ func (p *v1Puller) pullRepository(askedTag string) error {
 	out := p.config.OutStream
 	out.Write(p.sf.FormatStatus("", "Pulling repository %s", p.repoInfo.CanonicalName))
 
+	var summaryOut io.Writer
+	if p.config.ProgressSummary {
+		summaryOut = out
+	}
+	p.tracker = progressreader.NewTracker(p.sf, summaryOut)
+
 	repoData, err := p.session.GetRepositoryData(p.repoInfo.RemoteName)
 	if err != nil {
 		if strings.Contains(err.Error(), "HTTP code: 404") {
@@ -124,7 +134,12 @@ func (p *v1Puller) pullRepository(askedTag string) error {
 	imgIDs := []string{}
 	sessionID := p.session.ID()
 	defer func() {
-		p.graph.Release(sessionID, imgIDs...)
+		// The images were retained by the IDs they were registered with.
+		for _, id := range imgIDs {
+			if img, err := p.graph.GetByV1ID(id); err == nil {
+				p.graph.Release(sessionID, img.ID)
+			}
+		}
 	}()
 	for _, imgData := range repoData.ImgList {
 		downloadImage := func(img *registry.ImgData) {
@@ -159,7 +174,6 @@ func (p *v1Puller) pullRepository(askedTag string) error {
 			defer p.poolRemove("pull", "img:"+img.ID)
 
 			// we need to retain it until tagging
-			p.graph.Retain(sessionID, img.ID)
 			imgIDs = append(imgIDs, img.ID)
 
 			out.Write(p.sf.FormatProgress(stringid.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s", img.Tag, p.repoInfo.CanonicalName), nil))
@@ -221,7 +235,11 @@ func (p *v1Puller) pullRepository(askedTag string) error {
 		if askedTag != "" && tag != askedTag {
 			continue
 		}
-		if err := p.Tag(p.repoInfo.LocalName, tag, id, true); err != nil {
+		img, err := p.graph.GetByV1ID(id)
+		if err != nil {
+			return err
+		}
+		if err := p.Tag(p.repoInfo.LocalName, tag, img.ID, true); err != nil {
 			return err
 		}
 	}
@@ -230,110 +248,182 @@ func (p *v1Puller) pullRepository(askedTag string) error {
 	if len(askedTag) > 0 {
 		requestedTag = utils.ImageReference(p.repoInfo.LocalName, askedTag)
 	}
+	p.tracker.Flush(out)
 	WriteStatus(requestedTag, out, p.sf, layersDownloaded)
 	return nil
 }
 
-func (p *v1Puller) pullImage(imgID, endpoint string, token []string) (bool, error) {
+// v1Layer is a layer of an image pulled from a v1 registry.
+type v1Layer struct {
+	id      string
+	imgJSON []byte
+	// img is the image of the layer if it is already in the graph.
+	img      *image.Image
+	download *blobDownload
+	err      chan error
+}
+
+func (p *v1Puller) pullImage(imgID, endpoint string, token []string) (layersDownloaded bool, err error) {
 	history, err := p.session.GetRemoteHistory(imgID, endpoint)
 	if err != nil {
 		return false, err
 	}
 	out := p.config.OutStream
 	out.Write(p.sf.FormatProgress(stringid.TruncateID(imgID), "Pulling dependent layers", nil))
-	// FIXME: Try to stream the images?
-	// FIXME: Launch the getRemoteImage() in goroutines
 
-	sessionID := p.session.ID()
-	// As imgID has been retained in pullRepository, no need to retain again
-	p.graph.Retain(sessionID, history[1:]...)
-	defer p.graph.Release(sessionID, history[1:]...)
-
-	layersDownloaded := false
+	// Download the layers that are not in the graph yet in parallel, and
+	// register them in order once they are downloaded.
+	layers := make([]v1Layer, len(history))
+	defer func() {
+		// Release the downloaded layers that were not registered.
+		for i := range layers {
+			l := &layers[i]
+			if l.err != nil {
+				go func() {
+					if err := <-l.err; err == nil {
+						p.downloads.release(v1LayerDownloadKey(l.id), l.download)
+					}
+				}()
+			} else if l.download != nil {
+				p.downloads.release(v1LayerDownloadKey(l.id), l.download)
+			}
+		}
+	}()
 	for i := len(history) - 1; i >= 0; i-- {
-		id := history[i]
-
-		// ensure no two downloads of the same layer happen at the same time
-		if c, err := p.poolAdd("pull", "layer:"+id); err != nil {
-			logrus.Debugf("Image (id: %s) pull is already running, skipping: %v", id, err)
-			<-c
+		l := &layers[i]
+		l.id = history[i]
+		if img, err := p.graph.GetByV1ID(l.id); err == nil {
+			l.img = img
+			continue
 		}
-		defer p.poolRemove("pull", "layer:"+id)
-
-		if !p.graph.Exists(id) {
-			out.Write(p.sf.FormatProgress(stringid.TruncateID(id), "Pulling metadata", nil))
-			var (
-				imgJSON []byte
-				imgSize int
-				err     error
-				img     *image.Image
-			)
-			retries := 5
-			for j := 1; j <= retries; j++ {
-				imgJSON, imgSize, err = p.session.GetRemoteImageJSON(id, endpoint)
-				if err != nil && j == retries {
-					out.Write(p.sf.FormatProgress(stringid.TruncateID(id), "Error pulling dependent layers", nil))
-					return layersDownloaded, err
-				} else if err != nil {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				}
-				img, err = image.NewImgJSON(imgJSON)
-				layersDownloaded = true
-				if err != nil && j == retries {
-					out.Write(p.sf.FormatProgress(stringid.TruncateID(id), "Error pulling dependent layers", nil))
-					return layersDownloaded, fmt.Errorf("Failed to parse json: %s", err)
-				} else if err != nil {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				} else {
-					break
+
+		out.Write(p.tracker.FormatState(stringid.TruncateID(l.id), "Pulling metadata", jsonmessage.StateWaiting))
+		var imgSize int
+		retries := 5
+		for j := 1; j <= retries; j++ {
+			l.imgJSON, imgSize, err = p.session.GetRemoteImageJSON(l.id, endpoint)
+			if err == nil {
+				_, err = image.NewImgJSON(l.imgJSON)
+				if err != nil {
+					err = fmt.Errorf("Failed to parse json: %s", err)
 				}
 			}
+			layersDownloaded = true
+			if err != nil && j == retries {
+				out.Write(p.tracker.FormatState(stringid.TruncateID(l.id), "Error pulling dependent layers", jsonmessage.StateFailed))
+				return layersDownloaded, err
+			} else if err != nil {
+				time.Sleep(time.Duration(j) * 500 * time.Millisecond)
+				continue
+			}
+			break
+		}
 
-			for j := 1; j <= retries; j++ {
-				// Get the layer
-				status := "Pulling fs layer"
-				if j > 1 {
-					status = fmt.Sprintf("Pulling fs layer [retries: %d]", j)
-				}
-				out.Write(p.sf.FormatProgress(stringid.TruncateID(id), status, nil))
-				layer, err := p.session.GetRemoteImageLayer(img.ID, endpoint, int64(imgSize))
-				if uerr, ok := err.(*url.Error); ok {
-					err = uerr.Err
-				}
-				if terr, ok := err.(net.Error); ok && terr.Timeout() && j < retries {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				} else if err != nil {
-					out.Write(p.sf.FormatProgress(stringid.TruncateID(id), "Error pulling dependent layers", nil))
-					return layersDownloaded, err
-				}
-				layersDownloaded = true
-				defer layer.Close()
-
-				err = p.graph.Register(v1ImageDescriptor{img},
-					progressreader.New(progressreader.Config{
-						In:        layer,
-						Out:       out,
-						Formatter: p.sf,
-						Size:      imgSize,
-						NewLines:  false,
-						ID:        stringid.TruncateID(id),
-						Action:    "Downloading",
-					}))
-				if terr, ok := err.(net.Error); ok && terr.Timeout() && j < retries {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				} else if err != nil {
-					out.Write(p.sf.FormatProgress(stringid.TruncateID(id), "Error downloading dependent layers", nil))
-					return layersDownloaded, err
-				} else {
-					break
-				}
+		out.Write(p.tracker.FormatState(stringid.TruncateID(l.id), "Pulling fs layer", jsonmessage.StateWaiting))
+		l.err = make(chan error, 1)
+		go p.downloadLayer(l, endpoint, imgSize)
+	}
+
+	sessionID := p.session.ID()
+	// The image itself stays retained until it is tagged in pullRepository.
+	var retained []string
+	defer func() {
+		p.graph.Release(sessionID, retained...)
+	}()
+
+	var parentID string
+	for i := len(layers) - 1; i >= 0; i-- {
+		l := &layers[i]
+		if l.img == nil {
+			err := <-l.err
+			l.err = nil
+			if err != nil {
+				out.Write(p.tracker.FormatState(stringid.TruncateID(l.id), "Error pulling dependent layers", jsonmessage.StateFailed))
+				return layersDownloaded, err
+			}
+			layersDownloaded = true
+
+			if l.img, err = p.registerLayer(l, parentID); err != nil {
+				out.Write(p.tracker.FormatState(stringid.TruncateID(l.id), "Error downloading dependent layers", jsonmessage.StateFailed))
+				return layersDownloaded, err
 			}
 		}
-		out.Write(p.sf.FormatProgress(stringid.TruncateID(id), "Download complete", nil))
+		parentID = l.img.ID
+		p.graph.Retain(sessionID, parentID)
+		if i > 0 {
+			retained = append(retained, parentID)
+		}
+		out.Write(p.tracker.FormatState(stringid.TruncateID(l.id), "Download complete", jsonmessage.StateComplete))
 	}
 	return layersDownloaded, nil
 }
+
+// downloadLayer downloads the layer l, or waits for another pull downloading
+// it.
+func (p *v1Puller) downloadLayer(l *v1Layer, endpoint string, imgSize int) {
+	out := p.config.OutStream
+	d, err := p.downloads.download(v1LayerDownloadKey(l.id), func(f *os.File, offset int64, attempt int) error {
+		// The registry session resumes interrupted layer downloads
+		// itself, a new attempt starts over.
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+		layer, err := p.session.GetRemoteImageLayer(l.id, endpoint, int64(imgSize))
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		if err != nil {
+			return err
+		}
+		defer layer.Close()
+		_, err = io.Copy(f, progressreader.New(progressreader.Config{
+			In:        layer,
+			Out:       out,
+			Formatter: p.sf,
+			Size:      imgSize,
+			NewLines:  false,
+			ID:        stringid.TruncateID(l.id),
+			Action:    "Downloading",
+			State:     jsonmessage.StateDownloading,
+			Retries:   attempt - 1,
+			Tracker:   p.tracker,
+		}))
+		return err
+	}, func() {
+		out.Write(p.tracker.FormatState(stringid.TruncateID(l.id), "Layer already being pulled by another client. Waiting.", jsonmessage.StateWaiting))
+	})
+	l.download = d
+	l.err <- err
+}
+
+// registerLayer registers the downloaded layer l on top of the image parentID.
+func (p *v1Puller) registerLayer(l *v1Layer, parentID string) (*image.Image, error) {
+	f, err := os.Open(l.download.path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		p.downloads.release(v1LayerDownloadKey(l.id), l.download)
+		l.download = nil
+	}()
+	img, err := p.graph.Register(v1CompatibilityDescriptor{parentID, l.imgJSON}, f)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the JSON of the registry, so the image is pushed with its
+	// original ID.
+	if err := p.graph.SetV1CompatibilityConfig(img.ID, l.imgJSON); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// v1LayerDownloadKey returns the key the download of the v1 layer id is shared
+// by pulls under.
+func v1LayerDownloadKey(id string) string {
+	return "v1layer:" + id
+}
@@ -7,16 +7,18 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"sync"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
+	"github.com/docker/docker/cliconfig"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/tarindex"
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/utils"
 	"golang.org/x/net/context"
@@ -30,11 +32,18 @@ type v2Puller struct {
 	repoInfo  *registry.RepositoryInfo
 	repo      distribution.Repository
 	sessionID string
+	// tracker keeps the progress of the layers of the tag being pulled.
+	tracker *progressreader.Tracker
 }
 
 func (p *v2Puller) Pull(tag string) (fallback bool, err error) {
+	authConfig := p.config.AuthConfig
+	if p.endpoint.AuthConfig != nil {
+		// Mirrors configured with credentials do not get the ones of the pull.
+		authConfig = p.endpoint.AuthConfig
+	}
 	// TODO(tiborvass): was ReceiveTimeout
-	p.repo, err = NewV2Repository(p.repoInfo, p.endpoint, p.config.MetaHeaders, p.config.AuthConfig)
+	p.repo, err = NewV2Repository(p.registryService, p.repoInfo, p.endpoint, p.config.MetaHeaders, authConfig)
 	if err != nil {
 		logrus.Debugf("Error getting v2 registry: %v", err)
 		return true, err
@@ -104,67 +113,20 @@ func (p *v2Puller) pullV2Repository(tag string) (err error) {
 
 // downloadInfo is used to pass information from download to extractor
 type downloadInfo struct {
-	img     contentAddressableDescriptor
-	tmpFile *os.File
-	digest  digest.Digest
-	layer   distribution.ReadSeekCloser
-	size    int64
-	err     chan error
-	out     io.Writer // Download progress is written here.
-}
-
-// contentAddressableDescriptor is used to pass image data from a manifest to the
-// graph.
-type contentAddressableDescriptor struct {
-	id              string
-	parent          string
-	strongID        digest.Digest
-	compatibilityID string
-	config          []byte
+	digest          digest.Digest
 	v1Compatibility []byte
-}
-
-func newContentAddressableImage(v1Compatibility []byte, blobSum digest.Digest, parent digest.Digest) (contentAddressableDescriptor, error) {
-	img := contentAddressableDescriptor{
-		v1Compatibility: v1Compatibility,
-	}
-
-	var err error
-	img.config, err = image.MakeImageConfig(v1Compatibility, blobSum, parent)
-	if err != nil {
-		return img, err
-	}
-	img.strongID, err = image.StrongID(img.config)
-	if err != nil {
-		return img, err
-	}
-
-	unmarshalledConfig, err := image.NewImgJSON(v1Compatibility)
-	if err != nil {
-		return img, err
-	}
-
-	img.compatibilityID = unmarshalledConfig.ID
-	img.id = img.strongID.Hex()
-
-	return img, nil
-}
-
-// ID returns the actual ID to be used for the downloaded image. This may be
-// a computed ID.
-func (img contentAddressableDescriptor) ID() string {
-	return img.id
-}
-
-// Parent returns the parent ID to be used for the image. This may be a
-// computed ID.
-func (img contentAddressableDescriptor) Parent() string {
-	return img.parent
-}
-
-// MarshalConfig renders the image structure into JSON.
-func (img contentAddressableDescriptor) MarshalConfig() ([]byte, error) {
-	return img.config, nil
+	// compatibilityID is the ID of the layer in the registry, under which
+	// progress is reported.
+	compatibilityID string
+	// chainID is the chain ID of the layer if it is already in the graph.
+	chainID  digest.Digest
+	download *blobDownload
+	// lazyBlob is the blob of a layer pulled lazily, mounted with the
+	// index lazyIndex instead of being downloaded.
+	lazyBlob  *lazyBlob
+	lazyIndex *tarindex.Index
+	err       chan error
+	out       io.Writer // Download progress is written here.
 }
 
 type errVerification struct{}
@@ -172,81 +134,196 @@ type errVerification struct{}
 func (errVerification) Error() string { return "verification failed" }
 
 func (p *v2Puller) download(di *downloadInfo) {
-	logrus.Debugf("pulling blob %q to %s", di.digest, di.img.id)
+	logrus.Debugf("pulling blob %q to %s", di.digest, di.compatibilityID)
 
 	out := di.out
+	progressID := stringid.TruncateID(di.compatibilityID)
+	blobs := p.repo.Blobs(context.Background())
 
-	poolKey := "v2img:" + di.img.id
-	if c, err := p.poolAdd("pull", poolKey); err != nil {
-		if c != nil {
-			out.Write(p.sf.FormatProgress(stringid.TruncateID(di.img.id), "Layer already being pulled by another client. Waiting.", nil))
-			<-c
-			out.Write(p.sf.FormatProgress(stringid.TruncateID(di.img.id), "Download complete", nil))
-		} else {
-			logrus.Debugf("Image (id: %s) pull is already running, skipping: %v", di.img.id, err)
+	d, err := p.downloads.download(blobDownloadKey(di.digest), func(f *os.File, offset int64, attempt int) error {
+		if attempt > 1 {
+			out.Write(p.tracker.FormatProgress(progressID, "Retrying", &jsonmessage.JSONProgress{State: jsonmessage.StateRetrying, Retries: attempt - 1}))
 		}
-		di.err <- nil
-		return
-	}
-
-	defer p.poolRemove("pull", poolKey)
-	tmpFile, err := ioutil.TempFile("", "GetImageBlob")
+		return p.downloadBlob(blobs, di.digest, f, offset, attempt-1, out, progressID)
+	}, func() {
+		out.Write(p.tracker.FormatState(progressID, "Layer already being pulled by another client. Waiting.", jsonmessage.StateWaiting))
+	})
 	if err != nil {
+		out.Write(p.tracker.FormatState(progressID, "Download failed", jsonmessage.StateFailed))
 		di.err <- err
 		return
 	}
 
-	blobs := p.repo.Blobs(context.Background())
+	out.Write(p.tracker.FormatState(progressID, "Download complete", jsonmessage.StateDownloaded))
+
+	logrus.Debugf("Downloaded %s to %s", di.digest, d.path)
+	di.download = d
 
-	desc, err := blobs.Stat(context.Background(), di.digest)
+	di.err <- nil
+}
+
+// downloadBlob downloads the blob dgst to f, resuming from offset, after
+// retries failed attempts.
+func (p *v2Puller) downloadBlob(blobs distribution.BlobService, dgst digest.Digest, f *os.File, offset int64, retries int, out io.Writer, progressID string) error {
+	desc, err := blobs.Stat(context.Background(), dgst)
 	if err != nil {
 		logrus.Debugf("Error statting layer: %v", err)
-		di.err <- err
-		return
+		return err
 	}
-	di.size = desc.Size
 
-	layerDownload, err := blobs.Open(context.Background(), di.digest)
+	layerDownload, err := blobs.Open(context.Background(), dgst)
 	if err != nil {
 		logrus.Debugf("Error fetching layer: %v", err)
-		di.err <- err
-		return
+		return err
 	}
 	defer layerDownload.Close()
 
-	verifier, err := digest.NewDigestVerifier(di.digest)
-	if err != nil {
-		di.err <- err
-		return
+	if offset > 0 && offset < desc.Size {
+		if _, err := layerDownload.Seek(offset, os.SEEK_SET); err != nil {
+			logrus.Debugf("Error resuming download of %s: %v", dgst, err)
+			offset = 0
+		}
+	} else {
+		offset = 0
+	}
+	if offset == 0 {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
 	}
 
 	reader := progressreader.New(progressreader.Config{
-		In:        ioutil.NopCloser(io.TeeReader(layerDownload, verifier)),
+		In:        ioutil.NopCloser(layerDownload),
 		Out:       out,
 		Formatter: p.sf,
-		Size:      int(di.size),
+		Size:      int(desc.Size),
+		Current:   int(offset),
 		NewLines:  false,
-		ID:        stringid.TruncateID(di.img.id),
+		ID:        progressID,
 		Action:    "Downloading",
+		State:     jsonmessage.StateDownloading,
+		Retries:   retries,
+		Tracker:   p.tracker,
 	})
-	io.Copy(tmpFile, reader)
+	if _, err := io.Copy(f, reader); err != nil {
+		return err
+	}
 
-	out.Write(p.sf.FormatProgress(stringid.TruncateID(di.img.id), "Verifying Checksum", nil))
+	out.Write(p.tracker.FormatState(progressID, "Verifying Checksum", jsonmessage.StateVerifying))
 
+	verifier, err := digest.NewDigestVerifier(dgst)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	if _, err := io.Copy(verifier, f); err != nil {
+		return err
+	}
 	if !verifier.Verified() {
-		err = fmt.Errorf("filesystem layer verification failed for digest %s", di.digest)
+		// Start the next attempt over.
+		f.Truncate(0)
+		err := fmt.Errorf("filesystem layer verification failed for digest %s", dgst)
 		logrus.Error(err)
-		di.err <- err
-		return
+		return err
 	}
+	return nil
+}
 
-	out.Write(p.sf.FormatProgress(stringid.TruncateID(di.img.id), "Download complete", nil))
+// openLazyBlob returns the blob dgst to pull lazily and its index, which must
+// match indexDigest, or tarindex.ErrNotIndexed if the blob cannot be pulled
+// lazily.
+func (p *v2Puller) openLazyBlob(blobs distribution.BlobService, dgst, indexDigest digest.Digest) (*lazyBlob, *tarindex.Index, error) {
+	if indexDigest == "" {
+		return nil, nil, tarindex.ErrNotIndexed
+	}
+	desc, err := blobs.Stat(context.Background(), dgst)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := p.graph.newLazyBlob(dgst, desc.Size, indexDigest, blobOpener(blobs, dgst))
+	if err != nil {
+		return nil, nil, err
+	}
+	b.setOrigin(p.repoInfo.LocalName, p.endpoint.URL)
+	index, err := readLazyIndex(b)
+	if err != nil {
+		b.remove()
+		return nil, nil, err
+	}
+	return b, index, nil
+}
 
-	logrus.Debugf("Downloaded %s to tempfile %s", di.img.id, tmpFile.Name())
-	di.tmpFile = tmpFile
-	di.layer = layerDownload
+// blobOpener returns a function opening the blob dgst at an offset, with an
+// HTTP range request.
+func blobOpener(blobs distribution.BlobService, dgst digest.Digest) openBlobFunc {
+	return func(offset int64) (io.ReadCloser, error) {
+		rsc, err := blobs.Open(context.Background(), dgst)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := rsc.Seek(offset, os.SEEK_SET); err != nil {
+			rsc.Close()
+			return nil, err
+		}
+		return rsc, nil
+	}
+}
 
-	di.err <- nil
+// resumeLazyPulls resumes fetching the blobs of the lazily pulled layers from
+// the repositories they were pulled from, after the daemon restarts. Blobs of
+// repositories that need credentials are fetched when their images are pulled
+// again.
+func (s *TagStore) resumeLazyPulls() {
+	for _, state := range s.graph.stoppedLazyBlobs() {
+		if state.Repository == "" {
+			continue
+		}
+		blobs, err := s.lazyBlobService(state.Repository, state.Endpoint)
+		if err != nil {
+			logrus.Errorf("Failed to resume the fetch of lazily pulled blob %s from %s: %v", state.Digest, state.Repository, err)
+			continue
+		}
+		s.graph.resumeLazyLayer(state.ChainID, state.Digest, state.Repository, state.Endpoint, blobOpener(blobs, state.Digest))
+	}
+}
+
+// lazyBlobService returns the blobs of the repository at the v2 endpoint URL,
+// without credentials unless the endpoint is a mirror configured with some.
+func (s *TagStore) lazyBlobService(repository, url string) (distribution.BlobService, error) {
+	repoInfo, err := s.registryService.ResolveRepository(repository)
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := s.registryService.LookupPullEndpoints(repoInfo.CanonicalName)
+	if err != nil {
+		return nil, err
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.Version != registry.APIVersion2 || endpoint.URL != url {
+			continue
+		}
+		authConfig := endpoint.AuthConfig
+		if authConfig == nil {
+			authConfig = &cliconfig.AuthConfig{}
+		}
+		repo, err := NewV2Repository(s.registryService, repoInfo, endpoint, nil, authConfig)
+		if err != nil {
+			return nil, err
+		}
+		return repo.Blobs(context.Background()), nil
+	}
+	return nil, fmt.Errorf("endpoint %s is no longer configured", url)
+}
+
+// blobDownloadKey returns the key the download of the blob dgst is shared by
+// pulls under.
+func blobDownloadKey(dgst digest.Digest) string {
+	return "v2layer:" + string(dgst)
 }
 
 func (p *v2Puller) pullV2Tag(tag, taggedName string) (tagUpdated bool, err error) {
@@ -277,11 +354,6 @@ func (p *v2Puller) pullV2Tag(tag, taggedName string) (tagUpdated bool, err error
 		return false, err
 	}
 
-	imgs, err := p.getImageInfos(*verifiedManifest)
-	if err != nil {
-		return false, err
-	}
-
 	// By using a pipeWriter for each of the downloads to write their progress
 	// to, we can avoid an issue where this function returns an error but
 	// leaves behind running download goroutines. By splitting the writer
@@ -311,106 +383,156 @@ func (p *v2Puller) pullV2Tag(tag, taggedName string) (tagUpdated bool, err error
 
 	out.Write(p.sf.FormatStatus(tag, "Pulling from %s", p.repo.Name()))
 
-	downloads := make([]downloadInfo, len(verifiedManifest.FSLayers))
+	var summaryOut io.Writer
+	if p.config.ProgressSummary {
+		summaryOut = pipeWriter
+	}
+	p.tracker = progressreader.NewTracker(p.sf, summaryOut)
 
-	layerIDs := []string{}
-	defer func() {
-		p.graph.Release(p.sessionID, layerIDs...)
-	}()
+	downloads := make([]downloadInfo, len(verifiedManifest.FSLayers))
 
+	// Layers are only downloaded if they are not in the graph yet. As the
+	// chain ID of a layer depends on the layers below it, a layer can be
+	// found in the graph by the diff IDs of the layers pulled before.
+	var (
+		parentChainID digest.Digest
+		reuse         = true
+		blobs         = p.repo.Blobs(context.Background())
+	)
 	for i := len(verifiedManifest.FSLayers) - 1; i >= 0; i-- {
+		d := &downloads[i]
+		d.digest = verifiedManifest.FSLayers[i].BlobSum
+		d.v1Compatibility = []byte(verifiedManifest.History[i].V1Compatibility)
+		img, err := image.NewImgJSON(d.v1Compatibility)
+		if err != nil {
+			return false, err
+		}
+		d.compatibilityID = img.ID
+
+		if reuse {
+			if diffID, err := p.graph.getDiffIDByDigest(d.digest); err == nil {
+				chainID := image.ChainID(parentChainID, diffID)
+				if p.graph.layerExists(chainID) {
+					logrus.Debugf("Layer already exists: %s", chainID)
+					d.chainID = chainID
+					parentChainID = chainID
+					if p.lazyPull {
+						// The fetch of a lazily pulled layer stops when
+						// the daemon restarts.
+						p.graph.resumeLazyLayer(chainID, d.digest, p.repoInfo.LocalName, p.endpoint.URL, blobOpener(blobs, d.digest))
+					}
+					continue
+				}
+			}
+			reuse = false
+		}
 
-		img := imgs[i]
-		downloads[i].img = img
-		downloads[i].digest = verifiedManifest.FSLayers[i].BlobSum
+		if p.lazyPull {
+			if d.lazyBlob, d.lazyIndex, err = p.openLazyBlob(blobs, d.digest, layerIndexDigest(d.v1Compatibility)); err == nil {
+				continue
+			}
+			logrus.Debugf("Cannot pull %s lazily, downloading it: %v", d.digest, err)
+		}
 
-		p.graph.Retain(p.sessionID, img.id)
-		layerIDs = append(layerIDs, img.id)
+		out.Write(p.tracker.FormatState(stringid.TruncateID(d.compatibilityID), "Pulling fs layer", jsonmessage.StateWaiting))
 
-		p.graph.imageMutex.Lock(img.id)
+		d.err = make(chan error, 1)
+		d.out = pipeWriter
+		go p.download(d)
+	}
 
-		// Check if exists
-		if p.graph.Exists(img.id) {
-			if err := p.validateImageInGraph(img.id, imgs, i); err != nil {
-				p.graph.imageMutex.Unlock(img.id)
-				return false, fmt.Errorf("image validation failed: %v", err)
+	defer func() {
+		// Release the downloaded layers that were not registered.
+		for i := range downloads {
+			d := &downloads[i]
+			if d.err != nil {
+				go func() {
+					if err := <-d.err; err == nil {
+						p.downloads.release(blobDownloadKey(d.digest), d.download)
+					}
+				}()
+			} else if d.download != nil {
+				p.downloads.release(blobDownloadKey(d.digest), d.download)
+			} else if d.lazyBlob != nil {
+				d.lazyBlob.remove()
 			}
-			logrus.Debugf("Image already exists: %s", img.id)
-			p.graph.imageMutex.Unlock(img.id)
-			continue
 		}
-		p.graph.imageMutex.Unlock(img.id)
-
-		out.Write(p.sf.FormatProgress(stringid.TruncateID(img.id), "Pulling fs layer", nil))
+	}()
 
-		downloads[i].err = make(chan error, 1)
-		downloads[i].out = pipeWriter
-		go p.download(&downloads[i])
-	}
+	layerIDs := []string{}
+	defer func() {
+		p.graph.Release(p.sessionID, layerIDs...)
+	}()
 
+	var parentID string
 	for i := len(downloads) - 1; i >= 0; i-- {
 		d := &downloads[i]
 		if d.err != nil {
-			if err := <-d.err; err != nil {
+			err := <-d.err
+			d.err = nil
+			if err != nil {
 				return false, err
 			}
 		}
-		if d.layer != nil {
-			// if tmpFile is empty assume download and extracted elsewhere
-			defer os.Remove(d.tmpFile.Name())
-			defer d.tmpFile.Close()
-			d.tmpFile.Seek(0, 0)
-			if d.tmpFile != nil {
-				err := func() error {
-					reader := progressreader.New(progressreader.Config{
-						In:        d.tmpFile,
-						Out:       out,
-						Formatter: p.sf,
-						Size:      int(d.size),
-						NewLines:  false,
-						ID:        stringid.TruncateID(d.img.id),
-						Action:    "Extracting",
-					})
-
-					p.graph.imageMutex.Lock(d.img.id)
-					defer p.graph.imageMutex.Unlock(d.img.id)
-
-					// Must recheck the data on disk if any exists.
-					// This protects against races where something
-					// else is written to the graph under this ID
-					// after attemptIDReuse.
-					if p.graph.Exists(d.img.id) {
-						if err := p.validateImageInGraph(d.img.id, imgs, i); err != nil {
-							return fmt.Errorf("image validation failed: %v", err)
-						}
-					}
-
-					if err := p.graph.register(d.img, reader); err != nil {
-						return err
-					}
-
-					if err := p.graph.setLayerDigest(d.img.id, d.digest); err != nil {
-						return err
-					}
-
-					if err := p.graph.setV1CompatibilityConfig(d.img.id, d.img.v1Compatibility); err != nil {
-						return err
-					}
-
-					return nil
-				}()
-				if err != nil {
-					return false, err
-				}
-
-				// FIXME: Pool release here for parallel tag pull (ensures any downloads block until fully extracted)
+		descriptor := v1CompatibilityDescriptor{parentID, d.v1Compatibility}
+		var img *image.Image
+		if d.download != nil {
+			f, err := os.Open(d.download.path)
+			if err != nil {
+				return false, err
+			}
+			fi, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return false, err
+			}
+			reader := progressreader.New(progressreader.Config{
+				In:        f,
+				Out:       out,
+				Formatter: p.sf,
+				Size:      int(fi.Size()),
+				NewLines:  false,
+				ID:        stringid.TruncateID(d.compatibilityID),
+				Action:    "Extracting",
+				State:     jsonmessage.StateExtracting,
+				Tracker:   p.tracker,
+			})
+			img, err = p.graph.Register(descriptor, reader)
+			f.Close()
+			p.downloads.release(blobDownloadKey(d.digest), d.download)
+			d.download = nil
+			if err != nil {
+				return false, err
+			}
+			out.Write(p.tracker.FormatState(stringid.TruncateID(d.compatibilityID), "Pull complete", jsonmessage.StateComplete))
+			tagUpdated = true
+		} else if d.lazyBlob != nil {
+			b := d.lazyBlob
+			d.lazyBlob = nil
+			if img, err = p.graph.registerLazy(descriptor, b, d.lazyIndex); err != nil {
+				return false, err
 			}
-			out.Write(p.sf.FormatProgress(stringid.TruncateID(d.img.id), "Pull complete", nil))
+			out.Write(p.tracker.FormatState(stringid.TruncateID(d.compatibilityID), "Mounted, fetching in the background", jsonmessage.StateComplete))
 			tagUpdated = true
 		} else {
-			out.Write(p.sf.FormatProgress(stringid.TruncateID(d.img.id), "Already exists", nil))
+			if img, err = p.graph.registerOnLayer(descriptor, d.chainID); err != nil {
+				return false, err
+			}
+			out.Write(p.tracker.FormatState(stringid.TruncateID(d.compatibilityID), "Already exists", jsonmessage.StateExists))
+		}
+
+		if err = p.graph.SetLayerDigest(img.ID, d.digest); err != nil {
+			return false, err
+		}
+		if err = p.graph.SetV1CompatibilityConfig(img.ID, d.v1Compatibility); err != nil {
+			return false, err
 		}
+		// we need to retain it until tagging
+		p.graph.Retain(p.sessionID, img.ID)
+		layerIDs = append(layerIDs, img.ID)
+		parentID = img.ID
 	}
+	p.tracker.Flush(out)
 
 	manifestDigest, _, err := digestFromManifest(unverifiedManifest, p.repoInfo.LocalName)
 	if err != nil {
@@ -433,18 +555,21 @@ func (p *v2Puller) pullV2Tag(tag, taggedName string) (tagUpdated bool, err error
 	}
 
 	if utils.DigestReference(tag) {
-		// TODO(stevvooe): Ideally, we should always set the digest so we can
-		// use the digest whether we pull by it or not. Unfortunately, the tag
-		// store treats the digest as a separate tag, meaning there may be an
-		// untagged digest image that would seem to be dangling by a user.
-		if err = p.SetDigest(p.repoInfo.LocalName, tag, downloads[0].img.id); err != nil {
+		if err = p.SetDigest(p.repoInfo.LocalName, tag, parentID); err != nil {
 			return false, err
 		}
 	} else {
 		// only set the repository/tag -> image ID mapping when pulling by tag (i.e. not by digest)
-		if err = p.Tag(p.repoInfo.LocalName, tag, downloads[0].img.id, true); err != nil {
+		if err = p.Tag(p.repoInfo.LocalName, tag, parentID, true); err != nil {
 			return false, err
 		}
+		// Also record the digest of the manifest, so that the image can
+		// be referred to by digest without pulling it again.
+		if manifestDigest != "" {
+			if err = p.SetDigest(p.repoInfo.LocalName, manifestDigest.String(), parentID); err != nil {
+				logrus.Warnf("Could not record digest %s of %s: %v", manifestDigest, utils.ImageReference(p.repoInfo.LocalName, tag), err)
+			}
+		}
 	}
 
 	if manifestDigest != "" {
@@ -543,173 +668,3 @@ func fixManifestLayers(m *manifest.Manifest) error {
 
 	return nil
 }
-
-// getImageInfos returns an imageinfo struct for every image in the manifest.
-// These objects contain both calculated strongIDs and compatibilityIDs found
-// in v1Compatibility object.
-func (p *v2Puller) getImageInfos(m manifest.Manifest) ([]contentAddressableDescriptor, error) {
-	imgs := make([]contentAddressableDescriptor, len(m.FSLayers))
-
-	var parent digest.Digest
-	for i := len(imgs) - 1; i >= 0; i-- {
-		var err error
-		imgs[i], err = newContentAddressableImage([]byte(m.History[i].V1Compatibility), m.FSLayers[i].BlobSum, parent)
-		if err != nil {
-			return nil, err
-		}
-		parent = imgs[i].strongID
-	}
-
-	p.attemptIDReuse(imgs)
-
-	return imgs, nil
-}
-
-var idReuseLock sync.Mutex
-
-// attemptIDReuse does a best attempt to match verified compatibilityIDs
-// already in the graph with the computed strongIDs so we can keep using them.
-// This process will never fail but may just return the strongIDs if none of
-// the compatibilityIDs exists or can be verified. If the strongIDs themselves
-// fail verification, we deterministically generate alternate IDs to use until
-// we find one that's available or already exists with the correct data.
-func (p *v2Puller) attemptIDReuse(imgs []contentAddressableDescriptor) {
-	// This function needs to be protected with a global lock, because it
-	// locks multiple IDs at once, and there's no good way to make sure
-	// the locking happens a deterministic order.
-	idReuseLock.Lock()
-	defer idReuseLock.Unlock()
-
-	idMap := make(map[string]struct{})
-	for _, img := range imgs {
-		idMap[img.id] = struct{}{}
-		idMap[img.compatibilityID] = struct{}{}
-
-		if p.graph.Exists(img.compatibilityID) {
-			if _, err := p.graph.GenerateV1CompatibilityChain(img.compatibilityID); err != nil {
-				logrus.Debugf("Migration v1Compatibility generation error: %v", err)
-				return
-			}
-		}
-	}
-	for id := range idMap {
-		p.graph.imageMutex.Lock(id)
-		defer p.graph.imageMutex.Unlock(id)
-	}
-
-	// continueReuse controls whether the function will try to find
-	// existing layers on disk under the old v1 IDs, to avoid repulling
-	// them. The hashes are checked to ensure these layers are okay to
-	// use. continueReuse starts out as true, but is set to false if
-	// the code encounters something that doesn't match the expected hash.
-	continueReuse := true
-
-	for i := len(imgs) - 1; i >= 0; i-- {
-		if p.graph.Exists(imgs[i].id) {
-			// Found an image in the graph under the strongID. Validate the
-			// image before using it.
-			if err := p.validateImageInGraph(imgs[i].id, imgs, i); err != nil {
-				continueReuse = false
-				logrus.Debugf("not using existing strongID: %v", err)
-
-				// The strong ID existed in the graph but didn't
-				// validate successfully. We can't use the strong ID
-				// because it didn't validate successfully. Treat the
-				// graph like a hash table with probing... compute
-				// SHA256(id) until we find an ID that either doesn't
-				// already exist in the graph, or has existing content
-				// that validates successfully.
-				for {
-					if err := p.tryNextID(imgs, i, idMap); err != nil {
-						logrus.Debug(err.Error())
-					} else {
-						break
-					}
-				}
-			}
-			continue
-		}
-
-		if continueReuse {
-			compatibilityID := imgs[i].compatibilityID
-			if err := p.validateImageInGraph(compatibilityID, imgs, i); err != nil {
-				logrus.Debugf("stopping ID reuse: %v", err)
-				continueReuse = false
-			} else {
-				// The compatibility ID exists in the graph and was
-				// validated. Use it.
-				imgs[i].id = compatibilityID
-			}
-		}
-	}
-
-	// fix up the parents of the images
-	for i := 0; i < len(imgs); i++ {
-		if i == len(imgs)-1 { // Base layer
-			imgs[i].parent = ""
-		} else {
-			imgs[i].parent = imgs[i+1].id
-		}
-	}
-}
-
-// validateImageInGraph checks that an image in the graph has the expected
-// strongID. id is the entry in the graph to check, imgs is the slice of
-// images being processed (for access to the parent), and i is the index
-// into this slice which the graph entry should be checked against.
-func (p *v2Puller) validateImageInGraph(id string, imgs []contentAddressableDescriptor, i int) error {
-	img, err := p.graph.Get(id)
-	if err != nil {
-		return fmt.Errorf("missing: %v", err)
-	}
-	layerID, err := p.graph.getLayerDigest(id)
-	if err != nil {
-		return fmt.Errorf("digest: %v", err)
-	}
-	var parentID digest.Digest
-	if i != len(imgs)-1 {
-		if img.Parent != imgs[i+1].id { // comparing that graph points to validated ID
-			return fmt.Errorf("parent: %v %v", img.Parent, imgs[i+1].id)
-		} else {
-			parentID = imgs[i+1].strongID
-		}
-	} else if img.Parent != "" {
-		return fmt.Errorf("unexpected parent: %v", img.Parent)
-	}
-
-	v1Config, err := p.graph.getV1CompatibilityConfig(img.ID)
-	if err != nil {
-		return fmt.Errorf("v1Compatibility: %v %v", img.ID, err)
-	}
-
-	json, err := image.MakeImageConfig(v1Config, layerID, parentID)
-	if err != nil {
-		return fmt.Errorf("make config: %v", err)
-	}
-
-	if dgst, err := image.StrongID(json); err == nil && dgst == imgs[i].strongID {
-		logrus.Debugf("Validated %v as %v", dgst, id)
-	} else {
-		return fmt.Errorf("digest mismatch: %v %v, error: %v", dgst, imgs[i].strongID, err)
-	}
-
-	// All clear
-	return nil
-}
-
-func (p *v2Puller) tryNextID(imgs []contentAddressableDescriptor, i int, idMap map[string]struct{}) error {
-	nextID, _ := digest.FromBytes([]byte(imgs[i].id))
-	imgs[i].id = nextID.Hex()
-
-	if _, exists := idMap[imgs[i].id]; !exists {
-		p.graph.imageMutex.Lock(imgs[i].id)
-		defer p.graph.imageMutex.Unlock(imgs[i].id)
-	}
-
-	if p.graph.Exists(imgs[i].id) {
-		if err := p.validateImageInGraph(imgs[i].id, imgs, i); err != nil {
-			return fmt.Errorf("not using existing strongID permutation %s: %v", imgs[i].id, err)
-		}
-	}
-	return nil
-}
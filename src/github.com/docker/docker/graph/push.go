@@ -15,6 +15,10 @@ type ImagePushConfig struct {
 	AuthConfig  *cliconfig.AuthConfig
 	Tag         string
 	OutStream   io.Writer
+
+	// ProgressSummary writes the aggregate progress of the layers
+	// periodically, for clients that understand it.
+	ProgressSummary bool
 }
 
 type Pusher interface {
@@ -10,6 +10,7 @@ import (
 	"github.com/docker/distribution/registry/client/transport"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
 	"github.com/docker/docker/pkg/stringid"
@@ -27,6 +28,8 @@ type v1Pusher struct {
 	session   *registry.Session
 
 	out io.Writer
+	// tracker keeps the progress of the layers of the repository.
+	tracker *progressreader.Tracker
 }
 
 func (p *v1Pusher) Push() (fallback bool, err error) {
@@ -227,6 +230,11 @@ func (p *v1Pusher) pushImageToEndpoint(endpoint string, imageIDs []string, tags
 func (p *v1Pusher) pushRepository(tag string) error {
 	logrus.Debugf("Local repo: %s", p.localRepo)
 	p.out = ioutils.NewWriteFlusher(p.config.OutStream)
+	var summaryOut io.Writer
+	if p.config.ProgressSummary {
+		summaryOut = p.out
+	}
+	p.tracker = progressreader.NewTracker(p.sf, summaryOut)
 	imgList, tags, err := p.getImageList(tag)
 	if err != nil {
 		return err
@@ -267,6 +275,7 @@ func (p *v1Pusher) pushRepository(tag string) error {
 			return err
 		}
 	}
+	p.tracker.Flush(p.out)
 	_, err = p.session.PushImageJSONIndex(p.repoInfo.RemoteName, imageIndex, true, repoData.Endpoints)
 	return err
 }
@@ -276,7 +285,7 @@ func (p *v1Pusher) pushImage(imgID, ep string, token []string) (checksum string,
 	if err != nil {
 		return "", fmt.Errorf("Cannot retrieve the path for {%s}: %s", imgID, err)
 	}
-	p.out.Write(p.sf.FormatProgress(stringid.TruncateID(imgID), "Pushing", nil))
+	p.out.Write(p.tracker.FormatState(stringid.TruncateID(imgID), "Pushing", jsonmessage.StateWaiting))
 
 	compatibilityID, err := p.getV1ID(imgID)
 	if err != nil {
@@ -292,7 +301,7 @@ func (p *v1Pusher) pushImage(imgID, ep string, token []string) (checksum string,
 	// Send the json
 	if err := p.session.PushImageJSONRegistry(imgData, jsonRaw, ep); err != nil {
 		if err == registry.ErrAlreadyExists {
-			p.out.Write(p.sf.FormatProgress(stringid.TruncateID(imgID), "Image already pushed, skipping", nil))
+			p.out.Write(p.tracker.FormatState(stringid.TruncateID(imgID), "Image already pushed, skipping", jsonmessage.StateExists))
 			return "", nil
 		}
 		return "", err
@@ -316,6 +325,8 @@ func (p *v1Pusher) pushImage(imgID, ep string, token []string) (checksum string,
 			NewLines:  false,
 			ID:        stringid.TruncateID(imgID),
 			Action:    "Pushing",
+			State:     jsonmessage.StateUploading,
+			Tracker:   p.tracker,
 		}), ep, jsonRaw)
 	if err != nil {
 		return "", err
@@ -327,7 +338,7 @@ func (p *v1Pusher) pushImage(imgID, ep string, token []string) (checksum string,
 		return "", err
 	}
 
-	p.out.Write(p.sf.FormatProgress(stringid.TruncateID(imgID), "Image successfully pushed", nil))
+	p.out.Write(p.tracker.FormatState(stringid.TruncateID(imgID), "Image successfully pushed", jsonmessage.StatePushed))
 	return imgData.Checksum, nil
 }
 
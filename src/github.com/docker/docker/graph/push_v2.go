@@ -2,6 +2,7 @@ package graph
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
 	"github.com/docker/docker/pkg/stringid"
@@ -27,10 +29,12 @@ type v2Pusher struct {
 	config    *ImagePushConfig
 	sf        *streamformatter.StreamFormatter
 	repo      distribution.Repository
+	// tracker keeps the progress of the layers of the tag being pushed.
+	tracker *progressreader.Tracker
 }
 
 func (p *v2Pusher) Push() (fallback bool, err error) {
-	p.repo, err = NewV2Repository(p.repoInfo, p.endpoint, p.config.MetaHeaders, p.config.AuthConfig)
+	p.repo, err = NewV2Repository(p.registryService, p.repoInfo, p.endpoint, p.config.MetaHeaders, p.config.AuthConfig)
 	if err != nil {
 		logrus.Debugf("Error getting v2 registry: %v", err)
 		return true, err
@@ -111,6 +115,11 @@ func (p *v2Pusher) pushV2Tag(tag string) error {
 	}
 
 	out := p.config.OutStream
+	var summaryOut io.Writer
+	if p.config.ProgressSummary {
+		summaryOut = out
+	}
+	p.tracker = progressreader.NewTracker(p.sf, summaryOut)
 
 	for ; layer != nil; layer, err = p.graph.GetParent(layer) {
 		if err != nil {
@@ -137,11 +146,11 @@ func (p *v2Pusher) pushV2Tag(tag string) error {
 			switch err {
 			case nil:
 				exists = true
-				out.Write(p.sf.FormatProgress(stringid.TruncateID(layer.ID), "Image already exists", nil))
+				out.Write(p.tracker.FormatState(stringid.TruncateID(layer.ID), "Image already exists", jsonmessage.StateExists))
 			case distribution.ErrBlobUnknown:
 				// nop
 			default:
-				out.Write(p.sf.FormatProgress(stringid.TruncateID(layer.ID), "Image push failed", nil))
+				out.Write(p.tracker.FormatState(stringid.TruncateID(layer.ID), "Image push failed", jsonmessage.StateFailed))
 				return err
 			}
 		case ErrDigestNotSet:
@@ -153,9 +162,16 @@ func (p *v2Pusher) pushV2Tag(tag string) error {
 		// if digest was empty or not saved, or if blob does not exist on the remote repository,
 		// then fetch it.
 		if !exists {
-			if pushDigest, err := p.pushV2Image(p.repo.Blobs(context.Background()), layer); err != nil {
+			pushDigest, indexDigest, err := p.pushV2Image(p.repo.Blobs(context.Background()), layer)
+			if err != nil {
+				return err
+			}
+			// The index of the blob is anchored in the manifest, in the
+			// v1Compatibility of the layer.
+			if err := p.graph.SetLayerIndexDigest(layer.ID, indexDigest); err != nil {
 				return err
-			} else if pushDigest != dgst {
+			}
+			if pushDigest != dgst {
 				// Cache new checksum
 				if err := p.graph.SetLayerDigest(layer.ID, pushDigest); err != nil {
 					return err
@@ -175,6 +191,7 @@ func (p *v2Pusher) pushV2Tag(tag string) error {
 
 		layersSeen[layer.ID] = true
 	}
+	p.tracker.Flush(out)
 
 	logrus.Infof("Signed manifest for %s:%s using daemon's key: %s", p.repo.Name(), tag, p.trustKey.KeyID())
 	signed, err := manifest.Sign(m, p.trustKey)
@@ -194,42 +211,62 @@ func (p *v2Pusher) pushV2Tag(tag string) error {
 	if err != nil {
 		return err
 	}
-	return manSvc.Put(signed)
+	if err := manSvc.Put(signed); err != nil {
+		return err
+	}
+	// Record the digest the image was pushed with, like pulls do.
+	if manifestDigest != "" {
+		if err := p.SetDigest(p.repoInfo.LocalName, manifestDigest.String(), layerId); err != nil {
+			logrus.Warnf("Could not record digest %s of %s: %v", manifestDigest, utils.ImageReference(p.repoInfo.LocalName, tag), err)
+		}
+	}
+	return nil
 }
 
-func (p *v2Pusher) pushV2Image(bs distribution.BlobService, img *image.Image) (digest.Digest, error) {
+// pushV2Image pushes the layer of img, and returns the digest of the blob and
+// the digest of its index, if it is indexed.
+func (p *v2Pusher) pushV2Image(bs distribution.BlobService, img *image.Image) (digest.Digest, digest.Digest, error) {
 	out := p.config.OutStream
 
-	out.Write(p.sf.FormatProgress(stringid.TruncateID(img.ID), "Buffering to Disk", nil))
+	out.Write(p.tracker.FormatState(stringid.TruncateID(img.ID), "Buffering to Disk", jsonmessage.StateBuffering))
 
 	image, err := p.graph.Get(img.ID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	arch, err := p.graph.TarLayer(image)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	tf, err := p.graph.newTempFile()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	defer func() {
 		tf.Close()
 		os.Remove(tf.Name())
 	}()
 
-	size, dgst, err := bufferToFile(tf, arch)
+	var (
+		size        int64
+		dgst        digest.Digest
+		indexDigest digest.Digest
+	)
+	if p.pushLayerIndex {
+		size, dgst, indexDigest, err = bufferIndexedToFile(tf, arch, p.pushCompressionLevel)
+	} else {
+		size, dgst, err = bufferToFile(tf, arch, p.pushCompression, p.pushCompressionLevel)
+	}
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	// Send the layer
 	logrus.Debugf("rendered layer for %s of [%d] size", img.ID, size)
 	layerUpload, err := bs.Create(context.Background())
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	defer layerUpload.Close()
 
@@ -241,21 +278,23 @@ func (p *v2Pusher) pushV2Image(bs distribution.BlobService, img *image.Image) (d
 		NewLines:  false,
 		ID:        stringid.TruncateID(img.ID),
 		Action:    "Pushing",
+		State:     jsonmessage.StateUploading,
+		Tracker:   p.tracker,
 	})
 	n, err := layerUpload.ReadFrom(reader)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	if n != size {
-		return "", fmt.Errorf("short upload: only wrote %d of %d", n, size)
+		return "", "", fmt.Errorf("short upload: only wrote %d of %d", n, size)
 	}
 
 	desc := distribution.Descriptor{Digest: dgst}
 	if _, err := layerUpload.Commit(context.Background(), desc); err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	out.Write(p.sf.FormatProgress(stringid.TruncateID(img.ID), "Image successfully pushed", nil))
+	out.Write(p.tracker.FormatState(stringid.TruncateID(img.ID), "Image successfully pushed", jsonmessage.StatePushed))
 
-	return dgst, nil
+	return dgst, indexDigest, nil
 }
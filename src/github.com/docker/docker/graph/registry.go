@@ -11,6 +11,7 @@ import (
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/client"
 	"github.com/docker/distribution/registry/client/auth"
 	"github.com/docker/distribution/registry/client/transport"
@@ -28,7 +29,7 @@ func (dcs dumbCredentialStore) Basic(*url.URL) (string, string) {
 }
 
 // v2 only
-func NewV2Repository(repoInfo *registry.RepositoryInfo, endpoint registry.APIEndpoint, metaHeaders http.Header, authConfig *cliconfig.AuthConfig) (distribution.Repository, error) {
+func NewV2Repository(registryService *registry.Service, repoInfo *registry.RepositoryInfo, endpoint registry.APIEndpoint, metaHeaders http.Header, authConfig *cliconfig.AuthConfig) (distribution.Repository, error) {
 	ctx := context.Background()
 
 	repoName := repoInfo.CanonicalName
@@ -89,12 +90,20 @@ func NewV2Repository(repoInfo *registry.RepositoryInfo, endpoint registry.APIEnd
 	}
 
 	creds := dumbCredentialStore{auth: authConfig}
-	tokenHandler := auth.NewTokenHandler(authTransport, creds, repoName, "push", "pull")
+	tokenHandler := registryService.NewTokenHandler(authTransport, creds, repoName, "push", "pull")
 	basicHandler := auth.NewBasicHandler(creds)
 	modifiers = append(modifiers, auth.NewAuthorizer(challengeManager, tokenHandler, basicHandler))
-	tr := transport.NewTransport(base, modifiers...)
+	tr := transport.NewTransport(registryService.EvictRefusedTokens(base), modifiers...)
 
-	return client.NewRepository(ctx, repoName, endpoint.URL, tr)
+	repo, err := client.NewRepository(ctx, repoName, endpoint.URL, tr)
+	if err != nil {
+		return nil, err
+	}
+	ub, err := v2.NewURLBuilderFromString(endpoint.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeRepository{Repository: repo, client: &http.Client{Transport: tr}, ub: ub}, nil
 }
 
 func digestFromManifest(m *manifest.SignedManifest, localName string) (digest.Digest, int, error) {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -19,6 +20,8 @@ func (s *TagStore) Lookup(name string) (*types.ImageInspect, error) {
 
 	imageInspect := &types.ImageInspect{
 		Id:              image.ID,
+		RepoTags:        []string{},
+		RepoDigests:     []string{},
 		Parent:          image.Parent,
 		Comment:         image.Comment,
 		Created:         image.Created.Format(time.RFC3339Nano),
@@ -33,9 +36,29 @@ func (s *TagStore) Lookup(name string) (*types.ImageInspect, error) {
 		VirtualSize:     s.graph.GetParentsSize(image, 0) + image.Size,
 	}
 
+	for _, ref := range s.ByID()[image.ID] {
+		if strings.Contains(ref, "@") {
+			imageInspect.RepoDigests = append(imageInspect.RepoDigests, ref)
+		} else {
+			imageInspect.RepoTags = append(imageInspect.RepoTags, ref)
+		}
+	}
+
+	imageInspect.SignedBy, err = s.signedBy(image.ID)
+	if err != nil {
+		return nil, err
+	}
+	if imageInspect.SignedBy == nil {
+		imageInspect.SignedBy = []string{}
+	}
+
 	imageInspect.GraphDriver.Name = s.graph.driver.String()
 
-	graphDriverData, err := s.graph.driver.GetMetadata(image.ID)
+	cacheID, err := s.graph.GraphDriverID(image.ID)
+	if err != nil {
+		return nil, err
+	}
+	graphDriverData, err := s.graph.driver.GetMetadata(cacheID)
 	if err != nil {
 		return nil, err
 	}
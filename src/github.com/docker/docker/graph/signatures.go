@@ -0,0 +1,106 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/libtrust"
+)
+
+// ErrImageNotSigned is returned when the signature policy requires an image to
+// be signed by a trusted key and it is not.
+var ErrImageNotSigned = errors.New("image is not signed by a trusted key")
+
+// verifySignature checks that signature is a valid signature of the image id,
+// and returns the keys it was signed with.
+func verifySignature(id string, signature []byte) ([]libtrust.PublicKey, error) {
+	js, err := libtrust.ParsePrettySignature(signature, "signatures")
+	if err != nil {
+		return nil, fmt.Errorf("invalid image signature: %v", err)
+	}
+	keys, err := js.Verify()
+	if err != nil {
+		return nil, fmt.Errorf("invalid image signature: %v", err)
+	}
+	payload, err := js.Payload()
+	if err != nil {
+		return nil, err
+	}
+	var p types.ImageSignaturePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("invalid image signature payload: %v", err)
+	}
+	if p.ImageID != id {
+		return nil, fmt.Errorf("image signature is for image %s, not %s", p.ImageID, id)
+	}
+	return keys, nil
+}
+
+// AddSignature verifies and stores a detached signature of the image name. It
+// returns the IDs of the keys the image was signed with.
+func (s *TagStore) AddSignature(name string, signature []byte) ([]string, error) {
+	img, err := s.LookupImage(name)
+	if err != nil || img == nil {
+		return nil, fmt.Errorf("No such image: %s", name)
+	}
+	return s.addSignature(img.ID, signature)
+}
+
+func (s *TagStore) addSignature(id string, signature []byte) ([]string, error) {
+	keys, err := verifySignature(id, signature)
+	if err != nil {
+		return nil, err
+	}
+	var keyIDs []string
+	for _, key := range keys {
+		if err := s.graph.AddSignature(id, key.KeyID(), signature); err != nil {
+			return nil, err
+		}
+		keyIDs = append(keyIDs, key.KeyID())
+	}
+	return keyIDs, nil
+}
+
+// signedBy returns the IDs of the keys with a valid signature of the image id.
+func (s *TagStore) signedBy(id string) ([]string, error) {
+	signatures, err := s.graph.Signatures(id)
+	if err != nil {
+		return nil, err
+	}
+	var keyIDs []string
+	for keyID, signature := range signatures {
+		keys, err := verifySignature(id, signature)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyID() == keyID {
+				keyIDs = append(keyIDs, keyID)
+			}
+		}
+	}
+	return keyIDs, nil
+}
+
+// CheckSignature returns ErrImageNotSigned if the signature policy of the
+// daemon requires images to be signed by a trusted key and the image id is
+// not.
+func (s *TagStore) CheckSignature(id string) error {
+	if !s.requireSignature {
+		return nil
+	}
+	keyIDs, err := s.signedBy(id)
+	if err != nil {
+		return err
+	}
+	for _, keyID := range keyIDs {
+		for _, key := range s.trustedKeys {
+			if key.KeyID() == keyID {
+				return nil
+			}
+		}
+	}
+	return ErrImageNotSigned
+}
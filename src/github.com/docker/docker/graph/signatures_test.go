@@ -0,0 +1,243 @@
+package graph
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/utils"
+	"github.com/docker/libtrust"
+)
+
+func signImage(t *testing.T, id string, key libtrust.PrivateKey) []byte {
+	payload, err := json.Marshal(types.ImageSignaturePayload{ImageID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := libtrust.NewJSONSignature(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := js.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	signature, err := js.PrettySignature("signatures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestImageSignatures(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.AddSignature(testOfficialImageName, signImage(t, testPrivateImageID, key)); err == nil {
+		t.Fatal("Expected the signature of another image to be refused")
+	}
+	keyIDs, err := store.AddSignature(testOfficialImageName, signImage(t, testOfficialImageID, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyIDs) != 1 || keyIDs[0] != key.KeyID() {
+		t.Fatalf("Unexpected signing keys %v", keyIDs)
+	}
+	inspect, err := store.Lookup(testOfficialImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inspect.SignedBy) != 1 || inspect.SignedBy[0] != key.KeyID() {
+		t.Fatalf("Unexpected signing keys %v", inspect.SignedBy)
+	}
+
+	// Without policy, unsigned images pass.
+	if err := store.CheckSignature(testPrivateImageID); err != nil {
+		t.Fatal(err)
+	}
+
+	store.requireSignature = true
+	store.trustedKeys = []libtrust.PublicKey{otherKey.PublicKey()}
+	if err := store.CheckSignature(testOfficialImageID); err != ErrImageNotSigned {
+		t.Fatalf("Expected images signed by untrusted keys to be refused, got %v", err)
+	}
+	store.trustedKeys = append(store.trustedKeys, key.PublicKey())
+	if err := store.CheckSignature(testOfficialImageID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckSignature(testPrivateImageID); err != ErrImageNotSigned {
+		t.Fatalf("Expected unsigned images to be refused, got %v", err)
+	}
+}
+
+func TestExportLoadSignatures(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddSignature(testOfficialImageID, signImage(t, testOfficialImageID, key)); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "signatures-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := store.exportSignatures(testOfficialImageID, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signatures of other images are skipped.
+	if err := store.loadSignatures(testPrivateImageID, dir); err != nil {
+		t.Fatal(err)
+	}
+	if keyIDs, err := store.signedBy(testPrivateImageID); err != nil || len(keyIDs) != 0 {
+		t.Fatalf("Expected %s not to be signed, got %v: %v", testPrivateImageID, keyIDs, err)
+	}
+
+	if err := store.graph.Delete(testOfficialImageID); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := store.graph.Register(v1ImageDescriptor{&image.Image{Comment: "official"}}, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ID != testOfficialImageID {
+		t.Fatalf("Expected the image to be registered again as %s, got %s", testOfficialImageID, img.ID)
+	}
+	if err := store.loadSignatures(img.ID, dir); err != nil {
+		t.Fatal(err)
+	}
+	if keyIDs, err := store.signedBy(img.ID); err != nil || len(keyIDs) != 1 || keyIDs[0] != key.KeyID() {
+		t.Fatalf("Expected %s to be signed by %s, got %v: %v", img.ID, key.KeyID(), keyIDs, err)
+	}
+}
+
+func TestCheckLoadedSignatures(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.requireSignature = true
+	store.trustedKeys = []libtrust.PublicKey{key.PublicKey()}
+
+	existing := store.graph.Map()
+	register := func(comment, parent string) *image.Image {
+		archive, err := fakeTar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := store.graph.Register(v1ImageDescriptor{&image.Image{Comment: comment, Parent: parent}}, archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	parent := register("parent", "")
+	child := register("child", parent.ID)
+	loaded := map[string]string{"parent": parent.ID, "child": child.ID, "official": testOfficialImageID}
+
+	// Only the image without children needs to be signed.
+	if _, err := store.addSignature(child.ID, signImage(t, child.ID, key)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.checkLoadedSignatures(loaded, existing); err != nil {
+		t.Fatal(err)
+	}
+
+	other := register("other", parent.ID)
+	loaded["other"] = other.ID
+	if err := store.checkLoadedSignatures(loaded, existing); err == nil {
+		t.Fatal("Expected the unsigned image to be refused")
+	}
+	for _, id := range []string{parent.ID, child.ID, other.ID} {
+		if store.graph.Exists(id) {
+			t.Fatalf("Expected the refused load to delete %s", id)
+		}
+	}
+	if !store.graph.Exists(testOfficialImageID) {
+		t.Fatal("Expected the images that existed before the load to be kept")
+	}
+}
+
+func TestFailedLoadSignatures(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.requireSignature = true
+	store.trustedKeys = []libtrust.PublicKey{key.PublicKey()}
+
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerData, err := ioutil.ReadAll(layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The unsigned image is loaded before the broken one makes the load fail.
+	unsigned := strings.Repeat("a", 64)
+	broken := strings.Repeat("b", 64)
+	files := map[string][]byte{
+		unsigned + "/json":      []byte(`{"id": "` + unsigned + `", "comment": "unsigned"}`),
+		unsigned + "/layer.tar": layerData,
+		broken + "/json":        []byte(`{`),
+		broken + "/layer.tar":   layerData,
+	}
+
+	existing := store.graph.Map()
+	if err := store.Load(ociTar(t, files), ioutil.Discard); err == nil {
+		t.Fatal("Expected the load to fail")
+	}
+	for id := range store.graph.Map() {
+		if _, ok := existing[id]; !ok {
+			t.Fatalf("Expected the unsigned image loaded before the failure to be deleted, got %s", id)
+		}
+	}
+}
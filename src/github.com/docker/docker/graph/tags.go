@@ -16,6 +16,7 @@ import (
 	"github.com/docker/docker/daemon/events"
 	"github.com/docker/docker/graph/tags"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/parsers"
 	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/registry"
@@ -35,8 +36,28 @@ type TagStore struct {
 	// to a helper type
 	pullingPool     map[string]chan struct{}
 	pushingPool     map[string]chan struct{}
+	downloads       *downloadManager
 	registryService *registry.Service
 	eventsService   *events.Events
+
+	// requireSignature refuses to load images without a signature by one
+	// of trustedKeys.
+	requireSignature bool
+	trustedKeys      []libtrust.PublicKey
+
+	// pushCompression and pushCompressionLevel compress the layers pushed
+	// to v2 registries.
+	pushCompression      archive.Compression
+	pushCompressionLevel int
+	// pushLayerIndex indexes the layers pushed to v2 registries, so they
+	// can be pulled lazily.
+	pushLayerIndex bool
+	// lazyPull mounts the indexed layers pulled from v2 registries before
+	// they are downloaded.
+	lazyPull bool
+
+	// layersCache holds the layers of the images for ImagesDiskUsage.
+	layersCache imageLayersCache
 }
 
 type Repository map[string]string
@@ -64,6 +85,24 @@ type TagStoreConfig struct {
 	Key      libtrust.PrivateKey
 	Registry *registry.Service
 	Events   *events.Events
+	// MaxConcurrentDownloads limits the number of layers downloaded at
+	// the same time by all pulls.
+	MaxConcurrentDownloads int
+	// RequireSignature requires images to be signed by one of
+	// TrustedKeys to be loaded, or to pass CheckSignature.
+	RequireSignature bool
+	TrustedKeys      []libtrust.PublicKey
+	// PushCompression and PushCompressionLevel compress the layers pushed
+	// to v2 registries.
+	PushCompression      archive.Compression
+	PushCompressionLevel int
+	// PushLayerIndex indexes the layers pushed to v2 registries, so that
+	// they can be pulled lazily. The layers must be compressed with gzip.
+	PushLayerIndex bool
+	// LazyPull mounts the indexed layers pulled from v2 registries before
+	// they are downloaded, and downloads them in the background. The graph
+	// driver must support lazy pulls.
+	LazyPull bool
 }
 
 func NewTagStore(path string, cfg *TagStoreConfig) (*TagStore, error) {
@@ -71,6 +110,10 @@ func NewTagStore(path string, cfg *TagStoreConfig) (*TagStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	downloads, err := newDownloadManager(filepath.Join(cfg.Graph.root, "_downloads"), cfg.MaxConcurrentDownloads)
+	if err != nil {
+		return nil, err
+	}
 
 	store := &TagStore{
 		path:            abspath,
@@ -79,8 +122,21 @@ func NewTagStore(path string, cfg *TagStoreConfig) (*TagStore, error) {
 		Repositories:    make(map[string]Repository),
 		pullingPool:     make(map[string]chan struct{}),
 		pushingPool:     make(map[string]chan struct{}),
+		downloads:       downloads,
 		registryService: cfg.Registry,
 		eventsService:   cfg.Events,
+
+		requireSignature: cfg.RequireSignature,
+		trustedKeys:      cfg.TrustedKeys,
+
+		pushCompression:      cfg.PushCompression,
+		pushCompressionLevel: cfg.PushCompressionLevel,
+		pushLayerIndex:       cfg.PushLayerIndex,
+		lazyPull:             cfg.LazyPull,
+	}
+	if store.pushCompression == archive.Uncompressed {
+		store.pushCompression = archive.Gzip
+		store.pushCompressionLevel = archive.DefaultCompressionLevel
 	}
 	// Load the json file if it exists, otherwise create it.
 	if err := store.reload(); os.IsNotExist(err) {
@@ -90,6 +146,9 @@ func NewTagStore(path string, cfg *TagStoreConfig) (*TagStore, error) {
 	} else if err != nil {
 		return nil, err
 	}
+	if store.registryService != nil {
+		go store.resumeLazyPulls()
+	}
 	return store, nil
 }
 
@@ -227,7 +286,35 @@ func (store *TagStore) Delete(repoName, ref string) (bool, error) {
 	return deleted, store.save()
 }
 
+// ReplaceImageIDs points the tags and digests of the images in ids to the new
+// IDs ids maps them to, e.g. after the images were migrated.
+func (store *TagStore) ReplaceImageIDs(ids map[string]string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	store.Lock()
+	defer store.Unlock()
+	if err := store.reload(); err != nil {
+		return err
+	}
+	for _, repoRefs := range store.Repositories {
+		for ref, id := range repoRefs {
+			if newID, exists := ids[id]; exists {
+				repoRefs[ref] = newID
+			}
+		}
+	}
+	return store.save()
+}
+
+// Tag tags the image imageName, which can be a reference by digest, as
+// repoName:tag. Digests are only recorded by pulls and pushes with SetDigest,
+// as the manifest they are the digest of is not kept locally, so tag cannot be
+// a digest.
 func (store *TagStore) Tag(repoName, tag, imageName string, force bool) error {
+	if utils.DigestReference(tag) {
+		return fmt.Errorf("Cannot tag %s with digest %s, digests are set by pulls and pushes", imageName, tag)
+	}
 	return store.SetLoad(repoName, tag, imageName, force, nil)
 }
 
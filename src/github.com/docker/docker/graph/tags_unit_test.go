@@ -4,7 +4,9 @@ import (
 	"archive/tar"
 	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"testing"
 
@@ -12,18 +14,25 @@ import (
 	"github.com/docker/docker/daemon/graphdriver"
 	_ "github.com/docker/docker/daemon/graphdriver/vfs" // import the vfs driver so it is used in the tests
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/utils"
 )
 
 const (
-	testOfficialImageName    = "myapp"
-	testOfficialImageID      = "1a2d3c4d4e5fa2d2a21acea242a5e2345d3aefc3e7dfa2a2a2a21a2a2ad2d234"
-	testOfficialImageIDShort = "1a2d3c4d4e5f"
-	testPrivateImageName     = "127.0.0.1:8000/privateapp"
-	testPrivateImageID       = "5bc255f8699e4ee89ac4469266c3d11515da88fdcbde45d7b069b636ff4efd81"
-	testPrivateImageIDShort  = "5bc255f8699e"
-	testPrivateImageDigest   = "sha256:bc8813ea7b3603864987522f02a76101c17ad122e1c46d790efc0fca78ca7bfb"
-	testPrivateImageTag      = "sometag"
+	testOfficialImageName  = "myapp"
+	testPrivateImageName   = "127.0.0.1:8000/privateapp"
+	testPrivateImageDigest = "sha256:bc8813ea7b3603864987522f02a76101c17ad122e1c46d790efc0fca78ca7bfb"
+	testPrivateImageTag    = "sometag"
+)
+
+// The IDs of the test images are the digests of their configs, they are set
+// by mkTestTagStore.
+var (
+	testOfficialImageID      string
+	testOfficialImageIDShort string
+	testPrivateImageID       string
+	testPrivateImageIDShort  string
 )
 
 func fakeTar() (io.Reader, error) {
@@ -73,10 +82,12 @@ func mkTestTagStore(root string, t *testing.T) *TagStore {
 	if err != nil {
 		t.Fatal(err)
 	}
-	img := &image.Image{ID: testOfficialImageID}
-	if err := graph.Register(v1ImageDescriptor{img}, officialArchive); err != nil {
+	img, err := graph.Register(v1ImageDescriptor{&image.Image{Comment: "official"}}, officialArchive)
+	if err != nil {
 		t.Fatal(err)
 	}
+	testOfficialImageID = img.ID
+	testOfficialImageIDShort = stringid.TruncateID(img.ID)
 	if err := store.Tag(testOfficialImageName, "", testOfficialImageID, false); err != nil {
 		t.Fatal(err)
 	}
@@ -84,10 +95,12 @@ func mkTestTagStore(root string, t *testing.T) *TagStore {
 	if err != nil {
 		t.Fatal(err)
 	}
-	img = &image.Image{ID: testPrivateImageID}
-	if err := graph.Register(v1ImageDescriptor{img}, privateArchive); err != nil {
+	img, err = graph.Register(v1ImageDescriptor{&image.Image{Comment: "private"}}, privateArchive)
+	if err != nil {
 		t.Fatal(err)
 	}
+	testPrivateImageID = img.ID
+	testPrivateImageIDShort = stringid.TruncateID(img.ID)
 	if err := store.Tag(testPrivateImageName, "", testPrivateImageID, false); err != nil {
 		t.Fatal(err)
 	}
@@ -182,6 +195,64 @@ func TestLookupImage(t *testing.T) {
 	}
 }
 
+func TestTagDigestReference(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	if err := store.Tag("pinned", "v1", testPrivateImageName+"@"+testPrivateImageDigest, false); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := store.LookupImage("pinned:v1"); err != nil || img == nil || img.ID != testPrivateImageID {
+		t.Fatalf("Expected pinned:v1 to be tagged as %s, got %v: %v", testPrivateImageID, img, err)
+	}
+
+	if err := store.Tag("pinned", testPrivateImageDigest, testOfficialImageID, true); err == nil {
+		t.Fatal("Tagging an image with a digest should fail")
+	}
+
+	inspect, err := store.Lookup(testPrivateImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inspect.RepoDigests) != 1 || inspect.RepoDigests[0] != testPrivateImageName+"@"+testPrivateImageDigest {
+		t.Fatalf("Unexpected repository digests %v", inspect.RepoDigests)
+	}
+	if len(inspect.RepoTags) != 2 {
+		t.Fatalf("Unexpected repository tags %v", inspect.RepoTags)
+	}
+}
+
+func TestImagesDanglingDigest(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	// The private image is left with its digest only
+	if _, err := store.Delete(testPrivateImageName, DEFAULTTAG); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Delete(testOfficialImageName, DEFAULTTAG); err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := store.Images(&ImagesConfig{Filters: `{"dangling":["true"]}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].ID != testOfficialImageID {
+		t.Fatalf("Expected only %s to be dangling, got %v", testOfficialImageID, images)
+	}
+}
+
 func TestValidateDigest(t *testing.T) {
 	tests := []struct {
 		input       string
@@ -202,3 +273,63 @@ func TestValidateDigest(t *testing.T) {
 		}
 	}
 }
+
+func TestImageExportCompression(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	for _, c := range []archive.Compression{archive.Uncompressed, archive.Gzip, archive.Zstd} {
+		if c == archive.Zstd {
+			if _, err := exec.LookPath("zstd"); err != nil {
+				t.Logf("Skipping zstd: %v", err)
+				continue
+			}
+		}
+		var buf bytes.Buffer
+		if err := store.ImageExport(&ImageExportConfig{
+			Names:            []string{testOfficialImageName},
+			Outstream:        &buf,
+			Compression:      c,
+			CompressionLevel: archive.DefaultCompressionLevel,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(&buf)
+		found := false
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name != testOfficialImageID+"/layer.tar" {
+				continue
+			}
+			found = true
+			layer, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detected := archive.DetectCompression(layer); detected != c {
+				t.Fatalf("Expected the layer to be compressed with %s, got %s", c.Name(), detected.Name())
+			}
+			r, err := archive.DecompressStream(bytes.NewReader(layer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tar.NewReader(r).Next(); err != nil {
+				t.Fatalf("Expected the %s layer to decompress to a tar: %v", c.Name(), err)
+			}
+		}
+		if !found {
+			t.Fatalf("No layer exported for %s", c.Name())
+		}
+	}
+}
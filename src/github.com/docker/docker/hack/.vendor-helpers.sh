@@ -44,6 +44,17 @@ clone() {
 	echo done
 }
 
+# apply a patch from hack/patches to a cloned package, for changes that are
+# not merged upstream yet
+patch_vendor() {
+	local pkg="$1"
+	local patch="$2"
+
+	echo -n "$pkg: patch $patch, "
+	( cd "vendor/src/$pkg" && patch --quiet -p1 ) < "hack/patches/$patch"
+	echo done
+}
+
 # get an ENV from the Dockerfile with support for multiline values
 _dockerfile_env() {
 	local e="$1"
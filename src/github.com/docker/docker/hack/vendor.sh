@@ -11,6 +11,7 @@ clone git github.com/docker/libtrust 9cbd2a1374f46905c68a4eb3694a130610adc62a
 clone git github.com/go-check/check 64131543e7896d5bcc6bd5a76287eb75ea96c673
 clone git github.com/gorilla/context 14f550f51a
 clone git github.com/gorilla/mux e444e69cbd
+clone git github.com/hanwen/go-fuse/v2 v2.9.0 https://github.com/hanwen/go-fuse.git
 clone git github.com/kr/pty 5cf931ef8f
 clone git github.com/microsoft/hcsshim f674a70f1306dbe20b3a516bedd3285d85db60d9
 clone git github.com/mattn/go-sqlite3 b4142c444a8941d0d92b0b7103a24df9cd815e42
@@ -18,10 +19,13 @@ clone git github.com/mistifyio/go-zfs v2.1.1
 clone git github.com/natefinch/npipe 0938d701e50e580f5925c773055eb6d6b32a0cbc
 clone git github.com/tchap/go-patricia v2.1.0
 clone git golang.org/x/net 3cffabab72adf04f8e3b01c5baf775361837b5fe https://github.com/golang/net.git
+clone git golang.org/x/sys v0.28.0 https://github.com/golang/sys.git
 clone hg code.google.com/p/gosqlite 74691fb6f837
 
 #get libnetwork packages
 clone git github.com/docker/libnetwork bc565c2d295067c1a43674a23a473ec6336d7fd4
+# static IPv4/IPv6 addresses for the bridge driver, until merged upstream
+patch_vendor github.com/docker/libnetwork libnetwork-static-addresses.patch
 clone git github.com/armon/go-metrics eb0af217e5e9747e41dd5303755356b62d28e3ec
 clone git github.com/hashicorp/go-msgpack 71c2886f5a673a35f909803f38ece5810165097b
 clone git github.com/hashicorp/memberlist 9a1e242e454d2443df330bdd51a436d5a9058fc4
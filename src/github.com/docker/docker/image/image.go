@@ -20,9 +20,9 @@ var validHex = regexp.MustCompile(`^([a-f0-9]{64})$`)
 var noFallbackMinVersion = version.Version("1.8.3")
 
 // ImageDescriptor provides the information necessary to register an image in
-// the graph.
+// the graph. The ID of the image is computed by the graph from the config and
+// the layer of the image.
 type ImageDescriptor interface {
-	ID() string
 	Parent() string
 	MarshalConfig() ([]byte, error)
 }
@@ -57,10 +57,15 @@ type Image struct {
 	Size int64 `json:",omitempty"` // capitalized for backwards compatibility
 	// ParentID specifies the strong, content address of the parent configuration.
 	ParentID digest.Digest `json:"parent_id,omitempty"`
-	// LayerID provides the content address of the associated layer.
+	// LayerID provides the content address of the associated layer, the
+	// digest of its uncompressed tar stream (the diff ID).
 	LayerID digest.Digest `json:"layer_id,omitempty"`
 }
 
+// EmptyLayerDiffID is the diff ID of a layer without any changes, the digest
+// of a tar stream made of the two empty blocks ending an archive.
+const EmptyLayerDiffID = digest.Digest("sha256:5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef")
+
 // Build an Image object from raw json data
 func NewImgJSON(src []byte) (*Image, error) {
 	ret := &Image{}
@@ -139,6 +144,17 @@ func StrongID(configJSON []byte) (digest.Digest, error) {
 	return dgst, nil
 }
 
+// ChainID returns the ID of the layer with diff ID diffID on top of the layer
+// with chain ID parent, so layers are only identical if all the layers below
+// them are. The chain ID of a base layer is its diff ID.
+func ChainID(parent, diffID digest.Digest) digest.Digest {
+	if parent == "" {
+		return diffID
+	}
+	dgst, _ := digest.FromBytes([]byte(parent + " " + diffID))
+	return dgst
+}
+
 func rawJSON(value interface{}) *json.RawMessage {
 	jsonval, err := json.Marshal(value)
 	if err != nil {
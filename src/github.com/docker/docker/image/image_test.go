@@ -53,3 +53,18 @@ func TestGetStrongID(t *testing.T) {
 		}
 	}
 }
+
+func TestChainID(t *testing.T) {
+	base := digest.Digest("sha256:31176893850e05d308cdbfef88877e460d50c8063883fb13eb5753097da6422a")
+	if id := ChainID("", base); id != base {
+		t.Fatalf("expected the chain ID of a base layer to be its diff ID, got %s", id)
+	}
+
+	expected, _ := digest.FromBytes([]byte(base + " " + EmptyLayerDiffID))
+	if id := ChainID(base, EmptyLayerDiffID); id != expected {
+		t.Fatalf("expected chain ID %s, got %s", expected, id)
+	}
+	if ChainID(EmptyLayerDiffID, base) == ChainID(base, EmptyLayerDiffID) {
+		t.Fatal("expected the chain ID to depend on the order of the layers")
+	}
+}
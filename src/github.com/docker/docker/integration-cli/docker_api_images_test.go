@@ -131,3 +131,32 @@ func (s *DockerSuite) TestApiImagesSearchJSONContentType(c *check.C) {
 	c.Assert(res.StatusCode, check.Equals, http.StatusOK)
 	c.Assert(res.Header.Get("Content-Type"), check.Equals, "application/json")
 }
+
+func (s *DockerSuite) TestApiImagesGC(c *check.C) {
+	out, _ := dockerCmd(c, "run", "-d", "busybox", "true")
+	containerID := strings.TrimSpace(out)
+	out, _ = dockerCmd(c, "commit", containerID, "gctest")
+	danglingID := strings.TrimSpace(out)
+	out, _ = dockerCmd(c, "commit", containerID, "gctest")
+	taggedID := strings.TrimSpace(out)
+
+	status, b, err := sockRequest("POST", "/images/gc", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusOK)
+
+	var list []types.ImageDelete
+	if err := json.Unmarshal(b, &list); err != nil {
+		c.Fatal(err)
+	}
+	deleted := false
+	for _, d := range list {
+		c.Assert(d.Deleted, check.Not(check.Equals), taggedID)
+		deleted = deleted || d.Deleted == danglingID
+	}
+	c.Assert(deleted, check.Equals, true, check.Commentf("untagged image %s should be collected: %s", danglingID, b))
+
+	_, _, err = dockerCmdWithError(c, "inspect", danglingID)
+	c.Assert(err, check.NotNil)
+	dockerCmd(c, "inspect", taggedID)
+	dockerCmd(c, "inspect", "busybox")
+}
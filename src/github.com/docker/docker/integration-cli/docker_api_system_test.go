@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-check/check"
+)
+
+func (s *DockerSuite) TestSystemDiskUsageApi(c *check.C) {
+	out, _ := dockerCmd(c, "run", "-d", "-v", "/data", "busybox", "sh", "-c", "echo hello > /data/file; echo hello > /file")
+	id := strings.TrimSpace(out)
+	dockerCmd(c, "wait", id)
+
+	status, body, err := sockRequest("GET", "/system/df", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusOK)
+
+	var du types.DiskUsage
+	c.Assert(json.Unmarshal(body, &du), check.IsNil)
+	c.Assert(du.LayersSize > 0, check.Equals, true)
+
+	var image *types.ImageDiskUsage
+	for _, img := range du.Images {
+		for _, repoTag := range img.RepoTags {
+			if repoTag == "busybox:latest" {
+				image = img
+			}
+		}
+	}
+	c.Assert(image, check.NotNil)
+	c.Assert(image.Containers > 0, check.Equals, true)
+	c.Assert(image.Size, check.Equals, image.SharedSize+image.UniqueSize)
+
+	var container *types.ContainerDiskUsage
+	for _, ct := range du.Containers {
+		if ct.ID == id {
+			container = ct
+		}
+	}
+	c.Assert(container, check.NotNil)
+	c.Assert(container.SizeRw > 0, check.Equals, true)
+
+	var found bool
+	for _, v := range du.Volumes {
+		if v.Driver == "local" && v.Size > 0 {
+			found = true
+		}
+	}
+	c.Assert(found, check.Equals, true)
+}
+
+func (s *DockerSuite) TestSystemDf(c *check.C) {
+	out, _ := dockerCmd(c, "system", "df")
+	for _, typ := range []string{"Images", "Containers", "Logs", "Volumes"} {
+		c.Assert(out, check.Matches, "(?s).*\n"+typ+" .*")
+	}
+
+	out, _ = dockerCmd(c, "system", "df", "-v")
+	c.Assert(out, check.Matches, "(?s)Images space usage:.*Containers space usage:.*Volumes space usage:.*")
+}
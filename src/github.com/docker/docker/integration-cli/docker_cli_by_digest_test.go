@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"regexp"
 	"strings"
 
@@ -81,6 +82,30 @@ func (s *DockerRegistrySuite) TestPullByTagDisplaysDigest(c *check.C) {
 	}
 }
 
+func (s *DockerRegistrySuite) TestPullByTagRecordsDigest(c *check.C) {
+	pushDigest, err := setupImage(c)
+	if err != nil {
+		c.Fatalf("error setting up image: %v", err)
+	}
+
+	dockerCmd(c, "pull", repoName)
+
+	imageReference := fmt.Sprintf("%s@%s", repoName, pushDigest)
+	repoDigests, err := inspectField(repoName, "RepoDigests")
+	if err != nil {
+		c.Fatalf("error getting repo digests: %v", err)
+	}
+	if !strings.Contains(repoDigests, imageReference) {
+		c.Fatalf("expected repo digests %q to contain %q", repoDigests, imageReference)
+	}
+
+	// the digest resolves locally, without any pull output
+	out, _ := dockerCmd(c, "run", "--rm", imageReference, "sh", "-c", "echo $digest")
+	if strings.TrimSpace(out) != "1" {
+		c.Fatalf("expected digest=1 in the environment of the image, got %q", out)
+	}
+}
+
 func (s *DockerRegistrySuite) TestPullByDigest(c *check.C) {
 	pushDigest, err := setupImage(c)
 	if err != nil {
@@ -334,15 +359,11 @@ func (s *DockerRegistrySuite) TestListImagesWithDigests(c *check.C) {
 	// list images
 	out, _ = dockerCmd(c, "images", "--digests")
 
-	// make sure image 1 has repo, tag, <none> AND repo, <none>, digest
-	reWithTag1 := regexp.MustCompile(`\s*` + repoName + `\s*tag1\s*<none>\s`)
-	reWithDigest1 := regexp.MustCompile(`\s*` + repoName + `\s*<none>\s*` + digest1.String() + `\s`)
+	// make sure image 1 has repo, tag, digest
+	reWithTag1 := regexp.MustCompile(`\s*` + repoName + `\s*tag1\s*` + digest1.String() + `\s`)
 	if !reWithTag1.MatchString(out) {
 		c.Fatalf("expected %q: %s", reWithTag1.String(), out)
 	}
-	if !reWithDigest1.MatchString(out) {
-		c.Fatalf("expected %q: %s", reWithDigest1.String(), out)
-	}
 	// make sure image 2 has repo, <none>, digest
 	if !re2.MatchString(out) {
 		c.Fatalf("expected %q: %s", re2.String(), out)
@@ -360,14 +381,10 @@ func (s *DockerRegistrySuite) TestListImagesWithDigests(c *check.C) {
 	}
 
 	// make sure image 2 has repo, tag, digest
-	reWithTag2 := regexp.MustCompile(`\s*` + repoName + `\s*tag2\s*<none>\s`)
-	reWithDigest2 := regexp.MustCompile(`\s*` + repoName + `\s*<none>\s*` + digest2.String() + `\s`)
+	reWithTag2 := regexp.MustCompile(`\s*` + repoName + `\s*tag2\s*` + digest2.String() + `\s`)
 	if !reWithTag2.MatchString(out) {
 		c.Fatalf("expected %q: %s", reWithTag2.String(), out)
 	}
-	if !reWithDigest2.MatchString(out) {
-		c.Fatalf("expected %q: %s", reWithDigest2.String(), out)
-	}
 
 	// list images
 	out, _ = dockerCmd(c, "images", "--digests")
@@ -406,6 +423,32 @@ func (s *DockerRegistrySuite) TestDeleteImageByIDOnlyPulledByDigest(c *check.C)
 	dockerCmd(c, "rmi", imageID)
 }
 
+func (s *DockerRegistrySuite) TestImageGCKeepsImagePulledByDigest(c *check.C) {
+	pushDigest, err := setupImage(c)
+	if err != nil {
+		c.Fatalf("error setting up image: %v", err)
+	}
+
+	// pull from the registry using the <name>@<digest> reference
+	imageReference := fmt.Sprintf("%s@%s", repoName, pushDigest)
+	dockerCmd(c, "pull", imageReference)
+
+	imageID, err := inspectField(imageReference, "Id")
+	if err != nil {
+		c.Fatalf("error inspecting image id: %v", err)
+	}
+
+	// the image is pinned, so it is neither dangling nor collected
+	out, _ := dockerCmd(c, "images", "-q", "--no-trunc", "-f", "dangling=true")
+	if strings.Contains(out, imageID) {
+		c.Fatalf("image pulled by digest should not be dangling: %s", out)
+	}
+	status, _, err := sockRequest("POST", "/images/gc", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusOK)
+	dockerCmd(c, "inspect", imageReference)
+}
+
 // TestPullFailsWithAlteredManifest tests that a `docker pull` fails when
 // we have modified a manifest blob and its digest cannot be verified.
 func (s *DockerRegistrySuite) TestPullFailsWithAlteredManifest(c *check.C) {
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-check/check"
+)
+
+func (s *DockerSuite) TestCheckpointStoppedContainer(c *check.C) {
+	dockerCmd(c, "run", "--name", "checkpointstopped", "busybox", "true")
+
+	out, _, err := dockerCmdWithError(c, "checkpoint", "checkpointstopped")
+	if err == nil || !strings.Contains(out, "is not running") {
+		c.Fatalf("Expected checkpointing a stopped container to fail, got %q", out)
+	}
+}
+
+func (s *DockerSuite) TestRestoreNotCheckpointedContainer(c *check.C) {
+	dockerCmd(c, "run", "--name", "restorenotcheckpointed", "busybox", "true")
+
+	out, _, err := dockerCmdWithError(c, "restore", "restorenotcheckpointed")
+	if err == nil || !strings.Contains(out, "is not checkpointed") {
+		c.Fatalf("Expected restoring a container that was not checkpointed to fail, got %q", out)
+	}
+}
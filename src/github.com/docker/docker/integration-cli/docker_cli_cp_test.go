@@ -802,3 +802,45 @@ func (s *DockerSuite) TestCopyCreatedContainer(c *check.C) {
 	defer os.RemoveAll(tmpDir)
 	dockerCmd(c, "cp", "test_cp:/bin/sh", tmpDir)
 }
+
+func (s *DockerSuite) TestCpBetweenContainers(c *check.C) {
+	dockerCmd(c, "create", "--name", "cp_src", "busybox", "true")
+	dockerCmd(c, "create", "--name", "cp_dst", "busybox", "cat", "/tmp/issue")
+
+	dockerCmd(c, "cp", "cp_src:/etc/issue", "cp_dst:/tmp/")
+
+	out, _ := dockerCmd(c, "start", "-a", "cp_dst")
+	if !strings.Contains(out, "Welcome") {
+		c.Fatalf("expected the copied /etc/issue, got %q", out)
+	}
+
+	// Copying within a container must not deadlock.
+	dockerCmd(c, "cp", "cp_src:/etc/issue", "cp_src:/etc/issue.copy")
+}
+
+func (s *DockerSuite) TestCpToAndFromVolume(c *check.C) {
+	dockerCmd(c, "create", "--name", "cp_vol", "-v", "cpdata:/data", "busybox", "stat", "-c", "%u:%g", "/data/issue")
+
+	dockerCmd(c, "cp", "--chown", "1000:1001", "cp_vol:/etc/issue", "vol:cpdata:/")
+
+	out, _ := dockerCmd(c, "start", "-a", "cp_vol")
+	if strings.TrimSpace(out) != "1000:1001" {
+		c.Fatalf("expected the copied file to be owned by 1000:1001, got %q", out)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "test-docker-cp-volume-")
+	if err != nil {
+		c.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dockerCmd(c, "cp", "vol:cpdata:/issue", tmpDir)
+	if _, err := os.Stat(filepath.Join(tmpDir, "issue")); err != nil {
+		c.Fatal(err)
+	}
+
+	out, _, err = dockerCmdWithError(c, "cp", "vol:nosuchvolume:/issue", tmpDir)
+	if err == nil || !strings.Contains(out, "no such volume") {
+		c.Fatalf("expected an error copying from a missing volume, got %q", out)
+	}
+}
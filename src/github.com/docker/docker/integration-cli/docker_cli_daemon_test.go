@@ -931,6 +931,59 @@ func (s *DockerDaemonSuite) TestDaemonLinksIpTablesRulesWhenLinkAndUnlink(c *che
 	s.d.Cmd("kill", "parent")
 }
 
+func (s *DockerDaemonSuite) TestDaemonICCPolicy(c *check.C) {
+	bridgeName := "external-bridge"
+	bridgeIP := "192.169.1.1/24"
+
+	out, err := createInterface(c, "bridge", bridgeName, bridgeIP)
+	c.Assert(err, check.IsNil, check.Commentf(out))
+	defer deleteInterface(c, bridgeName)
+
+	policy, err := ioutil.TempFile("", "icc-policy")
+	c.Assert(err, check.IsNil)
+	defer os.Remove(policy.Name())
+	fmt.Fprint(policy, `{"Rules": [{"Action": "deny", "From": {"role": "client"}, "To": {"role": "server"}}]}`)
+	policy.Close()
+
+	err = s.d.StartWithBusybox("--bridge", bridgeName, "--icc-policy", policy.Name())
+	c.Assert(err, check.IsNil)
+	defer s.d.Restart()
+
+	_, err = s.d.Cmd("run", "-d", "--name", "server", "--label", "role=server", "busybox", "nc", "-l", "-p", "4567")
+	c.Assert(err, check.IsNil)
+	_, err = s.d.Cmd("run", "-d", "--name", "client", "--label", "role=client", "busybox", "top")
+	c.Assert(err, check.IsNil)
+
+	serverIP := s.d.findContainerIP("server")
+	clientIP := s.d.findContainerIP("client")
+
+	chain := "DOCKER-POL-" + bridgeName
+	jumpRule := []string{"-i", bridgeName, "-o", bridgeName, "-j", chain}
+	if !iptables.Exists("filter", "FORWARD", jumpRule...) {
+		c.Fatal("Iptables jump to the policy chain not found")
+	}
+	dropRule := []string{"-s", clientIP, "-d", serverIP, "-j", "DROP"}
+	if !iptables.Exists("filter", chain, dropRule...) {
+		c.Fatal("Iptables policy rule not found")
+	}
+
+	// The policy drops the connection even though --icc=true
+	out, err = s.d.Cmd("run", "--rm", "--label", "role=client", "busybox", "sh", "-c", "nc -w 1 "+serverIP+" 4567 </dev/null")
+	c.Assert(err, check.NotNil, check.Commentf(out))
+
+	s.d.Cmd("kill", "client")
+	if iptables.Exists("filter", chain, dropRule...) {
+		c.Fatal("Iptables policy rule should be removed when the container stops")
+	}
+	s.d.Cmd("kill", "server")
+}
+
+func (s *DockerDaemonSuite) TestDaemonICCPolicyRequiresIptables(c *check.C) {
+	if err := s.d.Start("--iptables=false", "--icc-policy", "/nonexistent"); err == nil {
+		c.Fatal("Expected daemon not to start with --icc-policy and --iptables=false")
+	}
+}
+
 func (s *DockerDaemonSuite) TestDaemonUlimitDefaults(c *check.C) {
 	testRequires(c, NativeExecDriver)
 
@@ -86,3 +86,23 @@ func (s *DockerSuite) TestDiffEmptyArgClientError(c *check.C) {
 	c.Assert(err, check.NotNil)
 	c.Assert(strings.TrimSpace(out), check.Equals, "Container name cannot be empty")
 }
+
+func (s *DockerSuite) TestDiffIncludeExcludeVerbose(c *check.C) {
+	containerCmd := `mkdir -p /root/keep /root/skip && echo foo > /root/keep/bar && echo foo > /root/skip/bar && rm /etc/motd`
+	out, _ := dockerCmd(c, "run", "-d", "busybox", "sh", "-c", containerCmd)
+	cleanCID := strings.TrimSpace(out)
+	dockerCmd(c, "wait", cleanCID)
+
+	out, _ = dockerCmd(c, "diff", "--include", "/root", "--exclude", "/root/skip", cleanCID)
+	if !strings.Contains(out, "A /root/keep/bar") {
+		c.Fatalf("Expected the included file in docker diff's output: %v", out)
+	}
+	if strings.Contains(out, "/root/skip") || strings.Contains(out, "/dev") {
+		c.Fatalf("Expected the excluded changes not to be in docker diff's output: %v", out)
+	}
+
+	out, _ = dockerCmd(c, "diff", "--verbose", "--include", "/root/keep/bar", cleanCID)
+	if fields := strings.Fields(out); len(fields) != 5 || fields[0] != "A" || fields[1] != "-rw-r--r--" || fields[2] != "4" || fields[4] != "/root/keep/bar" {
+		c.Fatalf("Expected the mode and size of the added file in docker diff's output: %v", out)
+	}
+}
@@ -54,3 +54,46 @@ func (s *DockerSuite) TestExportContainerWithOutputAndImportImage(c *check.C) {
 		c.Fatalf("output should have been an image id, got: %s", out)
 	}
 }
+
+func (s *DockerSuite) TestExportContainerChanges(c *check.C) {
+	containerID := "testexportcontainerchanges"
+
+	dockerCmd(c, "run", "--name", containerID, "busybox", "sh", "-c", "echo foo > /root/bar && echo foo > /root/baz && rm /etc/motd")
+
+	out, _ := dockerCmd(c, "export", "--changes", "--exclude", "/root/baz", containerID)
+
+	listCmd := exec.Command("tar", "-t")
+	listCmd.Stdin = strings.NewReader(out)
+	out, _, err := runCommandWithOutput(listCmd)
+	if err != nil {
+		c.Fatalf("failed to list the exported changes: %s, %v", out, err)
+	}
+	for _, name := range []string{"root/bar", "etc/.wh.motd"} {
+		if !strings.Contains(out, name) {
+			c.Fatalf("Expected %s in the exported changes: %s", name, out)
+		}
+	}
+	for _, name := range []string{"root/baz", "bin/"} {
+		if strings.Contains(out, name) {
+			c.Fatalf("Expected %s not to be in the exported changes: %s", name, out)
+		}
+	}
+}
+
+func (s *DockerSuite) TestExportContainerInclude(c *check.C) {
+	containerID := "testexportcontainerinclude"
+
+	dockerCmd(c, "run", "--name", containerID, "busybox", "true")
+
+	out, _ := dockerCmd(c, "export", "--include", "/etc", "--exclude", "/etc/passwd", containerID)
+
+	listCmd := exec.Command("tar", "-t")
+	listCmd.Stdin = strings.NewReader(out)
+	out, _, err := runCommandWithOutput(listCmd)
+	if err != nil {
+		c.Fatalf("failed to list the exported files: %s, %v", out, err)
+	}
+	if !strings.Contains(out, "etc/group") || strings.Contains(out, "etc/passwd") || strings.Contains(out, "bin/") {
+		c.Fatalf("Expected only /etc without /etc/passwd to be exported: %s", out)
+	}
+}
@@ -153,3 +153,15 @@ func (s *DockerSuite) TestImagesEnsureDanglingImageOnlyListedOnce(c *check.C) {
 		c.Fatalf("expected 1 dangling image, got %d: %s", a, out)
 	}
 }
+
+func (s *DockerSuite) TestImagesListTagWithoutDigest(c *check.C) {
+	// An image built or tagged locally has tags but no digests.
+	dockerCmd(c, "tag", "busybox", "tagonly:latest")
+
+	for _, args := range [][]string{{"images"}, {"images", "--digests"}} {
+		out, _ := dockerCmd(c, args...)
+		if !strings.Contains(out, "tagonly") {
+			c.Fatalf("%v should've listed tagonly: %s", args, out)
+		}
+	}
+}
@@ -78,3 +78,36 @@ func (s *DockerSuite) TestImportFileNonExistentFile(c *check.C) {
 		c.Fatalf("import non-existing file must failed")
 	}
 }
+
+func (s *DockerSuite) TestImportParent(c *check.C) {
+	dockerCmd(c, "run", "--name", "test-import-parent", "busybox", "sh", "-c", "echo foo > /root/bar && rm /etc/motd")
+
+	out, _, err := runCommandPipelineWithOutput(
+		exec.Command(dockerBinary, "export", "--changes", "test-import-parent"),
+		exec.Command(dockerBinary, "import", "--parent", "busybox", "--change", "ENV DEBUG true", "-"),
+	)
+	if err != nil {
+		c.Fatalf("import failed with errors: %v, output: %q", err, out)
+	}
+	image := strings.TrimSpace(out)
+
+	out, _ = dockerCmd(c, "inspect", "--format", "{{.Parent}}", image)
+	if parent, _ := dockerCmd(c, "inspect", "--format", "{{.Id}}", "busybox"); out != parent {
+		c.Fatalf("expected the parent of the imported image to be busybox, got %q", out)
+	}
+
+	out, _ = dockerCmd(c, "run", "--rm", image, "sh", "-c", "cat /root/bar && ls /etc/motd; echo $DEBUG")
+	if !strings.Contains(out, "foo") || !strings.Contains(out, "No such file") || !strings.Contains(out, "true") {
+		c.Fatalf("expected the changes to be applied on top of busybox, got %q", out)
+	}
+}
+
+func (s *DockerSuite) TestImportParentNonExistentImage(c *check.C) {
+	out, _, err := runCommandPipelineWithOutput(
+		exec.Command("tar", "-c", "--files-from", "/dev/null"),
+		exec.Command(dockerBinary, "import", "--parent", "nosuchimage", "-"),
+	)
+	if err == nil {
+		c.Fatalf("import on top of a non-existing image must fail, got %q", out)
+	}
+}
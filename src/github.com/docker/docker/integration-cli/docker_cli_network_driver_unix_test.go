@@ -0,0 +1,224 @@
+// +build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/go-check/check"
+)
+
+const (
+	testNetworkDriver     = "test-external-network-driver"
+	testNetworkDriverAddr = "10.77.0.2"
+	testNetworkDriverVeth = "dnettest0"
+)
+
+func init() {
+	check.Suite(&DockerExternalNetworkSuite{
+		ds: &DockerSuite{},
+	})
+}
+
+type networkEventCounter struct {
+	activations     int
+	createNetwork   int
+	deleteNetwork   int
+	createEndpoint  int
+	deleteEndpoint  int
+	endpointInfo    int
+	joins           int
+	leaves          int
+	lastNetworkID   string
+	lastEndpointID  string
+	lastSandboxPath string
+}
+
+type DockerExternalNetworkSuite struct {
+	server *httptest.Server
+	ds     *DockerSuite
+	d      *Daemon
+	ec     *networkEventCounter
+}
+
+func (s *DockerExternalNetworkSuite) SetUpTest(c *check.C) {
+	s.d = NewDaemon(c)
+	s.ec = &networkEventCounter{}
+}
+
+func (s *DockerExternalNetworkSuite) TearDownTest(c *check.C) {
+	s.d.Stop()
+	s.ds.TearDownTest(c)
+}
+
+// SetUpSuite starts a fake network driver plugin listening on a Unix socket in
+// the plugins directory, where the daemon discovers it by name.
+func (s *DockerExternalNetworkSuite) SetUpSuite(c *check.C) {
+	mux := http.NewServeMux()
+	s.server = httptest.NewUnstartedServer(mux)
+
+	if err := os.MkdirAll("/run/docker/plugins", 0755); err != nil {
+		c.Fatal(err)
+	}
+	l, err := net.Listen("unix", fmt.Sprintf("/run/docker/plugins/%s.sock", testNetworkDriver))
+	if err != nil {
+		c.Fatal(err)
+	}
+	s.server.Listener = l
+	s.server.Start()
+
+	type networkRequest struct {
+		NetworkID  string
+		EndpointID string
+		SandboxKey string
+	}
+
+	decode := func(w http.ResponseWriter, r *http.Request) (networkRequest, bool) {
+		var nr networkRequest
+		if err := json.NewDecoder(r.Body).Decode(&nr); err != nil {
+			http.Error(w, err.Error(), 500)
+			return nr, false
+		}
+		return nr, true
+	}
+
+	respond := func(w http.ResponseWriter, body string) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintln(w, body)
+	}
+
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		s.ec.activations++
+		respond(w, `{"Implements": ["NetworkDriver"]}`)
+	})
+
+	mux.HandleFunc("/NetworkDriver.CreateNetwork", func(w http.ResponseWriter, r *http.Request) {
+		nr, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		s.ec.createNetwork++
+		s.ec.lastNetworkID = nr.NetworkID
+		respond(w, `{}`)
+	})
+
+	mux.HandleFunc("/NetworkDriver.DeleteNetwork", func(w http.ResponseWriter, r *http.Request) {
+		s.ec.deleteNetwork++
+		respond(w, `{}`)
+	})
+
+	mux.HandleFunc("/NetworkDriver.CreateEndpoint", func(w http.ResponseWriter, r *http.Request) {
+		nr, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		s.ec.createEndpoint++
+		s.ec.lastEndpointID = nr.EndpointID
+		respond(w, fmt.Sprintf(`{"Interfaces": [{"ID": 0, "Address": "%s/24"}]}`, testNetworkDriverAddr))
+	})
+
+	mux.HandleFunc("/NetworkDriver.DeleteEndpoint", func(w http.ResponseWriter, r *http.Request) {
+		s.ec.deleteEndpoint++
+		respond(w, `{}`)
+	})
+
+	mux.HandleFunc("/NetworkDriver.EndpointOperInfo", func(w http.ResponseWriter, r *http.Request) {
+		s.ec.endpointInfo++
+		respond(w, `{"Value": {}}`)
+	})
+
+	mux.HandleFunc("/NetworkDriver.Join", func(w http.ResponseWriter, r *http.Request) {
+		nr, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		s.ec.joins++
+		s.ec.lastSandboxPath = nr.SandboxKey
+
+		// The driver hands over one end of a veth pair, which the daemon
+		// moves into the container's network namespace.
+		out, err := exec.Command("ip", "link", "add", testNetworkDriverVeth, "type", "veth", "peer", "name", testNetworkDriverVeth+"p").CombinedOutput()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v: %s", err, out), 500)
+			return
+		}
+		respond(w, fmt.Sprintf(`{"InterfaceNames": [{"SrcName": "%sp", "DstPrefix": "eth"}]}`, testNetworkDriverVeth))
+	})
+
+	mux.HandleFunc("/NetworkDriver.Leave", func(w http.ResponseWriter, r *http.Request) {
+		s.ec.leaves++
+		exec.Command("ip", "link", "del", testNetworkDriverVeth).Run()
+		respond(w, `{}`)
+	})
+}
+
+func (s *DockerExternalNetworkSuite) TearDownSuite(c *check.C) {
+	s.server.Close()
+
+	if err := os.RemoveAll("/run/docker/plugins"); err != nil {
+		c.Fatal(err)
+	}
+}
+
+func (s *DockerExternalNetworkSuite) TestRunWithExternalNetworkDriver(c *check.C) {
+	if err := s.d.StartWithBusybox(); err != nil {
+		c.Fatal(err)
+	}
+
+	out, err := s.d.Cmd("run", "--rm", "--net", testNetworkDriver, "busybox", "ip", "-o", "-4", "addr", "show", "eth0")
+	if err != nil {
+		c.Fatal(err, out)
+	}
+
+	if !strings.Contains(out, testNetworkDriverAddr+"/24") {
+		c.Fatalf("Expected eth0 to have the address handed out by the plugin, got: %s", out)
+	}
+
+	c.Assert(s.ec.activations, check.Equals, 1)
+	c.Assert(s.ec.createNetwork, check.Equals, 1)
+	c.Assert(s.ec.createEndpoint, check.Equals, 1)
+	c.Assert(s.ec.joins, check.Equals, 1)
+	c.Assert(s.ec.leaves, check.Equals, 1)
+	c.Assert(s.ec.deleteEndpoint, check.Equals, 1)
+	c.Assert(s.ec.lastNetworkID, check.Not(check.Equals), "")
+	c.Assert(s.ec.lastEndpointID, check.Not(check.Equals), "")
+}
+
+func (s *DockerExternalNetworkSuite) TestRunWithExternalNetworkDriverReusesNetwork(c *check.C) {
+	if err := s.d.StartWithBusybox(); err != nil {
+		c.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if out, err := s.d.Cmd("run", "--rm", "--net", testNetworkDriver, "busybox", "true"); err != nil {
+			c.Fatal(err, out)
+		}
+	}
+
+	// The network is created on first use and then shared by every
+	// container that selects the driver.
+	c.Assert(s.ec.createNetwork, check.Equals, 1)
+	c.Assert(s.ec.createEndpoint, check.Equals, 2)
+	c.Assert(s.ec.deleteEndpoint, check.Equals, 2)
+}
+
+func (s *DockerExternalNetworkSuite) TestRunWithMissingNetworkDriver(c *check.C) {
+	if err := s.d.StartWithBusybox(); err != nil {
+		c.Fatal(err)
+	}
+
+	out, err := s.d.Cmd("run", "--rm", "--net", "no-such-network-driver", "busybox", "true")
+	if err == nil {
+		c.Fatalf("Expected run with a missing network driver plugin to fail, got: %s", out)
+	}
+	if !strings.Contains(out, "Could not find a network driver plugin named no-such-network-driver") {
+		c.Fatalf("Expected a missing plugin error, got: %s", out)
+	}
+}
@@ -951,6 +951,107 @@ func (s *DockerSuite) TestRunDnsOptions(c *check.C) {
 	}
 }
 
+func (s *DockerSuite) TestRunDnsResolverOptions(c *check.C) {
+	out, _ := dockerCmd(c, "run", "--dns=8.8.8.8", "--dns-search=mydomain", "--dns-opt=ndots:9", "--dns-opt=rotate", "busybox", "cat", "/etc/resolv.conf")
+
+	actual := strings.Replace(strings.Trim(out, "\r\n"), "\n", " ", -1)
+	if actual != "nameserver 8.8.8.8 search mydomain options ndots:9 rotate" {
+		c.Fatalf("expected 'nameserver 8.8.8.8 search mydomain options ndots:9 rotate', but says: %q", actual)
+	}
+
+	out, _, err := dockerCmdWithError(c, "run", "--dns-opt=ndots: 9", "busybox", "true")
+	if err == nil || !strings.Contains(out, "is not a valid DNS option") {
+		c.Fatalf("expected an invalid DNS option to be rejected, got: %s", out)
+	}
+}
+
+// resolvOptions returns the options of the last options line of resolvConf.
+func resolvOptions(resolvConf []byte) []string {
+	options := []string{}
+	for _, line := range strings.Split(string(resolvConf), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "options" {
+			options = fields[1:]
+		}
+	}
+	return options
+}
+
+func (s *DockerSuite) TestRunDnsOptionsMergedWithHostResolvConf(c *check.C) {
+	testRequires(c, SameHostDaemon)
+
+	origResolvConf, err := ioutil.ReadFile("/etc/resolv.conf")
+	if os.IsNotExist(err) {
+		c.Fatalf("/etc/resolv.conf does not exist")
+	}
+
+	tmpResolvConf := []byte("search example.com\nnameserver 12.34.56.78\noptions timeout:3")
+	if err := ioutil.WriteFile("/etc/resolv.conf", tmpResolvConf, 0644); err != nil {
+		c.Fatal(err)
+	}
+	defer func() {
+		if err := ioutil.WriteFile("/etc/resolv.conf", origResolvConf, 0644); err != nil {
+			c.Fatal(err)
+		}
+	}()
+
+	// Only the options are replaced, the rest comes from the host
+	out, _ := dockerCmd(c, "run", "--dns-opt=ndots:2", "busybox", "cat", "/etc/resolv.conf")
+	if ns := resolvconf.GetNameservers([]byte(out)); len(ns) != 1 || ns[0] != "12.34.56.78" {
+		c.Fatalf("expected the host nameserver 12.34.56.78, but has: %v", ns)
+	}
+	if search := resolvconf.GetSearchDomains([]byte(out)); len(search) != 1 || search[0] != "example.com" {
+		c.Fatalf("expected the host search domain example.com, but has: %v", search)
+	}
+	if options := resolvOptions([]byte(out)); len(options) != 1 || options[0] != "ndots:2" {
+		c.Fatalf("expected the options ndots:2, but has: %v", options)
+	}
+
+	// Without --dns-opt the options of the host are kept
+	out, _ = dockerCmd(c, "run", "--dns-search=mydomain", "busybox", "cat", "/etc/resolv.conf")
+	if options := resolvOptions([]byte(out)); len(options) != 1 || options[0] != "timeout:3" {
+		c.Fatalf("expected the host options timeout:3, but has: %v", options)
+	}
+}
+
+func (s *DockerSuite) TestRunDnsUserManaged(c *check.C) {
+	testRequires(c, SameHostDaemon)
+
+	origResolvConf, err := ioutil.ReadFile("/etc/resolv.conf")
+	if os.IsNotExist(err) {
+		c.Fatalf("/etc/resolv.conf does not exist")
+	}
+	defer func() {
+		if err := ioutil.WriteFile("/etc/resolv.conf", origResolvConf, 0644); err != nil {
+			c.Fatal(err)
+		}
+	}()
+
+	dockerCmd(c, "run", "--name=usermanaged", "--dns-user-managed", "busybox", "true")
+	containerID, err := getIDByName("usermanaged")
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	generated, err := readContainerFile(containerID, "resolv.conf")
+	if err != nil {
+		c.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("/etc/resolv.conf", []byte("search example.com\nnameserver 12.34.56.78"), 0644); err != nil {
+		c.Fatal(err)
+	}
+
+	dockerCmd(c, "start", "usermanaged")
+
+	containerResolv, err := readContainerFile(containerID, "resolv.conf")
+	if err != nil {
+		c.Fatal(err)
+	}
+	if !bytes.Equal(containerResolv, generated) {
+		c.Fatalf("resolv.conf of a container with --dns-user-managed should not be updated; expected %q, got %q", string(generated), string(containerResolv))
+	}
+}
+
 func (s *DockerSuite) TestRunDnsOptionsBasedOnHostResolvConf(c *check.C) {
 	testRequires(c, SameHostDaemon)
 
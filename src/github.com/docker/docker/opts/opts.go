@@ -325,6 +325,16 @@ func ValidateDNSSearch(val string) (string, error) {
 	return validateDomain(val)
 }
 
+// ValidateDNSOption Validates an option for resolvconf options configuration,
+// such as ndots:2, timeout:3 or rotate.
+func ValidateDNSOption(val string) (string, error) {
+	val = strings.TrimSpace(val)
+	if val == "" || strings.ContainsAny(val, " \t") {
+		return "", fmt.Errorf("%q is not a valid DNS option", val)
+	}
+	return val, nil
+}
+
 func validateDomain(val string) (string, error) {
 	if alphaRegexp.FindString(val) == "" {
 		return "", fmt.Errorf("%s is not a valid domain", val)
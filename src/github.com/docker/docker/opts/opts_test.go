@@ -188,6 +188,34 @@ func TestValidateDNSSearch(t *testing.T) {
 	}
 }
 
+func TestValidateDNSOption(t *testing.T) {
+	valid := []string{
+		`ndots:2`,
+		`timeout:3`,
+		`rotate`,
+		` attempts:5 `,
+	}
+
+	invalid := []string{
+		``,
+		` `,
+		`ndots: 2`,
+		"timeout:3\trotate",
+	}
+
+	for _, option := range valid {
+		if ret, err := ValidateDNSOption(option); err != nil || ret == "" {
+			t.Fatalf("ValidateDNSOption(`"+option+"`) got %s %s", ret, err)
+		}
+	}
+
+	for _, option := range invalid {
+		if ret, err := ValidateDNSOption(option); err == nil || ret != "" {
+			t.Fatalf("ValidateDNSOption(`"+option+"`) got %s %s", ret, err)
+		}
+	}
+}
+
 func TestValidateExtraHosts(t *testing.T) {
 	valid := []string{
 		`myhost:192.168.0.1`,
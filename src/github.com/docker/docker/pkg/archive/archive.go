@@ -44,6 +44,10 @@ type (
 		// For each include when creating an archive, the included name will be
 		// replaced with the matching name from this map.
 		RebaseNames map[string]string
+
+		// WhiteoutFormat is the format of the whiteouts on the filesystem,
+		// which are converted from and to the AUFS whiteouts of archives.
+		WhiteoutFormat WhiteoutFormat
 	}
 
 	// Archiver allows the reuse of most utility functions of this package
@@ -68,8 +72,13 @@ const (
 	Bzip2
 	Gzip
 	Xz
+	Zstd
 )
 
+// DefaultCompressionLevel selects the default level of a compression
+// algorithm in CompressStreamLevel.
+const DefaultCompressionLevel = -1
+
 func IsArchive(header []byte) bool {
 	compression := DetectCompression(header)
 	if compression != Uncompressed {
@@ -85,6 +94,7 @@ func DetectCompression(source []byte) Compression {
 		Bzip2: {0x42, 0x5A, 0x68},
 		Gzip:  {0x1F, 0x8B, 0x08},
 		Xz:    {0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00},
+		Zstd:  {0x28, 0xB5, 0x2F, 0xFD},
 	} {
 		if len(source) < len(m) {
 			logrus.Debugf("Len too short")
@@ -103,6 +113,43 @@ func xzDecompress(archive io.Reader) (io.ReadCloser, error) {
 	return CmdStream(exec.Command(args[0], args[1:]...), archive)
 }
 
+func zstdDecompress(archive io.Reader) (io.ReadCloser, error) {
+	args := []string{"zstd", "-d", "-c", "-q"}
+
+	return CmdStream(exec.Command(args[0], args[1:]...), archive)
+}
+
+// cmdWriteCloser compresses what is written to it with a command, writing
+// the output of the command to dest. Close waits for the command to exit.
+type cmdWriteCloser struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+func cmdCompress(dest io.Writer, args ...string) (io.WriteCloser, error) {
+	w := &cmdWriteCloser{cmd: exec.Command(args[0], args[1:]...)}
+	w.cmd.Stdout = dest
+	w.cmd.Stderr = &w.stderr
+	stdin, err := w.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	w.WriteCloser = stdin
+	if err := w.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *cmdWriteCloser) Close() error {
+	w.WriteCloser.Close()
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %s", err, w.stderr.String())
+	}
+	return nil
+}
+
 func DecompressStream(archive io.Reader) (io.ReadCloser, error) {
 	p := pools.BufioReader32KPool
 	buf := p.Get(archive)
@@ -134,29 +181,125 @@ func DecompressStream(archive io.Reader) (io.ReadCloser, error) {
 		}
 		readBufWrapper := p.NewReadCloserWrapper(buf, xzReader)
 		return readBufWrapper, nil
+	case Zstd:
+		zstdReader, err := zstdDecompress(buf)
+		if err != nil {
+			return nil, err
+		}
+		readBufWrapper := p.NewReadCloserWrapper(buf, zstdReader)
+		return readBufWrapper, nil
 	default:
 		return nil, fmt.Errorf("Unsupported compression format %s", (&compression).Extension())
 	}
 }
 
 func CompressStream(dest io.WriteCloser, compression Compression) (io.WriteCloser, error) {
-	p := pools.BufioWriter32KPool
-	buf := p.Get(dest)
+	return CompressStreamLevel(dest, compression, DefaultCompressionLevel)
+}
+
+// CompressStreamLevel compresses what is written to the returned writer to
+// dest, at the given level of the compression algorithm. Gzip, xz and zstd
+// compress on all the CPUs of the host. As the standard library cannot write
+// bzip2, xz and zstd, they need the bzip2, xz and zstd commands.
+func CompressStreamLevel(dest io.WriteCloser, compression Compression, level int) (io.WriteCloser, error) {
+	if err := compression.ValidateLevel(level); err != nil {
+		return nil, err
+	}
+	threads := runtime.NumCPU()
+	var args []string
 	switch compression {
 	case Uncompressed:
+		p := pools.BufioWriter32KPool
+		buf := p.Get(dest)
 		writeBufWrapper := p.NewWriteCloserWrapper(buf, buf)
 		return writeBufWrapper, nil
 	case Gzip:
-		gzWriter := gzip.NewWriter(dest)
-		writeBufWrapper := p.NewWriteCloserWrapper(buf, gzWriter)
-		return writeBufWrapper, nil
-	case Bzip2, Xz:
-		// archive/bzip2 does not support writing, and there is no xz support at all
-		// However, this is not a problem as docker only currently generates gzipped tars
-		return nil, fmt.Errorf("Unsupported compression format %s", (&compression).Extension())
+		return newParallelGzipWriter(dest, level, threads), nil
+	case Bzip2:
+		args = []string{"bzip2", "-z", "-c", "-q"}
+	case Xz:
+		args = []string{"xz", "-z", "-c", "-q", fmt.Sprintf("-T%d", threads)}
+	case Zstd:
+		args = []string{"zstd", "-c", "-q", fmt.Sprintf("-T%d", threads)}
+	}
+	if level != DefaultCompressionLevel {
+		args = append(args, fmt.Sprintf("-%d", level))
+	}
+	return cmdCompress(dest, args...)
+}
+
+// ValidateLevel returns an error if the compression is not supported, or
+// level is not one of its levels.
+func (compression Compression) ValidateLevel(level int) error {
+	var min, max int
+	switch compression {
+	case Uncompressed:
+		return nil
+	case Gzip:
+		min, max = 0, 9
+	case Bzip2:
+		min, max = 1, 9
+	case Xz:
+		min, max = 0, 9
+	case Zstd:
+		min, max = 1, 19
 	default:
-		return nil, fmt.Errorf("Unsupported compression format %s", (&compression).Extension())
+		return fmt.Errorf("Unsupported compression format %s", (&compression).Extension())
+	}
+	if level != DefaultCompressionLevel && (level < min || level > max) {
+		return fmt.Errorf("Invalid compression level %d for %s, must be between %d and %d", level, compression.Name(), min, max)
+	}
+	return nil
+}
+
+// CheckCommand returns an error if the command compressing with the
+// compression algorithm is not installed. Xz and zstd streams are
+// decompressed with the command too.
+func (compression Compression) CheckCommand() error {
+	var name string
+	switch compression {
+	case Bzip2:
+		name = "bzip2"
+	case Xz:
+		name = "xz"
+	case Zstd:
+		name = "zstd"
+	default:
+		return nil
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%s compression needs the %s command: %v", compression.Name(), name, err)
+	}
+	return nil
+}
+
+// ParseCompression returns the compression algorithm of the given name, one
+// of none, gzip, bzip2, xz or zstd.
+func ParseCompression(name string) (Compression, error) {
+	for _, compression := range []Compression{Uncompressed, Bzip2, Gzip, Xz, Zstd} {
+		if compression.Name() == name {
+			return compression, nil
+		}
+	}
+	return Uncompressed, fmt.Errorf("Unsupported compression format %s", name)
+}
+
+// Name returns the name of the compression algorithm, as parsed by
+// ParseCompression.
+func (compression Compression) Name() string {
+	switch compression {
+	case Uncompressed:
+		return "none"
+	case Bzip2:
+		return "bzip2"
+	case Gzip:
+		return "gzip"
+	case Xz:
+		return "xz"
+	case Zstd:
+		return "zstd"
 	}
+	return ""
 }
 
 func (compression *Compression) Extension() string {
@@ -169,16 +312,45 @@ func (compression *Compression) Extension() string {
 		return "tar.gz"
 	case Xz:
 		return "tar.xz"
+	case Zstd:
+		return "tar.zst"
 	}
 	return ""
 }
 
+// WhiteoutFormat is the format of whiteouts on a filesystem.
+type WhiteoutFormat int
+
+const (
+	// AUFSWhiteoutFormat is the format of the whiteouts of archives, files
+	// prefixed with WhiteoutPrefix.
+	AUFSWhiteoutFormat WhiteoutFormat = iota
+	// OverlayWhiteoutFormat is the format of overlay, character devices
+	// 0/0 for removed files and an xattr on opaque directories.
+	OverlayWhiteoutFormat
+)
+
+// tarWhiteoutConverter converts the whiteouts of a filesystem from and to the
+// AUFS whiteouts of archives.
+type tarWhiteoutConverter interface {
+	// ConvertWrite converts hdr of the file at path in place. When it
+	// returns another header, that header is written after hdr.
+	ConvertWrite(hdr *tar.Header, path string, fi os.FileInfo) (*tar.Header, error)
+	// ConvertRead converts the whiteout hdr unpacked to path, and returns
+	// whether the file of hdr should still be created.
+	ConvertRead(hdr *tar.Header, path string) (bool, error)
+}
+
 type tarAppender struct {
 	TarWriter *tar.Writer
 	Buffer    *bufio.Writer
 
 	// for hardlink mapping
 	SeenFiles map[uint64]string
+
+	// WhiteoutConverter converts the whiteouts of the files added, when
+	// set.
+	WhiteoutConverter tarWhiteoutConverter
 }
 
 // canonicalTarName provides a platform-independent and consistent posix-style
@@ -246,11 +418,29 @@ func (ta *tarAppender) addTarFile(path, name string) error {
 		hdr.Xattrs["security.capability"] = string(capability)
 	}
 
+	// A whiteout converted from a device or a directory has no content.
+	converted := false
+	if ta.WhiteoutConverter != nil {
+		typeflag := hdr.Typeflag
+		wo, err := ta.WhiteoutConverter.ConvertWrite(hdr, path, fi)
+		if err != nil {
+			return err
+		}
+		// The whiteout of an opaque directory must follow the directory.
+		if wo != nil {
+			if err := ta.TarWriter.WriteHeader(hdr); err != nil {
+				return err
+			}
+			hdr = wo
+		}
+		converted = wo != nil || hdr.Typeflag != typeflag
+	}
+
 	if err := ta.TarWriter.WriteHeader(hdr); err != nil {
 		return err
 	}
 
-	if hdr.Typeflag == tar.TypeReg {
+	if hdr.Typeflag == tar.TypeReg && !converted {
 		file, err := os.Open(path)
 		if err != nil {
 			return err
@@ -405,9 +595,10 @@ func TarWithOptions(srcPath string, options *TarOptions) (io.ReadCloser, error)
 
 	go func() {
 		ta := &tarAppender{
-			TarWriter: tar.NewWriter(compressWriter),
-			Buffer:    pools.BufioWriter32KPool.Get(nil),
-			SeenFiles: make(map[uint64]string),
+			TarWriter:         tar.NewWriter(compressWriter),
+			Buffer:            pools.BufioWriter32KPool.Get(nil),
+			SeenFiles:         make(map[uint64]string),
+			WhiteoutConverter: getWhiteoutConverter(options.WhiteoutFormat),
 		}
 
 		defer func() {
@@ -536,6 +727,7 @@ func Unpack(decompressedArchive io.Reader, dest string, options *TarOptions) err
 	defer pools.BufioReader32KPool.Put(trBuf)
 
 	var dirs []*tar.Header
+	whiteoutConverter := getWhiteoutConverter(options.WhiteoutFormat)
 
 	// Iterate through the files in the archive.
 loop:
@@ -613,6 +805,16 @@ loop:
 		}
 		trBuf.Reset(tr)
 
+		if whiteoutConverter != nil {
+			writeFile, err := whiteoutConverter.ConvertRead(hdr, path)
+			if err != nil {
+				return err
+			}
+			if !writeFile {
+				continue
+			}
+		}
+
 		if err := createTarFile(path, dest, hdr, trBuf, !options.NoLchown, options.ChownOpts); err != nil {
 			return err
 		}
@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/system"
+)
+
+func getWhiteoutConverter(format WhiteoutFormat) tarWhiteoutConverter {
+	if format == OverlayWhiteoutFormat {
+		return overlayWhiteoutConverter{}
+	}
+	return nil
+}
+
+type overlayWhiteoutConverter struct{}
+
+func (overlayWhiteoutConverter) ConvertWrite(hdr *tar.Header, path string, fi os.FileInfo) (*tar.Header, error) {
+	// A removed file is a character device 0/0, which is archived as an
+	// empty file with the whiteout prefix.
+	if fi.Mode()&os.ModeCharDevice != 0 && hdr.Devmajor == 0 && hdr.Devminor == 0 {
+		dir, filename := filepath.Split(hdr.Name)
+		hdr.Name = filepath.Join(dir, WhiteoutPrefix+filename)
+		hdr.Mode = 0600
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = 0
+		return nil, nil
+	}
+
+	if !fi.IsDir() {
+		return nil, nil
+	}
+	// An opaque directory is archived with an opaque whiteout inside it.
+	opaque, err := system.Lgetxattr(path, "trusted.overlay.opaque")
+	if err != nil {
+		return nil, err
+	}
+	if len(opaque) != 1 || opaque[0] != 'y' {
+		return nil, nil
+	}
+	if hdr.Xattrs != nil {
+		delete(hdr.Xattrs, "trusted.overlay.opaque")
+	}
+	return &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Mode:       hdr.Mode & int64(os.ModePerm),
+		Name:       filepath.Join(hdr.Name, WhiteoutOpaqueDir),
+		Uid:        hdr.Uid,
+		Uname:      hdr.Uname,
+		Gid:        hdr.Gid,
+		Gname:      hdr.Gname,
+		ModTime:    hdr.ModTime,
+		AccessTime: hdr.AccessTime,
+		ChangeTime: hdr.ChangeTime,
+	}, nil
+}
+
+func (overlayWhiteoutConverter) ConvertRead(hdr *tar.Header, path string) (bool, error) {
+	base := filepath.Base(path)
+	dir := filepath.Dir(path)
+
+	if base == WhiteoutOpaqueDir {
+		if err := system.Lsetxattr(dir, "trusted.overlay.opaque", []byte{'y'}, 0); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	// Other AUFS metadata has no meaning for overlay.
+	if strings.HasPrefix(base, WhiteoutMetaPrefix) {
+		return false, nil
+	}
+
+	if strings.HasPrefix(base, WhiteoutPrefix) {
+		originalPath := filepath.Join(dir, base[len(WhiteoutPrefix):])
+		if err := syscall.Mknod(originalPath, syscall.S_IFCHR, 0); err != nil {
+			return false, err
+		}
+		if err := os.Lchown(originalPath, hdr.Uid, hdr.Gid); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
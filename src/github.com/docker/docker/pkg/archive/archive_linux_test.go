@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/pkg/pools"
+	"github.com/docker/docker/pkg/system"
+)
+
+// setupOverlayTestDir creates a directory with an overlay whiteout for
+// d1/f2 and an opaque directory d2.
+func setupOverlayTestDir(t *testing.T, src string) {
+	if err := os.MkdirAll(filepath.Join(src, "d1"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "d1", "f1"), []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mknod(filepath.Join(src, "d1", "f2"), syscall.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(src, "d2"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := system.Lsetxattr(filepath.Join(src, "d2"), "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOverlayTarUntar(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "docker-test-overlay-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	src := filepath.Join(tmpdir, "src")
+	dst := filepath.Join(tmpdir, "dst")
+	setupOverlayTestDir(t, src)
+
+	options := &TarOptions{
+		Compression:    Uncompressed,
+		WhiteoutFormat: OverlayWhiteoutFormat,
+	}
+	archive, err := TarWithOptions(src, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	if err := os.Mkdir(dst, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := Untar(archive, dst, options); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Lstat(filepath.Join(dst, "d1", "f2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeCharDevice == 0 || fi.Sys().(*syscall.Stat_t).Rdev != 0 {
+		t.Fatalf("d1/f2 should be a whiteout, got mode %s", fi.Mode())
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "d1", WhiteoutPrefix+"f2")); !os.IsNotExist(err) {
+		t.Fatalf("AUFS whiteout should not be unpacked, got %v", err)
+	}
+
+	opaque, err := system.Lgetxattr(filepath.Join(dst, "d2"), "trusted.overlay.opaque")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opaque) != "y" {
+		t.Fatalf("d2 should be opaque, got %q", opaque)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "d2", WhiteoutOpaqueDir)); !os.IsNotExist(err) {
+		t.Fatalf("opaque whiteout should not be unpacked, got %v", err)
+	}
+}
+
+func TestOverlayAddWhiteouts(t *testing.T) {
+	src, err := ioutil.TempDir("", "docker-test-overlay-whiteouts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	setupOverlayTestDir(t, src)
+
+	ta := &tarAppender{
+		TarWriter:         tar.NewWriter(ioutil.Discard),
+		Buffer:            pools.BufioWriter32KPool.Get(nil),
+		SeenFiles:         make(map[uint64]string),
+		WhiteoutConverter: getWhiteoutConverter(OverlayWhiteoutFormat),
+	}
+	defer pools.BufioWriter32KPool.Put(ta.Buffer)
+	// The content of the device and the directory is not archived
+	for _, name := range []string{"d1/f2", "d2"} {
+		if err := ta.addTarFile(filepath.Join(src, name), name); err != nil {
+			t.Fatalf("Failed to add whiteout %s: %v", name, err)
+		}
+	}
+}
+
+func TestOverlayChanges(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "docker-test-overlay-changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	lower := filepath.Join(tmpdir, "lower")
+	if err := os.MkdirAll(filepath.Join(lower, "d1"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	upper := filepath.Join(tmpdir, "upper")
+	setupOverlayTestDir(t, upper)
+
+	changes, err := OverlayChanges([]string{lower}, upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChanges := []Change{
+		{"/d1", ChangeModify},
+		{"/d1/f1", ChangeAdd},
+		{"/d1/f2", ChangeDelete},
+		{"/d2", ChangeAdd},
+	}
+	checkChanges(expectedChanges, changes, t)
+}
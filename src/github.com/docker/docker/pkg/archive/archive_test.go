@@ -3,6 +3,7 @@ package archive
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -88,25 +89,133 @@ func TestDecompressStreamXz(t *testing.T) {
 	}
 }
 
-func TestCompressStreamXzUnsuported(t *testing.T) {
-	dest, err := os.Create("/tmp/dest")
+func TestDecompressStreamZstd(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "touch /tmp/archive && zstd -q -f --rm /tmp/archive")
+	output, err := cmd.CombinedOutput()
 	if err != nil {
-		t.Fatalf("Fail to create the destination file")
+		t.Skipf("Fail to create an archive file for test : %s.", output)
 	}
-	_, err = CompressStream(dest, Xz)
-	if err == nil {
-		t.Fatalf("Should fail as xz is unsupported for compression format.")
+	archive, err := os.Open("/tmp/archive.zst")
+	_, err = DecompressStream(archive)
+	if err != nil {
+		t.Fatalf("Failed to decompress a zstd file.")
 	}
 }
 
-func TestCompressStreamBzip2Unsupported(t *testing.T) {
-	dest, err := os.Create("/tmp/dest")
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestCompressStreamDecompressStream(t *testing.T) {
+	// Several blocks of the parallel gzip writer, compressible across blocks.
+	data := bytes.Repeat([]byte("docker compresses layers in parallel\n"), 3*gzipBlockSize/37)
+	for _, c := range []Compression{Uncompressed, Gzip, Bzip2, Xz, Zstd} {
+		if c != Uncompressed && c != Gzip {
+			if _, err := exec.LookPath(c.Name()); err != nil {
+				t.Logf("Skipping %s: %v", c.Name(), err)
+				continue
+			}
+		}
+		for _, level := range []int{DefaultCompressionLevel, 1} {
+			var buf bytes.Buffer
+			w, err := CompressStreamLevel(nopWriteCloser{&buf}, c, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if detected := DetectCompression(buf.Bytes()); detected != c {
+				t.Fatalf("Expected %s, detected %s", c.Name(), detected.Name())
+			}
+			r, err := DecompressStream(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("Failed to decompress %s at level %d: %v", c.Name(), level, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("Unexpected %s decompressed data at level %d", c.Name(), level)
+			}
+		}
+	}
+}
+
+func TestCompressStreamParallelGzip(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), gzipBlockSize/3)
+	var buf bytes.Buffer
+	w, err := CompressStream(nopWriteCloser{&buf}, Gzip)
 	if err != nil {
-		t.Fatalf("Fail to create the destination file")
+		t.Fatal(err)
 	}
-	_, err = CompressStream(dest, Xz)
-	if err == nil {
-		t.Fatalf("Should fail as xz is unsupported for compression format.")
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The blocks make a single gzip member, and refer to each other.
+	if buf.Len() > len(data)/100 {
+		t.Fatalf("Expected the blocks to be compressed with the previous ones as dictionary, got %d bytes", buf.Len())
+	}
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Multistream(false)
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("Unexpected decompressed data")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Expected a single gzip member, %d bytes left", buf.Len())
+	}
+}
+
+func TestCompressStreamInvalidLevel(t *testing.T) {
+	for c, level := range map[Compression]int{Gzip: 10, Bzip2: 0, Xz: -2, Zstd: 20} {
+		if _, err := CompressStreamLevel(nopWriteCloser{ioutil.Discard}, c, level); err == nil {
+			t.Fatalf("Expected level %d of %s to be refused", level, c.Name())
+		}
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, c := range []Compression{Uncompressed, Bzip2, Gzip, Xz, Zstd} {
+		if parsed, err := ParseCompression(c.Name()); err != nil || parsed != c {
+			t.Fatalf("Expected %s to be parsed, got %v: %v", c.Name(), parsed, err)
+		}
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Fatal("Expected lz4 to be unsupported")
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", "")
+	for _, c := range []Compression{Uncompressed, Gzip} {
+		if err := c.CheckCommand(); err != nil {
+			t.Fatalf("Expected %s to need no command: %v", c.Name(), err)
+		}
+	}
+	for _, c := range []Compression{Bzip2, Xz, Zstd} {
+		if err := c.CheckCommand(); err == nil {
+			t.Fatalf("Expected %s to need a command", c.Name())
+		}
 	}
 }
 
@@ -157,6 +266,13 @@ func TestExtensionXz(t *testing.T) {
 		t.Fatalf("The extension of a bzip2 archive should be 'tar.xz'")
 	}
 }
+func TestExtensionZstd(t *testing.T) {
+	compression := Zstd
+	output := compression.Extension()
+	if output != "tar.zst" {
+		t.Fatalf("The extension of a zstd archive should be 'tar.zst'")
+	}
+}
 
 func TestCmdStreamLargeStderr(t *testing.T) {
 	cmd := exec.Command("/bin/sh", "-c", "dd if=/dev/zero bs=1k count=1000 of=/dev/stderr; echo hello")
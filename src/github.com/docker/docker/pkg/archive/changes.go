@@ -69,11 +69,52 @@ func sameFsTimeSpec(a, b syscall.Timespec) bool {
 // Changes walks the path rw and determines changes for the files in the path,
 // with respect to the parent layers
 func Changes(layers []string, rw string) ([]Change, error) {
-	var (
-		changes     []Change
-		changedDirs = make(map[string]struct{})
-	)
+	return changes(layers, rw, aufsDeletedFile, aufsMetadataSkip)
+}
+
+// WalkChanges is like Changes, but calls fn with each change as it is found
+// instead of returning them all. It stops at the first error returned by fn.
+func WalkChanges(layers []string, rw string, fn func(Change) error) error {
+	return walkChanges(layers, rw, aufsDeletedFile, aufsMetadataSkip, fn)
+}
+
+// skipChange reports whether the file at path is layer metadata that is not
+// part of the changes.
+type skipChange func(path string) (bool, error)
+
+// deleteChange returns the path of the file removed by the whiteout at path,
+// or "" when the file at path is not a whiteout.
+type deleteChange func(root, path string, fi os.FileInfo) (string, error)
+
+func aufsMetadataSkip(path string) (bool, error) {
+	return filepath.Match(string(os.PathSeparator)+WhiteoutMetaPrefix+"*", path)
+}
+
+func aufsDeletedFile(root, path string, fi os.FileInfo) (string, error) {
+	f := filepath.Base(path)
+
+	// If there is a whiteout, then the file was removed
+	if strings.HasPrefix(f, WhiteoutPrefix) {
+		originalFile := f[len(WhiteoutPrefix):]
+		return filepath.Join(filepath.Dir(path), originalFile), nil
+	}
+	return "", nil
+}
+
+func changes(layers []string, rw string, dc deleteChange, sc skipChange) ([]Change, error) {
+	var changes []Change
+	err := walkChanges(layers, rw, dc, sc, func(change Change) error {
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
 
+func walkChanges(layers []string, rw string, dc deleteChange, sc skipChange, fn func(Change) error) error {
+	changedDirs := make(map[string]struct{})
 	err := filepath.Walk(rw, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -93,21 +134,25 @@ func Changes(layers []string, rw string) ([]Change, error) {
 			return nil
 		}
 
-		// Skip AUFS metadata
-		if matched, err := filepath.Match(string(os.PathSeparator)+".wh..wh.*", path); err != nil || matched {
-			return err
+		// Skip layer metadata
+		if sc != nil {
+			if skip, err := sc(path); err != nil || skip {
+				return err
+			}
 		}
 
 		change := Change{
 			Path: path,
 		}
 
+		deletedFile, err := dc(rw, path, f)
+		if err != nil {
+			return err
+		}
+
 		// Find out what kind of modification happened
-		file := filepath.Base(path)
-		// If there is a whiteout, then the file was removed
-		if strings.HasPrefix(file, ".wh.") {
-			originalFile := file[len(".wh."):]
-			change.Path = filepath.Join(filepath.Dir(path), originalFile)
+		if deletedFile != "" {
+			change.Path = deletedFile
 			change.Kind = ChangeDelete
 		} else {
 			// Otherwise, the file was added
@@ -146,19 +191,20 @@ func Changes(layers []string, rw string) ([]Change, error) {
 		if change.Kind == ChangeAdd || change.Kind == ChangeDelete {
 			parent := filepath.Dir(path)
 			if _, ok := changedDirs[parent]; !ok && parent != "/" {
-				changes = append(changes, Change{Path: parent, Kind: ChangeModify})
+				if err := fn(Change{Path: parent, Kind: ChangeModify}); err != nil {
+					return err
+				}
 				changedDirs[parent] = struct{}{}
 			}
 		}
 
 		// Record change
-		changes = append(changes, change)
-		return nil
+		return fn(change)
 	})
 	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+		return err
 	}
-	return changes, nil
+	return nil
 }
 
 type FileInfo struct {
@@ -314,6 +360,118 @@ func ChangesDirs(newDir, oldDir string) ([]Change, error) {
 	return newRoot.Changes(oldRoot), nil
 }
 
+// WalkChangesDirs is like ChangesDirs, but calls fn with each change as it is
+// found instead of returning them all: it walks newDir for the added and
+// modified files, then oldDir for the deleted ones. The directories holding
+// changes are reported before them. It stops at the first error returned by
+// fn.
+func WalkChangesDirs(newDir, oldDir string, fn func(Change) error) error {
+	w := &changesDirsWalker{
+		fn:       fn,
+		reported: map[string]struct{}{string(os.PathSeparator): {}},
+	}
+	err := walkRelative(newDir, func(path string, f os.FileInfo) error {
+		change := Change{Path: path, Kind: ChangeAdd}
+		if oldDir != "" {
+			oldStat, err := system.Lstat(filepath.Join(oldDir, path))
+			if err != nil && !notExist(err) {
+				return err
+			}
+			if err == nil {
+				newStat, err := system.Lstat(filepath.Join(newDir, path))
+				if err != nil {
+					return err
+				}
+				oldCapability, _ := system.Lgetxattr(filepath.Join(oldDir, path), "security.capability")
+				newCapability, _ := system.Lgetxattr(filepath.Join(newDir, path), "security.capability")
+				if !statDifferent(oldStat, newStat) && bytes.Equal(oldCapability, newCapability) {
+					return nil
+				}
+				change.Kind = ChangeModify
+			}
+		}
+		return w.report(change, f.IsDir())
+	})
+	if err != nil || oldDir == "" {
+		return err
+	}
+
+	// The files of removed directories, or of directories replaced by
+	// other files, are not reported.
+	return walkRelative(oldDir, func(path string, f os.FileInfo) error {
+		fi, err := os.Lstat(filepath.Join(newDir, path))
+		if err != nil && !notExist(err) {
+			return err
+		}
+		if err != nil {
+			err = w.report(Change{Path: path, Kind: ChangeDelete}, false)
+		}
+		if err == nil && f.IsDir() && (fi == nil || !fi.IsDir()) {
+			return filepath.SkipDir
+		}
+		return err
+	})
+}
+
+// changesDirsWalker reports the changes found by WalkChangesDirs.
+type changesDirsWalker struct {
+	fn func(Change) error
+	// reported holds the directories already reported.
+	reported map[string]struct{}
+}
+
+// report reports change, after the directories holding it that were not
+// reported yet.
+func (w *changesDirsWalker) report(change Change, isDir bool) error {
+	var parents []string
+	for dir := filepath.Dir(change.Path); ; dir = filepath.Dir(dir) {
+		if _, ok := w.reported[dir]; ok {
+			break
+		}
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if err := w.fn(Change{Path: parents[i], Kind: ChangeModify}); err != nil {
+			return err
+		}
+		w.reported[parents[i]] = struct{}{}
+	}
+	if isDir {
+		w.reported[change.Path] = struct{}{}
+	}
+	return w.fn(change)
+}
+
+// walkRelative walks the tree rooted at root, calling fn with the path of
+// each file relative to root, except root itself.
+func walkRelative(root string, fn func(path string, f os.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		// As this runs on the daemon side, file paths are OS specific.
+		path = filepath.Join(string(os.PathSeparator), rel)
+		if path == string(os.PathSeparator) {
+			return nil
+		}
+		return fn(path, f)
+	})
+}
+
+// notExist returns whether err is returned for a file that does not exist,
+// including when a parent of the file is not a directory.
+func notExist(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.ENOTDIR
+}
+
 // ChangesSize calculates the size in bytes of the provided changes, based on newDir.
 func ChangesSize(newDir string, changes []Change) int64 {
 	var size int64
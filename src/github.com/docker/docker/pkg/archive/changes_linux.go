@@ -283,3 +283,27 @@ func clen(n []byte) int {
 	}
 	return len(n)
 }
+
+// OverlayChanges walks the path rw of an overlay upper directory and
+// determines changes for the files in the path, with respect to the lower
+// layers.
+func OverlayChanges(layers []string, rw string) ([]Change, error) {
+	return changes(layers, rw, overlayDeletedFile, nil)
+}
+
+// WalkOverlayChanges is like OverlayChanges, but calls fn with each change as
+// it is found instead of returning them all. It stops at the first error
+// returned by fn.
+func WalkOverlayChanges(layers []string, rw string, fn func(Change) error) error {
+	return walkChanges(layers, rw, overlayDeletedFile, nil, fn)
+}
+
+func overlayDeletedFile(root, path string, fi os.FileInfo) (string, error) {
+	// Overlay removes a file by replacing it with a character device 0/0.
+	if fi.Mode()&os.ModeCharDevice != 0 {
+		if s, ok := fi.Sys().(*syscall.Stat_t); ok && s.Rdev == 0 {
+			return path, nil
+		}
+	}
+	return "", nil
+}
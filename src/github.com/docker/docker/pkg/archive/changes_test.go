@@ -1,10 +1,12 @@
 package archive
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"sort"
 	"testing"
 	"time"
@@ -391,6 +393,63 @@ func TestChangesDirsMutated(t *testing.T) {
 	}
 }
 
+func TestWalkChangesDirsMutated(t *testing.T) {
+	src, err := ioutil.TempDir("", "docker-changes-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createSampleDir(t, src)
+	dst := src + "-copy"
+	if err := copyDir(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	defer os.RemoveAll(dst)
+
+	mutateSampleDir(t, dst)
+	// Changes in unchanged directories.
+	if err := os.MkdirAll(filepath.Join(dst, "dir4", "subnew"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dst, "dir4", "subnew", "filenew"), []byte("filenew\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dst, "dir4", "file3-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedChanges, err := ChangesDirs(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []Change
+	reported := make(map[string]bool)
+	err = WalkChangesDirs(dst, src, func(change Change) error {
+		// The directories holding changes are reported first.
+		if dir := filepath.Dir(change.Path); dir != "/" && !reported[dir] {
+			t.Fatalf("%s reported before %s", change.Path, dir)
+		}
+		reported[change.Path] = true
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChanges(expectedChanges, changes, t)
+
+	// The walk stops at the first error.
+	errStop := errors.New("stop")
+	var n int
+	err = WalkChangesDirs(dst, src, func(change Change) error {
+		n++
+		return errStop
+	})
+	if err != errStop || n != 1 {
+		t.Fatalf("Expected the walk to stop after a change, got %d changes: %v", n, err)
+	}
+}
+
 func TestApplyLayer(t *testing.T) {
 	src, err := ioutil.TempDir("", "docker-changes-test")
 	if err != nil {
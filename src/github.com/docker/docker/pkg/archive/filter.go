@@ -0,0 +1,97 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/docker/docker/pkg/ioutils"
+)
+
+// FilterArchive returns a tar archive of the entries of in whose paths are
+// one of includeFiles, or under one of them, and do not match
+// excludePatterns. All the entries are included when includeFiles is empty.
+// Whiteout files are filtered by the path of the file they delete.
+func FilterArchive(in io.ReadCloser, includeFiles, excludePatterns []string) (io.ReadCloser, error) {
+	patterns, patDirs, _, err := fileutils.CleanPatterns(excludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	for i, include := range includeFiles {
+		includeFiles[i] = filepath.Clean(include)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		tr := tar.NewReader(in)
+		tw := tar.NewWriter(pipeWriter)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+
+			name := filteredPath(hdr.Name)
+			if !isIncluded(name, includeFiles) {
+				continue
+			}
+			skip, err := fileutils.OptimizedMatches(name, patterns, patDirs)
+			if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+			if skip {
+				continue
+			}
+
+			if err := tw.WriteHeader(hdr); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+		pipeWriter.CloseWithError(tw.Close())
+	}()
+
+	return ioutils.NewReadCloserWrapper(pipeReader, func() error {
+		pipeReader.Close()
+		return in.Close()
+	}), nil
+}
+
+// filteredPath returns the path a tar entry named name is filtered by,
+// which is the path of the deleted file for whiteout files.
+func filteredPath(name string) string {
+	name = filepath.Clean(name)
+	dir, base := filepath.Split(name)
+	if base == WhiteoutOpaqueDir {
+		return filepath.Clean(dir)
+	}
+	if strings.HasPrefix(base, WhiteoutPrefix) {
+		return filepath.Join(dir, base[len(WhiteoutPrefix):])
+	}
+	return name
+}
+
+// isIncluded returns whether name is one of includeFiles or under one of
+// them, or whether includeFiles is empty.
+func isIncluded(name string, includeFiles []string) bool {
+	if len(includeFiles) == 0 {
+		return true
+	}
+	for _, include := range includeFiles {
+		if include == "." || name == include || strings.HasPrefix(name, include+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
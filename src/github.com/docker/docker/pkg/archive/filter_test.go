@@ -0,0 +1,79 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFilterArchive(t *testing.T) {
+	cases := []struct {
+		include, exclude []string
+		expected         []string
+	}{
+		{
+			expected: []string{"etc", "etc/hosts", "etc/.wh.passwd", "var/log/app.log", "var/log/.wh..wh..opq", "tmp/cache"},
+		},
+		{
+			include:  []string{"etc"},
+			expected: []string{"etc", "etc/hosts", "etc/.wh.passwd"},
+		},
+		{
+			include:  []string{"./var/log/"},
+			expected: []string{"var/log/app.log", "var/log/.wh..wh..opq"},
+		},
+		{
+			exclude:  []string{"etc/passwd", "tmp"},
+			expected: []string{"etc", "etc/hosts", "var/log/app.log", "var/log/.wh..wh..opq"},
+		},
+		{
+			include:  []string{"var", "tmp"},
+			exclude:  []string{"*/*/*.log"},
+			expected: []string{"var/log/.wh..wh..opq", "tmp/cache"},
+		},
+	}
+
+	for _, c := range cases {
+		in, err := Generate("etc", "", "etc/hosts", "127.0.0.1 localhost", "etc/.wh.passwd", "", "var/log/app.log", "log", "var/log/.wh..wh..opq", "", "tmp/cache", "cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := FilterArchive(in, c.include, c.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		tr := tar.NewReader(out)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+		}
+		out.Close()
+		if !reflect.DeepEqual(names, c.expected) {
+			t.Fatalf("Expected %v including %v and excluding %v, got %v", c.expected, c.include, c.exclude, names)
+		}
+	}
+}
+
+func TestFilterArchiveInvalidPattern(t *testing.T) {
+	in, err := Generate("etc/hosts", "127.0.0.1 localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := FilterArchive(in, nil, []string{"["})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := tar.NewReader(out).Next(); err != filepath.ErrBadPattern {
+		t.Fatalf("Expected the archive to fail with the pattern error, got %v", err)
+	}
+}
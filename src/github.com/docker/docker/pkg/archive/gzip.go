@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+const (
+	// gzipBlockSize is the size of the blocks compressed concurrently.
+	gzipBlockSize = 1 << 20
+	// gzipDictSize is the size of the deflate window, the end of each block
+	// being the dictionary of the next one.
+	gzipDictSize = 32 << 10
+)
+
+// parallelGzipWriter compresses blocks of its input concurrently, like pigz.
+// Each block is deflated with the end of the previous block as dictionary,
+// and flushed to a byte boundary, so that the blocks concatenate into a
+// single gzip member that any gzip reader can read, with about the same
+// compression ratio as compress/gzip.
+type parallelGzipWriter struct {
+	dest  io.Writer
+	level int
+	block []byte
+	dict  []byte
+	crc   uint32
+	size  uint32
+
+	// blocks queues the compressed blocks in order, and bounds the number
+	// of blocks compressed at once.
+	blocks chan chan []byte
+	done   chan struct{}
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+func newParallelGzipWriter(dest io.Writer, level, threads int) *parallelGzipWriter {
+	if level == DefaultCompressionLevel {
+		level = gzip.DefaultCompression
+	}
+	w := &parallelGzipWriter{
+		dest:   dest,
+		level:  level,
+		block:  make([]byte, 0, gzipBlockSize),
+		blocks: make(chan chan []byte, threads),
+		done:   make(chan struct{}),
+	}
+	go w.writeBlocks()
+	return w
+}
+
+func writeFull(w io.Writer, p []byte) error {
+	_, err := w.Write(p)
+	return err
+}
+
+func (w *parallelGzipWriter) setErr(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+}
+
+func (w *parallelGzipWriter) getErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// writeBlocks writes the compressed blocks to dest in order. It keeps
+// reading them after an error so that no compression is left blocked.
+func (w *parallelGzipWriter) writeBlocks() {
+	defer close(w.done)
+	// The header of compress/gzip, without name, comment nor time.
+	w.setErr(writeFull(w.dest, []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}))
+	for c := range w.blocks {
+		data := <-c
+		if w.getErr() == nil {
+			w.setErr(writeFull(w.dest, data))
+		}
+	}
+}
+
+// flushBlock starts the compression of the current block.
+func (w *parallelGzipWriter) flushBlock(last bool) {
+	block, dict, level := w.block, w.dict, w.level
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, block)
+	w.size += uint32(len(block))
+
+	c := make(chan []byte, 1)
+	w.blocks <- c
+	go func() {
+		var b bytes.Buffer
+		fw, err := flate.NewWriterDict(&b, level, dict)
+		if err == nil {
+			if _, err = fw.Write(block); err == nil {
+				if last {
+					err = fw.Close()
+				} else {
+					err = fw.Flush()
+				}
+			}
+		}
+		if err != nil {
+			w.setErr(err)
+		}
+		c <- b.Bytes()
+	}()
+
+	if last {
+		return
+	}
+	w.dict = block[len(block)-gzipDictSize:]
+	w.block = make([]byte, 0, gzipBlockSize)
+}
+
+func (w *parallelGzipWriter) Write(p []byte) (int, error) {
+	if err := w.getErr(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := copy(w.block[len(w.block):cap(w.block)], p)
+		w.block = w.block[:len(w.block)+m]
+		p = p[m:]
+		if len(w.block) == cap(w.block) {
+			w.flushBlock(false)
+		}
+	}
+	return n, nil
+}
+
+// Close compresses the last block and writes the gzip trailer. It does not
+// close dest.
+func (w *parallelGzipWriter) Close() error {
+	if w.closed {
+		return w.getErr()
+	}
+	w.closed = true
+	w.flushBlock(true)
+	close(w.blocks)
+	<-w.done
+	if err := w.getErr(); err != nil {
+		return err
+	}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], w.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], w.size)
+	return writeFull(w.dest, trailer)
+}
@@ -21,11 +21,32 @@ func (e *JSONError) Error() string {
 	return e.Message
 }
 
+// The states of the layers in the progress of pulls and pushes.
+const (
+	StateWaiting     = "waiting"
+	StateDownloading = "downloading"
+	StateVerifying   = "verifying"
+	StateDownloaded  = "downloaded"
+	StateExtracting  = "extracting"
+	StateComplete    = "complete"
+	StateExists      = "exists"
+	StateBuffering   = "buffering"
+	StateUploading   = "uploading"
+	StatePushed      = "pushed"
+	StateRetrying    = "retrying"
+	StateFailed      = "failed"
+)
+
 type JSONProgress struct {
 	terminalFd uintptr
 	Current    int   `json:"current,omitempty"`
 	Total      int   `json:"total,omitempty"`
 	Start      int64 `json:"start,omitempty"`
+
+	// State is the state of the layer the progress is of, in pulls and
+	// pushes, and Retries the number of times its transfer was retried.
+	State   string `json:"state,omitempty"`
+	Retries int    `json:"retries,omitempty"`
 }
 
 func (p *JSONProgress) String() string {
@@ -73,7 +94,40 @@ func (p *JSONProgress) String() string {
 			timeLeftBox = " " + left.String()
 		}
 	}
-	return pbBox + numbersBox + timeLeftBox
+	return pbBox + numbersBox + timeLeftBox + p.retriesBox()
+}
+
+func (p *JSONProgress) retriesBox() string {
+	if p.Retries <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (retry %d)", p.Retries)
+}
+
+// JSONSummary is the aggregate progress of the layers of a pull or a push.
+type JSONSummary struct {
+	Layers   int   `json:"layers"`
+	Complete int   `json:"complete"`
+	Current  int64 `json:"current"`
+	Total    int64 `json:"total"`
+	// Rate is the transfer rate in bytes per second, and ETA the number of
+	// seconds left at that rate.
+	Rate int64 `json:"rate,omitempty"`
+	ETA  int64 `json:"eta,omitempty"`
+}
+
+func (s *JSONSummary) String() string {
+	parts := []string{fmt.Sprintf("%d/%d layers", s.Complete, s.Layers)}
+	if s.Total > 0 {
+		parts = append(parts, fmt.Sprintf("%v/%v", units.HumanSize(float64(s.Current)), units.HumanSize(float64(s.Total))))
+	}
+	if s.Rate > 0 {
+		parts = append(parts, units.HumanSize(float64(s.Rate))+"/s")
+	}
+	if s.ETA > 0 {
+		parts = append(parts, (time.Duration(s.ETA)*time.Second).String()+" left")
+	}
+	return strings.Join(parts, ", ")
 }
 
 type JSONMessage struct {
@@ -86,6 +140,10 @@ type JSONMessage struct {
 	Time            int64         `json:"time,omitempty"`
 	Error           *JSONError    `json:"errorDetail,omitempty"`
 	ErrorMessage    string        `json:"error,omitempty"` //deprecated
+
+	// Summary is the aggregate progress of a pull or a push, sent
+	// periodically.
+	Summary *JSONSummary `json:"summaryDetail,omitempty"`
 }
 
 func (jm *JSONMessage) Display(out io.Writer, isTerminal bool) error {
@@ -95,6 +153,14 @@ func (jm *JSONMessage) Display(out io.Writer, isTerminal bool) error {
 		}
 		return jm.Error
 	}
+	if jm.Summary != nil {
+		// The summary is only displayed on terminals, below the layers,
+		// like progress bars.
+		if isTerminal {
+			fmt.Fprintf(out, "%c[2K\r%s\r", 27, jm.Summary.String())
+		}
+		return nil
+	}
 	var endl string
 	if isTerminal && jm.Stream == "" && jm.Progress != nil {
 		// <ESC>[2K = erase entire current line
@@ -129,6 +195,9 @@ func DisplayJSONMessagesStream(in io.Reader, out io.Writer, terminalFd uintptr,
 		dec  = json.NewDecoder(in)
 		ids  = make(map[string]int)
 		diff = 0
+		// summary is the last summary displayed, on the line below the
+		// layers, where the cursor stays.
+		summary *JSONMessage
 	)
 	for {
 		var jm JSONMessage
@@ -142,9 +211,20 @@ func DisplayJSONMessagesStream(in io.Reader, out io.Writer, terminalFd uintptr,
 		if jm.Progress != nil {
 			jm.Progress.terminalFd = terminalFd
 		}
+		if jm.Summary != nil {
+			if isTerminal {
+				summary = &jm
+			}
+			if err := jm.Display(out, isTerminal); err != nil {
+				return err
+			}
+			continue
+		}
+		newLine := false
 		if jm.ID != "" && (jm.Progress != nil || jm.ProgressMessage != "") {
 			line, ok := ids[jm.ID]
 			if !ok {
+				newLine = true
 				line = len(ids)
 				ids[jm.ID] = line
 				if isTerminal {
@@ -158,6 +238,11 @@ func DisplayJSONMessagesStream(in io.Reader, out io.Writer, terminalFd uintptr,
 				// <ESC>[{diff}A = move cursor up diff rows
 				fmt.Fprintf(out, "%c[%dA", 27, diff)
 			}
+		} else if summary != nil && isTerminal {
+			// Keep the summary above the messages that are not about a
+			// layer.
+			fmt.Fprintf(out, "\n")
+			summary = nil
 		}
 		err := jm.Display(out, isTerminal)
 		if jm.ID != "" && isTerminal {
@@ -167,6 +252,10 @@ func DisplayJSONMessagesStream(in io.Reader, out io.Writer, terminalFd uintptr,
 		if err != nil {
 			return err
 		}
+		if newLine && summary != nil {
+			// The new layer took the line of the summary.
+			summary.Display(out, isTerminal)
+		}
 	}
 	return nil
 }
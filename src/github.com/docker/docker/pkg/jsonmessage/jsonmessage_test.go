@@ -208,3 +208,49 @@ func TestDisplayJSONMessagesStream(t *testing.T) {
 	}
 
 }
+
+func TestProgressRetries(t *testing.T) {
+	expected := "[=========================>                         ]     50 B/100 B (retry 2)"
+	jp := JSONProgress{Current: 50, Total: 100, Retries: 2}
+	if jp.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, jp.String())
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := JSONSummary{Layers: 3, Complete: 1}
+	if expected := "1/3 layers"; s.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, s.String())
+	}
+	s = JSONSummary{Layers: 3, Complete: 1, Current: 20, Total: 100, Rate: 8, ETA: 10}
+	if expected := "1/3 layers, 20 B/100 B, 8 B/s, 10s left"; s.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, s.String())
+	}
+}
+
+func TestDisplayJSONMessagesStreamSummary(t *testing.T) {
+	stream := `{"summaryDetail":{"layers":1,"complete":0,"current":1,"total":2}}` +
+		`{"id":"ID","status":"Downloading","progressDetail":{"current":1,"total":2,"state":"downloading"}}` +
+		`{"status":"Digest: sha256:abc"}`
+
+	data := bytes.NewBuffer([]byte{})
+	if err := DisplayJSONMessagesStream(strings.NewReader(stream), data, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Digest: sha256:abc\n"; data.String() != expected {
+		t.Fatalf("Expected [%q], got [%q]", expected, data.String())
+	}
+
+	data = bytes.NewBuffer([]byte{})
+	if err := DisplayJSONMessagesStream(strings.NewReader(stream), data, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	summary := fmt.Sprintf("%c[2K\r0/1 layers, 1 B/2 B\r", 27)
+	expected := summary +
+		// The layer takes the line of the summary, which moves below it.
+		fmt.Sprintf("\n%c[%dA%c[2K\rID: Downloading %s\r%c[%dB", 27, 0, 27, (&JSONProgress{Current: 1, Total: 2}).String(), 27, 0) + summary +
+		"\nDigest: sha256:abc\n"
+	if data.String() != expected {
+		t.Fatalf("Expected [%q], got [%q]", expected, data.String())
+	}
+}
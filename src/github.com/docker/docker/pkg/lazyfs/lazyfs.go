@@ -0,0 +1,281 @@
+// +build linux
+
+// Package lazyfs serves the files of an indexed archive, as written by
+// pkg/tarindex, as a read-only FUSE filesystem. The contents of files are
+// read from the archive on demand, so the archive can be mounted before it is
+// downloaded.
+package lazyfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/tarindex"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+const (
+	// ttl is how long the kernel caches the attributes and names of files.
+	ttl = time.Hour
+
+	// chunkCacheSize is the number of decompressed chunks kept in memory,
+	// as files are read in pieces smaller than a chunk.
+	chunkCacheSize = 16
+)
+
+// Server serves a mounted filesystem.
+type Server struct {
+	mountpoint string
+	server     *fuse.Server
+	tree       *tree
+	archive    io.ReaderAt
+	done       chan struct{}
+
+	mu     sync.Mutex
+	chunks map[int64][]byte
+	// order holds the offsets of the cached chunks, oldest first.
+	order []int64
+}
+
+// Mount mounts the files of the archive r with the given index on mountpoint,
+// with whiteouts in the given format. The contents of files are read from r
+// when they are first accessed.
+func Mount(mountpoint string, index *tarindex.Index, r io.ReaderAt, format archive.WhiteoutFormat) (*Server, error) {
+	s := &Server{
+		mountpoint: mountpoint,
+		tree:       newTree(index, format),
+		archive:    r,
+		done:       make(chan struct{}),
+		chunks:     make(map[int64][]byte),
+	}
+
+	timeout := ttl
+	root := &inode{s: s, n: s.tree.root()}
+	server, err := fs.Mount(mountpoint, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			AllowOther: true,
+			Options:    []string{"default_permissions"},
+			FsName:     "lazyfs",
+			Name:       "lazyfs",
+			// The daemon mounts the filesystem itself, without the
+			// fusermount helper.
+			DirectMountStrict: true,
+			DirectMountFlags:  syscall.MS_RDONLY,
+		},
+		EntryTimeout:    &timeout,
+		AttrTimeout:     &timeout,
+		NullPermissions: true,
+		RootStableAttr:  &fs.StableAttr{Ino: rootID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("lazyfs: error mounting %s: %v", mountpoint, err)
+	}
+	s.server = server
+	go func() {
+		server.Wait()
+		close(s.done)
+	}()
+	return s, nil
+}
+
+// Unmount detaches the filesystem from its mountpoint. It keeps being served
+// until the last of its files in use is closed.
+func (s *Server) Unmount() error {
+	return syscall.Unmount(s.mountpoint, syscall.MNT_DETACH)
+}
+
+// Done returns a channel closed when the filesystem is not served anymore.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// read returns size bytes of the contents of n at offset.
+func (s *Server) read(n *node, offset int64, size int) ([]byte, syscall.Errno) {
+	if n.mode&syscall.S_IFMT != syscall.S_IFREG {
+		return nil, syscall.EINVAL
+	}
+	if offset >= n.size {
+		return nil, 0
+	}
+	if end := n.size - offset; int64(size) > end {
+		size = int(end)
+	}
+
+	out := make([]byte, 0, size)
+	for _, c := range n.chunks {
+		if c.FileOffset+c.Size <= offset {
+			continue
+		}
+		if len(out) == size {
+			break
+		}
+		data, err := s.chunk(c)
+		if err != nil {
+			logrus.Errorf("lazyfs: error reading %s: %v", s.mountpoint, err)
+			return nil, syscall.EIO
+		}
+		data = data[offset-c.FileOffset:]
+		if len(data) > size-len(out) {
+			data = data[:size-len(out)]
+		}
+		out = append(out, data...)
+		offset += int64(len(data))
+	}
+	if len(out) != size {
+		// The contents of the file are not indexed.
+		return nil, syscall.EIO
+	}
+	return out, 0
+}
+
+// chunk returns the contents of the chunk c.
+func (s *Server) chunk(c tarindex.Chunk) ([]byte, error) {
+	s.mu.Lock()
+	data, ok := s.chunks[c.Offset]
+	s.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	data, err := tarindex.ReadChunk(s.archive, c)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if _, ok := s.chunks[c.Offset]; !ok {
+		if len(s.order) == chunkCacheSize {
+			delete(s.chunks, s.order[0])
+			s.order = s.order[1:]
+		}
+		s.chunks[c.Offset] = data
+		s.order = append(s.order, c.Offset)
+	}
+	s.mu.Unlock()
+	return data, nil
+}
+
+// inode is the inode of a node of the tree in the filesystem.
+type inode struct {
+	fs.Inode
+	s *Server
+	n *node
+}
+
+var (
+	_ = (fs.NodeOnAdder)((*inode)(nil))
+	_ = (fs.NodeGetattrer)((*inode)(nil))
+	_ = (fs.NodeOpener)((*inode)(nil))
+	_ = (fs.NodeReader)((*inode)(nil))
+	_ = (fs.NodeReadlinker)((*inode)(nil))
+	_ = (fs.NodeGetxattrer)((*inode)(nil))
+	_ = (fs.NodeListxattrer)((*inode)(nil))
+	_ = (fs.NodeStatfser)((*inode)(nil))
+)
+
+// OnAdd adds the files of the tree to the root directory when the
+// filesystem is mounted. The files with several links share their inode.
+func (i *inode) OnAdd(ctx context.Context) {
+	inodes := make(map[*node]*fs.Inode)
+	var add func(dir *fs.Inode, n *node)
+	add = func(dir *fs.Inode, n *node) {
+		for _, name := range n.names {
+			child := n.children[name]
+			ch, ok := inodes[child]
+			if !ok {
+				ch = dir.NewPersistentInode(ctx, &inode{s: i.s, n: child}, fs.StableAttr{
+					Mode: child.mode & syscall.S_IFMT,
+					Ino:  child.id,
+				})
+				inodes[child] = ch
+				if child.children != nil {
+					add(ch, child)
+				}
+			}
+			dir.AddChild(name, ch, true)
+		}
+	}
+	add(&i.Inode, i.n)
+}
+
+// Getattr returns the attributes of the file.
+func (i *inode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	n := i.n
+	mtime := n.modTime
+	if mtime.IsZero() {
+		mtime = time.Unix(0, 0)
+	}
+	out.Mode = n.mode
+	out.Size = uint64(n.size)
+	out.Blocks = uint64((n.size + 511) / 512)
+	out.Nlink = n.nlink
+	out.Owner = fuse.Owner{Uid: n.uid, Gid: n.gid}
+	out.Rdev = n.rdev
+	out.SetTimes(&mtime, &mtime, &mtime)
+	out.SetTimeout(ttl)
+	return 0
+}
+
+// Open opens a regular file, whose contents stay in the page cache after it
+// is closed, as they never change.
+func (i *inode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// Read reads the contents of a regular file from the archive.
+func (i *inode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	data, errno := i.s.read(i.n, off, len(dest))
+	if errno != 0 {
+		return nil, errno
+	}
+	return fuse.ReadResultData(data), 0
+}
+
+// Readlink returns the target of a symlink.
+func (i *inode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	if i.n.mode&syscall.S_IFMT != syscall.S_IFLNK {
+		return nil, syscall.EINVAL
+	}
+	return []byte(i.n.link), 0
+}
+
+// Getxattr returns the value of the extended attribute attr in dest.
+func (i *inode) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	value, ok := i.n.xattrs[attr]
+	if !ok {
+		return 0, syscall.ENODATA
+	}
+	return xattrReply(dest, []byte(value))
+}
+
+// Listxattr returns the names of the extended attributes in dest.
+func (i *inode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	var names []byte
+	for name := range i.n.xattrs {
+		names = append(names, name...)
+		names = append(names, 0)
+	}
+	return xattrReply(dest, names)
+}
+
+func xattrReply(dest, value []byte) (uint32, syscall.Errno) {
+	if len(dest) < len(value) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
+}
+
+// Statfs returns the statistics of a filesystem without free space.
+func (i *inode) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	out.Bsize = 4096
+	out.NameLen = 255
+	out.Frsize = 4096
+	return 0
+}
@@ -0,0 +1,169 @@
+// +build linux
+
+package lazyfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/system"
+	"github.com/docker/docker/pkg/tarindex"
+)
+
+// testArchive returns an indexed archive with files, links and whiteouts,
+// the digest of its index and the contents of its file "etc/data".
+func testArchive(t *testing.T) ([]byte, digest.Digest, []byte) {
+	data := bytes.Repeat([]byte("0123456789"), 30000)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0751, Uid: 1, Gid: 2},
+		{Name: "etc/data", Typeflag: tar.TypeReg, Mode: 0640, Size: int64(len(data))},
+		{Name: "etc/hardlink", Typeflag: tar.TypeLink, Linkname: "etc/data"},
+		{Name: "etc/symlink", Typeflag: tar.TypeSymlink, Linkname: "data"},
+		{Name: "etc/.wh.passwd", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "var/lib/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "var/lib/empty", Typeflag: tar.TypeReg, Mode: 0600},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write(data)
+		}
+	}
+	tw.Close()
+
+	var compressed bytes.Buffer
+	// Small chunks, so that reads span several of them.
+	dgst, err := tarindex.Compress(&compressed, &buf, gzip.DefaultCompression, 64<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes(), dgst, data
+}
+
+func TestMount(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Mounting a FUSE filesystem requires root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("FUSE is not supported")
+	}
+
+	compressed, dgst, data := testArchive(t)
+	r := bytes.NewReader(compressed)
+	index, err := tarindex.ReadIndex(r, int64(len(compressed)), dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mountpoint, err := ioutil.TempDir("", "lazyfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mountpoint)
+
+	s, err := Mount(mountpoint, index, r, archive.OverlayWhiteoutFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := s.Unmount(); err != nil {
+			t.Fatal(err)
+		}
+		<-s.Done()
+	}()
+
+	dir, err := ioutil.ReadDir(filepath.Join(mountpoint, "etc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range dir {
+		names = append(names, fi.Name())
+	}
+	if expected := []string{"data", "hardlink", "passwd", "symlink"}; !sort.StringsAreSorted(names) || len(names) != len(expected) || names[2] != "passwd" {
+		t.Fatalf("Expected %v in etc, got %v", expected, names)
+	}
+
+	fi, err := os.Stat(filepath.Join(mountpoint, "etc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi.Sys().(*syscall.Stat_t); fi.Mode().Perm() != 0751 || st.Uid != 1 || st.Gid != 2 {
+		t.Fatalf("Unexpected attributes of etc: %v %d:%d", fi.Mode(), st.Uid, st.Gid)
+	}
+
+	for _, name := range []string{"etc/data", "etc/hardlink", "etc/symlink"} {
+		read, err := ioutil.ReadFile(filepath.Join(mountpoint, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, data) {
+			t.Fatalf("Unexpected contents of %s", name)
+		}
+	}
+	f, err := os.Open(filepath.Join(mountpoint, "etc/data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	part := make([]byte, 100)
+	if _, err := f.ReadAt(part, 65530); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, data[65530:65630]) {
+		t.Fatal("Unexpected contents across chunks")
+	}
+
+	// Hard links share their inode.
+	dataInfo, err := os.Stat(filepath.Join(mountpoint, "etc/data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkInfo, err := os.Stat(filepath.Join(mountpoint, "etc/hardlink"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := dataInfo.Sys().(*syscall.Stat_t); !os.SameFile(dataInfo, linkInfo) || st.Nlink != 2 {
+		t.Fatalf("Expected etc/hardlink to be a link of etc/data, with %d links", st.Nlink)
+	}
+
+	if target, err := os.Readlink(filepath.Join(mountpoint, "etc/symlink")); err != nil || target != "data" {
+		t.Fatalf("Unexpected symlink target %q: %v", target, err)
+	}
+
+	// Whiteouts are in the format of overlay.
+	fi, err = os.Lstat(filepath.Join(mountpoint, "etc/passwd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeCharDevice == 0 || fi.Sys().(*syscall.Stat_t).Rdev != 0 {
+		t.Fatalf("Expected a whiteout for etc/passwd, got %v", fi.Mode())
+	}
+	opaque, err := system.Lgetxattr(filepath.Join(mountpoint, "var/lib"), "trusted.overlay.opaque")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opaque) != "y" {
+		t.Fatalf("Expected var/lib to be opaque, got %q", opaque)
+	}
+	if _, err := os.Lstat(filepath.Join(mountpoint, "var/lib", archive.WhiteoutOpaqueDir)); !os.IsNotExist(err) {
+		t.Fatalf("Expected no opaque whiteout file, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(mountpoint, "etc/new"), nil, 0644); err == nil {
+		t.Fatal("Expected the filesystem to be read-only")
+	}
+}
@@ -0,0 +1,36 @@
+// +build !linux
+
+// Package lazyfs serves the files of an indexed archive as a read-only FUSE
+// filesystem, which is only supported on Linux.
+package lazyfs
+
+import (
+	"errors"
+	"io"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/tarindex"
+)
+
+// ErrNotSupported is returned by Mount on platforms without FUSE support.
+var ErrNotSupported = errors.New("lazyfs: not supported on this platform")
+
+// Server serves a mounted filesystem.
+type Server struct{}
+
+// Mount returns ErrNotSupported.
+func Mount(mountpoint string, index *tarindex.Index, r io.ReaderAt, format archive.WhiteoutFormat) (*Server, error) {
+	return nil, ErrNotSupported
+}
+
+// Unmount returns ErrNotSupported.
+func (s *Server) Unmount() error {
+	return ErrNotSupported
+}
+
+// Done returns a closed channel.
+func (s *Server) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
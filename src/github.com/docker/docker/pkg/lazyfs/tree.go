@@ -0,0 +1,213 @@
+// +build linux
+
+package lazyfs
+
+import (
+	"archive/tar"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/tarindex"
+)
+
+// rootID is the ID of the root node.
+const rootID = 1
+
+// node is a file of the filesystem.
+type node struct {
+	id uint64
+	// mode holds the type and permissions of the file, as in stat(2).
+	mode    uint32
+	size    int64
+	uid     uint32
+	gid     uint32
+	rdev    uint32
+	nlink   uint32
+	modTime time.Time
+	// link is the target of a symlink.
+	link   string
+	xattrs map[string]string
+	// chunks are the chunks of the contents of a regular file.
+	chunks []tarindex.Chunk
+
+	// children are the files of a directory, by name, and names their
+	// names in order.
+	children map[string]*node
+	names    []string
+}
+
+// tree is the filesystem of the entries of an index.
+type tree struct {
+	// nodes are the nodes by ID minus rootID, the ID being the inode
+	// number of the node.
+	nodes []*node
+}
+
+// newTree returns the filesystem of the entries of index, with whiteouts in
+// the given format.
+func newTree(index *tarindex.Index, format archive.WhiteoutFormat) *tree {
+	t := &tree{}
+	root := t.newDir()
+	root.mode = syscall.S_IFDIR | 0755
+
+	for _, e := range index.Entries {
+		name := path.Clean("/" + e.Name)
+		if name == "/" {
+			setAttributes(root, e)
+			continue
+		}
+		dir, base := t.mkdirAll(path.Dir(name)), path.Base(name)
+
+		if format == archive.OverlayWhiteoutFormat {
+			// Whiteouts are converted like when the layer is applied.
+			if base == archive.WhiteoutOpaqueDir {
+				if dir.xattrs == nil {
+					dir.xattrs = make(map[string]string)
+				}
+				dir.xattrs["trusted.overlay.opaque"] = "y"
+				continue
+			}
+			if strings.HasPrefix(base, archive.WhiteoutMetaPrefix) {
+				continue
+			}
+			if strings.HasPrefix(base, archive.WhiteoutPrefix) {
+				n := t.newNode()
+				n.mode = syscall.S_IFCHR
+				n.uid, n.gid = uint32(e.Uid), uint32(e.Gid)
+				n.modTime = e.ModTime
+				dir.add(base[len(archive.WhiteoutPrefix):], n)
+				continue
+			}
+		}
+
+		if e.Type == tar.TypeLink {
+			if target := t.lookup(path.Clean("/" + e.Linkname)); target != nil && target.mode&syscall.S_IFMT != syscall.S_IFDIR {
+				target.nlink++
+				dir.add(base, target)
+			}
+			continue
+		}
+
+		var n *node
+		if e.Type == tar.TypeDir {
+			// A directory may have been created for the entries in it.
+			if n = dir.children[base]; n == nil || n.mode&syscall.S_IFMT != syscall.S_IFDIR {
+				n = t.newDir()
+			}
+		} else {
+			n = t.newNode()
+		}
+		setAttributes(n, e)
+		dir.add(base, n)
+	}
+
+	for _, n := range t.nodes {
+		if n.children != nil {
+			sort.Strings(n.names)
+		}
+	}
+	return t
+}
+
+func (t *tree) newNode() *node {
+	n := &node{id: uint64(len(t.nodes) + rootID), nlink: 1}
+	t.nodes = append(t.nodes, n)
+	return n
+}
+
+func (t *tree) newDir() *node {
+	n := t.newNode()
+	n.mode = syscall.S_IFDIR | 0755
+	n.children = make(map[string]*node)
+	return n
+}
+
+// root returns the root directory of the tree.
+func (t *tree) root() *node {
+	return t.nodes[0]
+}
+
+// lookup returns the node at the absolute path name, or nil if there is
+// none.
+func (t *tree) lookup(name string) *node {
+	n := t.nodes[0]
+	for _, part := range strings.Split(name, "/") {
+		if part == "" {
+			continue
+		}
+		if n = n.children[part]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// mkdirAll returns the directory at the absolute path name, creating it and
+// its parents as needed.
+func (t *tree) mkdirAll(name string) *node {
+	n := t.nodes[0]
+	for _, part := range strings.Split(name, "/") {
+		if part == "" {
+			continue
+		}
+		child := n.children[part]
+		if child == nil || child.children == nil {
+			child = t.newDir()
+			n.add(part, child)
+		}
+		n = child
+	}
+	return n
+}
+
+// add adds the file n to the directory with the given name, replacing any
+// file with the same name.
+func (n *node) add(name string, child *node) {
+	if _, exists := n.children[name]; !exists {
+		n.names = append(n.names, name)
+	}
+	n.children[name] = child
+}
+
+// setAttributes sets the attributes of n from the entry e.
+func setAttributes(n *node, e *tarindex.Entry) {
+	perm := uint32(e.Mode) & 07777
+	switch e.Type {
+	case tar.TypeDir:
+		n.mode = syscall.S_IFDIR | perm
+	case tar.TypeSymlink:
+		n.mode = syscall.S_IFLNK | perm
+		n.link = e.Linkname
+		n.size = int64(len(e.Linkname))
+	case tar.TypeChar:
+		n.mode = syscall.S_IFCHR | perm
+		n.rdev = mkdev(e.Devmajor, e.Devminor)
+	case tar.TypeBlock:
+		n.mode = syscall.S_IFBLK | perm
+		n.rdev = mkdev(e.Devmajor, e.Devminor)
+	case tar.TypeFifo:
+		n.mode = syscall.S_IFIFO | perm
+	default:
+		n.mode = syscall.S_IFREG | perm
+		n.size = e.Size
+		n.chunks = e.Chunks
+	}
+	n.uid, n.gid = uint32(e.Uid), uint32(e.Gid)
+	n.modTime = e.ModTime
+	for k, v := range e.Xattrs {
+		if n.xattrs == nil {
+			n.xattrs = make(map[string]string)
+		}
+		n.xattrs[k] = v
+	}
+}
+
+// mkdev returns the device number of the given major and minor numbers, as
+// encoded in the attributes of the FUSE protocol.
+func mkdev(major, minor int64) uint32 {
+	return uint32((minor & 0xff) | (major&0xfff)<<8 | (minor&^0xff)<<12)
+}
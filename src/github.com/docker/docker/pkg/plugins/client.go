@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -42,16 +43,47 @@ func (c *Client) Call(serviceMethod string, args interface{}, ret interface{}) e
 	return c.callWithRetry(serviceMethod, args, ret, true)
 }
 
+// Stream calls the specified method with the specified arguments for the
+// plugin, and returns the response body instead of decoding it.
+func (c *Client) Stream(serviceMethod string, args interface{}) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(args); err != nil {
+		return nil, err
+	}
+	return c.sendRequest(serviceMethod, &buf, true)
+}
+
+// SendFile calls the specified method for the plugin with data as the request
+// body, and decodes the response into ret. The call is not retried, as data
+// can only be read once.
+func (c *Client) SendFile(serviceMethod string, data io.Reader, ret interface{}) error {
+	body, err := c.sendRequest(serviceMethod, data, false)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(&ret)
+}
+
 func (c *Client) callWithRetry(serviceMethod string, args interface{}, ret interface{}, retry bool) error {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(args); err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", "/"+serviceMethod, &buf)
+	body, err := c.sendRequest(serviceMethod, &buf, retry)
 	if err != nil {
 		return err
 	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(&ret)
+}
+
+func (c *Client) sendRequest(serviceMethod string, data io.Reader, retry bool) (io.ReadCloser, error) {
+	req, err := http.NewRequest("POST", "/"+serviceMethod, data)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Accept", versionMimetype)
 	req.URL.Scheme = "http"
 	req.URL.Host = c.addr
@@ -63,12 +95,12 @@ func (c *Client) callWithRetry(serviceMethod string, args interface{}, ret inter
 		resp, err := c.http.Do(req)
 		if err != nil {
 			if !retry {
-				return err
+				return nil, err
 			}
 
 			timeOff := backoff(retries)
 			if abort(start, timeOff) {
-				return err
+				return nil, err
 			}
 			retries++
 			logrus.Warnf("Unable to connect to plugin: %s, retrying in %v", c.addr, timeOff)
@@ -76,16 +108,16 @@ func (c *Client) callWithRetry(serviceMethod string, args interface{}, ret inter
 			continue
 		}
 
-		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
 			remoteErr, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
 			if err != nil {
-				return fmt.Errorf("Plugin Error: %s", err)
+				return nil, fmt.Errorf("Plugin Error: %s", err)
 			}
-			return fmt.Errorf("Plugin Error: %s", remoteErr)
+			return nil, fmt.Errorf("Plugin Error: %s", remoteErr)
 		}
 
-		return json.NewDecoder(resp.Body).Decode(&ret)
+		return resp.Body, nil
 	}
 }
 
@@ -1,10 +1,13 @@
 package plugins
 
 import (
+	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -65,6 +68,46 @@ func TestEchoInputOutput(t *testing.T) {
 	}
 }
 
+func TestStreamAndSendFile(t *testing.T) {
+	addr := setupRemotePluginServer()
+	defer teardownRemotePluginServer()
+
+	mux.HandleFunc("/Test.Stream", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("/Test.SendFile", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", versionMimetype)
+		json.NewEncoder(w).Encode(map[string]string{"Data": string(data) + "?" + r.URL.RawQuery})
+	})
+
+	c, _ := NewClient(addr, tlsconfig.Options{InsecureSkipVerify: true})
+	body, err := c.Stream("Test.Stream", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "\"stream\"\n" {
+		t.Fatalf("Unexpected stream %q", data)
+	}
+
+	var ret map[string]string
+	if err := c.SendFile("Test.SendFile?id=1", strings.NewReader("file"), &ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret["Data"] != "file?id=1" {
+		t.Fatalf("Unexpected response %v", ret)
+	}
+
+	if _, err := c.Stream("Test.NotFound", nil); err == nil {
+		t.Fatal("Expected an error streaming a missing method")
+	}
+}
+
 func TestBackoff(t *testing.T) {
 	cases := []struct {
 		retries    int
@@ -2,6 +2,7 @@ package progressreader
 
 import (
 	"io"
+	"time"
 
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/streamformatter"
@@ -18,6 +19,13 @@ type Config struct {
 	NewLines   bool
 	ID         string
 	Action     string
+
+	// State and Retries are reported with the progress of layers, which
+	// is recorded by Tracker when set.
+	State   string
+	Retries int
+	Tracker *Tracker
+	start   int64
 }
 
 func New(newReader Config) *Config {
@@ -25,6 +33,9 @@ func New(newReader Config) *Config {
 }
 
 func (config *Config) Read(p []byte) (n int, err error) {
+	if config.start == 0 {
+		config.start = time.Now().Unix()
+	}
 	read, err := config.In.Read(p)
 	config.Current += read
 	updateEvery := 1024 * 512 //512kB
@@ -58,7 +69,18 @@ func (config *Config) Close() error {
 }
 
 func updateProgress(config *Config) {
-	progress := jsonmessage.JSONProgress{Current: config.Current, Total: config.Size}
-	fmtMessage := config.Formatter.FormatProgress(config.ID, config.Action, &progress)
+	progress := jsonmessage.JSONProgress{
+		Current: config.Current,
+		Total:   config.Size,
+		Start:   config.start,
+		State:   config.State,
+		Retries: config.Retries,
+	}
+	var fmtMessage []byte
+	if config.Tracker != nil {
+		fmtMessage = config.Tracker.FormatProgress(config.ID, config.Action, &progress)
+	} else {
+		fmtMessage = config.Formatter.FormatProgress(config.ID, config.Action, &progress)
+	}
 	config.Out.Write(fmtMessage)
 }
@@ -0,0 +1,142 @@
+package progressreader
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/streamformatter"
+)
+
+// SummaryInterval is how often a Tracker writes the summary of the layers.
+const SummaryInterval = time.Second
+
+// Tracker keeps the progress of the layers of a pull or a push, to write
+// their aggregate progress, rate and ETA periodically. It is safe for
+// concurrent use.
+type Tracker struct {
+	sf  *streamformatter.StreamFormatter
+	out io.Writer
+
+	mu     sync.Mutex
+	layers map[string]*layerProgress
+	// transferred is the number of bytes transferred since start, which
+	// the rate is computed from.
+	transferred int64
+	start       time.Time
+	last        time.Time
+}
+
+type layerProgress struct {
+	state   string
+	current int64
+	total   int64
+}
+
+// NewTracker returns a Tracker formatting progress with sf, which writes
+// summaries to out. No summary is written when out is nil.
+func NewTracker(sf *streamformatter.StreamFormatter, out io.Writer) *Tracker {
+	return &Tracker{
+		sf:     sf,
+		out:    out,
+		layers: make(map[string]*layerProgress),
+	}
+}
+
+// FormatProgress records the progress of the layer id and formats it like
+// the StreamFormatter. A summary is written first when one is due.
+func (t *Tracker) FormatProgress(id, action string, progress *jsonmessage.JSONProgress) []byte {
+	if progress == nil {
+		progress = &jsonmessage.JSONProgress{}
+	}
+	t.mu.Lock()
+	t.update(id, progress)
+	if t.out != nil && time.Since(t.last) >= SummaryInterval {
+		t.writeSummary(t.out)
+	}
+	t.mu.Unlock()
+	return t.sf.FormatProgress(id, action, progress)
+}
+
+// FormatState formats that the layer id moved to state.
+func (t *Tracker) FormatState(id, action, state string) []byte {
+	return t.FormatProgress(id, action, &jsonmessage.JSONProgress{State: state})
+}
+
+func (t *Tracker) update(id string, progress *jsonmessage.JSONProgress) {
+	l, ok := t.layers[id]
+	if !ok {
+		l = &layerProgress{}
+		t.layers[id] = l
+	}
+	if progress.State != "" {
+		l.state = progress.State
+	}
+	switch l.state {
+	case jsonmessage.StateDownloading, jsonmessage.StateUploading:
+		if progress.Total > 0 {
+			l.total = int64(progress.Total)
+		}
+		current := int64(progress.Current)
+		if current > l.current {
+			if t.start.IsZero() {
+				t.start = time.Now()
+			}
+			t.transferred += current - l.current
+		}
+		l.current = current
+	case jsonmessage.StateComplete, jsonmessage.StatePushed, jsonmessage.StateDownloaded:
+		l.current = l.total
+	}
+}
+
+// Summary returns the aggregate progress of the layers.
+func (t *Tracker) Summary() jsonmessage.JSONSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.summary()
+}
+
+func (t *Tracker) summary() jsonmessage.JSONSummary {
+	s := jsonmessage.JSONSummary{Layers: len(t.layers)}
+	for _, l := range t.layers {
+		switch l.state {
+		case jsonmessage.StateComplete, jsonmessage.StateExists, jsonmessage.StatePushed:
+			s.Complete++
+		}
+		s.Current += l.current
+		s.Total += l.total
+	}
+	if !t.start.IsZero() {
+		if elapsed := time.Since(t.start); elapsed >= time.Second {
+			s.Rate = int64(float64(t.transferred) / elapsed.Seconds())
+		}
+	}
+	if s.Rate > 0 && s.Total > s.Current {
+		s.ETA = (s.Total - s.Current + s.Rate - 1) / s.Rate
+	}
+	return s
+}
+
+func (t *Tracker) writeSummary(out io.Writer) {
+	if len(t.layers) == 0 {
+		return
+	}
+	s := t.summary()
+	out.Write(t.sf.FormatSummary(&s))
+	t.last = time.Now()
+}
+
+// Flush writes the final summary of the layers to out, which may be another
+// writer than the one of the periodic summaries to order it after the
+// progress of the layers. Nothing is written when the Tracker writes no
+// summaries.
+func (t *Tracker) Flush(out io.Writer) {
+	if t.out == nil {
+		return
+	}
+	t.mu.Lock()
+	t.writeSummary(out)
+	t.mu.Unlock()
+}
@@ -0,0 +1,99 @@
+package progressreader
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/streamformatter"
+)
+
+// summaries returns the summaries written to buf.
+func summaries(t *testing.T, buf *bytes.Buffer) []jsonmessage.JSONSummary {
+	var list []jsonmessage.JSONSummary
+	dec := json.NewDecoder(buf)
+	for {
+		var jm jsonmessage.JSONMessage
+		if err := dec.Decode(&jm); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if jm.Summary != nil {
+			list = append(list, *jm.Summary)
+		}
+	}
+	return list
+}
+
+func TestTracker(t *testing.T) {
+	var summaryBuf bytes.Buffer
+	sf := streamformatter.NewJSONStreamFormatter()
+	tracker := NewTracker(sf, &summaryBuf)
+
+	tracker.FormatState("a", "Already exists", jsonmessage.StateExists)
+	tracker.FormatState("b", "Pulling fs layer", jsonmessage.StateWaiting)
+
+	content := []byte("TESTING")
+	var out bytes.Buffer
+	reader := New(Config{
+		In:        ioutil.NopCloser(bytes.NewReader(content)),
+		Out:       &out,
+		Formatter: sf,
+		Size:      len(content),
+		ID:        "b",
+		Action:    "Downloading",
+		State:     jsonmessage.StateDownloading,
+		Retries:   1,
+		Tracker:   tracker,
+	})
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		t.Fatal(err)
+	}
+
+	var jm jsonmessage.JSONMessage
+	if err := json.NewDecoder(&out).Decode(&jm); err != nil {
+		t.Fatal(err)
+	}
+	if jm.Progress == nil || jm.Progress.State != jsonmessage.StateDownloading || jm.Progress.Retries != 1 || jm.Progress.Start == 0 {
+		t.Fatalf("Unexpected progress %+v", jm.Progress)
+	}
+
+	s := tracker.Summary()
+	if s.Layers != 2 || s.Complete != 1 || s.Current != int64(len(content)) || s.Total != int64(len(content)) {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+
+	tracker.FormatState("b", "Pull complete", jsonmessage.StateComplete)
+	var final bytes.Buffer
+	tracker.Flush(&final)
+	list := summaries(t, &final)
+	if len(list) != 1 || list[0].Complete != 2 || list[0].Layers != 2 {
+		t.Fatalf("Unexpected final summary %+v", list)
+	}
+	// The first summary is written right away, the next ones once per
+	// SummaryInterval.
+	if list := summaries(t, &summaryBuf); len(list) != 1 || list[0].Layers != 1 {
+		t.Fatalf("Unexpected summaries %+v", list)
+	}
+}
+
+func TestTrackerWithoutSummaries(t *testing.T) {
+	tracker := NewTracker(streamformatter.NewJSONStreamFormatter(), nil)
+	res := tracker.FormatState("a", "Pull complete", jsonmessage.StateComplete)
+	var jm jsonmessage.JSONMessage
+	if err := json.Unmarshal(res, &jm); err != nil {
+		t.Fatal(err)
+	}
+	if jm.ID != "a" || jm.Status != "Pull complete" || jm.Progress == nil || jm.Progress.State != jsonmessage.StateComplete {
+		t.Fatalf("Unexpected message %+v", jm)
+	}
+	var out bytes.Buffer
+	tracker.Flush(&out)
+	if out.Len() != 0 {
+		t.Fatalf("Expected no summary, got %q", out.String())
+	}
+}
@@ -86,6 +86,18 @@ func (sf *StreamFormatter) FormatProgress(id, action string, progress *jsonmessa
 	return []byte(action + " " + progress.String() + endl)
 }
 
+// FormatSummary formats the aggregate progress of a pull or a push.
+func (sf *StreamFormatter) FormatSummary(summary *jsonmessage.JSONSummary) []byte {
+	if sf.json {
+		b, err := json.Marshal(&jsonmessage.JSONMessage{Summary: summary})
+		if err != nil {
+			return nil
+		}
+		return append(b, streamNewlineBytes...)
+	}
+	return []byte(summary.String() + streamNewline)
+}
+
 type StdoutFormater struct {
 	io.Writer
 	*StreamFormatter
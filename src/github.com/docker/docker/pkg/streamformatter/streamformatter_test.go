@@ -91,3 +91,16 @@ func TestJSONFormatProgress(t *testing.T) {
 		t.Fatal("Original progress not equals progress from FormatProgress")
 	}
 }
+
+func TestJSONFormatSummary(t *testing.T) {
+	sf := NewJSONStreamFormatter()
+	summary := &jsonmessage.JSONSummary{Layers: 2, Complete: 1, Current: 10, Total: 20, Rate: 5, ETA: 2}
+	res := sf.FormatSummary(summary)
+	msg := &jsonmessage.JSONMessage{}
+	if err := json.Unmarshal(res, msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg.Summary, summary) {
+		t.Fatalf("Expected summary %+v, got %+v", summary, msg.Summary)
+	}
+}
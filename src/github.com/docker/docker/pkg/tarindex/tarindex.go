@@ -0,0 +1,430 @@
+// Package tarindex writes gzip compressed tar archives with an index of their
+// entries, from which the contents of single files can be read without
+// decompressing the whole archive.
+//
+// The contents of each regular file are compressed in chunks, each in its own
+// gzip member, and the index records where the members of every file are in
+// the compressed archive. The index is stored in the extra field of empty
+// gzip members at the end of the archive, followed by a footer member holding
+// the offset of the index. The archive decompresses to the very same tar
+// stream as a plain gzip compression of it, so tools unaware of the index
+// read it as usual.
+//
+// The index holds the digest of every chunk, and is read back given its own
+// digest, so that the contents read from an archive are as trusted as the
+// digest of its index.
+package tarindex
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/docker/distribution/digest"
+)
+
+const (
+	// DefaultChunkSize is the size of the chunks the contents of files are
+	// compressed in.
+	DefaultChunkSize = 1 << 20
+
+	// version is the version of the index format.
+	version = 1
+
+	// maxExtraData is the maximum size of the data of a subfield of the
+	// extra field of a gzip member.
+	maxExtraData = 0xffff - 4
+)
+
+// Subfield IDs of the extra field of the gzip members holding the index and
+// the footer.
+var (
+	indexSubfieldID  = [2]byte{'T', 'I'}
+	footerSubfieldID = [2]byte{'T', 'F'}
+)
+
+// ErrNotIndexed is returned by ReadIndex for archives without an index.
+var ErrNotIndexed = errors.New("tarindex: archive has no index")
+
+// ErrIndexDigest is returned by ReadIndex when the index of an archive does
+// not match its digest.
+var ErrIndexDigest = errors.New("tarindex: index does not match its digest")
+
+// Index is the index of the entries of an archive.
+type Index struct {
+	Version int
+	// DiffID is the digest of the uncompressed tar stream.
+	DiffID  digest.Digest
+	Entries []*Entry
+}
+
+// Entry is an entry of an archive.
+type Entry struct {
+	Name     string
+	Type     byte
+	Linkname string `json:",omitempty"`
+	Size     int64  `json:",omitempty"`
+	Mode     int64
+	Uid      int
+	Gid      int
+	ModTime  time.Time
+	Devmajor int64             `json:",omitempty"`
+	Devminor int64             `json:",omitempty"`
+	Xattrs   map[string]string `json:",omitempty"`
+	// Chunks are the chunks of the contents of a regular file, in order.
+	Chunks []Chunk `json:",omitempty"`
+}
+
+// Chunk is a chunk of the contents of a file.
+type Chunk struct {
+	// Offset and CompressedSize locate the gzip member holding the chunk
+	// in the compressed archive.
+	Offset         int64
+	CompressedSize int64
+	// FileOffset is the offset of the chunk in the file.
+	FileOffset int64
+	Size       int64
+	// Digest is the digest of the contents of the chunk.
+	Digest digest.Digest
+}
+
+// Indexed returns whether the contents of the entry can be read from its
+// chunks. The contents of sparse files are not indexed.
+func (e *Entry) Indexed() bool {
+	return e.Size == 0 || len(e.Chunks) > 0
+}
+
+// Compress writes the tar archive read from src to dest, compressed with gzip
+// at the given level and indexed, and returns the digest of the index. The
+// contents of files are split in chunks of chunkSize bytes, or
+// DefaultChunkSize if chunkSize is not positive.
+func Compress(dest io.Writer, src io.Reader, level, chunkSize int) (digest.Digest, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	w := &writer{dest: dest, level: level}
+	if err := w.newMember(); err != nil {
+		return "", err
+	}
+
+	digester := digest.Canonical.New()
+	// The tar stream is copied as it is read, so that the uncompressed
+	// archive is not changed by decoding and encoding its headers.
+	rawSrc := io.TeeReader(src, io.MultiWriter(w, digester.Hash()))
+	tr := tar.NewReader(rawSrc)
+
+	index := &Index{Version: version}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		entry := newEntry(hdr)
+		index.Entries = append(index.Entries, entry)
+		if hdr.Size == 0 || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) || isSparse(hdr) {
+			continue
+		}
+
+		for offset := int64(0); offset < hdr.Size; offset += int64(chunkSize) {
+			if err := w.newMember(); err != nil {
+				return "", err
+			}
+			size := hdr.Size - offset
+			if size > int64(chunkSize) {
+				size = int64(chunkSize)
+			}
+			chunkDigester := digest.Canonical.New()
+			if _, err := io.CopyN(chunkDigester.Hash(), tr, size); err != nil {
+				return "", err
+			}
+			entry.Chunks = append(entry.Chunks, Chunk{
+				Offset:     w.memberOffset,
+				FileOffset: offset,
+				Size:       size,
+				Digest:     chunkDigester.Digest(),
+			})
+			w.chunk = &entry.Chunks[len(entry.Chunks)-1]
+		}
+		// The padding of the file and the next header go to a new member.
+		if err := w.newMember(); err != nil {
+			return "", err
+		}
+	}
+	// The padding after the end of the archive is part of the tar stream.
+	if _, err := io.Copy(ioutil.Discard, rawSrc); err != nil {
+		return "", err
+	}
+	index.DiffID = digester.Digest()
+
+	if err := w.gz.Close(); err != nil {
+		return "", err
+	}
+	return w.writeIndex(index)
+}
+
+func newEntry(hdr *tar.Header) *Entry {
+	return &Entry{
+		Name:     hdr.Name,
+		Type:     hdr.Typeflag,
+		Linkname: hdr.Linkname,
+		Size:     hdr.Size,
+		Mode:     hdr.Mode,
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+		ModTime:  hdr.ModTime,
+		Devmajor: hdr.Devmajor,
+		Devminor: hdr.Devminor,
+		Xattrs:   hdr.Xattrs,
+	}
+}
+
+// isSparse returns whether hdr is the header of a sparse file, whose raw
+// contents differ from the contents read from the tar reader.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if len(key) > 11 && key[:11] == "GNU.sparse." {
+			return true
+		}
+	}
+	return false
+}
+
+// writer compresses the archive in gzip members.
+type writer struct {
+	dest  io.Writer
+	level int
+	// written is the number of bytes written to dest.
+	written int64
+	gz      *gzip.Writer
+	// memberOffset is the offset of the current member.
+	memberOffset int64
+	// chunk is the chunk the current member holds, if any.
+	chunk *Chunk
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.gz.Write(p)
+}
+
+// newMember ends the current member, if any, and starts a new one.
+func (w *writer) newMember() error {
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if w.chunk != nil {
+		w.chunk.CompressedSize = w.written - w.chunk.Offset
+		w.chunk = nil
+	}
+	w.memberOffset = w.written
+	gz, err := gzip.NewWriterLevel(countWriter{w}, w.level)
+	if err != nil {
+		return err
+	}
+	w.gz = gz
+	return nil
+}
+
+// writeIndex writes the index and the footer after the archive, and returns
+// the digest of the index.
+func (w *writer) writeIndex(index *Index) (digest.Digest, error) {
+	indexOffset := w.written
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	dgst, err := digest.FromBytes(data)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	for data := buf.Bytes(); len(data) > 0; {
+		n := len(data)
+		if n > maxExtraData {
+			n = maxExtraData
+		}
+		if err := writeEmptyMember(countWriter{w}, indexSubfieldID, data[:n]); err != nil {
+			return "", err
+		}
+		data = data[n:]
+	}
+
+	if err := writeEmptyMember(countWriter{w}, footerSubfieldID, footerData(indexOffset)); err != nil {
+		return "", err
+	}
+	return dgst, nil
+}
+
+// countWriter writes to the destination of a writer, counting the bytes
+// written.
+type countWriter struct {
+	w *writer
+}
+
+func (cw countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.dest.Write(p)
+	cw.w.written += int64(n)
+	return n, err
+}
+
+// writeEmptyMember writes a gzip member without contents, whose extra field
+// holds data in a subfield with the given ID.
+func writeEmptyMember(w io.Writer, id [2]byte, data []byte) error {
+	extra := make([]byte, 4, 4+len(data))
+	extra[0], extra[1] = id[0], id[1]
+	extra[2], extra[3] = byte(len(data)), byte(len(data)>>8)
+	gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	gz.Extra = append(extra, data...)
+	return gz.Close()
+}
+
+// footerData returns the data of the footer of an archive whose index
+// starts at indexOffset.
+func footerData(indexOffset int64) []byte {
+	return []byte(fmt.Sprintf("%016x", indexOffset))
+}
+
+// footerSize is the size of the footer member.
+var footerSize = func() int64 {
+	var buf bytes.Buffer
+	writeEmptyMember(&buf, footerSubfieldID, footerData(0))
+	return int64(buf.Len())
+}()
+
+// subfieldData returns the data of the subfield id of the extra field of a
+// gzip member, or nil if it has none.
+func subfieldData(extra []byte, id [2]byte) []byte {
+	for len(extra) >= 4 {
+		size := int(extra[2]) | int(extra[3])<<8
+		if len(extra) < 4+size {
+			return nil
+		}
+		if extra[0] == id[0] && extra[1] == id[1] {
+			return extra[4 : 4+size]
+		}
+		extra = extra[4+size:]
+	}
+	return nil
+}
+
+// ReadIndex reads the index of the compressed archive r of the given size,
+// which must match dgst, the digest of the index returned by Compress. It
+// returns ErrNotIndexed if the archive has no index, and ErrIndexDigest if
+// the index does not match dgst.
+func ReadIndex(r io.ReaderAt, size int64, dgst digest.Digest) (*Index, error) {
+	if size < footerSize {
+		return nil, ErrNotIndexed
+	}
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-footerSize); err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(footer))
+	if err != nil {
+		return nil, ErrNotIndexed
+	}
+	data := subfieldData(gz.Extra, footerSubfieldID)
+	if data == nil {
+		return nil, ErrNotIndexed
+	}
+	indexOffset, err := strconv.ParseInt(string(data), 16, 64)
+	if err != nil || indexOffset < 0 || indexOffset >= size-footerSize {
+		return nil, ErrNotIndexed
+	}
+
+	// The compressed index is split in the extra fields of the members
+	// between indexOffset and the footer.
+	var compressed bytes.Buffer
+	br := bufio.NewReader(io.NewSectionReader(r, indexOffset, size-footerSize-indexOffset))
+	if err := gz.Reset(br); err != nil {
+		return nil, fmt.Errorf("tarindex: invalid index: %v", err)
+	}
+	for {
+		gz.Multistream(false)
+		compressed.Write(subfieldData(gz.Extra, indexSubfieldID))
+		if _, err := io.Copy(ioutil.Discard, gz); err != nil {
+			return nil, fmt.Errorf("tarindex: invalid index: %v", err)
+		}
+		if err := gz.Reset(br); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("tarindex: invalid index: %v", err)
+		}
+	}
+
+	gz, err = gzip.NewReader(&compressed)
+	if err != nil {
+		return nil, fmt.Errorf("tarindex: invalid index: %v", err)
+	}
+	verifier, err := digest.NewDigestVerifier(dgst)
+	if err != nil {
+		return nil, err
+	}
+	data, err = ioutil.ReadAll(io.TeeReader(gz, verifier))
+	if err != nil {
+		return nil, fmt.Errorf("tarindex: invalid index: %v", err)
+	}
+	if !verifier.Verified() {
+		return nil, ErrIndexDigest
+	}
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("tarindex: invalid index: %v", err)
+	}
+	if index.Version != version {
+		return nil, fmt.Errorf("tarindex: unsupported index version %d", index.Version)
+	}
+	return &index, nil
+}
+
+// ReadChunk reads the contents of chunk c from the compressed archive r, and
+// checks them against the digest of the chunk.
+func ReadChunk(r io.ReaderAt, c Chunk) ([]byte, error) {
+	gz, err := gzip.NewReader(io.NewSectionReader(r, c.Offset, c.CompressedSize))
+	if err != nil {
+		return nil, err
+	}
+	gz.Multistream(false)
+	data := make([]byte, c.Size)
+	if _, err := io.ReadFull(gz, data); err != nil {
+		return nil, err
+	}
+	if n, _ := gz.Read(make([]byte, 1)); n != 0 {
+		return nil, fmt.Errorf("tarindex: chunk at %d is larger than %d bytes", c.Offset, c.Size)
+	}
+	verifier, err := digest.NewDigestVerifier(c.Digest)
+	if err != nil {
+		return nil, err
+	}
+	verifier.Write(data)
+	if !verifier.Verified() {
+		return nil, fmt.Errorf("tarindex: chunk at %d does not match its digest %s", c.Offset, c.Digest)
+	}
+	return data, nil
+}
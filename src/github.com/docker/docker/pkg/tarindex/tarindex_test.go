@@ -0,0 +1,177 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+)
+
+// testArchive returns a tar archive with a directory, a file of size bytes,
+// an empty file and a symlink, and padding after its end.
+func testArchive(t *testing.T, size int) ([]byte, []byte) {
+	contents := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(contents)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	headers := []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/data", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(size), Uid: 1, Gid: 2},
+		{Name: "etc/empty", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "etc/link", Typeflag: tar.TypeSymlink, Linkname: "data"},
+	}
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write(contents)
+		}
+	}
+	tw.Close()
+	buf.Write(make([]byte, 1024))
+	return buf.Bytes(), contents
+}
+
+func TestCompress(t *testing.T) {
+	archive, contents := testArchive(t, 2*DefaultChunkSize+100)
+
+	var compressed bytes.Buffer
+	dgst, err := Compress(&compressed, bytes.NewReader(archive), gzip.DefaultCompression, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive decompresses to the original tar stream.
+	gz, err := gzip.NewReader(bytes.NewReader(compressed.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, archive) {
+		t.Fatal("Decompressed archive differs from the original one")
+	}
+
+	r := bytes.NewReader(compressed.Bytes())
+	index, err := ReadIndex(r, int64(compressed.Len()), dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := digest.FromBytes(archive); index.DiffID != expected {
+		t.Fatalf("Expected diff ID %s, got %s", expected, index.DiffID)
+	}
+	if len(index.Entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(index.Entries))
+	}
+	if e := index.Entries[3]; e.Name != "etc/link" || e.Type != tar.TypeSymlink || e.Linkname != "data" {
+		t.Fatalf("Unexpected symlink entry %+v", e)
+	}
+	if e := index.Entries[2]; !e.Indexed() || len(e.Chunks) != 0 {
+		t.Fatalf("Unexpected empty file entry %+v", e)
+	}
+
+	e := index.Entries[1]
+	if e.Name != "etc/data" || e.Uid != 1 || e.Gid != 2 || e.Mode != 0644 {
+		t.Fatalf("Unexpected file entry %+v", e)
+	}
+	if len(e.Chunks) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(e.Chunks))
+	}
+	var read []byte
+	for _, c := range e.Chunks {
+		if c.FileOffset != int64(len(read)) {
+			t.Fatalf("Expected chunk at %d, got %d", len(read), c.FileOffset)
+		}
+		data, err := ReadChunk(r, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, data...)
+	}
+	if !bytes.Equal(read, contents) {
+		t.Fatal("Chunks differ from the contents of the file")
+	}
+}
+
+func TestReadIndexNotIndexed(t *testing.T) {
+	archive, _ := testArchive(t, 100)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(archive)
+	gz.Close()
+
+	dgst, _ := digest.FromBytes(nil)
+	if _, err := ReadIndex(bytes.NewReader(compressed.Bytes()), int64(compressed.Len()), dgst); err != ErrNotIndexed {
+		t.Fatalf("Expected ErrNotIndexed, got %v", err)
+	}
+	if _, err := ReadIndex(bytes.NewReader(nil), 0, dgst); err != ErrNotIndexed {
+		t.Fatalf("Expected ErrNotIndexed, got %v", err)
+	}
+}
+
+func TestReadIndexSplit(t *testing.T) {
+	// The index of many files does not fit in a single extra field.
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < 5000; i++ {
+		tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("dir/file-%d-%x", i, rand.Int63()), Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+		tw.Write([]byte{byte(i)})
+	}
+	tw.Close()
+
+	var compressed bytes.Buffer
+	dgst, err := Compress(&compressed, &buf, gzip.BestSpeed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ReadIndex(bytes.NewReader(compressed.Bytes()), int64(compressed.Len()), dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Entries) != 5000 {
+		t.Fatalf("Expected 5000 entries, got %d", len(index.Entries))
+	}
+	data, err := ReadChunk(bytes.NewReader(compressed.Bytes()), index.Entries[4242].Chunks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 1 || data[0] != byte(4242%256) {
+		t.Fatalf("Unexpected contents %v", data)
+	}
+}
+
+func TestReadIndexVerified(t *testing.T) {
+	archive, _ := testArchive(t, 100)
+	var compressed bytes.Buffer
+	dgst, err := Compress(&compressed, bytes.NewReader(archive), gzip.DefaultCompression, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(compressed.Bytes())
+
+	// An index is only read given its digest.
+	other, _ := digest.FromBytes(archive)
+	if _, err := ReadIndex(r, int64(compressed.Len()), other); err != ErrIndexDigest {
+		t.Fatalf("Expected ErrIndexDigest, got %v", err)
+	}
+
+	// The chunks read are checked against their digest.
+	index, err := ReadIndex(r, int64(compressed.Len()), dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := index.Entries[1].Chunks[0]
+	c.Digest = other
+	if _, err := ReadChunk(r, c); err == nil {
+		t.Fatal("Expected a chunk not matching its digest to be refused")
+	}
+}
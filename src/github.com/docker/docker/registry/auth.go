@@ -224,8 +224,8 @@ func tryV2TokenAuthLogin(authConfig *cliconfig.AuthConfig, params map[string]str
 func ResolveAuthConfig(config *cliconfig.ConfigFile, index *IndexInfo) cliconfig.AuthConfig {
 	configKey := index.GetAuthConfigKey()
 	// First try the happy case
-	if c, found := config.AuthConfigs[configKey]; found || index.Official {
-		return c
+	if _, found := config.AuthConfigs[configKey]; found || index.Official || config.CredentialHelper(configKey) != "" {
+		return getAuthConfig(config, configKey)
 	}
 
 	convertToHostname := func(url string) string {
@@ -243,12 +243,23 @@ func ResolveAuthConfig(config *cliconfig.ConfigFile, index *IndexInfo) cliconfig
 
 	// Maybe they have a legacy config file, we will iterate the keys converting
 	// them to the new format and testing
-	for registry, ac := range config.AuthConfigs {
+	for registry := range config.AuthConfigs {
 		if configKey == convertToHostname(registry) {
-			return ac
+			return getAuthConfig(config, registry)
 		}
 	}
 
 	// When all else fails, return an empty auth config
 	return cliconfig.AuthConfig{}
 }
+
+// getAuthConfig returns the credentials of serverAddress in config, or empty
+// credentials if its credential helper fails.
+func getAuthConfig(config *cliconfig.ConfigFile, serverAddress string) cliconfig.AuthConfig {
+	authConfig, err := config.GetAuthConfig(serverAddress)
+	if err != nil {
+		logrus.Warnf("Could not get the credentials of %s: %v", serverAddress, err)
+		return cliconfig.AuthConfig{}
+	}
+	return authConfig
+}
@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/registry/api/errcode"
+)
+
+const (
+	// minMirrorBackoff is how long a mirror that could not be reached is
+	// tried after the registry it mirrors. It doubles for each failure in a
+	// row, up to maxMirrorBackoff.
+	minMirrorBackoff = 30 * time.Second
+	maxMirrorBackoff = 10 * time.Minute
+)
+
+// mirrorHealth tracks the mirrors that could not be reached recently.
+type mirrorHealth struct {
+	sync.Mutex
+	down map[string]*mirrorFailure
+}
+
+type mirrorFailure struct {
+	failures int
+	until    time.Time
+}
+
+func newMirrorHealth() *mirrorHealth {
+	return &mirrorHealth{down: make(map[string]*mirrorFailure)}
+}
+
+// isDown returns true if mirror could not be reached recently.
+func (h *mirrorHealth) isDown(mirror string) bool {
+	h.Lock()
+	defer h.Unlock()
+	f, ok := h.down[mirror]
+	return ok && time.Now().Before(f.until)
+}
+
+// report records the outcome of using mirror. The mirror is considered down if
+// err shows it could not be reached, and up again once it could.
+func (h *mirrorHealth) report(mirror string, err error) {
+	h.Lock()
+	defer h.Unlock()
+	if !isConnectionError(err) {
+		delete(h.down, mirror)
+		return
+	}
+	f, ok := h.down[mirror]
+	if !ok {
+		f = &mirrorFailure{}
+		h.down[mirror] = f
+	}
+	f.failures++
+	backoff := minMirrorBackoff << uint(f.failures-1)
+	if backoff > maxMirrorBackoff || backoff <= 0 {
+		backoff = maxMirrorBackoff
+	}
+	f.until = time.Now().Add(backoff)
+	logrus.Warnf("Mirror %s could not be reached, trying it last for %s: %v", mirror, backoff, err)
+}
+
+// isConnectionError returns true if err shows a registry could not be
+// reached, as opposed to an error returned by the registry.
+func isConnectionError(err error) bool {
+	switch v := err.(type) {
+	case nil:
+		return false
+	case errcode.Errors:
+		return len(v) > 0 && isConnectionError(v[0])
+	case ErrNoSupport:
+		return isConnectionError(v.Err)
+	case *url.Error:
+		return true
+	case net.Error:
+		return true
+	}
+	return false
+}
@@ -641,6 +641,30 @@ func TestNewIndexInfo(t *testing.T) {
 	}
 	testIndexInfo(config, expectedIndexInfos)
 
+	privateMirrors := []string{"https://mirror1.internal/", "https://mirror2.internal/"}
+	config = makeServiceConfig([]string{"registry.internal=" + privateMirrors[0], "registry.internal=" + privateMirrors[1]}, nil)
+	expectedIndexInfos = map[string]*IndexInfo{
+		IndexName: {
+			Name:     IndexName,
+			Official: true,
+			Secure:   true,
+			Mirrors:  noMirrors,
+		},
+		"registry.internal": {
+			Name:     "registry.internal",
+			Official: false,
+			Secure:   true,
+			Mirrors:  privateMirrors,
+		},
+		"other.com": {
+			Name:     "other.com",
+			Official: false,
+			Secure:   true,
+			Mirrors:  noMirrors,
+		},
+	}
+	testIndexInfo(config, expectedIndexInfos)
+
 	config = makeServiceConfig(nil, []string{"42.42.0.0/16"})
 	expectedIndexInfos = map[string]*IndexInfo{
 		"example.com": {
@@ -12,14 +12,18 @@ import (
 // Service is a registry service. It tracks configuration data such as a list
 // of mirrors.
 type Service struct {
-	Config *ServiceConfig
+	Config  *ServiceConfig
+	mirrors *mirrorHealth
+	tokens  *tokenCache
 }
 
 // NewService returns a new instance of Service ready to be
 // installed into an engine.
 func NewService(options *Options) *Service {
 	return &Service{
-		Config: NewServiceConfig(options),
+		Config:  NewServiceConfig(options),
+		mirrors: newMirrorHealth(),
+		tokens:  newTokenCache(),
 	}
 }
 
@@ -93,6 +97,10 @@ type APIEndpoint struct {
 	TLSConfig     *tls.Config
 	VersionHeader string
 	Versions      []auth.APIVersion
+
+	// AuthConfig holds the credentials configured for a mirror. They are
+	// used instead of the credentials of the pull if set.
+	AuthConfig *cliconfig.AuthConfig
 }
 
 // ToV1Endpoint returns a V1 API endpoint based on the APIEndpoint
@@ -113,9 +121,46 @@ func (s *Service) tlsConfigForMirror(mirror string) (*tls.Config, error) {
 	return s.TLSConfig(mirrorURL.Host)
 }
 
+// ReportMirror records the outcome of pulling from the mirror endpoint. Mirrors
+// that could not be reached are tried after the registry they mirror for a
+// while.
+func (s *Service) ReportMirror(endpoint APIEndpoint, err error) {
+	if endpoint.Mirror {
+		s.mirrors.report(endpoint.URL, err)
+	}
+}
+
+// mirrorEndpoints returns the v2 endpoints of mirrors, in the order they are
+// configured. Mirrors that could not be reached recently are returned
+// separately, to be tried last.
+func (s *Service) mirrorEndpoints(mirrors []string) (up, down []APIEndpoint, err error) {
+	for _, mirror := range mirrors {
+		mirrorTLSConfig, err := s.tlsConfigForMirror(mirror)
+		if err != nil {
+			return nil, nil, err
+		}
+		endpoint := APIEndpoint{
+			URL: mirror,
+			// guess mirrors are v2
+			Version:      APIVersion2,
+			Mirror:       true,
+			TrimHostname: true,
+			TLSConfig:    mirrorTLSConfig,
+			AuthConfig:   s.Config.mirrorAuth[mirror],
+		}
+		if s.mirrors.isDown(mirror) {
+			down = append(down, endpoint)
+		} else {
+			up = append(up, endpoint)
+		}
+	}
+	return up, down, nil
+}
+
 // LookupPullEndpoints creates an list of endpoints to try to pull from, in order of preference.
 // It gives preference to v2 endpoints over v1, mirrors over the actual
-// registry, and HTTPS over plain HTTP.
+// registry, and HTTPS over plain HTTP. Mirrors that could not be reached
+// recently are tried after the actual registry.
 func (s *Service) LookupPullEndpoints(repoName string) (endpoints []APIEndpoint, err error) {
 	return s.lookupEndpoints(repoName)
 }
@@ -124,7 +169,16 @@ func (s *Service) LookupPullEndpoints(repoName string) (endpoints []APIEndpoint,
 // It gives preference to v2 endpoints over v1, and HTTPS over plain HTTP.
 // Mirrors are not included.
 func (s *Service) LookupPushEndpoints(repoName string) (endpoints []APIEndpoint, err error) {
-	return s.lookupEndpoints(repoName)
+	allEndpoints, err := s.lookupEndpoints(repoName)
+	if err != nil {
+		return nil, err
+	}
+	for _, endpoint := range allEndpoints {
+		if !endpoint.Mirror {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
 }
 
 func (s *Service) lookupEndpoints(repoName string) (endpoints []APIEndpoint, err error) {
@@ -13,20 +13,11 @@ func (s *Service) lookupV2Endpoints(repoName string) (endpoints []APIEndpoint, e
 	tlsConfig := &cfg
 	if strings.HasPrefix(repoName, DefaultNamespace+"/") {
 		// v2 mirrors
-		for _, mirror := range s.Config.Mirrors {
-			mirrorTLSConfig, err := s.tlsConfigForMirror(mirror)
-			if err != nil {
-				return nil, err
-			}
-			endpoints = append(endpoints, APIEndpoint{
-				URL: mirror,
-				// guess mirrors are v2
-				Version:      APIVersion2,
-				Mirror:       true,
-				TrimHostname: true,
-				TLSConfig:    mirrorTLSConfig,
-			})
+		mirrors, downMirrors, err := s.mirrorEndpoints(s.Config.Mirrors)
+		if err != nil {
+			return nil, err
 		}
+		endpoints = append(endpoints, mirrors...)
 		// v2 registry
 		endpoints = append(endpoints, APIEndpoint{
 			URL:          DefaultV2Registry,
@@ -36,7 +27,7 @@ func (s *Service) lookupV2Endpoints(repoName string) (endpoints []APIEndpoint, e
 			TLSConfig:    tlsConfig,
 		})
 
-		return endpoints, nil
+		return append(endpoints, downMirrors...), nil
 	}
 
 	slashIndex := strings.IndexRune(repoName, '/')
@@ -50,22 +41,28 @@ func (s *Service) lookupV2Endpoints(repoName string) (endpoints []APIEndpoint, e
 		return nil, err
 	}
 
+	// v2 mirrors
+	var mirrors, downMirrors []APIEndpoint
+	if index, ok := s.Config.IndexConfigs[hostname]; ok {
+		if mirrors, downMirrors, err = s.mirrorEndpoints(index.Mirrors); err != nil {
+			return nil, err
+		}
+	}
+
 	v2Versions := []auth.APIVersion{
 		{
 			Type:    "registry",
 			Version: "2.0",
 		},
 	}
-	endpoints = []APIEndpoint{
-		{
-			URL:           "https://" + hostname,
-			Version:       APIVersion2,
-			TrimHostname:  true,
-			TLSConfig:     tlsConfig,
-			VersionHeader: DefaultRegistryVersionHeader,
-			Versions:      v2Versions,
-		},
-	}
+	endpoints = append(mirrors, APIEndpoint{
+		URL:           "https://" + hostname,
+		Version:       APIVersion2,
+		TrimHostname:  true,
+		TLSConfig:     tlsConfig,
+		VersionHeader: DefaultRegistryVersionHeader,
+		Versions:      v2Versions,
+	})
 
 	if tlsConfig.InsecureSkipVerify {
 		endpoints = append(endpoints, APIEndpoint{
@@ -79,5 +76,5 @@ func (s *Service) lookupV2Endpoints(repoName string) (endpoints []APIEndpoint, e
 		})
 	}
 
-	return endpoints, nil
+	return append(endpoints, downMirrors...), nil
 }
@@ -1,16 +1,32 @@
 package registry
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/registry/client/auth"
+)
+
+const (
+	// minimumTokenLifetime is how long tokens are valid when the token
+	// server says nothing or less, as in the token authentication spec.
+	minimumTokenLifetime = 60 * time.Second
+	// tokenRefreshMargin is how long before they expire cached tokens are
+	// refreshed, so that they do not expire while requests are in flight.
+	tokenRefreshMargin = 10 * time.Second
 )
 
 type tokenResponse struct {
-	Token string `json:"token"`
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
 }
 
 func getToken(username, password string, params map[string]string, registryEndpoint *Endpoint) (string, error) {
@@ -32,21 +48,28 @@ func getToken(username, password string, params map[string]string, registryEndpo
 		}
 	}
 
+	tr, err := requestToken(registryEndpoint.client, realmURL, params["service"], strings.Fields(params["scope"]), username, password)
+	if err != nil {
+		return "", fmt.Errorf("token auth attempt for registry %s: %v", registryEndpoint, err)
+	}
+	return tr.Token, nil
+}
+
+// requestToken requests a token for scopes from the token server at realmURL.
+func requestToken(client *http.Client, realmURL *url.URL, service string, scopes []string, username, password string) (*tokenResponse, error) {
 	req, err := http.NewRequest("GET", realmURL.String(), nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	reqParams := req.URL.Query()
-	service := params["service"]
-	scope := params["scope"]
 
 	if service != "" {
 		reqParams.Add("service", service)
 	}
 
-	for _, scopeField := range strings.Fields(scope) {
-		reqParams.Add("scope", scopeField)
+	for _, scope := range scopes {
+		reqParams.Add("scope", scope)
 	}
 
 	if username != "" {
@@ -56,26 +79,197 @@ func getToken(username, password string, params map[string]string, registryEndpo
 
 	req.URL.RawQuery = reqParams.Encode()
 
-	resp, err := registryEndpoint.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("token auth attempt for registry %s: %s request failed with status: %d %s", registryEndpoint, req.URL, resp.StatusCode, http.StatusText(resp.StatusCode))
+		return nil, fmt.Errorf("%s request failed with status: %d %s", req.URL, resp.StatusCode, http.StatusText(resp.StatusCode))
 	}
 
 	decoder := json.NewDecoder(resp.Body)
 
 	tr := new(tokenResponse)
 	if err = decoder.Decode(tr); err != nil {
-		return "", fmt.Errorf("unable to decode token response: %s", err)
+		return nil, fmt.Errorf("unable to decode token response: %s", err)
 	}
 
+	// Token servers implementing OAuth 2 return access_token.
 	if tr.Token == "" {
-		return "", errors.New("authorization server did not include a token in the response")
+		tr.Token = tr.AccessToken
 	}
 
+	if tr.Token == "" {
+		return nil, errors.New("authorization server did not include a token in the response")
+	}
+
+	return tr, nil
+}
+
+// tokenCache caches the bearer tokens of the daemon until they expire, so
+// that pulls and pushes of the same repositories with the same credentials
+// share them.
+type tokenCache struct {
+	sync.Mutex
+	tokens map[string]*cachedToken
+}
+
+// cachedToken is locked while its token is fetched. Its fields are only
+// changed with the tokenCache locked too, so that expired tokens can be
+// pruned without waiting for fetches.
+type cachedToken struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{tokens: make(map[string]*cachedToken)}
+}
+
+// get returns the token cached for key, or the one fetch returns once it has
+// expired. Tokens of different keys are fetched concurrently, and those of
+// the same key only once.
+func (c *tokenCache) get(key string, fetch func() (*tokenResponse, error)) (string, error) {
+	now := time.Now()
+
+	c.Lock()
+	t, ok := c.tokens[key]
+	if !ok {
+		t = &cachedToken{}
+		c.tokens[key] = t
+	}
+	for k, other := range c.tokens {
+		if other != t && !other.expires.IsZero() && now.After(other.expires) {
+			delete(c.tokens, k)
+		}
+	}
+	c.Unlock()
+
+	t.Lock()
+	defer t.Unlock()
+	if now.Add(tokenRefreshMargin).Before(t.expires) {
+		return t.token, nil
+	}
+	tr, err := fetch()
+	if err != nil {
+		c.Lock()
+		if t.expires.IsZero() && c.tokens[key] == t {
+			delete(c.tokens, key)
+		}
+		c.Unlock()
+		return "", err
+	}
+	lifetime := time.Duration(tr.ExpiresIn) * time.Second
+	if lifetime < minimumTokenLifetime {
+		lifetime = minimumTokenLifetime
+	}
+	c.Lock()
+	t.token = tr.Token
+	t.expires = now.Add(lifetime)
+	c.Unlock()
 	return tr.Token, nil
 }
+
+// evict removes token from the cache, so that it is fetched again.
+func (c *tokenCache) evict(token string) {
+	c.Lock()
+	defer c.Unlock()
+	for k, t := range c.tokens {
+		if t.token == token {
+			delete(c.tokens, k)
+		}
+	}
+}
+
+// tokenEvicter evicts the cached bearer tokens of the requests the registry
+// refuses, e.g. tokens revoked before they expire, so that the next requests
+// fetch new ones.
+type tokenEvicter struct {
+	http.RoundTripper
+	cache *tokenCache
+}
+
+// EvictRefusedTokens returns a transport sending requests with base, which
+// evicts the bearer tokens the registry refuses from the tokens of s. It
+// must be the base transport of the token handlers, which set the tokens.
+func (s *Service) EvictRefusedTokens(base http.RoundTripper) http.RoundTripper {
+	return &tokenEvicter{RoundTripper: base, cache: s.tokens}
+}
+
+func (t *tokenEvicter) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			t.cache.evict(strings.TrimPrefix(auth, "Bearer "))
+		}
+	}
+	return resp, err
+}
+
+// tokenHandler handles bearer token auth challenges with the tokens of a
+// tokenCache.
+type tokenHandler struct {
+	transport http.RoundTripper
+	creds     auth.CredentialStore
+	scope     string
+	cache     *tokenCache
+}
+
+// NewTokenHandler returns a handler of bearer token auth challenges for
+// actions on the repository repoName, which shares tokens with the other
+// handlers of s until they expire.
+func (s *Service) NewTokenHandler(transport http.RoundTripper, creds auth.CredentialStore, repoName string, actions ...string) auth.AuthenticationHandler {
+	return &tokenHandler{
+		transport: transport,
+		creds:     creds,
+		scope:     fmt.Sprintf("repository:%s:%s", repoName, strings.Join(actions, ",")),
+		cache:     s.tokens,
+	}
+}
+
+func (th *tokenHandler) Scheme() string {
+	return "bearer"
+}
+
+func (th *tokenHandler) AuthorizeRequest(req *http.Request, params map[string]string) error {
+	realm, ok := params["realm"]
+	if !ok {
+		return errors.New("no realm specified for token auth challenge")
+	}
+
+	realmURL, err := url.Parse(realm)
+	if err != nil {
+		return fmt.Errorf("invalid token auth challenge realm: %s", err)
+	}
+
+	var username, password string
+	if th.creds != nil {
+		username, password = th.creds.Basic(realmURL)
+		if username == "" || password == "" {
+			username, password = "", ""
+		}
+	}
+
+	// Tokens are only shared by requests with the same credentials.
+	key := fmt.Sprintf("%s %s %s %x", realm, params["service"], th.scope, sha256.Sum256([]byte(username+":"+password)))
+	token, err := th.cache.get(key, func() (*tokenResponse, error) {
+		client := &http.Client{
+			Transport: th.transport,
+			Timeout:   15 * time.Second,
+		}
+		tr, err := requestToken(client, realmURL, params["service"], []string{th.scope}, username, password)
+		if err != nil {
+			return nil, fmt.Errorf("token auth attempt for registry: %v", err)
+		}
+		return tr, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
+}
@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type staticCredentials struct {
+	username, password string
+}
+
+func (c staticCredentials) Basic(*url.URL) (string, string) {
+	return c.username, c.password
+}
+
+func TestTokenHandlerCache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `{"token": "token%d", "expires_in": 300}`, requests)
+	}))
+	defer server.Close()
+
+	s := &Service{tokens: newTokenCache()}
+	params := map[string]string{"realm": server.URL, "service": "registry"}
+	authorize := func(creds staticCredentials, repoName string) string {
+		handler := s.NewTokenHandler(http.DefaultTransport, creds, repoName, "push", "pull")
+		req, err := http.NewRequest("GET", "https://registry/v2/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handler.AuthorizeRequest(req, params); err != nil {
+			t.Fatal(err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	user := staticCredentials{"user", "password"}
+	if auth := authorize(user, "foo/bar"); auth != "Bearer token1" {
+		t.Fatalf("Unexpected authorization %q", auth)
+	}
+	// Another handler of the same scope and credentials shares the token.
+	if auth := authorize(user, "foo/bar"); auth != "Bearer token1" || requests != 1 {
+		t.Fatalf("Expected the token to be cached, got %q after %d requests", auth, requests)
+	}
+	if auth := authorize(user, "foo/baz"); auth != "Bearer token2" {
+		t.Fatalf("Expected a token for another scope, got %q", auth)
+	}
+	if auth := authorize(staticCredentials{"user", "other"}, "foo/bar"); auth != "Bearer token3" {
+		t.Fatalf("Expected a token for other credentials, got %q", auth)
+	}
+
+	// Tokens are refreshed before they expire.
+	for _, token := range s.tokens.tokens {
+		token.expires = time.Now().Add(tokenRefreshMargin / 2)
+	}
+	if auth := authorize(user, "foo/bar"); auth != "Bearer token4" {
+		t.Fatalf("Expected the token to be refreshed, got %q", auth)
+	}
+}
+
+func TestTokenHandlerMinimumLifetime(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token": "token", "expires_in": 1}`)
+	}))
+	defer server.Close()
+
+	s := &Service{tokens: newTokenCache()}
+	handler := s.NewTokenHandler(http.DefaultTransport, nil, "foo/bar", "pull")
+	req, err := http.NewRequest("GET", "https://registry/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.AuthorizeRequest(req, map[string]string{"realm": server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Bearer token" {
+		t.Fatalf("Unexpected authorization %q", auth)
+	}
+	for _, token := range s.tokens.tokens {
+		if token.expires.Before(time.Now().Add(minimumTokenLifetime - time.Second)) {
+			t.Fatalf("Expected tokens to be cached at least %s, expire at %s", minimumTokenLifetime, token.expires)
+		}
+	}
+}
+
+func TestTokenEvictedWhenRefused(t *testing.T) {
+	requests := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `{"token": "token%d", "expires_in": 300}`, requests)
+	}))
+	defer tokenServer.Close()
+	// The registry only accepts the second token, the first one is revoked.
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer registry.Close()
+
+	s := &Service{tokens: newTokenCache()}
+	handler := s.NewTokenHandler(http.DefaultTransport, nil, "foo/bar", "pull")
+	client := &http.Client{Transport: s.EvictRefusedTokens(http.DefaultTransport)}
+	status := func() int {
+		req, err := http.NewRequest("GET", registry.URL+"/v2/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handler.AuthorizeRequest(req, map[string]string{"realm": tokenServer.URL}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := status(); code != http.StatusUnauthorized {
+		t.Fatalf("Expected the revoked token to be refused, got %d", code)
+	}
+	if code := status(); code != http.StatusOK || requests != 2 {
+		t.Fatalf("Expected a new token after the refused one, got %d after %d token requests", code, requests)
+	}
+	if code := status(); code != http.StatusOK || requests != 2 {
+		t.Fatalf("Expected the new token to be cached, got %d after %d token requests", code, requests)
+	}
+}
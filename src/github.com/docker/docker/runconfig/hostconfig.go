@@ -240,7 +240,11 @@ type HostConfig struct {
 	PublishAllPorts  bool
 	Dns              []string
 	DnsSearch        []string
+	DnsOptions       []string
+	DnsUserManaged   bool // Only generate resolv.conf once, the user owns it afterwards
 	ExtraHosts       []string
+	IPv4Address      string // Static IPv4 address on the default bridge
+	IPv6Address      string // Static IPv6 address on the default bridge
 	VolumesFrom      []string
 	Devices          []DeviceMapping
 	NetworkMode      NetworkMode
@@ -255,8 +259,9 @@ type HostConfig struct {
 	ReadonlyRootfs   bool
 	Ulimits          []*ulimit.Ulimit
 	LogConfig        LogConfig
-	CgroupParent     string // Parent cgroup.
-	ConsoleSize      [2]int // Initial console size on Windows
+	CgroupParent     string            // Parent cgroup.
+	ConsoleSize      [2]int            // Initial console size on Windows
+	StorageOpt       map[string]string // Storage driver options of the container's writable layer, e.g. "size"
 }
 
 func MergeConfigs(config *Config, hostConfig *HostConfig) *ContainerConfigWrapper {
@@ -19,6 +19,7 @@ func TestNetworkModeTest(t *testing.T) {
 		"container:name": {false, false, false, true, false, false},
 		"none":           {true, false, false, false, true, false},
 		"default":        {true, false, false, false, false, true},
+		"weave":          {true, false, false, false, false, false},
 	}
 	networkModeNames := map[NetworkMode]string{
 		"":                         "",
@@ -29,6 +30,7 @@ func TestNetworkModeTest(t *testing.T) {
 		"container:name": "container",
 		"none":           "none",
 		"default":        "default",
+		"weave":          "weave",
 	}
 	for networkMode, state := range networkModes {
 		if networkMode.IsPrivate() != state[0] {
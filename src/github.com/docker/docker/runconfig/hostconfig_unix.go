@@ -30,6 +30,8 @@ func (n NetworkMode) NetworkName() string {
 		return "none"
 	} else if n.IsDefault() {
 		return "default"
+	} else if n.IsUserDefined() {
+		return string(n)
 	}
 	return ""
 }
@@ -50,3 +52,12 @@ func (n NetworkMode) IsContainer() bool {
 func (n NetworkMode) IsNone() bool {
 	return n == "none"
 }
+
+// IsUserDefined indicates whether the network is provided by a network
+// driver plugin rather than one of the built-in modes.
+func (n NetworkMode) IsUserDefined() bool {
+	if n == "" || strings.Contains(string(n), ":") {
+		return false
+	}
+	return !n.IsDefault() && !n.IsBridge() && !n.IsHost() && !n.IsNone()
+}
@@ -2,6 +2,7 @@ package runconfig
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 
@@ -15,9 +16,11 @@ import (
 var (
 	ErrConflictContainerNetworkAndLinks = fmt.Errorf("Conflicting options: --net=container can't be used with links. This would result in undefined behavior")
 	ErrConflictNetworkAndDns            = fmt.Errorf("Conflicting options: --dns and the network mode (--net)")
+	ErrConflictNetworkAndDnsOptions     = fmt.Errorf("Conflicting options: --dns-opt and the network mode (--net)")
 	ErrConflictNetworkHostname          = fmt.Errorf("Conflicting options: -h and the network mode (--net)")
 	ErrConflictHostNetworkAndLinks      = fmt.Errorf("Conflicting options: --net=host can't be used with links. This would result in undefined behavior")
 	ErrConflictContainerNetworkAndMac   = fmt.Errorf("Conflicting options: --mac-address and the network mode (--net)")
+	ErrConflictNetworkAndIP             = fmt.Errorf("Conflicting options: --ip, --ip6 and the network mode (--net)")
 	ErrConflictNetworkHosts             = fmt.Errorf("Conflicting options: --add-host and the network mode (--net)")
 	ErrConflictNetworkPublishPorts      = fmt.Errorf("Conflicting options: -p, -P, --publish-all, --publish and the network mode (--net)")
 	ErrConflictNetworkExposePorts       = fmt.Errorf("Conflicting options: --expose and the network mode (--expose)")
@@ -29,8 +32,11 @@ type validateNM struct {
 	flHostname     *string
 	flLinks        opts.ListOpts
 	flDns          opts.ListOpts
+	flDnsOptions   opts.ListOpts
 	flExtraHosts   opts.ListOpts
 	flMacAddress   *string
+	flIPv4Address  *string
+	flIPv6Address  *string
 	flPublish      opts.ListOpts
 	flPublishAll   *bool
 	flExpose       opts.ListOpts
@@ -53,6 +59,7 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		flExpose      = opts.NewListOpts(nil)
 		flDns         = opts.NewListOpts(opts.ValidateIPAddress)
 		flDnsSearch   = opts.NewListOpts(opts.ValidateDNSSearch)
+		flDnsOptions  = opts.NewListOpts(opts.ValidateDNSOption)
 		flExtraHosts  = opts.NewListOpts(opts.ValidateExtraHost)
 		flVolumesFrom = opts.NewListOpts(nil)
 		flLxcOpts     = opts.NewListOpts(nil)
@@ -63,6 +70,7 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		flSecurityOpt = opts.NewListOpts(nil)
 		flLabelsFile  = opts.NewListOpts(nil)
 		flLoggingOpts = opts.NewListOpts(nil)
+		flStorageOpt  = opts.NewListOpts(nil)
 
 		flNetwork         = cmd.Bool([]string{"#n", "#-networking"}, true, "Enable networking for this container")
 		flPrivileged      = cmd.Bool([]string{"#privileged", "-privileged"}, false, "Give extended privileges to this container")
@@ -88,6 +96,9 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		flSwappiness      = cmd.Int64([]string{"-memory-swappiness"}, -1, "Tuning container memory swappiness (0 to 100)")
 		flNetMode         = cmd.String([]string{"-net"}, "default", "Set the Network mode for the container")
 		flMacAddress      = cmd.String([]string{"-mac-address"}, "", "Container MAC address (e.g. 92:d0:c6:0a:29:33)")
+		flIPv4Address     = cmd.String([]string{"-ip"}, "", "Container IPv4 address (e.g. 172.17.0.42)")
+		flIPv6Address     = cmd.String([]string{"-ip6"}, "", "Container IPv6 address (e.g. 2001:db8::42)")
+		flDnsUserManaged  = cmd.Bool([]string{"-dns-user-managed"}, false, "Only generate resolv.conf once and never update it")
 		flIpcMode         = cmd.String([]string{"-ipc"}, "", "IPC namespace to use")
 		flRestartPolicy   = cmd.String([]string{"-restart"}, "no", "Restart policy to apply when a container exits")
 		flReadonlyRootfs  = cmd.Bool([]string{"-read-only"}, false, "Mount the container's root filesystem as read only")
@@ -108,6 +119,7 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 	cmd.Var(&flExpose, []string{"#expose", "-expose"}, "Expose a port or a range of ports")
 	cmd.Var(&flDns, []string{"#dns", "-dns"}, "Set custom DNS servers")
 	cmd.Var(&flDnsSearch, []string{"-dns-search"}, "Set custom DNS search domains")
+	cmd.Var(&flDnsOptions, []string{"-dns-opt"}, "Set DNS options")
 	cmd.Var(&flExtraHosts, []string{"-add-host"}, "Add a custom host-to-IP mapping (host:ip)")
 	cmd.Var(&flVolumesFrom, []string{"#volumes-from", "-volumes-from"}, "Mount volumes from the specified container(s)")
 	cmd.Var(&flLxcOpts, []string{"#lxc-conf", "-lxc-conf"}, "Add custom lxc options")
@@ -117,6 +129,7 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 	cmd.Var(&flSecurityOpt, []string{"-security-opt"}, "Security Options")
 	cmd.Var(flUlimits, []string{"-ulimit"}, "Ulimit options")
 	cmd.Var(&flLoggingOpts, []string{"-log-opt"}, "Log driver options")
+	cmd.Var(&flStorageOpt, []string{"-storage-opt"}, "Set storage driver options per container")
 
 	expFlags := attachExperimentalFlags(cmd)
 
@@ -138,15 +151,18 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 	}
 
 	vals := validateNM{
-		netMode:      netMode,
-		flHostname:   flHostname,
-		flLinks:      flLinks,
-		flDns:        flDns,
-		flExtraHosts: flExtraHosts,
-		flMacAddress: flMacAddress,
-		flPublish:    flPublish,
-		flPublishAll: flPublishAll,
-		flExpose:     flExpose,
+		netMode:       netMode,
+		flHostname:    flHostname,
+		flLinks:       flLinks,
+		flDns:         flDns,
+		flDnsOptions:  flDnsOptions,
+		flExtraHosts:  flExtraHosts,
+		flMacAddress:  flMacAddress,
+		flIPv4Address: flIPv4Address,
+		flIPv6Address: flIPv6Address,
+		flPublish:     flPublish,
+		flPublishAll:  flPublishAll,
+		flExpose:      flExpose,
 	}
 
 	if err := validateNetMode(&vals); err != nil {
@@ -159,6 +175,18 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 			return nil, nil, cmd, fmt.Errorf("%s is not a valid mac address", *flMacAddress)
 		}
 	}
+
+	// Validate the input ip addresses
+	if *flIPv4Address != "" {
+		if ip := net.ParseIP(*flIPv4Address); ip == nil || ip.To4() == nil {
+			return nil, nil, cmd, fmt.Errorf("%s is not a valid IPv4 address", *flIPv4Address)
+		}
+	}
+	if *flIPv6Address != "" {
+		if ip := net.ParseIP(*flIPv6Address); ip == nil || ip.To4() != nil {
+			return nil, nil, cmd, fmt.Errorf("%s is not a valid IPv6 address", *flIPv6Address)
+		}
+	}
 	if *flStdin {
 		attachStdin = true
 	}
@@ -316,6 +344,11 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		return nil, nil, cmd, err
 	}
 
+	storageOpts, err := parseStorageOpts(flStorageOpt.GetAll())
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+
 	config := &Config{
 		Hostname:        hostname,
 		Domainname:      domainname,
@@ -358,7 +391,11 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		PublishAllPorts:  *flPublishAll,
 		Dns:              flDns.GetAll(),
 		DnsSearch:        flDnsSearch.GetAll(),
+		DnsOptions:       flDnsOptions.GetAll(),
+		DnsUserManaged:   *flDnsUserManaged,
 		ExtraHosts:       flExtraHosts.GetAll(),
+		IPv4Address:      *flIPv4Address,
+		IPv6Address:      *flIPv6Address,
 		VolumesFrom:      flVolumesFrom.GetAll(),
 		NetworkMode:      netMode,
 		IpcMode:          ipcMode,
@@ -374,6 +411,7 @@ func Parse(cmd *flag.FlagSet, args []string) (*Config, *HostConfig, *flag.FlagSe
 		Ulimits:          flUlimits.GetList(),
 		LogConfig:        LogConfig{Type: *flLoggingDriver, Config: loggingOpts},
 		CgroupParent:     *flCgroupParent,
+		StorageOpt:       storageOpts,
 	}
 
 	applyExperimentalFlags(expFlags, config, hostConfig)
@@ -424,6 +462,19 @@ func parseLoggingOpts(loggingDriver string, loggingOpts []string) (map[string]st
 	return loggingOptsMap, nil
 }
 
+// parseStorageOpts converts ["size=10G"] to {"size": "10G"}
+func parseStorageOpts(storageOpts []string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, option := range storageOpts {
+		key, val, err := parsers.ParseKeyValueOpt(option)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid storage option %q", option)
+		}
+		m[key] = val
+	}
+	return m, nil
+}
+
 // ParseRestartPolicy returns the parsed policy or an error indicating what is incorrect
 func ParseRestartPolicy(policy string) (RestartPolicy, error) {
 	p := RestartPolicy{}
@@ -231,8 +231,33 @@ func TestNetHostname(t *testing.T) {
 	if _, _, _, err := parseRun([]string{"--net=container", "img", "cmd"}); err == nil || err.Error() != "--net: invalid net mode: invalid container format container:<name|id>" {
 		t.Fatalf("Expected error with --net=container, got : %v", err)
 	}
-	if _, _, _, err := parseRun([]string{"--net=weird", "img", "cmd"}); err == nil || err.Error() != "--net: invalid net mode: invalid --net: weird" {
-		t.Fatalf("Expected error with --net=weird, got: %s", err)
+	if _, _, _, err := parseRun([]string{"--net=we:ird", "img", "cmd"}); err == nil || err.Error() != "--net: invalid net mode: invalid --net: we:ird" {
+		t.Fatalf("Expected error with --net=we:ird, got: %s", err)
+	}
+	if _, _, _, err := parseRun([]string{"--net=-weird", "img", "cmd"}); err == nil || err.Error() != "--net: invalid net mode: invalid --net: -weird" {
+		t.Fatalf("Expected error with --net=-weird, got: %s", err)
+	}
+}
+
+func TestParseNetworkDriverPlugin(t *testing.T) {
+	_, hostconfig, _, err := parseRun([]string{"--net=weave", "img", "cmd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hostconfig.NetworkMode.IsUserDefined() {
+		t.Fatalf("Expected --net=weave to select a network driver plugin, got %q", hostconfig.NetworkMode)
+	}
+	if name := hostconfig.NetworkMode.NetworkName(); name != "weave" {
+		t.Fatalf("Expected network name weave, got %q", name)
+	}
+	for _, mode := range []string{"default", "bridge", "host", "none", "container:other"} {
+		if NetworkMode(mode).IsUserDefined() {
+			t.Fatalf("Expected %s not to be a network driver plugin", mode)
+		}
+	}
+
+	if _, _, _, err := parseRun([]string{"--net=weave", "--ip=10.0.0.2", "img", "cmd"}); err != ErrConflictNetworkAndIP {
+		t.Fatalf("Expected error ErrConflictNetworkAndIP, got: %s", err)
 	}
 }
 
@@ -252,6 +277,12 @@ func TestConflictNetworkModeAndOptions(t *testing.T) {
 	if _, _, _, err := parseRun([]string{"--net=container:other", "--dns=8.8.8.8", "img", "cmd"}); err != ErrConflictNetworkAndDns {
 		t.Fatalf("Expected error ErrConflictNetworkAndDns, got %s", err)
 	}
+	if _, _, _, err := parseRun([]string{"--net=host", "--dns-opt=ndots:2", "img", "cmd"}); err != ErrConflictNetworkAndDnsOptions {
+		t.Fatalf("Expected error ErrConflictNetworkAndDnsOptions, got %s", err)
+	}
+	if _, _, _, err := parseRun([]string{"--net=container:other", "--dns-opt=ndots:2", "img", "cmd"}); err != ErrConflictNetworkAndDnsOptions {
+		t.Fatalf("Expected error ErrConflictNetworkAndDnsOptions, got %s", err)
+	}
 	if _, _, _, err := parseRun([]string{"--net=host", "--add-host=name:8.8.8.8", "img", "cmd"}); err != ErrConflictNetworkHosts {
 		t.Fatalf("Expected error ErrConflictNetworkAndDns, got %s", err)
 	}
@@ -273,6 +304,12 @@ func TestConflictNetworkModeAndOptions(t *testing.T) {
 	if _, _, _, err := parseRun([]string{"--net=container:other", "--expose", "8000-9000", "img", "cmd"}); err != ErrConflictNetworkExposePorts {
 		t.Fatalf("Expected error ErrConflictNetworkExposePorts, got %s", err)
 	}
+	if _, _, _, err := parseRun([]string{"--net=host", "--ip=172.17.0.42", "img", "cmd"}); err != ErrConflictNetworkAndIP {
+		t.Fatalf("Expected error ErrConflictNetworkAndIP, got %s", err)
+	}
+	if _, _, _, err := parseRun([]string{"--net=none", "--ip6=2001:db8::42", "img", "cmd"}); err != ErrConflictNetworkAndIP {
+		t.Fatalf("Expected error ErrConflictNetworkAndIP, got %s", err)
+	}
 }
 
 // Simple parse with MacAddress validatation
@@ -287,6 +324,43 @@ func TestParseWithMacAddress(t *testing.T) {
 	}
 }
 
+// Simple parse with IP address validation
+func TestParseWithIPAddress(t *testing.T) {
+	for _, invalid := range []string{"--ip=invalid", "--ip=2001:db8::42"} {
+		if _, _, _, err := parseRun([]string{invalid, "img", "cmd"}); err == nil || !strings.HasSuffix(err.Error(), "is not a valid IPv4 address") {
+			t.Fatalf("Expected an error with %v, got %v", invalid, err)
+		}
+	}
+	for _, invalid := range []string{"--ip6=invalid", "--ip6=172.17.0.42"} {
+		if _, _, _, err := parseRun([]string{invalid, "img", "cmd"}); err == nil || !strings.HasSuffix(err.Error(), "is not a valid IPv6 address") {
+			t.Fatalf("Expected an error with %v, got %v", invalid, err)
+		}
+	}
+	_, hostconfig := mustParse(t, "--ip=172.17.0.42 --ip6=2001:db8::42")
+	if hostconfig.IPv4Address != "172.17.0.42" {
+		t.Fatalf("Expected the hostconfig to have '172.17.0.42' as IPv4Address, got '%v'", hostconfig.IPv4Address)
+	}
+	if hostconfig.IPv6Address != "2001:db8::42" {
+		t.Fatalf("Expected the hostconfig to have '2001:db8::42' as IPv6Address, got '%v'", hostconfig.IPv6Address)
+	}
+}
+
+func TestParseWithDNSOptions(t *testing.T) {
+	if _, _, _, err := parseRun([]string{"--dns-opt=ndots: 2", "img", "cmd"}); err == nil || !strings.HasSuffix(err.Error(), "is not a valid DNS option") {
+		t.Fatalf("Expected an error with an invalid DNS option, got %v", err)
+	}
+	_, hostconfig := mustParse(t, "--dns-opt=ndots:2 --dns-opt=rotate --dns-user-managed")
+	if len(hostconfig.DnsOptions) != 2 || hostconfig.DnsOptions[0] != "ndots:2" || hostconfig.DnsOptions[1] != "rotate" {
+		t.Fatalf("Expected the hostconfig to have [ndots:2 rotate] as DnsOptions, got '%v'", hostconfig.DnsOptions)
+	}
+	if !hostconfig.DnsUserManaged {
+		t.Fatal("Expected the hostconfig to have DnsUserManaged set")
+	}
+	if _, hostconfig := mustParse(t, ""); hostconfig.DnsUserManaged {
+		t.Fatal("Expected DnsUserManaged to be off by default")
+	}
+}
+
 func TestParseWithMemory(t *testing.T) {
 	invalidMemory := "--memory=invalid"
 	validMemory := "--memory=1G"
@@ -503,6 +577,20 @@ func TestParseLoggingOpts(t *testing.T) {
 	}
 }
 
+func TestParseStorageOpts(t *testing.T) {
+	// storage options
+	if _, _, _, err := parseRun([]string{"--storage-opt=size", "img", "cmd"}); err == nil || err.Error() != `Invalid storage option "size"` {
+		t.Fatalf("Expected an error with message 'Invalid storage option \"size\"', got %v", err)
+	}
+	_, hostconfig, _, err := parseRun([]string{"--storage-opt=size=10G", "img", "cmd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hostconfig.StorageOpt) != 1 || hostconfig.StorageOpt["size"] != "10G" {
+		t.Fatalf("Expected a StorageOpt with size 10G, got %v", hostconfig.StorageOpt)
+	}
+}
+
 func TestParseEnvfileVariables(t *testing.T) {
 	// env ko
 	if _, _, _, err := parseRun([]string{"--env-file=nonexistent", "img", "cmd"}); err == nil || err.Error() != "open nonexistent: no such file or directory" {
@@ -4,9 +4,14 @@ package runconfig
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 )
 
+// validNetworkDriverName matches the network driver plugin names accepted by
+// --net.
+var validNetworkDriverName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
 func parseNetMode(netMode string) (NetworkMode, error) {
 	parts := strings.Split(netMode, ":")
 	switch mode := parts[0]; mode {
@@ -16,7 +21,10 @@ func parseNetMode(netMode string) (NetworkMode, error) {
 			return "", fmt.Errorf("invalid container format container:<name|id>")
 		}
 	default:
-		return "", fmt.Errorf("invalid --net: %s", netMode)
+		// Anything else names a network driver plugin
+		if len(parts) > 1 || !validNetworkDriverName.MatchString(mode) {
+			return "", fmt.Errorf("invalid --net: %s", netMode)
+		}
 	}
 	return NetworkMode(netMode), nil
 }
@@ -39,6 +47,10 @@ func validateNetMode(vals *validateNM) error {
 		return ErrConflictNetworkAndDns
 	}
 
+	if (vals.netMode.IsHost() || vals.netMode.IsContainer()) && vals.flDnsOptions.Len() > 0 {
+		return ErrConflictNetworkAndDnsOptions
+	}
+
 	if (vals.netMode.IsContainer() || vals.netMode.IsHost()) && vals.flExtraHosts.Len() > 0 {
 		return ErrConflictNetworkHosts
 	}
@@ -47,6 +59,10 @@ func validateNetMode(vals *validateNM) error {
 		return ErrConflictContainerNetworkAndMac
 	}
 
+	if (vals.netMode.IsContainer() || vals.netMode.IsHost() || vals.netMode.IsNone() || vals.netMode.IsUserDefined()) && (*vals.flIPv4Address != "" || *vals.flIPv6Address != "") {
+		return ErrConflictNetworkAndIP
+	}
+
 	if vals.netMode.IsContainer() && (vals.flPublish.Len() > 0 || *vals.flPublishAll == true) {
 		return ErrConflictNetworkPublishPorts
 	}